@@ -0,0 +1,209 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/lingyunzhao/pqcrypto/internal/atomicfile"
+	"github.com/lingyunzhao/pqcrypto/ldwm"
+	"github.com/lingyunzhao/pqcrypto/xmss"
+)
+
+// Key files hold a single line "<scheme> <hex>", where hex is the String() form
+// of a public key, or the MarshalPrivate() form of a private key, in the ldwm or
+// xmss package. Signature files hold the signature in hexadecimal.
+
+const (
+	schemeLMS    = "lms"
+	schemeHSS    = "hss"
+	schemeXMSS   = "xmss"
+	schemeXMSSMT = "xmssmt"
+)
+
+// A privateKey is one of *ldwm.LmsPrivateKey, *ldwm.HssPrivateKey, *xmss.SK and *xmss.MTSK.
+type privateKey interface {
+	SignReader(r io.Reader) ([]byte, error)
+	Remaining() uint64
+	MarshalPrivate() ([]byte, error)
+	Destroy()
+}
+
+// A publicKey verifies signatures of one of the schemes.
+type publicKey interface {
+	verifyReader(r io.Reader, sig []byte) error
+	String() string
+}
+
+type lmsPublicKey struct{ *ldwm.LmsPublicKey }
+
+func (k lmsPublicKey) verifyReader(r io.Reader, sig []byte) error {
+	return k.VerifyReader(r, sig)
+}
+
+type hssPublicKey struct{ *ldwm.HssPublicKey }
+
+func (k hssPublicKey) verifyReader(r io.Reader, sig []byte) error {
+	return k.VerifyReader(r, sig)
+}
+
+type xmssPublicKey struct{ *xmss.PK }
+
+func (k xmssPublicKey) verifyReader(r io.Reader, sig []byte) error {
+	if !k.VerifyReader(r, sig) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+type xmssmtPublicKey struct{ *xmss.MTPK }
+
+func (k xmssmtPublicKey) verifyReader(r io.Reader, sig []byte) error {
+	if !k.VerifyReader(r, sig) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// publicKeyOf returns the public key of a private key.
+func publicKeyOf(key privateKey) (publicKey, error) {
+	switch k := key.(type) {
+	case *ldwm.LmsPrivateKey:
+		pub, err := k.Public()
+		if err != nil {
+			return nil, err
+		}
+		return lmsPublicKey{pub}, nil
+	case *ldwm.HssPrivateKey:
+		return hssPublicKey{k.Public()}, nil
+	case *xmss.SK:
+		return xmssPublicKey{k.Public()}, nil
+	case *xmss.MTSK:
+		return xmssmtPublicKey{k.Public()}, nil
+	}
+	return nil, errors.New("unknown key type")
+}
+
+func schemeOf(key interface{}) string {
+	switch key.(type) {
+	case *ldwm.LmsPrivateKey, lmsPublicKey:
+		return schemeLMS
+	case *ldwm.HssPrivateKey, hssPublicKey:
+		return schemeHSS
+	case *xmss.SK, xmssPublicKey:
+		return schemeXMSS
+	case *xmss.MTSK, xmssmtPublicKey:
+		return schemeXMSSMT
+	}
+	return ""
+}
+
+func splitKeyFile(path string) (string, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		return "", "", fmt.Errorf("%s: not a key file", path)
+	}
+	return fields[0], fields[1], nil
+}
+
+func readPrivateKey(path string) (privateKey, error) {
+	scheme, keyHex, err := splitKeyFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var key privateKey
+	switch scheme {
+	case schemeLMS:
+		key, err = ldwm.ParseLmsPrivateKey(keyHex)
+	case schemeHSS:
+		key, err = ldwm.ParseHssPrivateKey(keyHex)
+	case schemeXMSS:
+		key, err = xmss.ParseSK(keyHex)
+	case schemeXMSSMT:
+		key, err = xmss.ParseMTSK(keyHex)
+	default:
+		return nil, fmt.Errorf("%s: unknown scheme %q", path, scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return key, nil
+}
+
+func readPublicKey(path string) (publicKey, error) {
+	scheme, keyHex, err := splitKeyFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var key publicKey
+	switch scheme {
+	case schemeLMS:
+		var pub *ldwm.LmsPublicKey
+		pub, err = ldwm.ParseLmsPublicKey(keyHex)
+		key = lmsPublicKey{pub}
+	case schemeHSS:
+		var pub *ldwm.HssPublicKey
+		pub, err = ldwm.ParseHssPublicKey(keyHex)
+		key = hssPublicKey{pub}
+	case schemeXMSS:
+		var pub *xmss.PK
+		pub, err = xmss.ParsePK(keyHex)
+		key = xmssPublicKey{pub}
+	case schemeXMSSMT:
+		var pub *xmss.MTPK
+		pub, err = xmss.ParseMTPK(keyHex)
+		key = xmssmtPublicKey{pub}
+	default:
+		return nil, fmt.Errorf("%s: unknown scheme %q", path, scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return key, nil
+}
+
+func formatKey(key publicKey) []byte {
+	return []byte(schemeOf(key) + " " + key.String() + "\n")
+}
+
+func formatPrivateKey(key privateKey) ([]byte, error) {
+	b, err := key.MarshalPrivate()
+	if err != nil {
+		return nil, err
+	}
+	defer zeroize(b)
+	return []byte(schemeOf(key) + " " + hex.EncodeToString(b) + "\n"), nil
+}
+
+// zeroize overwrites b with zeros.
+func zeroize(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// writeFileAtomic replaces the file at path with data so that it holds either
+// the old or the new contents after a crash. Tests replace it to simulate a
+// failing disk.
+var writeFileAtomic = atomicfile.WriteFile
+
+// lockKey creates a lock file next to the private key so that two processes
+// do not sign with the same state. The returned function removes it.
+func lockKey(path string) (func(), error) {
+	unlock, err := atomicfile.Lock(path, 0)
+	if err == atomicfile.ErrLocked {
+		return nil, fmt.Errorf("%s is locked by another process; remove %s if it is stale", path, path+".lock")
+	}
+	return unlock, err
+}
@@ -0,0 +1,492 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command pqsig generates keys, signs and verifies with the stateful hash-based
+// signature schemes LMS, HSS, XMSS and XMSS^MT.
+//
+// Usage:
+//
+//	pqsig keygen -scheme hss -type LMS_SHA256_M32_H10 -ots LMOTS_SHA256_N32_W4 -layers 2 -key key.sk -pub key.pk
+//	pqsig keygen -scheme xmss -type XMSSSHA2H10W256 -key key.sk -pub key.pk
+//	pqsig sign -key key.sk [-in file] [-out file.sig]
+//	pqsig verify -pub key.pk -sig file.sig [-in file]
+//	pqsig pubkey -key key.sk [-out key.pk]
+//	pqsig inspect [-json] (-key key.sk | -pub key.pk [-sig file.sig])
+//	pqsig remaining -key key.sk
+//
+// The private key file is updated after every signature by writing the new
+// state to a temporary file and renaming it over the old one. The signature is
+// only written out once the new state has been persisted.
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/lingyunzhao/pqcrypto/internal/atomicfile"
+	"github.com/lingyunzhao/pqcrypto/ldwm"
+	"github.com/lingyunzhao/pqcrypto/xmss"
+)
+
+var lmsTypes = map[string]uint{
+	"LMS_SHA256_M32_H5":  ldwm.LMS_SHA256_M32_H5,
+	"LMS_SHA256_M32_H10": ldwm.LMS_SHA256_M32_H10,
+	"LMS_SHA256_M32_H15": ldwm.LMS_SHA256_M32_H15,
+	"LMS_SHA256_M32_H20": ldwm.LMS_SHA256_M32_H20,
+	"LMS_SHA256_M32_H25": ldwm.LMS_SHA256_M32_H25,
+}
+
+var otsTypes = map[string]uint{
+	"LMOTS_SHA256_N32_W1": ldwm.LMOTS_SHA256_N32_W1,
+	"LMOTS_SHA256_N32_W2": ldwm.LMOTS_SHA256_N32_W2,
+	"LMOTS_SHA256_N32_W4": ldwm.LMOTS_SHA256_N32_W4,
+	"LMOTS_SHA256_N32_W8": ldwm.LMOTS_SHA256_N32_W8,
+}
+
+var xmssTypes = map[string]uint{
+	"XMSSSHA2H10W256":  xmss.XMSSSHA2H10W256,
+	"XMSSSHA2H16W256":  xmss.XMSSSHA2H16W256,
+	"XMSSSHA2H20W256":  xmss.XMSSSHA2H20W256,
+	"XMSSSHA2H10W512":  xmss.XMSSSHA2H10W512,
+	"XMSSSHA2H16W512":  xmss.XMSSSHA2H16W512,
+	"XMSSSHA2H20W512":  xmss.XMSSSHA2H20W512,
+	"XMSSSHAKEH10W256": xmss.XMSSSHAKEH10W256,
+	"XMSSSHAKEH16W256": xmss.XMSSSHAKEH16W256,
+	"XMSSSHAKEH20W256": xmss.XMSSSHAKEH20W256,
+	"XMSSSHAKEH10W512": xmss.XMSSSHAKEH10W512,
+	"XMSSSHAKEH16W512": xmss.XMSSSHAKEH16W512,
+	"XMSSSHAKEH20W512": xmss.XMSSSHAKEH20W512,
+}
+
+var xmssmtTypes = map[string]uint{
+	"XMSSMTSHA2H20D2W256":   xmss.XMSSMTSHA2H20D2W256,
+	"XMSSMTSHA2H20D4W256":   xmss.XMSSMTSHA2H20D4W256,
+	"XMSSMTSHA2H40D2W256":   xmss.XMSSMTSHA2H40D2W256,
+	"XMSSMTSHA2H40D4W256":   xmss.XMSSMTSHA2H40D4W256,
+	"XMSSMTSHA2H40D8W256":   xmss.XMSSMTSHA2H40D8W256,
+	"XMSSMTSHA2H60D3W256":   xmss.XMSSMTSHA2H60D3W256,
+	"XMSSMTSHA2H60D6W256":   xmss.XMSSMTSHA2H60D6W256,
+	"XMSSMTSHA2H60D12W256":  xmss.XMSSMTSHA2H60D12W256,
+	"XMSSMTSHA2H20D2W512":   xmss.XMSSMTSHA2H20D2W512,
+	"XMSSMTSHA2H20D4W512":   xmss.XMSSMTSHA2H20D4W512,
+	"XMSSMTSHA2H40D2W512":   xmss.XMSSMTSHA2H40D2W512,
+	"XMSSMTSHA2H40D4W512":   xmss.XMSSMTSHA2H40D4W512,
+	"XMSSMTSHA2H40D8W512":   xmss.XMSSMTSHA2H40D8W512,
+	"XMSSMTSHA2H60D3W512":   xmss.XMSSMTSHA2H60D3W512,
+	"XMSSMTSHA2H60D6W512":   xmss.XMSSMTSHA2H60D6W512,
+	"XMSSMTSHA2H60D12W512":  xmss.XMSSMTSHA2H60D12W512,
+	"XMSSMTSHAKEH20D2W256":  xmss.XMSSMTSHAKEH20D2W256,
+	"XMSSMTSHAKEH20D4W256":  xmss.XMSSMTSHAKEH20D4W256,
+	"XMSSMTSHAKEH40D2W256":  xmss.XMSSMTSHAKEH40D2W256,
+	"XMSSMTSHAKEH40D4W256":  xmss.XMSSMTSHAKEH40D4W256,
+	"XMSSMTSHAKEH40D8W256":  xmss.XMSSMTSHAKEH40D8W256,
+	"XMSSMTSHAKEH60D3W256":  xmss.XMSSMTSHAKEH60D3W256,
+	"XMSSMTSHAKEH60D6W256":  xmss.XMSSMTSHAKEH60D6W256,
+	"XMSSMTSHAKEH60D12W256": xmss.XMSSMTSHAKEH60D12W256,
+	"XMSSMTSHAKEH20D2W512":  xmss.XMSSMTSHAKEH20D2W512,
+	"XMSSMTSHAKEH20D4W512":  xmss.XMSSMTSHAKEH20D4W512,
+	"XMSSMTSHAKEH40D2W512":  xmss.XMSSMTSHAKEH40D2W512,
+	"XMSSMTSHAKEH40D4W512":  xmss.XMSSMTSHAKEH40D4W512,
+	"XMSSMTSHAKEH40D8W512":  xmss.XMSSMTSHAKEH40D8W512,
+	"XMSSMTSHAKEH60D3W512":  xmss.XMSSMTSHAKEH60D3W512,
+	"XMSSMTSHAKEH60D6W512":  xmss.XMSSMTSHAKEH60D6W512,
+	"XMSSMTSHAKEH60D12W512": xmss.XMSSMTSHAKEH60D12W512,
+}
+
+type command struct {
+	run   func(args []string, stdin io.Reader, stdout io.Writer) error
+	usage string
+}
+
+var commands = map[string]command{
+	"keygen":    {keygen, "generate a key pair"},
+	"sign":      {sign, "sign a file and update the private key"},
+	"verify":    {verify, "verify a signature"},
+	"pubkey":    {pubkey, "write the public key of a private key"},
+	"inspect":   {inspect, "describe a key file"},
+	"remaining": {remaining, "print the number of signatures left"},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 {
+		usage(stderr)
+		return 2
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		usage(stderr)
+		return 2
+	}
+	if err := cmd.run(args[1:], stdin, stdout); err != nil {
+		fmt.Fprintf(stderr, "pqsig %s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: pqsig <command> [flags]")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-10s %s\n", name, commands[name].usage)
+	}
+}
+
+var errVerify = errors.New("invalid signature")
+
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+func keygen(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := newFlagSet("keygen")
+	scheme := fs.String("scheme", "", "lms, hss, xmss or xmssmt")
+	typ := fs.String("type", "", "LMS, XMSS or XMSS^MT parameter set")
+	ots := fs.String("ots", "LMOTS_SHA256_N32_W4", "LM-OTS parameter set (lms and hss)")
+	layers := fs.Int("layers", 2, "number of HSS layers (hss)")
+	keyPath := fs.String("key", "", "private key file to create")
+	pubPath := fs.String("pub", "", "public key file to create")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *keyPath == "" {
+		return errors.New("missing -key")
+	}
+	// Hold the lock and create the private key file before generating the key,
+	// so that an existing key, or one another process is creating, is never
+	// replaced.
+	unlock, err := lockKey(*keyPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	f, err := os.OpenFile(*keyPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		return fmt.Errorf("%s already exists", *keyPath)
+	}
+	if err != nil {
+		return err
+	}
+	written := false
+	defer func() {
+		if !written {
+			f.Close()
+			os.Remove(*keyPath)
+		}
+	}()
+
+	var key privateKey
+	switch *scheme {
+	case schemeLMS, schemeHSS:
+		lmsType, ok := lmsTypes[*typ]
+		if !ok {
+			return fmt.Errorf("unknown LMS parameter set %q (one of %s)", *typ, names(lmsTypes))
+		}
+		otsType, ok := otsTypes[*ots]
+		if !ok {
+			return fmt.Errorf("unknown LM-OTS parameter set %q (one of %s)", *ots, names(otsTypes))
+		}
+		if *scheme == schemeLMS {
+			key, err = ldwm.GenerateLmsPrivateKey(lmsType, otsType)
+		} else {
+			key, err = ldwm.GenerateHssPrivateKey(lmsType, otsType, *layers)
+		}
+	case schemeXMSS:
+		oid, ok := xmssTypes[*typ]
+		if !ok {
+			return fmt.Errorf("unknown XMSS parameter set %q (one of %s)", *typ, names(xmssTypes))
+		}
+		key, _, err = xmss.KeyGen(oid)
+	case schemeXMSSMT:
+		oid, ok := xmssmtTypes[*typ]
+		if !ok {
+			return fmt.Errorf("unknown XMSS^MT parameter set %q (one of %s)", *typ, names(xmssmtTypes))
+		}
+		key, _, err = xmss.MTkeyGen(oid)
+	default:
+		return fmt.Errorf("unknown scheme %q", *scheme)
+	}
+	if err != nil {
+		return err
+	}
+	defer key.Destroy()
+
+	pub, err := publicKeyOf(key)
+	if err != nil {
+		return err
+	}
+	data, err := formatPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	defer zeroize(data)
+	if err := atomicfile.WriteNew(f, data); err != nil {
+		return err
+	}
+	written = true
+	// The public key is written last: if that fails, it can still be
+	// recovered from the private key with pubkey.
+	if *pubPath != "" {
+		if err := writeFileAtomic(*pubPath, formatKey(pub), 0644); err != nil {
+			return fmt.Errorf("%s was created, but writing the public key failed: %v", *keyPath, err)
+		}
+	}
+	return nil
+}
+
+func names(types map[string]uint) string {
+	list := make([]string, 0, len(types))
+	for name := range types {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ", ")
+}
+
+func openInput(path string, stdin io.Reader) (io.ReadCloser, error) {
+	if path == "" || path == "-" {
+		return io.NopCloser(stdin), nil
+	}
+	return os.Open(path)
+}
+
+func writeOutput(path string, stdout io.Writer, data []byte) error {
+	if path == "" || path == "-" {
+		_, err := stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func sign(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := newFlagSet("sign")
+	keyPath := fs.String("key", "", "private key file")
+	in := fs.String("in", "-", "file to sign")
+	out := fs.String("out", "-", "signature file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *keyPath == "" {
+		return errors.New("missing -key")
+	}
+
+	unlock, err := lockKey(*keyPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	key, err := readPrivateKey(*keyPath)
+	if err != nil {
+		return err
+	}
+	defer key.Destroy()
+	r, err := openInput(*in, stdin)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	sig, signErr := key.SignReader(r)
+	// The leaf has been consumed even if reading the input failed, so the new
+	// state is saved either way.
+	data, err := formatPrivateKey(key)
+	if err == nil {
+		err = writeFileAtomic(*keyPath, data, 0600)
+		zeroize(data)
+	}
+	if err != nil {
+		return fmt.Errorf("signature discarded, cannot save the private key state: %v", err)
+	}
+	if signErr != nil {
+		return signErr
+	}
+	return writeOutput(*out, stdout, []byte(hex.EncodeToString(sig)+"\n"))
+}
+
+func verify(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := newFlagSet("verify")
+	pubPath := fs.String("pub", "", "public key file")
+	sigPath := fs.String("sig", "", "signature file")
+	in := fs.String("in", "-", "signed file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *pubPath == "" || *sigPath == "" {
+		return errors.New("missing -pub or -sig")
+	}
+
+	pub, err := readPublicKey(*pubPath)
+	if err != nil {
+		return err
+	}
+	sig, err := readSignature(*sigPath)
+	if err != nil {
+		return err
+	}
+	r, err := openInput(*in, stdin)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if err := pub.verifyReader(r, sig); err != nil {
+		return errVerify
+	}
+	fmt.Fprintln(stdout, "OK")
+	return nil
+}
+
+func readSignature(path string) ([]byte, error) {
+	sigHex, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := hex.DecodeString(strings.TrimSpace(string(sigHex)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return sig, nil
+}
+
+func pubkey(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := newFlagSet("pubkey")
+	keyPath := fs.String("key", "", "private key file")
+	out := fs.String("out", "-", "public key file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	key, err := readPrivateKey(*keyPath)
+	if err != nil {
+		return err
+	}
+	defer key.Destroy()
+	pub, err := publicKeyOf(key)
+	if err != nil {
+		return err
+	}
+	return writeOutput(*out, stdout, formatKey(pub))
+}
+
+func inspect(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := newFlagSet("inspect")
+	keyPath := fs.String("key", "", "private key file")
+	pubPath := fs.String("pub", "", "public key file")
+	sigPath := fs.String("sig", "", "signature file, decoded with the parameters of -pub")
+	asJSON := fs.Bool("json", false, "print JSON instead of text")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var desc fmt.Stringer
+	switch {
+	case *keyPath != "":
+		key, err := readPrivateKey(*keyPath)
+		if err != nil {
+			return err
+		}
+		defer key.Destroy()
+		desc = describeKey(key)
+	case *pubPath != "" && *sigPath != "":
+		pub, err := readPublicKey(*pubPath)
+		if err != nil {
+			return err
+		}
+		sig, err := readSignature(*sigPath)
+		if err != nil {
+			return err
+		}
+		desc, err = describeSignature(pub, sig)
+		if err != nil {
+			return err
+		}
+	case *pubPath != "":
+		pub, err := readPublicKey(*pubPath)
+		if err != nil {
+			return err
+		}
+		desc = describeKey(pub)
+	default:
+		return errors.New("missing -key or -pub")
+	}
+
+	if *asJSON {
+		data, err := json.MarshalIndent(desc, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = stdout.Write(append(data, '\n'))
+		return err
+	}
+	_, err := io.WriteString(stdout, desc.String())
+	return err
+}
+
+func describeKey(key interface{}) fmt.Stringer {
+	switch k := key.(type) {
+	case *ldwm.LmsPrivateKey:
+		return k.Describe()
+	case *ldwm.HssPrivateKey:
+		return k.Describe()
+	case *xmss.SK:
+		return k.Describe()
+	case *xmss.MTSK:
+		return k.Describe()
+	case lmsPublicKey:
+		return k.Describe()
+	case hssPublicKey:
+		return k.Describe()
+	case xmssPublicKey:
+		return k.Describe()
+	case xmssmtPublicKey:
+		return k.Describe()
+	}
+	return nil
+}
+
+func describeSignature(pub publicKey, sig []byte) (fmt.Stringer, error) {
+	switch k := pub.(type) {
+	case lmsPublicKey:
+		return ldwm.DescribeLmsSignature(sig)
+	case hssPublicKey:
+		return ldwm.DescribeHssSignature(sig)
+	case xmssPublicKey:
+		return k.DescribeSignature(sig)
+	case xmssmtPublicKey:
+		return k.DescribeSignature(sig)
+	}
+	return nil, errors.New("unknown key type")
+}
+
+func remaining(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := newFlagSet("remaining")
+	keyPath := fs.String("key", "", "private key file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	key, err := readPrivateKey(*keyPath)
+	if err != nil {
+		return err
+	}
+	defer key.Destroy()
+	fmt.Fprintln(stdout, key.Remaining())
+	return nil
+}
@@ -0,0 +1,163 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func pqsig(t *testing.T, stdin string, args ...string) (string, int) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	if code != 0 {
+		return stderr.String(), code
+	}
+	return stdout.String(), code
+}
+
+func TestSignVerify(t *testing.T) {
+	cases := [][]string{
+		{"-scheme", "lms", "-type", "LMS_SHA256_M32_H5", "-ots", "LMOTS_SHA256_N32_W8"},
+		{"-scheme", "hss", "-type", "LMS_SHA256_M32_H5", "-ots", "LMOTS_SHA256_N32_W8", "-layers", "2"},
+		{"-scheme", "xmss", "-type", "XMSSSHA2H10W256"},
+		{"-scheme", "xmssmt", "-type", "XMSSMTSHA2H20D4W256"},
+	}
+	for _, c := range cases {
+		dir := t.TempDir()
+		key := filepath.Join(dir, "key.sk")
+		pub := filepath.Join(dir, "key.pk")
+		sig := filepath.Join(dir, "msg.sig")
+		if out, code := pqsig(t, "", append([]string{"keygen", "-key", key, "-pub", pub}, c...)...); code != 0 {
+			t.Fatalf("%s: keygen: %s", c[1], out)
+		}
+		if out, code := pqsig(t, "", "keygen", "-key", key, c[0], c[1], c[2], c[3]); code == 0 || !strings.Contains(out, "exists") {
+			t.Errorf("%s: keygen overwrote an existing key", c[1])
+		}
+
+		before, _ := pqsig(t, "", "remaining", "-key", key)
+		if out, code := pqsig(t, "message", "sign", "-key", key, "-out", sig); code != 0 {
+			t.Fatalf("%s: sign: %s", c[1], out)
+		}
+		after, _ := pqsig(t, "", "remaining", "-key", key)
+		b, _ := strconv.ParseUint(strings.TrimSpace(before), 10, 64)
+		a, _ := strconv.ParseUint(strings.TrimSpace(after), 10, 64)
+		if a != b-1 {
+			t.Errorf("%s: remaining went from %d to %d after signing", c[1], b, a)
+		}
+
+		if out, code := pqsig(t, "message", "verify", "-pub", pub, "-sig", sig); code != 0 || out != "OK\n" {
+			t.Errorf("%s: verify: %s", c[1], out)
+		}
+		if _, code := pqsig(t, "massage", "verify", "-pub", pub, "-sig", sig); code == 0 {
+			t.Errorf("%s: verify accepted a modified message", c[1])
+		}
+
+		derived, _ := pqsig(t, "", "pubkey", "-key", key)
+		stored, _ := os.ReadFile(pub)
+		if derived != string(stored) {
+			t.Errorf("%s: pubkey does not match the key generated public key", c[1])
+		}
+		if out, code := pqsig(t, "", "inspect", "-key", key); code != 0 || !strings.Contains(out, "private key") {
+			t.Errorf("%s: inspect -key: %s", c[1], out)
+		}
+		out, code := pqsig(t, "", "inspect", "-json", "-pub", pub, "-sig", sig)
+		var desc map[string]interface{}
+		if code != 0 || json.Unmarshal([]byte(out), &desc) != nil {
+			t.Errorf("%s: inspect -sig: %s", c[1], out)
+		}
+	}
+}
+
+func TestSignLocked(t *testing.T) {
+	dir := t.TempDir()
+	key := filepath.Join(dir, "key.sk")
+	pqsig(t, "", "keygen", "-scheme", "lms", "-type", "LMS_SHA256_M32_H5", "-ots", "LMOTS_SHA256_N32_W8", "-key", key)
+
+	unlock, err := lockKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, code := pqsig(t, "message", "sign", "-key", key); code == 0 || !strings.Contains(out, "locked") {
+		t.Errorf("sign ignored the lock: %s", out)
+	}
+	unlock()
+	if out, code := pqsig(t, "message", "sign", "-key", key); code != 0 {
+		t.Errorf("sign after unlock: %s", out)
+	}
+}
+
+func TestSignPersistFailure(t *testing.T) {
+	dir := t.TempDir()
+	key := filepath.Join(dir, "key.sk")
+	pqsig(t, "", "keygen", "-scheme", "xmss", "-type", "XMSSSHA2H10W256", "-key", key)
+	before, _ := os.ReadFile(key)
+
+	defer func(f func(string, []byte, os.FileMode) error) { writeFileAtomic = f }(writeFileAtomic)
+	writeFileAtomic = func(string, []byte, os.FileMode) error {
+		return errors.New("disk full")
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"sign", "-key", key}, strings.NewReader("message"), &stdout, &stderr); code == 0 {
+		t.Fatal("sign succeeded although the state could not be saved")
+	}
+	if stdout.Len() != 0 {
+		t.Error("sign output a signature although the state could not be saved")
+	}
+	if after, _ := os.ReadFile(key); !bytes.Equal(before, after) {
+		t.Error("private key file changed")
+	}
+}
+
+func TestKeygenCreatesPrivateKeyFirst(t *testing.T) {
+	dir := t.TempDir()
+	key := filepath.Join(dir, "key.sk")
+	pub := filepath.Join(dir, "key.pk")
+	lms := []string{"-scheme", "lms", "-type", "LMS_SHA256_M32_H5", "-ots", "LMOTS_SHA256_N32_W8"}
+
+	// A key that another process is creating or using is not replaced.
+	unlock, err := lockKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, code := pqsig(t, "", append([]string{"keygen", "-key", key, "-pub", pub}, lms...)...); code == 0 || !strings.Contains(out, "locked") {
+		t.Errorf("keygen ignored the lock: %s", out)
+	}
+	unlock()
+	if _, err := os.Stat(pub); err == nil {
+		t.Error("keygen wrote the public key without a private key")
+	}
+
+	// A failed keygen leaves no file behind.
+	if _, code := pqsig(t, "", "keygen", "-key", key, "-scheme", "lms", "-type", "LMS_SHA256_M32_H99"); code == 0 {
+		t.Fatal("keygen accepted an unknown parameter set")
+	}
+	if _, err := os.Stat(key); err == nil {
+		t.Error("a failed keygen left a private key file")
+	}
+
+	// The private key is on disk before the public key is written.
+	defer func(f func(string, []byte, os.FileMode) error) { writeFileAtomic = f }(writeFileAtomic)
+	writeFileAtomic = func(string, []byte, os.FileMode) error {
+		if _, err := os.Stat(key); err != nil {
+			t.Error("the public key was written before the private key")
+		}
+		return errors.New("disk full")
+	}
+	if _, code := pqsig(t, "", append([]string{"keygen", "-key", key, "-pub", pub}, lms...)...); code == 0 {
+		t.Error("keygen succeeded although the public key could not be written")
+	}
+	if out, code := pqsig(t, "", "remaining", "-key", key); code != 0 || strings.TrimSpace(out) != "32" {
+		t.Errorf("the private key was not kept: %s", out)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package atomicfile writes files so that a crash leaves either their old or
+// their new contents, and locks them between processes, for the files of
+// private keys and their counters.
+package atomicfile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrLocked is returned by Lock when the lock file of a path exists.
+var ErrLocked = errors.New("atomicfile: locked by another process")
+
+// WriteFile replaces the file at path with data. The data is written to a
+// temporary file in the same directory, synced and renamed over path, and the
+// directory is synced, so that the file holds either the old or the new
+// contents after a crash.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	SyncDir(dir)
+	return nil
+}
+
+// WriteNew writes data to a file just created with O_EXCL, syncs and closes it,
+// and syncs its directory.
+func WriteNew(f *os.File, data []byte) error {
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	SyncDir(filepath.Dir(f.Name()))
+	return nil
+}
+
+// SyncDir makes the creation or renaming of a file in dir durable. Not every
+// platform can sync a directory.
+func SyncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// Lock creates the lock file path + ".lock", waiting up to wait for another
+// process to remove it, and returns a function that removes it. It returns
+// ErrLocked if the lock file still exists after wait.
+func Lock(path string, wait time.Duration) (func(), error) {
+	lock := path + ".lock"
+	deadline := time.Now().Add(wait)
+	for {
+		f, err := os.OpenFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lock) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if !time.Now().Before(deadline) {
+			return nil, ErrLocked
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package parallel spreads the signatures and verifications of many messages
+// over all CPUs for the ldwm and xmss packages.
+package parallel
+
+import (
+	"runtime"
+	"sync"
+)
+
+// SignResult is the result of signing one of the messages passed to SignMany.
+type SignResult struct {
+	// Index of the message in the slice passed to SignMany.
+	Index     int
+	Signature []byte
+	Err       error
+}
+
+// Do calls f for every index from 0 to n-1 on GOMAXPROCS goroutines at most,
+// and returns once every call has returned.
+func Do(n int, f func(i int)) {
+	jobs := make(chan int)
+	workers := runtime.GOMAXPROCS(0)
+	if workers > n {
+		workers = n
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				f(j)
+			}
+		}()
+	}
+	for j := 0; j < n; j++ {
+		jobs <- j
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// SignMany calls sign for every message in parallel and sends the results on
+// the returned channel in the order they complete. The channel is closed once
+// every message has been handled.
+func SignMany(messages [][]byte, sign func([]byte) ([]byte, error)) <-chan SignResult {
+	results := make(chan SignResult, len(messages))
+	go func() {
+		Do(len(messages), func(j int) {
+			sig, err := sign(messages[j])
+			results <- SignResult{Index: j, Signature: sig, Err: err}
+		})
+		close(results)
+	}()
+	return results
+}
@@ -0,0 +1,56 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package parallel
+
+import (
+	"bytes"
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDo(t *testing.T) {
+	for _, n := range []int{0, 1, 7, 1000} {
+		calls := make([]int32, n)
+		Do(n, func(i int) { atomic.AddInt32(&calls[i], 1) })
+		for i, c := range calls {
+			if c != 1 {
+				t.Errorf("Do(%d) called f(%d) %d times", n, i, c)
+			}
+		}
+	}
+}
+
+func TestSignMany(t *testing.T) {
+	messages := [][]byte{[]byte("a"), []byte("b"), nil, []byte("d")}
+	results := SignMany(messages, func(m []byte) ([]byte, error) {
+		if m == nil {
+			return nil, errors.New("empty message")
+		}
+		return append([]byte("sig "), m...), nil
+	})
+	seen := make([]bool, len(messages))
+	for r := range results {
+		if seen[r.Index] {
+			t.Fatalf("message %d signed twice", r.Index)
+		}
+		seen[r.Index] = true
+		if messages[r.Index] == nil {
+			if r.Err == nil {
+				t.Errorf("message %d: missing error", r.Index)
+			}
+		} else if r.Err != nil || !bytes.Equal(r.Signature, append([]byte("sig "), messages[r.Index]...)) {
+			t.Errorf("message %d: got %q, %v", r.Index, r.Signature, r.Err)
+		}
+	}
+	for i, s := range seen {
+		if !s {
+			t.Errorf("message %d was not signed", i)
+		}
+	}
+	if _, ok := <-SignMany(nil, nil); ok {
+		t.Error("SignMany sent a result without messages")
+	}
+}
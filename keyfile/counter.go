@@ -0,0 +1,108 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package keyfile
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lingyunzhao/pqcrypto/internal/atomicfile"
+)
+
+// A MonotonicCounter is a counter that can only be read and incremented, kept
+// apart from the key files it protects: a TPM NV counter, a counter service
+// on another host, or a file on storage that backups of the keys do not
+// restore. Its value starts at 0. Increment must be atomic, so that two
+// callers never see the same new value.
+type MonotonicCounter interface {
+	// Value returns the current value of the counter.
+	Value() (uint64, error)
+	// Increment adds one to the counter and returns its new value.
+	Increment() (uint64, error)
+}
+
+// A MemoryCounter is a MonotonicCounter held in memory, a stand-in for a
+// hardware counter in tests and single-process use. It is safe for
+// concurrent use.
+type MemoryCounter struct {
+	mu    sync.Mutex
+	value uint64
+}
+
+// Value returns the current value of the counter.
+func (c *MemoryCounter) Value() (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.value, nil
+}
+
+// Increment adds one to the counter and returns its new value.
+func (c *MemoryCounter) Increment() (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.value++
+	return c.value, nil
+}
+
+// A FileCounter is a MonotonicCounter stored as a decimal number in a file,
+// which is missing while the value is 0. Increment holds a lock file next to
+// it and replaces the file atomically and durably, syncing its directory, so
+// that processes on the same host can share the counter and a crash never
+// takes it back.
+type FileCounter struct {
+	path string
+}
+
+// NewFileCounter returns a counter stored in the file at path.
+func NewFileCounter(path string) *FileCounter {
+	return &FileCounter{path: path}
+}
+
+// Value returns the current value of the counter.
+func (c *FileCounter) Value() (uint64, error) {
+	data, err := os.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	value, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, errors.New("keyfile: invalid counter file " + c.path)
+	}
+	return value, nil
+}
+
+// Increment adds one to the counter and returns its new value. It waits up to
+// a few seconds for another process to release the lock.
+func (c *FileCounter) Increment() (uint64, error) {
+	unlock, err := c.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	value, err := c.Value()
+	if err != nil {
+		return 0, err
+	}
+	value++
+	if err := atomicfile.WriteFile(c.path, []byte(strconv.FormatUint(value, 10)+"\n"), 0600); err != nil {
+		return 0, err
+	}
+	return value, nil
+}
+
+func (c *FileCounter) lock() (func(), error) {
+	unlock, err := atomicfile.Lock(c.path, 5*time.Second)
+	if err == atomicfile.ErrLocked {
+		return nil, errors.New("keyfile: " + c.path + " is locked; remove " + c.path + ".lock if it is stale")
+	}
+	return unlock, err
+}
@@ -0,0 +1,147 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package keyfile
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	errRolledBack = errors.New("keyfile: the key file is older than its counter; it was rolled back or the key was used elsewhere")
+	errAhead      = errors.New("keyfile: the key file is ahead of its counter")
+)
+
+// A GuardedKey signs with a private key whose every state is sealed into a
+// file and tied to a MonotonicCounter. After each signature it seals the next
+// state with the next generation, passes the file to its save function and
+// increments the counter, and only then returns the signature. Before each
+// signature it checks that the counter still has the generation of its last
+// file, so a copy of the key that another process has used since is refused.
+// It is safe for concurrent use.
+//
+// The private key and the Sealer must not be used directly while they are
+// guarded.
+type GuardedKey struct {
+	mu         sync.Mutex
+	key        PrivateKey
+	sealer     *Sealer
+	counter    MonotonicCounter
+	save       func(file []byte) error
+	generation uint64
+}
+
+// Guard ties a private key and its Sealer to counter, and passes every new key
+// file to save, which should replace the previous one durably.
+//
+// If the Sealer was returned by OpenWithPassphrase or OpenWithKEK, the file it
+// was opened from must have the generation of the counter, and an older file is
+// refused. A file one generation ahead is accepted, and the counter
+// incremented: it was saved by a signature that stopped before incrementing the
+// counter and returning. Otherwise the key is new; Guard saves its first file.
+func Guard(key PrivateKey, sealer *Sealer, counter MonotonicCounter, save func(file []byte) error) (*GuardedKey, error) {
+	value, err := counter.Value()
+	if err != nil {
+		return nil, err
+	}
+	g := &GuardedKey{key: key, sealer: sealer, counter: counter, save: save, generation: value}
+	if !sealer.opened {
+		if err := g.commit(); err != nil {
+			return nil, err
+		}
+		return g, nil
+	}
+	switch {
+	case sealer.generation < value:
+		return nil, errRolledBack
+	case sealer.generation == value+1:
+		if value, err = counter.Increment(); err != nil {
+			return nil, err
+		}
+		if value != sealer.generation {
+			return nil, errRolledBack
+		}
+	case sealer.generation > value:
+		return nil, errAhead
+	}
+	g.generation = sealer.generation
+	return g, nil
+}
+
+// Sign signs a message once the counter confirms that the key has not been
+// used elsewhere, and returns the signature after the next state is saved and
+// the counter incremented.
+func (g *GuardedKey) Sign(message []byte) ([]byte, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.key == nil {
+		return nil, errors.New("keyfile: destroyed key")
+	}
+	value, err := g.counter.Value()
+	if err != nil {
+		return nil, err
+	}
+	if value != g.generation {
+		return nil, errRolledBack
+	}
+	sig, err := g.key.Sign(message)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.commit(); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+// Remaining returns the number of signatures the private key can still generate.
+func (g *GuardedKey) Remaining() uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.key == nil {
+		return 0
+	}
+	return g.key.Remaining()
+}
+
+// Generation returns the generation of the last key file and counter value.
+func (g *GuardedKey) Generation() uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.generation
+}
+
+// Destroy destroys the private key.
+func (g *GuardedKey) Destroy() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.key != nil {
+		g.key.Destroy()
+		g.key = nil
+	}
+}
+
+// commit saves the current state with the next generation and increments the
+// counter to it.
+func (g *GuardedKey) commit() error {
+	file, err := g.sealer.seal(g.key, g.generation+1)
+	if err != nil {
+		return err
+	}
+	if err := g.save(file); err != nil {
+		return err
+	}
+	value, err := g.counter.Increment()
+	if err != nil {
+		return err
+	}
+	if value != g.generation+1 {
+		// Another copy of the key incremented the counter in between.
+		return errRolledBack
+	}
+	g.generation = value
+	g.sealer.generation = value
+	return nil
+}
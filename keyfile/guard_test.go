@@ -0,0 +1,220 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package keyfile
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/lingyunzhao/pqcrypto/ldwm"
+)
+
+// A remoteCounter simulates a counter service: requests are sent to a
+// goroutine that owns the value.
+type remoteCounter struct {
+	requests chan chan uint64
+	values   chan uint64
+}
+
+func newRemoteCounter() *remoteCounter {
+	c := &remoteCounter{requests: make(chan chan uint64), values: make(chan uint64)}
+	go func() {
+		var value uint64
+		for {
+			select {
+			case reply := <-c.requests:
+				value++
+				reply <- value
+			case c.values <- value:
+			}
+		}
+	}()
+	return c
+}
+
+func (c *remoteCounter) Value() (uint64, error) {
+	return <-c.values, nil
+}
+
+func (c *remoteCounter) Increment() (uint64, error) {
+	reply := make(chan uint64)
+	c.requests <- reply
+	return <-reply, nil
+}
+
+// A failingCounter fails its next increment when fail is set.
+type failingCounter struct {
+	MonotonicCounter
+	fail bool
+}
+
+func (c *failingCounter) Increment() (uint64, error) {
+	if c.fail {
+		c.fail = false
+		return 0, errors.New("counter unavailable")
+	}
+	return c.MonotonicCounter.Increment()
+}
+
+func testCounters(t *testing.T) map[string]MonotonicCounter {
+	return map[string]MonotonicCounter{
+		"memory": new(MemoryCounter),
+		"file":   NewFileCounter(filepath.Join(t.TempDir(), "counter")),
+		"remote": newRemoteCounter(),
+	}
+}
+
+func TestGuardRefusesRollback(t *testing.T) {
+	kek := bytes.Repeat([]byte{7}, 32)
+	for name, counter := range testCounters(t) {
+		lmsPriv, _ := ldwm.GenerateLmsPrivateKey(ldwm.LMS_SHA256_M32_H5, ldwm.LMOTS_SHA256_N32_W8)
+		lmsPub, _ := lmsPriv.Public()
+		sealer, _ := NewKEKSealer(kek)
+		var files [][]byte
+		save := func(file []byte) error {
+			files = append(files, file)
+			return nil
+		}
+		g, err := Guard(lmsPriv, sealer, counter, save)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		for i := 0; i < 3; i++ {
+			sig, err := g.Sign([]byte("message"))
+			if err != nil || lmsPub.Verify([]byte("message"), sig) != nil {
+				t.Fatalf("%s: invalid signature %d: %v", name, i, err)
+			}
+		}
+		if len(files) != 4 || g.Generation() != 4 {
+			t.Fatalf("%s: %d files saved up to generation %d, want 4", name, len(files), g.Generation())
+		}
+
+		// Only the latest file can be loaded.
+		for i, file := range files {
+			key, sealer, err := OpenWithKEK(file, kek)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = Guard(key, sealer, counter, save)
+			if i < len(files)-1 && err == nil {
+				t.Errorf("%s: loaded the rolled-back file of generation %d", name, i+1)
+			}
+			if i == len(files)-1 && err != nil {
+				t.Errorf("%s: failed to load the latest file: %v", name, err)
+			}
+		}
+	}
+}
+
+func TestGuardRefusesClone(t *testing.T) {
+	kek := bytes.Repeat([]byte{7}, 32)
+	for name, counter := range testCounters(t) {
+		lmsPriv, _ := ldwm.GenerateLmsPrivateKey(ldwm.LMS_SHA256_M32_H5, ldwm.LMOTS_SHA256_N32_W8)
+		sealer, _ := NewKEKSealer(kek)
+		var latest []byte
+		save := func(file []byte) error {
+			latest = file
+			return nil
+		}
+		if _, err := Guard(lmsPriv, sealer, counter, save); err != nil {
+			t.Fatal(err)
+		}
+
+		// Two processes load the same file; once one has signed, the other
+		// may no longer sign.
+		file := latest
+		key1, sealer1, _ := OpenWithKEK(file, kek)
+		key2, sealer2, _ := OpenWithKEK(file, kek)
+		g1, err1 := Guard(key1, sealer1, counter, save)
+		g2, err2 := Guard(key2, sealer2, counter, save)
+		if err1 != nil || err2 != nil {
+			t.Fatalf("%s: %v, %v", name, err1, err2)
+		}
+		if _, err := g1.Sign([]byte("message")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := g2.Sign([]byte("message")); err == nil {
+			t.Errorf("%s: signed with a clone of the key", name)
+		}
+		if _, err := g1.Sign([]byte("message")); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestGuardCompletesInterruptedSignature(t *testing.T) {
+	kek := bytes.Repeat([]byte{7}, 32)
+	lmsPriv, _ := ldwm.GenerateLmsPrivateKey(ldwm.LMS_SHA256_M32_H5, ldwm.LMOTS_SHA256_N32_W8)
+	sealer, _ := NewKEKSealer(kek)
+	counter := &failingCounter{MonotonicCounter: new(MemoryCounter)}
+	var latest []byte
+	save := func(file []byte) error {
+		latest = file
+		return nil
+	}
+	g, _ := Guard(lmsPriv, sealer, counter, save)
+
+	// The state after the signature is saved, but the counter is not
+	// incremented and the signature never returned.
+	counter.fail = true
+	if _, err := g.Sign([]byte("message")); err == nil {
+		t.Fatal("returned a signature without incrementing the counter")
+	}
+	key, sealer, _ := OpenWithKEK(latest, kek)
+	g, err := Guard(key, sealer, counter, save)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := counter.Value(); value != 2 || g.Generation() != 2 {
+		t.Errorf("counter = %d, generation = %d after loading; want 2", value, g.Generation())
+	}
+	if g.Remaining() != 31 {
+		t.Errorf("Remaining() = %d, want 31", g.Remaining())
+	}
+	if _, err := g.Sign([]byte("message")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFileCounter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counter")
+	if value, err := NewFileCounter(path).Value(); err != nil || value != 0 {
+		t.Fatalf("new counter = %d, %v", value, err)
+	}
+	// Increments from several processes, simulated by separate counters on
+	// the same file, are never lost.
+	var wg sync.WaitGroup
+	seen := make(chan uint64, 40)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := NewFileCounter(path)
+			for j := 0; j < 10; j++ {
+				value, err := c.Increment()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				seen <- value
+			}
+		}()
+	}
+	wg.Wait()
+	close(seen)
+	values := make(map[uint64]bool)
+	for value := range seen {
+		if values[value] {
+			t.Errorf("value %d returned twice", value)
+		}
+		values[value] = true
+	}
+	if value, _ := NewFileCounter(path).Value(); value != 40 {
+		t.Errorf("counter = %d after 40 increments", value)
+	}
+}
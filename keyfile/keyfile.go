@@ -0,0 +1,335 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package keyfile stores the private keys of the ldwm and xmss packages in
+// encrypted files.
+//
+// The key of a file is either derived from a passphrase with scrypt or
+// supplied by the caller as a key-encryption key (KEK). Either way it only
+// wraps a random data key, from which the AES-256-GCM key of each file is
+// derived. A Sealer keeps the data key, so that the key can be sealed again after each
+// signature without running scrypt or touching the KEK. The header of a file,
+// including the number of signatures the key has left and the generation of
+// the state, is authenticated along with the key, and a file whose header or
+// contents were modified does not open.
+//
+// Each file a Sealer writes has the next generation. Guard checks it against a
+// MonotonicCounter so that an old copy of a file, or a second copy of a key in
+// use elsewhere, is refused instead of reusing one-time keys.
+package keyfile
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+
+	"github.com/lingyunzhao/pqcrypto/ldwm"
+	"github.com/lingyunzhao/pqcrypto/xmss"
+	"golang.org/x/crypto/scrypt"
+)
+
+// A file is laid out as
+//
+//	magic "PQSK" || version (1 byte) || mode (1 byte) || [salt (16 bytes) || log2 N || r || p]
+//	|| nonce (12 bytes) || wrapped data key (48 bytes)
+//	|| scheme (1 byte) || remaining (8 bytes) || generation (8 bytes) || salt (12 bytes)
+//	|| encrypted key
+//
+// where the scrypt parameters are only present in passphrase mode. The data key
+// is wrapped with the authenticated data of everything before its nonce. The
+// private key, as serialized by MarshalPrivate, is encrypted with the
+// authenticated data of everything before it, under the key
+// HMAC-SHA256(data key, salt || generation) and the nonce 0^32 || generation,
+// so that no GCM key seals more than one file whatever the number of files a
+// data key seals.
+
+const (
+	version        = 1
+	modePassphrase = 1
+	modeKEK        = 2
+
+	saltLength     = 16
+	fileSaltLength = 12
+	keyLength      = 32
+)
+
+var magic = []byte("PQSK")
+
+// The schemes of the private keys in a file.
+const (
+	schemeLMS    = 1
+	schemeHSS    = 2
+	schemeXMSS   = 3
+	schemeXMSSMT = 4
+)
+
+// scrypt parameters of new passphrase files: N = 2^15, r = 8 and p = 1.
+var (
+	scryptLogN byte = 15
+	scryptR    byte = 8
+	scryptP    byte = 1
+)
+
+// A PrivateKey is one of *ldwm.LmsPrivateKey, *ldwm.HssPrivateKey, *xmss.SK and
+// *xmss.MTSK.
+type PrivateKey interface {
+	Sign(message []byte) ([]byte, error)
+	MarshalPrivate() ([]byte, error)
+	Remaining() uint64
+	Destroy()
+}
+
+// A Sealer encrypts private keys into files that share its passphrase or KEK
+// and data key.
+type Sealer struct {
+	// header holds the fields of a file up to the wrapped data key.
+	header  []byte
+	dataKey []byte
+	// generation is that of the last file written or opened.
+	generation uint64
+	opened     bool
+}
+
+// NewPassphraseSealer returns a Sealer for new files whose key is derived from
+// passphrase with a new random salt.
+func NewPassphraseSealer(passphrase []byte) (*Sealer, error) {
+	salt := make([]byte, saltLength)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	prefix := bytes.Join([][]byte{magic, {version, modePassphrase}, salt, {scryptLogN, scryptR, scryptP}}, nil)
+	kek, err := deriveKEK(passphrase, salt, scryptLogN, scryptR, scryptP)
+	if err != nil {
+		return nil, err
+	}
+	defer zeroize(kek)
+	return newSealer(prefix, kek)
+}
+
+// NewKEKSealer returns a Sealer for new files whose data key is wrapped with
+// kek, a 32-byte AES key.
+func NewKEKSealer(kek []byte) (*Sealer, error) {
+	if len(kek) != keyLength {
+		return nil, errors.New("keyfile: the KEK must be 32 bytes long")
+	}
+	return newSealer(append(append([]byte(nil), magic...), version, modeKEK), kek)
+}
+
+// newSealer wraps a new random data key with kek.
+func newSealer(prefix []byte, kek []byte) (*Sealer, error) {
+	dataKey := make([]byte, keyLength)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+	defer zeroize(dataKey)
+	wrap, err := newAEAD(kek)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, wrap.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	header := append(append(prefix, nonce...), wrap.Seal(nil, nonce, dataKey, prefix)...)
+	return &Sealer{header: header, dataKey: append([]byte(nil), dataKey...)}, nil
+}
+
+// Seal encrypts the current state of a private key with the next generation.
+// It only costs an AES-GCM encryption of the key, so it can be called after
+// every signature; each call encrypts with a new key derived from the data key.
+func (s *Sealer) Seal(key PrivateKey) ([]byte, error) {
+	file, err := s.seal(key, s.generation+1)
+	if err != nil {
+		return nil, err
+	}
+	s.generation++
+	return file, nil
+}
+
+// Generation returns the generation of the last file the Sealer wrote or, if
+// it wrote none, of the file it was opened from.
+func (s *Sealer) Generation() uint64 {
+	return s.generation
+}
+
+func (s *Sealer) seal(key PrivateKey, generation uint64) ([]byte, error) {
+	var scheme byte
+	switch key.(type) {
+	case *ldwm.LmsPrivateKey:
+		scheme = schemeLMS
+	case *ldwm.HssPrivateKey:
+		scheme = schemeHSS
+	case *xmss.SK:
+		scheme = schemeXMSS
+	case *xmss.MTSK:
+		scheme = schemeXMSSMT
+	default:
+		return nil, errors.New("keyfile: unknown private key type")
+	}
+	plaintext, err := key.MarshalPrivate()
+	if err != nil {
+		return nil, err
+	}
+	defer zeroize(plaintext)
+
+	ad := append(append([]byte(nil), s.header...), scheme)
+	ad = binary.BigEndian.AppendUint64(ad, key.Remaining())
+	ad = binary.BigEndian.AppendUint64(ad, generation)
+	salt := make([]byte, fileSaltLength)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	ad = append(ad, salt...)
+	aead, err := fileAEAD(s.dataKey, salt, generation)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(ad, fileNonce(generation), plaintext, ad), nil
+}
+
+// OpenWithPassphrase decrypts a file written by a Sealer from
+// NewPassphraseSealer. It returns the private key and a Sealer that writes its
+// next states with the same passphrase and data key. It cannot tell an old file
+// from the latest one; Guard can.
+func OpenWithPassphrase(file []byte, passphrase []byte) (PrivateKey, *Sealer, error) {
+	invalid := errors.New("keyfile: invalid key file")
+	if len(file) < len(magic)+2 || file[len(magic)+1] != modePassphrase {
+		return nil, nil, invalid
+	}
+	params := file[len(magic)+2:]
+	if len(params) < saltLength+3 {
+		return nil, nil, invalid
+	}
+	salt := params[:saltLength]
+	logN, r, p := params[saltLength], params[saltLength+1], params[saltLength+2]
+	// Refuse parameters that would take unreasonable time or memory.
+	if logN < 10 || logN > 22 || r < 1 || r > 32 || p < 1 || p > 16 {
+		return nil, nil, invalid
+	}
+	kek, err := deriveKEK(passphrase, salt, logN, r, p)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer zeroize(kek)
+	return open(file, len(magic)+2+saltLength+3, kek)
+}
+
+// OpenWithKEK decrypts a file written by a Sealer from NewKEKSealer. It returns
+// the private key and a Sealer that writes its next states with the same KEK
+// and data key. It cannot tell an old file from the latest one; Guard can.
+func OpenWithKEK(file []byte, kek []byte) (PrivateKey, *Sealer, error) {
+	if len(file) < len(magic)+2 || file[len(magic)+1] != modeKEK {
+		return nil, nil, errors.New("keyfile: invalid key file")
+	}
+	if len(kek) != keyLength {
+		return nil, nil, errors.New("keyfile: the KEK must be 32 bytes long")
+	}
+	return open(file, len(magic)+2, kek)
+}
+
+// open unwraps the data key of a file whose wrapping nonce starts at offset,
+// and decrypts the private key.
+func open(file []byte, offset int, kek []byte) (PrivateKey, *Sealer, error) {
+	invalid := errors.New("keyfile: invalid key file")
+	if !bytes.Equal(file[:len(magic)], magic) || file[len(magic)] != version {
+		return nil, nil, invalid
+	}
+	stateLength := 1 + 8 + 8 + fileSaltLength
+	wrap, err := newAEAD(kek)
+	if err != nil {
+		return nil, nil, err
+	}
+	headerLength := offset + wrap.NonceSize() + keyLength + wrap.Overhead()
+	if len(file) < headerLength+stateLength {
+		return nil, nil, invalid
+	}
+	dataKey, err := wrap.Open(nil, file[offset:offset+wrap.NonceSize()], file[offset+wrap.NonceSize():headerLength], file[:offset])
+	if err != nil {
+		return nil, nil, errors.New("keyfile: wrong passphrase or KEK, or modified key file")
+	}
+	defer zeroize(dataKey)
+
+	scheme := file[headerLength]
+	remaining := binary.BigEndian.Uint64(file[headerLength+1:])
+	generation := binary.BigEndian.Uint64(file[headerLength+9:])
+	adLength := headerLength + stateLength
+	aead, err := fileAEAD(dataKey, file[headerLength+17:adLength], generation)
+	if err != nil {
+		return nil, nil, err
+	}
+	plaintext, err := aead.Open(nil, fileNonce(generation), file[adLength:], file[:adLength])
+	if err != nil {
+		return nil, nil, errors.New("keyfile: modified key file")
+	}
+	defer zeroize(plaintext)
+
+	key, err := parse(scheme, plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+	if key.Remaining() != remaining {
+		key.Destroy()
+		return nil, nil, invalid
+	}
+	header := append([]byte(nil), file[:headerLength]...)
+	return key, &Sealer{header: header, dataKey: append([]byte(nil), dataKey...), generation: generation, opened: true}, nil
+}
+
+// parse parses a private key serialized by MarshalPrivate.
+func parse(scheme byte, b []byte) (PrivateKey, error) {
+	keyHex := hex.EncodeToString(b)
+	switch scheme {
+	case schemeLMS:
+		return ldwm.ParseLmsPrivateKey(keyHex)
+	case schemeHSS:
+		return ldwm.ParseHssPrivateKey(keyHex)
+	case schemeXMSS:
+		return xmss.ParseSK(keyHex)
+	case schemeXMSSMT:
+		return xmss.ParseMTSK(keyHex)
+	}
+	return nil, errors.New("keyfile: unknown private key type")
+}
+
+func deriveKEK(passphrase []byte, salt []byte, logN byte, r byte, p byte) ([]byte, error) {
+	return scrypt.Key(passphrase, salt, 1<<logN, int(r), int(p), keyLength)
+}
+
+// fileAEAD returns the AES-256-GCM cipher of the file with the given salt and
+// generation.
+func fileAEAD(dataKey []byte, salt []byte, generation uint64) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, dataKey)
+	mac.Write(salt)
+	mac.Write(binary.BigEndian.AppendUint64(nil, generation))
+	key := mac.Sum(nil)
+	defer zeroize(key)
+	return newAEAD(key)
+}
+
+// fileNonce returns the GCM nonce of the file with the given generation.
+func fileNonce(generation uint64) []byte {
+	return binary.BigEndian.AppendUint64(make([]byte, 4), generation)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// zeroize overwrites b with zeros.
+func zeroize(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package keyfile
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/lingyunzhao/pqcrypto/ldwm"
+	"github.com/lingyunzhao/pqcrypto/xmss"
+)
+
+// A signer is a private key of any scheme.
+type signer interface {
+	PrivateKey
+	Sign(message []byte) ([]byte, error)
+}
+
+func testKeys(t *testing.T) map[string]signer {
+	lmsPriv, err := ldwm.GenerateLmsPrivateKey(ldwm.LMS_SHA256_M32_H5, ldwm.LMOTS_SHA256_N32_W8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hssPriv, err := ldwm.GenerateHssPrivateKey(ldwm.LMS_SHA256_M32_H5, ldwm.LMOTS_SHA256_N32_W8, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xsk, _, err := xmss.KeyGen(xmss.XMSSSHA2H10W256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mtsk, _, err := xmss.MTkeyGen(xmss.XMSSMTSHA2H20D4W256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]signer{"LMS": lmsPriv, "HSS": hssPriv, "XMSS": xsk, "XMSS^MT": mtsk}
+}
+
+func marshal(t *testing.T, key PrivateKey) []byte {
+	b, err := key.MarshalPrivate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestSealAndOpen(t *testing.T) {
+	kek := bytes.Repeat([]byte{7}, 32)
+	passphraseSealer, err := NewPassphraseSealer([]byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kekSealer, err := NewKEKSealer(kek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	open := map[*Sealer]func([]byte) (PrivateKey, *Sealer, error){
+		passphraseSealer: func(file []byte) (PrivateKey, *Sealer, error) {
+			return OpenWithPassphrase(file, []byte("passphrase"))
+		},
+		kekSealer: func(file []byte) (PrivateKey, *Sealer, error) { return OpenWithKEK(file, kek) },
+	}
+
+	for name, key := range testKeys(t) {
+		for sealer, open := range open {
+			file, err := sealer.Seal(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 3; i++ {
+				opened, next, err := open(file)
+				if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				if !bytes.Equal(marshal(t, opened), marshal(t, key)) {
+					t.Fatalf("%s: opened key differs from the sealed one", name)
+				}
+				if _, err := key.Sign([]byte("message")); err != nil {
+					t.Fatal(err)
+				}
+				// The state after a signature is sealed with the data key of
+				// the file; only the encrypted key changes.
+				if file, err = next.Seal(key); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(file[:len(sealer.header)], sealer.header) {
+					t.Fatalf("%s: sealing after a signature changed the header", name)
+				}
+			}
+		}
+	}
+}
+
+func TestOpenRejectsModifiedFile(t *testing.T) {
+	lmsPriv, _ := ldwm.GenerateLmsPrivateKey(ldwm.LMS_SHA256_M32_H5, ldwm.LMOTS_SHA256_N32_W8)
+	kek := bytes.Repeat([]byte{7}, 32)
+	sealer, _ := NewKEKSealer(kek)
+	file, _ := sealer.Seal(lmsPriv)
+
+	// Flipping any bit, including those of the remaining signatures in the
+	// header, is detected.
+	for i := range file {
+		modified := append([]byte(nil), file...)
+		modified[i] ^= 1
+		if _, _, err := OpenWithKEK(modified, kek); err == nil {
+			t.Errorf("opened a file modified at byte %d", i)
+		}
+	}
+	for _, b := range [][]byte{nil, file[:20], file[:len(file)-1], append(append([]byte(nil), file...), 0)} {
+		if _, _, err := OpenWithKEK(b, kek); err == nil {
+			t.Errorf("opened %x", b)
+		}
+	}
+	if _, _, err := OpenWithKEK(file, bytes.Repeat([]byte{8}, 32)); err == nil {
+		t.Error("opened a file with the wrong KEK")
+	}
+	if _, _, err := OpenWithPassphrase(file, []byte("passphrase")); err == nil {
+		t.Error("opened a KEK file with a passphrase")
+	}
+
+	passphraseSealer, _ := NewPassphraseSealer([]byte("passphrase"))
+	file, _ = passphraseSealer.Seal(lmsPriv)
+	if _, _, err := OpenWithPassphrase(file, []byte("wrong")); err == nil {
+		t.Error("opened a file with the wrong passphrase")
+	}
+	// An old state cannot be given the header of a newer one.
+	lmsPriv.Sign([]byte("message"))
+	newer, _ := passphraseSealer.Seal(lmsPriv)
+	spliced := append(append([]byte(nil), newer[:len(passphraseSealer.header)+17]...), file[len(passphraseSealer.header)+17:]...)
+	if _, _, err := OpenWithPassphrase(spliced, []byte("passphrase")); err == nil {
+		t.Error("opened an old state with the header of a newer one")
+	}
+}
+
+func TestSealDerivesFileKeys(t *testing.T) {
+	lmsPriv, _ := ldwm.GenerateLmsPrivateKey(ldwm.LMS_SHA256_M32_H5, ldwm.LMOTS_SHA256_N32_W8)
+	kek := bytes.Repeat([]byte{7}, 32)
+	sealer, _ := NewKEKSealer(kek)
+	file, _ := sealer.Seal(lmsPriv)
+
+	// Two copies of a file seal the same generation under different keys.
+	_, first, _ := OpenWithKEK(file, kek)
+	_, second, _ := OpenWithKEK(file, kek)
+	a, _ := first.Seal(lmsPriv)
+	b, _ := second.Seal(lmsPriv)
+	stateLength := 1 + 8 + 8 + fileSaltLength
+	if first.Generation() != second.Generation() || bytes.Equal(a[len(sealer.header)+17:], b[len(sealer.header)+17:]) {
+		t.Fatal("two files of the same generation share their salt or ciphertext")
+	}
+	for _, f := range [][]byte{a, b} {
+		adLength := len(sealer.header) + stateLength
+		aead, _ := fileAEAD(sealer.dataKey, f[adLength-fileSaltLength:adLength], 2)
+		if _, err := aead.Open(nil, fileNonce(2), f[adLength:], f[:adLength]); err != nil {
+			t.Error("file not encrypted with the key and nonce of its salt and generation")
+		}
+		if _, _, err := OpenWithKEK(f, kek); err != nil {
+			t.Error(err)
+		}
+	}
+}
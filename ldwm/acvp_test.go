@@ -0,0 +1,193 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ldwm
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The ACVP harness runs the LMS keyGen, sigGen and sigVer vector sets in
+// testdata/acvp through the package API. A vector set is a JSON file holding
+// both the prompts and the expected results, like the internalProjection.json
+// files of the ACVP server, either bare or wrapped in the [{"acvVersion"},
+// {...}] array of the ACVP protocol. Every test case runs as a subtest named
+// after its tgId and tcId, so go test -v -run TestACVP reports each case.
+// Parameter sets the package does not implement are skipped.
+
+// acvpHex is a byte string encoded in hexadecimal.
+type acvpHex []byte
+
+func (b *acvpHex) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	x, err := hex.DecodeString(s)
+	*b = x
+	return err
+}
+
+type acvpVectorSet struct {
+	Algorithm  string           `json:"algorithm"`
+	Mode       string           `json:"mode"`
+	TestGroups []*acvpTestGroup `json:"testGroups"`
+}
+
+type acvpTestGroup struct {
+	TgID      int             `json:"tgId"`
+	TestType  string          `json:"testType"`
+	LmsMode   string          `json:"lmsMode"`
+	LmOtsMode string          `json:"lmOtsMode"`
+	Seed      acvpHex         `json:"seed"`
+	I         acvpHex         `json:"i"`
+	PublicKey acvpHex         `json:"publicKey"`
+	Tests     []*acvpTestCase `json:"tests"`
+}
+
+type acvpTestCase struct {
+	TcID       int     `json:"tcId"`
+	Seed       acvpHex `json:"seed"`
+	I          acvpHex `json:"i"`
+	Q          int     `json:"q"`
+	PublicKey  acvpHex `json:"publicKey"`
+	Message    acvpHex `json:"message"`
+	Signature  acvpHex `json:"signature"`
+	TestPassed *bool   `json:"testPassed"`
+	Reason     string  `json:"reason"`
+}
+
+func readACVPVectorSet(path string) (*acvpVectorSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var wrapped []json.RawMessage
+	if json.Unmarshal(data, &wrapped) == nil {
+		if len(wrapped) != 2 {
+			return nil, fmt.Errorf("%s: unexpected ACVP array of length %d", path, len(wrapped))
+		}
+		data = wrapped[1]
+	}
+	vs := new(acvpVectorSet)
+	if err := json.Unmarshal(data, vs); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return vs, nil
+}
+
+// acvpTypecode returns the typecode with the given name.
+func acvpTypecode(names map[uint]string, name string) (uint, bool) {
+	for typecode, n := range names {
+		if n == name {
+			return typecode, true
+		}
+	}
+	return 0, false
+}
+
+func TestACVP(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "acvp", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		vs, err := readACVPVectorSet(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if vs.Algorithm != "LMS" {
+			continue
+		}
+		t.Run(strings.TrimSuffix(filepath.Base(path), ".json"), func(t *testing.T) {
+			for _, tg := range vs.TestGroups {
+				for _, tc := range tg.Tests {
+					tg, tc := tg, tc
+					t.Run(fmt.Sprintf("tgId=%d/tcId=%d", tg.TgID, tc.TcID), func(t *testing.T) {
+						runACVPTestCase(t, vs.Mode, tg, tc)
+					})
+				}
+			}
+		})
+	}
+}
+
+func runACVPTestCase(t *testing.T, mode string, tg *acvpTestGroup, tc *acvpTestCase) {
+	lmsTypecode, ok := acvpTypecode(lmsTypeNames, tg.LmsMode)
+	if !ok {
+		t.Skipf("unsupported LMS mode %s", tg.LmsMode)
+	}
+	otsTypecode, ok := acvpTypecode(otsTypeNames, tg.LmOtsMode)
+	if !ok {
+		t.Skipf("unsupported LM-OTS mode %s", tg.LmOtsMode)
+	}
+	seed, I := tc.Seed, tc.I
+	if seed == nil {
+		seed, I = tg.Seed, tg.I
+	}
+
+	switch mode {
+	case "keyGen":
+		lmsPriv, err := parseLmsPrivateKey(bytes.Join([][]byte{u32Str(int(lmsTypecode)), u32Str(int(otsTypecode)), u32Str(0), I, seed}, []byte("")), useDefaultK)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lmsPub, _ := lmsPriv.Public()
+		if !bytes.Equal(lmsPub.serialize(), tc.PublicKey) {
+			t.Errorf("public key = %x, want %x", lmsPub.serialize(), []byte(tc.PublicKey))
+		}
+
+	case "sigGen":
+		if seed == nil {
+			// The IUT picks the key, so the signature is checked with its own public key.
+			lmsPriv, err := GenerateLmsPrivateKey(lmsTypecode, otsTypecode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lmsPub, _ := lmsPriv.Public()
+			sig, err := lmsPriv.Sign(tc.Message)
+			if err != nil || lmsPub.Verify(tc.Message, sig) != nil {
+				t.Errorf("failed to sign and verify: %v", err)
+			}
+			return
+		}
+		lmsPriv, err := parseLmsPrivateKey(bytes.Join([][]byte{u32Str(int(lmsTypecode)), u32Str(int(otsTypecode)), u32Str(tc.Q), I, seed}, []byte("")), useDefaultK)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, err := lmsPriv.Sign(tc.Message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(sig, tc.Signature) {
+			t.Errorf("signature = %x, want %x", sig, []byte(tc.Signature))
+		}
+
+	case "sigVer":
+		publicKey := tc.PublicKey
+		if publicKey == nil {
+			publicKey = tg.PublicKey
+		}
+		if tc.TestPassed == nil {
+			t.Fatal("missing testPassed")
+		}
+		lmsPub, err := parseLmsPublicKey(publicKey)
+		if err == nil {
+			err = lmsPub.Verify(tc.Message, tc.Signature)
+		}
+		if (err == nil) != *tc.TestPassed {
+			t.Errorf("verification error = %v, want testPassed = %v (%s)", err, *tc.TestPassed, tc.Reason)
+		}
+
+	default:
+		t.Skipf("unsupported mode %s", mode)
+	}
+}
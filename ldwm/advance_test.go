@@ -0,0 +1,103 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ldwm
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLmsAdvanceTo(t *testing.T) {
+	lmsPriv, _ := GenerateLmsPrivateKeyWithRand(LMS_SHA256_M32_H10, LMOTS_SHA256_N32_W2, newTestRand("advance"))
+	lmsPub, _ := lmsPriv.Public()
+	key, _ := lmsPriv.MarshalPrivate()
+
+	// The signatures are deterministic, so a key moved forward signs exactly as
+	// one that signed its way there.
+	for _, q := range []int{0, 1, 300, 301, 512, 1023} {
+		advanced, _ := parseLmsPrivateKey(key, useDefaultK)
+		if err := advanced.AdvanceTo(uint64(q)); err != nil {
+			t.Fatal(err)
+		}
+		for lmsPriv.q < q {
+			lmsPriv.Sign([]byte("skipped"))
+		}
+		sig, err := advanced.Sign([]byte("message"))
+		if err != nil || lmsPub.Verify([]byte("message"), sig) != nil {
+			t.Fatalf("invalid signature after AdvanceTo(%d): %v", q, err)
+		}
+		want, _ := lmsPriv.Sign([]byte("message"))
+		if !bytes.Equal(sig, want) {
+			t.Errorf("AdvanceTo(%d) signed differently from a key that signed %d messages", q, q)
+		}
+	}
+
+	if err := lmsPriv.AdvanceTo(10); err == nil {
+		t.Error("AdvanceTo moved the key backward")
+	}
+	if err := lmsPriv.AdvanceTo(1025); err == nil {
+		t.Error("AdvanceTo moved past the last leaf")
+	}
+	if err := lmsPriv.AdvanceTo(1024); err != nil || lmsPriv.Remaining() != 0 {
+		t.Errorf("AdvanceTo(1024) did not exhaust the key: %v", err)
+	}
+	if _, err := lmsPriv.Sign([]byte("message")); err == nil {
+		t.Error("signed with an exhausted key")
+	}
+}
+
+func TestHssAdvanceTo(t *testing.T) {
+	hssPriv1, _ := GenerateHssPrivateKeyWithRand(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8, 3, newTestRand("advance"))
+	hssPriv2, _ := GenerateHssPrivateKeyWithRand(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8, 3, newTestRand("advance"))
+	hssPub := hssPriv1.Public()
+
+	// Moving within a bottom tree, or to the next one, reads the same new trees
+	// from rand as signing does.
+	for _, idx := range []uint64{5, 31, 32, 33, 60} {
+		if err := hssPriv1.AdvanceTo(idx); err != nil {
+			t.Fatal(err)
+		}
+		for hssPriv2.Remaining() > 1<<15-idx {
+			hssPriv2.Sign([]byte("skipped"))
+		}
+		sig, err := hssPriv1.Sign([]byte("message"))
+		if err != nil || hssPub.Verify([]byte("message"), sig) != nil {
+			t.Fatalf("invalid signature after AdvanceTo(%d): %v", idx, err)
+		}
+		want, _ := hssPriv2.Sign([]byte("message"))
+		if !bytes.Equal(sig, want) {
+			t.Errorf("AdvanceTo(%d) signed differently from a key that signed %d messages", idx, idx)
+		}
+	}
+
+	// Further jumps replace the trees below the layers that move.
+	for _, idx := range []uint64{1000, 1024, 5000, 1<<15 - 1} {
+		if err := hssPriv1.AdvanceTo(idx); err != nil {
+			t.Fatal(err)
+		}
+		if hssPriv1.Remaining() != 1<<15-idx {
+			t.Fatalf("Remaining() = %d after AdvanceTo(%d)", hssPriv1.Remaining(), idx)
+		}
+		sig, err := hssPriv1.Sign([]byte("message"))
+		if err != nil || hssPub.Verify([]byte("message"), sig) != nil {
+			t.Fatalf("invalid signature after AdvanceTo(%d): %v", idx, err)
+		}
+		s, _ := ParseHssSignature(sig)
+		lms := s.LmsSignatures()
+		got := uint64(lms[0].Q())<<10 | uint64(lms[1].Q())<<5 | uint64(lms[2].Q())
+		if got != idx {
+			t.Errorf("AdvanceTo(%d) signed with index %d", idx, got)
+		}
+	}
+	if err := hssPriv1.AdvanceTo(100); err == nil {
+		t.Error("AdvanceTo moved the key backward")
+	}
+	if err := hssPriv1.AdvanceTo(1<<15 + 1); err == nil {
+		t.Error("AdvanceTo moved past the last signature")
+	}
+	if _, err := hssPriv1.Sign([]byte("message")); err == nil {
+		t.Error("signed with an exhausted key")
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ldwm
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+)
+
+// A backup holds the parameter set, the identifier I, the SEED and the index of
+// a private key:
+//
+//	LMS: lmsTypecode (4 bytes) || otsTypecode (4 bytes) || q (4 bytes) || I || SEED
+//	HSS: L (4 bytes) || LMS backup of the top tree
+//
+// The LMS backup has the layout of a serialized LMS private key. The trees below
+// the top one of an HSS key are derived from it, so RestoreHssPrivateKey
+// derives those that the leaf q of the top tree signs.
+//
+// The index is a high-water mark: Backup(reserve) records an index that stays
+// ahead of the key for reserve more signatures, so that one backup can be
+// written ahead of a batch of signatures and a key restored from it never
+// reuses one of their leaves.
+
+// Returns a seed-only backup of the private key whose index is q plus reserve.
+// It contains the secret seed and must be protected like the key itself;
+// RestoreLmsPrivateKey rebuilds the key from it.
+func (lmsPriv *LmsPrivateKey) Backup(reserve uint64) ([]byte, error) {
+	if len(lmsPriv.skSeed) != HashLength {
+		return nil, errors.New("lms: invalid LMS private key")
+	}
+	leaves := uint64(powInt(2, lmsPriv.height))
+	q := leaves
+	if uint64(lmsPriv.q) < leaves && reserve < leaves-uint64(lmsPriv.q) {
+		q = uint64(lmsPriv.q) + reserve
+	}
+	return lmsBackup(lmsPriv, int(q)), nil
+}
+
+func lmsBackup(lmsPriv *LmsPrivateKey, q int) []byte {
+	return bytes.Join([][]byte{u32Str(int(lmsPriv.lmsTypecode)), u32Str(int(lmsPriv.otsTypecode)),
+		u32Str(q), lmsPriv.id, lmsPriv.skSeed}, []byte(""))
+}
+
+// Rebuilds an LMS private key from a backup written by LmsPrivateKey.Backup. The
+// key is ready to sign with the leaf q of the backup.
+func RestoreLmsPrivateKey(backup []byte) (*LmsPrivateKey, error) {
+	if len(backup) != 4+4+4+IdentifierLength+HashLength {
+		return nil, errors.New("lms: invalid LMS backup")
+	}
+	lmsType, err := lmsParams(uint(strTou32(backup[:4])))
+	if err != nil {
+		return nil, errors.New("lms: invalid LMS backup")
+	}
+	if strTou32(backup[8:12]) >= powInt(2, lmsType.h) {
+		return nil, errors.New("lms: no signatures left in the LMS backup")
+	}
+	lmsPriv, err := parseLmsPrivateKey(backup, useDefaultK)
+	if err != nil {
+		return nil, errors.New("lms: invalid LMS backup")
+	}
+	return lmsPriv, nil
+}
+
+// Returns a seed-only backup of the private key. It records the top tree and
+// the first of its leaves that has not signed a tree below it yet, or that will
+// not have after reserve more signatures. It contains the secret seed of the
+// top tree and must be protected like the key itself; RestoreHssPrivateKey
+// rebuilds the key from it.
+func (hssPriv *HssPrivateKey) Backup(reserve uint64) ([]byte, error) {
+	if hssPriv.layer < 1 || len(hssPriv.lmsPriv) != hssPriv.layer {
+		return nil, errors.New("hss: invalid HSS private key")
+	}
+	for _, lmsPriv := range hssPriv.lmsPriv {
+		if len(lmsPriv.skSeed) != HashLength {
+			return nil, errors.New("hss: invalid HSS private key")
+		}
+	}
+	top := hssPriv.lmsPriv[0]
+	if hssPriv.layer == 1 {
+		backup, _ := top.Backup(reserve)
+		return append(u32Str(1), backup...), nil
+	}
+
+	// Count the signatures the current child of the top tree can still make, as
+	// Remaining does for the whole key.
+	total := new(big.Int)
+	used := new(big.Int)
+	for i, lmsPriv := range hssPriv.lmsPriv[1:] {
+		q := lmsPriv.q
+		if i < len(hssPriv.lmsPriv)-2 {
+			q--
+		}
+		total.Lsh(total.SetInt64(1), uint(lmsPriv.height*(i+1)))
+		used.Lsh(used, uint(lmsPriv.height)).Add(used, big.NewInt(int64(q)))
+	}
+	left := new(big.Int).Sub(total, used)
+
+	// The leaves past q are needed once the child is exhausted, one for every
+	// further child tree.
+	q := new(big.Int).SetInt64(int64(top.q))
+	if extra := new(big.Int).SetUint64(reserve); extra.Cmp(left) > 0 {
+		extra.Sub(extra, left)
+		extra.Add(extra, total).Sub(extra, big.NewInt(1)).Div(extra, total)
+		q.Add(q, extra)
+	}
+	if leaves := big.NewInt(int64(powInt(2, top.height))); q.Cmp(leaves) > 0 {
+		q = leaves
+	}
+	return append(u32Str(hssPriv.layer), lmsBackup(top, int(q.Int64()))...), nil
+}
+
+// Rebuilds an HSS private key from a backup written by HssPrivateKey.Backup. The
+// trees below the top one are derived from it: the first tree of each layer under
+// the leaf q of the backup, which the top tree signs.
+func RestoreHssPrivateKey(backup []byte) (*HssPrivateKey, error) {
+	if len(backup) < 4 {
+		return nil, errors.New("hss: invalid HSS backup")
+	}
+	L := strTou32(backup[:4])
+	if L < 1 || L > 8 || len(backup) != 4+4+4+4+IdentifierLength+HashLength {
+		return nil, errors.New("hss: invalid HSS backup")
+	}
+	lmsType, err := lmsParams(uint(strTou32(backup[4:8])))
+	if err != nil {
+		return nil, errors.New("hss: invalid HSS backup")
+	}
+	if strTou32(backup[12:16]) >= powInt(2, lmsType.h) {
+		return nil, errors.New("hss: no signatures left in the HSS backup")
+	}
+	top, err := parseLmsPrivateKey(backup[4:], useDefaultK)
+	if err != nil {
+		return nil, errors.New("hss: invalid HSS backup")
+	}
+
+	hssPriv := new(HssPrivateKey)
+	hssPriv.layer = L
+	hssPriv.k = top.bds.K()
+	hssPriv.lmsPriv = make([]*LmsPrivateKey, L)
+	hssPriv.lmsPub = make([]*LmsPublicKey, L)
+	hssPriv.lmsSig = make([][]byte, L-1)
+	hssPriv.lmsPriv[0] = top
+	hssPriv.lmsPub[0], _ = top.Public()
+	for i := 1; i < L; i++ {
+		hssPriv.lmsPriv[i], err = hssPriv.newTree(i)
+		if err != nil {
+			destroyAll(hssPriv.lmsPriv)
+			return nil, err
+		}
+		hssPriv.lmsPub[i], _ = hssPriv.lmsPriv[i].Public()
+		hssPriv.lmsSig[i-1], _ = hssPriv.lmsPriv[i-1].Sign(hssPriv.lmsPub[i].serialize())
+	}
+	return hssPriv, nil
+}
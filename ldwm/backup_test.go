@@ -0,0 +1,107 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ldwm
+
+import (
+	"testing"
+)
+
+func TestRestoreLmsPrivateKey(t *testing.T) {
+	lmsPriv, _ := GenerateLmsPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8)
+	lmsPub, _ := lmsPriv.Public()
+	for i := 0; i < 7; i++ {
+		lmsPriv.Sign([]byte("message"))
+	}
+	backup, err := lmsPriv.Backup(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := RestoreLmsPrivateKey(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if privateHex(restored) != privateHex(lmsPriv) {
+		t.Error("restored key differs from the original")
+	}
+
+	backup, _ = lmsPriv.Backup(4)
+	restored, _ = RestoreLmsPrivateKey(backup)
+	sig, err := restored.Sign([]byte("message"))
+	if err != nil || lmsPub.Verify([]byte("message"), sig) != nil {
+		t.Fatalf("invalid signature from a restored key: %v", err)
+	}
+	if s, _ := ParseLmsSignature(sig); s.Q() != 11 {
+		t.Errorf("restored key signed with leaf %d, want 11", s.Q())
+	}
+
+	backup, _ = lmsPriv.Backup(1 << 40)
+	if _, err := RestoreLmsPrivateKey(backup); err == nil {
+		t.Error("restored a key with no signatures left")
+	}
+}
+
+func TestRestoreHssPrivateKey(t *testing.T) {
+	hssPriv, _ := GenerateHssPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8, 2)
+	hssPub := hssPriv.Public()
+	for i := 0; i < 40; i++ {
+		hssPriv.Sign([]byte("message"))
+	}
+
+	// The top tree has signed its second child with leaf 1, which has 24
+	// signatures left.
+	tests := []struct {
+		reserve uint64
+		q       int
+	}{
+		{0, 2}, {24, 2}, {25, 3}, {56, 3}, {57, 4}, {1 << 40, 32},
+	}
+	for _, test := range tests {
+		backup, err := hssPriv.Backup(test.reserve)
+		if err != nil {
+			t.Fatal(err)
+		}
+		restored, err := RestoreHssPrivateKey(backup)
+		if test.q == 32 {
+			if err == nil {
+				t.Error("restored a key with no signatures left")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, err := restored.Sign([]byte("message"))
+		if err != nil || hssPub.Verify([]byte("message"), sig) != nil {
+			t.Fatalf("invalid signature from a restored key: %v", err)
+		}
+		s, _ := ParseHssSignature(sig)
+		if q := s.LmsSignatures()[0].Q(); q != test.q {
+			t.Errorf("Backup(%d): the top tree signed with leaf %d, want %d", test.reserve, q, test.q)
+		}
+	}
+}
+
+func TestRestoreRejectsInvalidBackup(t *testing.T) {
+	lmsPriv, _ := GenerateLmsPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8)
+	hssPriv, _ := GenerateHssPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8, 2)
+	lmsBackup, _ := lmsPriv.Backup(0)
+	hssBackup, _ := hssPriv.Backup(0)
+
+	for _, b := range [][]byte{nil, lmsBackup[:len(lmsBackup)-1], append(lmsBackup, 0), hssBackup} {
+		if _, err := RestoreLmsPrivateKey(b); err == nil {
+			t.Errorf("RestoreLmsPrivateKey accepted %x", b)
+		}
+	}
+	for _, b := range [][]byte{nil, hssBackup[:len(hssBackup)-1], append(hssBackup, 0), lmsBackup,
+		append(u32Str(9), hssBackup[4:]...)} {
+		if _, err := RestoreHssPrivateKey(b); err == nil {
+			t.Errorf("RestoreHssPrivateKey accepted %x", b)
+		}
+	}
+	hssPriv.Destroy()
+	if _, err := hssPriv.Backup(0); err == nil {
+		t.Error("backed up a destroyed key")
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ldwm
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/lingyunzhao/pqcrypto/merkle"
+)
+
+// Batch signatures sign many messages with a single leaf. The messages are the
+// leaves of a Merkle tree built as in RFC 9162: leaf hashes are H(0x00 || m) and
+// interior nodes H(0x01 || left || right), where a node without a sibling is
+// promoted to the next level unchanged. Only the message
+//
+//	"ldwm batch" || u32str(size) || root
+//
+// is signed. The signature of a member is
+//
+//	u32str(index) || u32str(size) || inclusion path || signature of the root
+//
+// The root is signed as an ordinary message, so Sign, SignReader and the Sign
+// methods of the signers refuse messages that start with the tag "ldwm batch":
+// their signatures would also verify as batch signatures of any message under
+// a forged batch root. Such messages can only be signed as members of a batch.
+
+var batchTag = []byte("ldwm batch")
+
+var errBatchTag = errors.New("ldwm: the message starts with the batch tag; sign it with SignBatch")
+
+const maxBatchSize = 1 << 31
+
+// batchHasher computes the nodes of a batch tree with SHA-256.
+type batchHasher struct{}
+
+func (batchHasher) HashLeaf(message []byte) []byte {
+	return sha256Hash(bytes.Join([][]byte{{0x00}, message}, []byte("")))
+}
+
+func (batchHasher) HashChildren(left []byte, right []byte) []byte {
+	return sha256Hash(bytes.Join([][]byte{{0x01}, left, right}, []byte("")))
+}
+
+func batchRootMessage(size int, root []byte) []byte {
+	return bytes.Join([][]byte{batchTag, u32Str(size), root}, []byte(""))
+}
+
+// checkMessage refuses a message that starts with the batch tag.
+func checkMessage(message []byte) error {
+	if bytes.HasPrefix(message, batchTag) {
+		return errBatchTag
+	}
+	return nil
+}
+
+// A batchTagReader reads a message that must not start with the batch tag. Its
+// first Read fails with errBatchTag if it does.
+type batchTagReader struct {
+	r       io.Reader
+	checked bool
+}
+
+func (t *batchTagReader) Read(p []byte) (int, error) {
+	if !t.checked {
+		t.checked = true
+		head := make([]byte, len(batchTag))
+		n, err := io.ReadFull(t.r, head)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		if err := checkMessage(head[:n]); err != nil {
+			return 0, err
+		}
+		t.r = io.MultiReader(bytes.NewReader(head[:n]), t.r)
+	}
+	return t.r.Read(p)
+}
+
+// Signs a batch of messages with a single leaf of the private key and returns one
+// signature per message, to be checked with VerifyBatchMember.
+func signBatch(messages [][]byte, sign func(messageDigest) ([]byte, error)) ([][]byte, error) {
+	if len(messages) == 0 || len(messages) > maxBatchSize {
+		return nil, errors.New("ldwm: invalid batch size")
+	}
+
+	tree := merkle.NewLogTree(batchHasher{}, messages...)
+	rootSig, err := sign(bytesMessage(batchRootMessage(len(messages), tree.Root())))
+	if err != nil {
+		return nil, err
+	}
+
+	sigs := make([][]byte, len(messages))
+	for i := range messages {
+		proof, _ := tree.InclusionProof(i)
+		sig := bytes.Join([][]byte{u32Str(i), u32Str(len(messages))}, []byte(""))
+		for _, node := range proof {
+			sig = append(sig, node...)
+		}
+		sigs[i] = append(sig, rootSig...)
+	}
+
+	return sigs, nil
+}
+
+// Recomputes the message signed by the root signature of a batch member and
+// returns it with the root signature.
+func batchMember(message []byte, sig []byte) ([]byte, []byte, error) {
+	if len(sig) < 8 {
+		return nil, nil, errors.New("ldwm: invalid batch signature")
+	}
+	index := strTou32(sig[:4])
+	size := strTou32(sig[4:8])
+	if size == 0 || index >= size {
+		return nil, nil, errors.New("ldwm: invalid batch signature")
+	}
+	n := merkle.InclusionProofLen(index, size) * HashLength
+	if len(sig) < 8+n {
+		return nil, nil, errors.New("ldwm: invalid batch signature")
+	}
+
+	root, err := merkle.RootFromInclusionProof(batchHasher{}, message, index, size, split(sig[8:8+n], HashLength))
+	if err != nil {
+		return nil, nil, errors.New("ldwm: invalid batch signature")
+	}
+	return batchRootMessage(size, root), sig[8+n:], nil
+}
+
+// Signs a batch of messages with a single LMS leaf and updates the private key.
+// The returned signatures are verified with VerifyBatchMember.
+func (lmsPriv *LmsPrivateKey) SignBatch(messages [][]byte) ([][]byte, error) {
+	return signBatch(messages, lmsPriv.sign)
+}
+
+// Verifies a message with its batch signature generated by SignBatch.
+func (lmsPub *LmsPublicKey) VerifyBatchMember(message, sig []byte) error {
+	rootMessage, rootSig, err := batchMember(message, sig)
+	if err != nil {
+		return err
+	}
+	return lmsPub.Verify(rootMessage, rootSig)
+}
+
+// Signs a batch of messages with a single leaf of the bottom LMS tree and updates
+// the private key. The returned signatures are verified with VerifyBatchMember.
+func (hssPriv *HssPrivateKey) SignBatch(messages [][]byte) ([][]byte, error) {
+	return signBatch(messages, hssPriv.sign)
+}
+
+// Verifies a message with its batch signature generated by SignBatch.
+func (hssPub *HssPublicKey) VerifyBatchMember(message, sig []byte) error {
+	rootMessage, rootSig, err := batchMember(message, sig)
+	if err != nil {
+		return err
+	}
+	return hssPub.Verify(rootMessage, rootSig)
+}
@@ -0,0 +1,129 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ldwm
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/lingyunzhao/pqcrypto/merkle"
+)
+
+// mth computes the Merkle tree hash of RFC 9162, section 2.1.1.
+func mth(messages [][]byte) []byte {
+	if len(messages) == 1 {
+		return batchHasher{}.HashLeaf(messages[0])
+	}
+	k := 1
+	for 2*k < len(messages) {
+		k *= 2
+	}
+	return batchHasher{}.HashChildren(mth(messages[:k]), mth(messages[k:]))
+}
+
+func TestBatchTree(t *testing.T) {
+	for size := 1; size <= 33; size++ {
+		messages := make([][]byte, size)
+		for i := range messages {
+			messages[i] = []byte(fmt.Sprintf("entry %d", i))
+		}
+		tree := merkle.NewLogTree(batchHasher{}, messages...)
+		if !bytes.Equal(tree.Root(), mth(messages)) {
+			t.Errorf("batch root differs from RFC 9162 when size = %d", size)
+		}
+	}
+}
+
+func TestHssSignBatch(t *testing.T) {
+	hssPriv, _ := GenerateHssPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W4, 2)
+	hssPub := hssPriv.Public()
+
+	for _, size := range []int{1, 2, 3, 5, 8, 13} {
+		messages := make([][]byte, size)
+		for i := range messages {
+			messages[i] = []byte(fmt.Sprintf("entry %d of %d", i, size))
+		}
+		sigs, err := hssPriv.SignBatch(messages)
+		if err != nil {
+			t.Fatalf("failed to sign batch when size = %d: %v", size, err)
+		}
+		for i, sig := range sigs {
+			if hssPub.VerifyBatchMember(messages[i], sig) != nil {
+				t.Errorf("invalid batch signature when size = %d, i = %d", size, i)
+			}
+			if hssPub.VerifyBatchMember([]byte("other"), sig) == nil {
+				t.Errorf("batch signature verified a wrong message when size = %d, i = %d", size, i)
+			}
+			if size > 1 && hssPub.VerifyBatchMember(messages[(i+1)%size], sig) == nil {
+				t.Errorf("batch signature verified another member when size = %d, i = %d", size, i)
+			}
+		}
+		if size > 2 {
+			sig := append([]byte{}, sigs[1]...)
+			copy(sig[:4], u32Str(2))
+			if hssPub.VerifyBatchMember(messages[1], sig) == nil {
+				t.Errorf("batch signature with modified index verified when size = %d", size)
+			}
+		}
+	}
+
+	if _, err := hssPriv.SignBatch(nil); err == nil {
+		t.Errorf("signed an empty batch")
+	}
+	if hssPub.VerifyBatchMember([]byte("abc"), []byte{0, 0, 0, 1}) == nil {
+		t.Errorf("verified a truncated batch signature")
+	}
+}
+
+func TestLmsSignBatch(t *testing.T) {
+	lmsPriv, _ := GenerateLmsPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8)
+	lmsPub, _ := lmsPriv.Public()
+	messages := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	sigs, err := NewLmsSigner(lmsPriv).SignBatch(messages)
+	if err != nil {
+		t.Fatalf("failed to sign batch: %v", err)
+	}
+	for i, sig := range sigs {
+		if lmsPub.VerifyBatchMember(messages[i], sig) != nil {
+			t.Errorf("invalid batch signature when i = %d", i)
+		}
+	}
+	if lmsPriv.q != 1 {
+		t.Errorf("batch used %d leaves, want 1", lmsPriv.q)
+	}
+}
+
+func TestSignRefusesBatchTag(t *testing.T) {
+	hssPriv, _ := GenerateHssPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8, 2)
+	hssPub := hssPriv.Public()
+	sigs, _ := hssPriv.SignBatch([][]byte{[]byte("a"), []byte("b")})
+
+	// A signature of the root message of another batch would make its members
+	// verify.
+	forged := batchRootMessage(1, batchHasher{}.HashLeaf([]byte("forged")))
+	if _, err := hssPriv.Sign(forged); err == nil {
+		t.Fatal("signed a message with the batch tag")
+	}
+	if _, err := NewHssSigner(hssPriv).Sign(forged); err == nil {
+		t.Error("the signer signed a message with the batch tag")
+	}
+	if _, err := hssPriv.SignReader(bytes.NewReader(forged)); err == nil {
+		t.Error("signed a message with the batch tag read from a stream")
+	}
+	if hssPriv.Remaining() != 1024-2 {
+		t.Errorf("Remaining() = %d, want %d", hssPriv.Remaining(), 1024-2)
+	}
+
+	for _, message := range [][]byte{batchTag[:4], []byte("batch"), nil} {
+		sig, err := hssPriv.SignReader(bytes.NewReader(message))
+		if err != nil || hssPub.Verify(message, sig) != nil {
+			t.Errorf("failed to sign %q: %v", message, err)
+		}
+	}
+	if hssPub.VerifyBatchMember([]byte("b"), sigs[1]) != nil {
+		t.Error("invalid batch signature")
+	}
+}
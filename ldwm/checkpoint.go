@@ -0,0 +1,173 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ldwm
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+
+	"github.com/lingyunzhao/pqcrypto/merkle"
+)
+
+// A checkpoint holds the state of a key generation, from which it can be resumed:
+//
+//	LMS: u32 lmsTypecode || u32 otsTypecode || tree
+//	HSS: u32 L || u32 lmsTypecode || u32 otsTypecode || u32 count || tree ...
+//
+// where each tree is I || SEED || root || BDS state, the root being zero until
+// the tree is complete, and the trees of an HSS checkpoint are those of the
+// count layers started so far, from the top down.
+
+// Receives the checkpoints of a key generation. Each checkpoint supersedes the
+// previous ones, so a sink only needs to keep the latest; it contains the secret
+// seeds of the key and must be protected like the key itself. An error stops
+// the generation and is returned by it.
+type Checkpoint func(checkpoint []byte) error
+
+// Generates an LMS private key like GenerateLmsPrivateKeyWithContext, passing a
+// checkpoint to checkpoint every interval leaves and once more when ctx is done.
+// ResumeLmsPrivateKey continues from any of them and yields the key an
+// uninterrupted run would have.
+func GenerateLmsPrivateKeyWithCheckpoints(ctx context.Context, lmsTypecode uint, otsTypecode uint, interval uint64, checkpoint Checkpoint, progress Progress) (*LmsPrivateKey, error) {
+	lmsType, err := lmsParams(lmsTypecode)
+	if err != nil {
+		return nil, err
+	}
+	lmsPriv, err := newLmsPrivateKey(lmsTypecode, otsTypecode, defaultK(lmsType.h), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	g := &keygen{ctx: ctx, progress: progress, interval: interval, checkpoint: checkpoint}
+	return g.lms(lmsPriv)
+}
+
+// Resumes the generation of an LMS private key from a checkpoint written by
+// GenerateLmsPrivateKeyWithCheckpoints or ResumeLmsPrivateKey, and keeps
+// writing checkpoints as they do. The progress counts the leaves computed
+// before the checkpoint.
+func ResumeLmsPrivateKey(ctx context.Context, state []byte, interval uint64, checkpoint Checkpoint, progress Progress) (*LmsPrivateKey, error) {
+	invalid := errors.New("lms: invalid LMS checkpoint")
+	if len(state) < 8 {
+		return nil, invalid
+	}
+	lmsPriv, read, err := parseCheckpointTree(state[8:], uint(strTou32(state[:4])), uint(strTou32(state[4:8])))
+	if err != nil || read != len(state)-8 {
+		return nil, invalid
+	}
+	g := &keygen{ctx: ctx, progress: progress, interval: interval, checkpoint: checkpoint}
+	g.done = uint64(lmsPriv.bds.InitLeaves())
+	return g.lms(lmsPriv)
+}
+
+// Generates an HSS private key like GenerateHssPrivateKeyWithContext, passing a
+// checkpoint to checkpoint every interval leaves and once more when ctx is done.
+// ResumeHssPrivateKey continues from any of them and yields the key an
+// uninterrupted run would have. The value of layer should satisfy 1 <= layer <= 8.
+func GenerateHssPrivateKeyWithCheckpoints(ctx context.Context, lmsTypecode uint, otsTypecode uint, layer int, interval uint64, checkpoint Checkpoint, progress Progress) (*HssPrivateKey, error) {
+	lmsType, err := lmsParams(lmsTypecode)
+	if err != nil {
+		return nil, errors.New("hss: invalid LMS typecode")
+	}
+	g := &keygen{ctx: ctx, progress: progress, interval: interval, checkpoint: checkpoint}
+	return g.hss(lmsTypecode, otsTypecode, layer, defaultK(lmsType.h), rand.Reader, nil)
+}
+
+// Resumes the generation of an HSS private key from a checkpoint written by
+// GenerateHssPrivateKeyWithCheckpoints or ResumeHssPrivateKey, and keeps
+// writing checkpoints as they do. The progress counts the leaves computed
+// before the checkpoint.
+func ResumeHssPrivateKey(ctx context.Context, state []byte, interval uint64, checkpoint Checkpoint, progress Progress) (*HssPrivateKey, error) {
+	invalid := errors.New("hss: invalid HSS checkpoint")
+	if len(state) < 16 {
+		return nil, invalid
+	}
+	layer := strTou32(state[:4])
+	lmsTypecode := uint(strTou32(state[4:8]))
+	otsTypecode := uint(strTou32(state[8:12]))
+	count := strTou32(state[12:16])
+	if layer < 1 || layer > 8 || count < 1 || count > layer {
+		return nil, invalid
+	}
+	state = state[16:]
+	trees := make([]*LmsPrivateKey, count)
+	g := &keygen{ctx: ctx, progress: progress, interval: interval, checkpoint: checkpoint}
+	for i := range trees {
+		lmsPriv, read, err := parseCheckpointTree(state, lmsTypecode, otsTypecode)
+		// Only the last tree may be under construction, and all share k.
+		if err != nil || (i < count-1 && lmsPriv.root == nil) || (i > 0 && lmsPriv.bds.K() != trees[0].bds.K()) {
+			destroyAll(trees)
+			if lmsPriv != nil {
+				lmsPriv.Destroy()
+			}
+			return nil, invalid
+		}
+		trees[i] = lmsPriv
+		state = state[read:]
+		g.done += uint64(lmsPriv.bds.InitLeaves())
+	}
+	if len(state) != 0 {
+		destroyAll(trees)
+		return nil, invalid
+	}
+	return g.hss(lmsTypecode, otsTypecode, layer, trees[0].bds.K(), rand.Reader, trees)
+}
+
+// lmsCheckpoint encodes the checkpoint of the generation of an LMS private key.
+func lmsCheckpoint(lmsPriv *LmsPrivateKey) []byte {
+	return bytes.Join([][]byte{u32Str(int(lmsPriv.lmsTypecode)), u32Str(int(lmsPriv.otsTypecode)),
+		lmsPriv.checkpointTree()}, []byte(""))
+}
+
+// hssCheckpoint encodes the checkpoint of the generation of an HSS private key
+// whose layers have been started up to the first nil tree.
+func hssCheckpoint(hssPriv *HssPrivateKey, lmsTypecode uint, otsTypecode uint) []byte {
+	var trees [][]byte
+	for _, lmsPriv := range hssPriv.lmsPriv {
+		if lmsPriv == nil {
+			break
+		}
+		trees = append(trees, lmsPriv.checkpointTree())
+	}
+	return bytes.Join(append([][]byte{u32Str(hssPriv.layer), u32Str(int(lmsTypecode)), u32Str(int(otsTypecode)),
+		u32Str(len(trees))}, trees...), []byte(""))
+}
+
+func (lmsPriv *LmsPrivateKey) checkpointTree() []byte {
+	root := lmsPriv.root
+	if root == nil {
+		root = make([]byte, HashLength)
+	}
+	return bytes.Join([][]byte{lmsPriv.id, lmsPriv.skSeed, root, lmsPriv.bds.Marshal(HashLength)}, []byte(""))
+}
+
+// parseCheckpointTree decodes a tree of a checkpoint and returns it with the
+// number of bytes read.
+func parseCheckpointTree(b []byte, lmsTypecode uint, otsTypecode uint) (*LmsPrivateKey, int, error) {
+	invalid := errors.New("lms: invalid LMS checkpoint")
+	lmsType, err := lmsParams(lmsTypecode)
+	if err != nil {
+		return nil, 0, invalid
+	}
+	if _, err := otsParams(otsTypecode); err != nil {
+		return nil, 0, invalid
+	}
+	if len(b) < IdentifierLength+2*HashLength {
+		return nil, 0, invalid
+	}
+	bds, read, err := merkle.ParseBDS(b[IdentifierLength+2*HashLength:], HashLength, lmsType.h)
+	if err != nil {
+		return nil, 0, invalid
+	}
+	lmsPriv := newMerkleTree(b[:IdentifierLength], b[IdentifierLength:IdentifierLength+HashLength],
+		lmsTypecode, otsTypecode, bds.K())
+	lmsPriv.bds = bds
+	if bds.Initialized() {
+		lmsPriv.root = make([]byte, HashLength)
+		copy(lmsPriv.root, b[IdentifierLength+HashLength:IdentifierLength+2*HashLength])
+	}
+	return lmsPriv, IdentifierLength + 2*HashLength + read, nil
+}
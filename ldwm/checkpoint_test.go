@@ -0,0 +1,137 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ldwm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+// interrupted generates a key with checkpoints every 5 leaves and cancels the
+// generation after the given number of leaves. It returns the checkpoints.
+func interrupted(t *testing.T, leaves uint64, generate func(context.Context, Checkpoint, Progress) error) [][]byte {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var checkpoints [][]byte
+	err := generate(ctx, func(checkpoint []byte) error {
+		checkpoints = append(checkpoints, append([]byte(nil), checkpoint...))
+		return nil
+	}, func(done uint64, total uint64) {
+		if done == leaves {
+			cancel()
+		}
+	})
+	if err != context.Canceled {
+		t.Fatalf("interrupted generation returned %v", err)
+	}
+	return checkpoints
+}
+
+func TestResumeLmsPrivateKey(t *testing.T) {
+	checkpoints := interrupted(t, 17, func(ctx context.Context, checkpoint Checkpoint, progress Progress) error {
+		_, err := GenerateLmsPrivateKeyWithCheckpoints(ctx, LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8, 5, checkpoint, progress)
+		return err
+	})
+	// Checkpoints after leaves 5, 10 and 15, and when the context was canceled.
+	if len(checkpoints) != 4 {
+		t.Fatalf("got %d checkpoints, want 4", len(checkpoints))
+	}
+
+	var want []byte
+	for i, checkpoint := range checkpoints {
+		var first uint64
+		lmsPriv, err := ResumeLmsPrivateKey(context.Background(), checkpoint, 0, nil, func(done uint64, total uint64) {
+			if first == 0 {
+				first = done
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := []uint64{6, 11, 16, 18}[i]; first != expected {
+			t.Errorf("resumed generation started at leaf %d, want %d", first, expected)
+		}
+		// A key parsed from the seeds has the tree of an uninterrupted run.
+		key, _ := lmsPriv.MarshalPrivate()
+		whole, _ := parseLmsPrivateKey(key, useDefaultK)
+		lmsPub, _ := whole.Public()
+		sig, _ := lmsPriv.Sign([]byte("abc"))
+		if wholeSig, _ := whole.Sign([]byte("abc")); !bytes.Equal(sig, wholeSig) || lmsPub.Verify([]byte("abc"), sig) != nil {
+			t.Fatalf("key resumed from checkpoint %d differs from an uninterrupted run", i)
+		}
+		if want == nil {
+			want = sig
+		} else if !bytes.Equal(sig, want) {
+			t.Errorf("key resumed from checkpoint %d differs from the others", i)
+		}
+	}
+}
+
+func TestResumeHssPrivateKey(t *testing.T) {
+	checkpoints := interrupted(t, 40, func(ctx context.Context, checkpoint Checkpoint, progress Progress) error {
+		_, err := GenerateHssPrivateKeyWithCheckpoints(ctx, LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8, 2, 5, checkpoint, progress)
+		return err
+	})
+	// Checkpoints after every 5 leaves up to 40, and when the context was
+	// canceled.
+	if len(checkpoints) != 9 {
+		t.Fatalf("got %d checkpoints, want 9", len(checkpoints))
+	}
+
+	// The second tree was started after leaf 32, so resuming from any later
+	// checkpoint yields the same key.
+	var want []byte
+	for i, checkpoint := range checkpoints {
+		hssPriv, err := ResumeHssPrivateKey(context.Background(), checkpoint, 0, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		whole, _ := ParseHssPrivateKey(privateHex(hssPriv))
+		sig, _ := hssPriv.Sign([]byte("abc"))
+		if wholeSig, _ := whole.Sign([]byte("abc")); !bytes.Equal(sig, wholeSig) || hssPriv.Public().Verify([]byte("abc"), sig) != nil {
+			t.Fatalf("key resumed from checkpoint %d differs from an uninterrupted run", i)
+		}
+		if i < 6 {
+			continue
+		}
+		if want == nil {
+			want = sig
+		} else if !bytes.Equal(sig, want) {
+			t.Errorf("key resumed from checkpoint %d differs from the others", i)
+		}
+	}
+}
+
+func TestCheckpointErrorStopsGeneration(t *testing.T) {
+	stop := errors.New("disk full")
+	_, err := GenerateHssPrivateKeyWithCheckpoints(context.Background(), LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8, 2, 10,
+		func([]byte) error { return stop }, nil)
+	if err != stop {
+		t.Errorf("generation returned %v, want the error of the checkpoint", err)
+	}
+}
+
+func TestResumeRejectsInvalidCheckpoint(t *testing.T) {
+	lms := interrupted(t, 10, func(ctx context.Context, checkpoint Checkpoint, progress Progress) error {
+		_, err := GenerateLmsPrivateKeyWithCheckpoints(ctx, LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8, 0, checkpoint, progress)
+		return err
+	})[0]
+	hss := interrupted(t, 40, func(ctx context.Context, checkpoint Checkpoint, progress Progress) error {
+		_, err := GenerateHssPrivateKeyWithCheckpoints(ctx, LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8, 2, 0, checkpoint, progress)
+		return err
+	})[0]
+	for _, b := range [][]byte{nil, lms[:len(lms)-1], append(lms, 0), hss} {
+		if _, err := ResumeLmsPrivateKey(context.Background(), b, 0, nil, nil); err == nil {
+			t.Errorf("ResumeLmsPrivateKey accepted %x", b)
+		}
+	}
+	for _, b := range [][]byte{nil, hss[:len(hss)-1], append(hss, 0), lms, append(u32Str(1), hss[4:]...)} {
+		if _, err := ResumeHssPrivateKey(context.Background(), b, 0, nil, nil); err == nil {
+			t.Errorf("ResumeHssPrivateKey accepted %x", b)
+		}
+	}
+}
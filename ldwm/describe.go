@@ -0,0 +1,299 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ldwm
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// The descriptions below hold the decoded fields of keys and signatures. They
+// are meant for debugging and tooling: String renders a description as text and
+// encoding/json renders it as JSON. Byte strings are given in hexadecimal and
+// private seeds are never included.
+
+var lmsTypeNames = map[uint]string{
+	uint(LMS_SHA256_M32_H5):  "LMS_SHA256_M32_H5",
+	uint(LMS_SHA256_M32_H10): "LMS_SHA256_M32_H10",
+	uint(LMS_SHA256_M32_H15): "LMS_SHA256_M32_H15",
+	uint(LMS_SHA256_M32_H20): "LMS_SHA256_M32_H20",
+	uint(LMS_SHA256_M32_H25): "LMS_SHA256_M32_H25",
+}
+
+var otsTypeNames = map[uint]string{
+	uint(LMOTS_SHA256_N32_W1): "LMOTS_SHA256_N32_W1",
+	uint(LMOTS_SHA256_N32_W2): "LMOTS_SHA256_N32_W2",
+	uint(LMOTS_SHA256_N32_W4): "LMOTS_SHA256_N32_W4",
+	uint(LMOTS_SHA256_N32_W8): "LMOTS_SHA256_N32_W8",
+}
+
+func typeName(names map[uint]string, typecode uint) string {
+	if name, ok := names[typecode]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown (0x%08x)", typecode)
+}
+
+// Description of an LMS signature.
+type LmsSignatureDescription struct {
+	Q       int      `json:"q"`
+	OtsType string   `json:"otsType"`
+	C       string   `json:"c"`
+	Y       []string `json:"y"`
+	LmsType string   `json:"lmsType"`
+	Path    []string `json:"path"`
+}
+
+// Description of an HSS signature. SignedKeys holds the signed public keys of
+// the layers below the root, and Signature the LMS signature of the message.
+type HssSignatureDescription struct {
+	Layers     int                        `json:"layers"`
+	SignedKeys []*HssSignedKeyDescription `json:"signedKeys"`
+	Signature  *LmsSignatureDescription   `json:"signature"`
+}
+
+// Description of an LMS public key and its signature by the layer above.
+type HssSignedKeyDescription struct {
+	Signature *LmsSignatureDescription `json:"signature"`
+	PublicKey *LmsPublicKeyDescription `json:"publicKey"`
+}
+
+// Description of an LMS public key.
+type LmsPublicKeyDescription struct {
+	LmsType string `json:"lmsType"`
+	OtsType string `json:"otsType"`
+	I       string `json:"i"`
+	Root    string `json:"root"`
+}
+
+// Description of an HSS public key.
+type HssPublicKeyDescription struct {
+	Layers    int                      `json:"layers"`
+	PublicKey *LmsPublicKeyDescription `json:"publicKey"`
+}
+
+// Description of an LMS private key. Q is the index of the next leaf to be used.
+type LmsPrivateKeyDescription struct {
+	LmsType   string `json:"lmsType"`
+	OtsType   string `json:"otsType"`
+	Q         int    `json:"q"`
+	Remaining uint64 `json:"remaining"`
+	I         string `json:"i"`
+	Root      string `json:"root"`
+}
+
+// Description of an HSS private key. Keys holds the current LMS key of every
+// layer, starting with the root.
+type HssPrivateKeyDescription struct {
+	Layers    int                         `json:"layers"`
+	Remaining uint64                      `json:"remaining"`
+	Keys      []*LmsPrivateKeyDescription `json:"keys"`
+}
+
+// Decodes an LMS signature without verifying it.
+func DescribeLmsSignature(lmsSig []byte) (*LmsSignatureDescription, error) {
+	sig, err := ParseLmsSignature(lmsSig)
+	if err != nil {
+		return nil, err
+	}
+	return sig.Describe(), nil
+}
+
+// Decodes an HSS signature without verifying it.
+func DescribeHssSignature(hssSig []byte) (*HssSignatureDescription, error) {
+	sig, err := ParseHssSignature(hssSig)
+	if err != nil {
+		return nil, err
+	}
+	return sig.Describe(), nil
+}
+
+// Describes the LMS signature.
+func (sig *LmsSignature) Describe() *LmsSignatureDescription {
+	return &LmsSignatureDescription{
+		Q:       sig.q,
+		OtsType: typeName(otsTypeNames, sig.otsTypecode),
+		C:       hex.EncodeToString(sig.c),
+		Y:       hexList(sig.Y()),
+		LmsType: typeName(lmsTypeNames, sig.lmsTypecode),
+		Path:    hexList(sig.Path()),
+	}
+}
+
+// Describes the HSS signature.
+func (sig *HssSignature) Describe() *HssSignatureDescription {
+	desc := new(HssSignatureDescription)
+	desc.Layers = sig.Layers()
+	for i, lmsPub := range sig.lmsPub {
+		desc.SignedKeys = append(desc.SignedKeys, &HssSignedKeyDescription{sig.lmsSig[i].Describe(), lmsPub.Describe()})
+	}
+	desc.Signature = sig.lmsSig[len(sig.lmsSig)-1].Describe()
+	return desc
+}
+
+// Describes the LMS public key.
+func (lmsPub *LmsPublicKey) Describe() *LmsPublicKeyDescription {
+	return &LmsPublicKeyDescription{
+		LmsType: typeName(lmsTypeNames, lmsPub.lmsTypecode),
+		OtsType: typeName(otsTypeNames, lmsPub.otsTypecode),
+		I:       hex.EncodeToString(lmsPub.id),
+		Root:    hex.EncodeToString(lmsPub.t1),
+	}
+}
+
+// Describes the HSS public key.
+func (hssPub *HssPublicKey) Describe() *HssPublicKeyDescription {
+	return &HssPublicKeyDescription{hssPub.layer, hssPub.lmsPub.Describe()}
+}
+
+// Describes the LMS private key.
+func (lmsPriv *LmsPrivateKey) Describe() *LmsPrivateKeyDescription {
+	return &LmsPrivateKeyDescription{
+		LmsType:   typeName(lmsTypeNames, lmsPriv.lmsTypecode),
+		OtsType:   typeName(otsTypeNames, lmsPriv.otsTypecode),
+		Q:         lmsPriv.q,
+		Remaining: lmsPriv.Remaining(),
+		I:         hex.EncodeToString(lmsPriv.id),
+		Root:      hex.EncodeToString(lmsPriv.root),
+	}
+}
+
+// Describes the HSS private key.
+func (hssPriv *HssPrivateKey) Describe() *HssPrivateKeyDescription {
+	desc := &HssPrivateKeyDescription{Layers: hssPriv.layer, Remaining: hssPriv.Remaining()}
+	for _, lmsPriv := range hssPriv.lmsPriv {
+		desc.Keys = append(desc.Keys, lmsPriv.Describe())
+	}
+	return desc
+}
+
+func (desc *LmsSignatureDescription) String() string {
+	w := new(textWriter)
+	w.line("LMS signature")
+	desc.write(w.indent())
+	return w.String()
+}
+
+func (desc *LmsSignatureDescription) write(w *textWriter) {
+	w.field("q", desc.Q)
+	w.field("LM-OTS type", desc.OtsType)
+	w.field("C", desc.C)
+	w.list("y", desc.Y)
+	w.field("LMS type", desc.LmsType)
+	w.list("path", desc.Path)
+}
+
+func (desc *HssSignatureDescription) String() string {
+	w := new(textWriter)
+	w.line("HSS signature")
+	w = w.indent()
+	w.field("layers", desc.Layers)
+	for i, signed := range desc.SignedKeys {
+		w.line(fmt.Sprintf("layer %d signature of layer %d public key", i, i+1))
+		signed.Signature.write(w.indent())
+		w.line(fmt.Sprintf("layer %d public key", i+1))
+		signed.PublicKey.write(w.indent())
+	}
+	w.line(fmt.Sprintf("layer %d signature of message", desc.Layers-1))
+	desc.Signature.write(w.indent())
+	return w.String()
+}
+
+func (desc *LmsPublicKeyDescription) String() string {
+	w := new(textWriter)
+	w.line("LMS public key")
+	desc.write(w.indent())
+	return w.String()
+}
+
+func (desc *LmsPublicKeyDescription) write(w *textWriter) {
+	w.field("LMS type", desc.LmsType)
+	w.field("LM-OTS type", desc.OtsType)
+	w.field("I", desc.I)
+	w.field("root", desc.Root)
+}
+
+func (desc *HssPublicKeyDescription) String() string {
+	w := new(textWriter)
+	w.line("HSS public key")
+	w = w.indent()
+	w.field("layers", desc.Layers)
+	desc.PublicKey.write(w)
+	return w.String()
+}
+
+func (desc *LmsPrivateKeyDescription) String() string {
+	w := new(textWriter)
+	w.line("LMS private key")
+	desc.write(w.indent())
+	return w.String()
+}
+
+func (desc *LmsPrivateKeyDescription) write(w *textWriter) {
+	w.field("LMS type", desc.LmsType)
+	w.field("LM-OTS type", desc.OtsType)
+	w.field("q", desc.Q)
+	w.field("remaining", desc.Remaining)
+	w.field("I", desc.I)
+	w.field("root", desc.Root)
+}
+
+func (desc *HssPrivateKeyDescription) String() string {
+	w := new(textWriter)
+	w.line("HSS private key")
+	w = w.indent()
+	w.field("layers", desc.Layers)
+	w.field("remaining", desc.Remaining)
+	for i, key := range desc.Keys {
+		w.line(fmt.Sprintf("layer %d", i))
+		key.write(w.indent())
+	}
+	return w.String()
+}
+
+// A textWriter renders descriptions as indented "name: value" lines.
+type textWriter struct {
+	b      *strings.Builder
+	prefix string
+}
+
+func (w *textWriter) builder() *strings.Builder {
+	if w.b == nil {
+		w.b = new(strings.Builder)
+	}
+	return w.b
+}
+
+func (w *textWriter) indent() *textWriter {
+	return &textWriter{w.builder(), w.prefix + "  "}
+}
+
+func (w *textWriter) line(s string) {
+	fmt.Fprintf(w.builder(), "%s%s\n", w.prefix, s)
+}
+
+func (w *textWriter) field(name string, value interface{}) {
+	w.line(fmt.Sprintf("%-12s %v", name+":", value))
+}
+
+func (w *textWriter) list(name string, values []string) {
+	for i, value := range values {
+		w.field(fmt.Sprintf("%s[%d]", name, i), value)
+	}
+}
+
+func (w *textWriter) String() string {
+	return w.builder().String()
+}
+
+// hexList encodes byte strings in hexadecimal.
+func hexList(list [][]byte) []string {
+	strs := make([]string, len(list))
+	for i, b := range list {
+		strs[i] = hex.EncodeToString(b)
+	}
+	return strs
+}
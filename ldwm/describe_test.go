@@ -0,0 +1,74 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ldwm
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDescribeHssSignature(t *testing.T) {
+	hssPriv, _ := GenerateHssPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8, 3)
+	hssPriv.Sign([]byte("message 0"))
+	hssSig, _ := hssPriv.Sign([]byte("message 1"))
+
+	desc, err := DescribeHssSignature(hssSig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.Layers != 3 || len(desc.SignedKeys) != 2 {
+		t.Fatalf("layers = %d, signed keys = %d", desc.Layers, len(desc.SignedKeys))
+	}
+	if desc.Signature.Q != 1 || desc.Signature.LmsType != "LMS_SHA256_M32_H5" ||
+		desc.Signature.OtsType != "LMOTS_SHA256_N32_W8" {
+		t.Errorf("signature q = %d, types = %s, %s", desc.Signature.Q, desc.Signature.LmsType, desc.Signature.OtsType)
+	}
+	if len(desc.Signature.Path) != 5 || len(desc.Signature.Y) != 34 {
+		t.Errorf("path has %d nodes, y has %d elements", len(desc.Signature.Path), len(desc.Signature.Y))
+	}
+	if desc.SignedKeys[1].PublicKey.I != hex.EncodeToString(hssPriv.lmsPub[2].id) {
+		t.Error("wrong public key of layer 2")
+	}
+
+	if !strings.Contains(desc.String(), "path[4]:") {
+		t.Errorf("text description lacks the authentication path:\n%s", desc)
+	}
+	data, err := json.Marshal(desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded HssSignatureDescription
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded.Signature.C != desc.Signature.C {
+		t.Error("JSON description does not round-trip")
+	}
+
+	for _, n := range []int{0, 3, 4, 100, len(hssSig) - 1} {
+		if _, err := DescribeHssSignature(hssSig[:n]); err == nil {
+			t.Errorf("truncated signature of %d bytes accepted", n)
+		}
+	}
+	if _, err := DescribeLmsSignature(hssSig); err == nil {
+		t.Error("HSS signature accepted as an LMS signature")
+	}
+}
+
+func TestDescribeKeys(t *testing.T) {
+	hssPriv, _ := GenerateHssPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8, 2)
+	for i := 0; i < 33; i++ {
+		hssPriv.Sign([]byte("message"))
+	}
+	desc := hssPriv.Describe()
+	if desc.Layers != 2 || desc.Remaining != 1024-33 || desc.Keys[0].Q != 2 || desc.Keys[1].Q != 1 {
+		t.Errorf("unexpected description:\n%s", desc)
+	}
+	if strings.Contains(desc.String(), hex.EncodeToString(hssPriv.lmsPriv[0].skSeed)) {
+		t.Error("description contains the private seed")
+	}
+	if hssPriv.Public().Describe().PublicKey.Root != hex.EncodeToString(hssPriv.lmsPub[0].t1) {
+		t.Error("wrong root in the public key description")
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ldwm
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// The fuzz targets feed arbitrary bytes to the parsers and verifiers, which
+// must return an error rather than panic. Parsed keys and signatures must
+// serialize back to their input. Run one with, for example,
+//
+//	go test -run '^$' -fuzz FuzzHssVerify ./ldwm
+
+// fuzzSeeds returns a valid HSS key pair, a message and its signature.
+func fuzzSeeds() (*HssPrivateKey, *HssPublicKey, []byte, []byte) {
+	hssPriv, _ := GenerateHssPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W4, 2)
+	message := []byte("fuzz")
+	sig, _ := hssPriv.Sign(message)
+	return hssPriv, hssPriv.Public(), message, sig
+}
+
+// slowLmsPrivateKey reports whether parsing the LMS private key would build a
+// tree higher than 5, which takes too long for fuzzing.
+func slowLmsPrivateKey(key []byte) bool {
+	if len(key) < 4 {
+		return false
+	}
+	lmsTypecode := uint(strTou32(key[:4]))
+	return lmsTypecode > LMS_SHA256_M32_H5 && lmsTypecode <= LMS_SHA256_M32_H25
+}
+
+// slowHssPrivateKey reports whether parsing the HSS private key would build
+// more than two trees, or a tree of other than the smallest parameter set:
+// LMS_SHA256_M32_H5 with LMOTS_SHA256_N32_W1. Every layer is built again when
+// the key is parsed, and the fuzzer runs each new input many times while
+// minimizing it.
+func slowHssPrivateKey(key []byte) bool {
+	if len(key) < 4 {
+		return false
+	}
+	L := strTou32(key[:4])
+	if L > 2 {
+		return true
+	}
+	lmsPrivlen := 4 + 4 + 4 + IdentifierLength + HashLength
+	for i := 0; i < L && len(key) >= 4+lmsPrivlen*i+8; i++ {
+		layer := key[4+lmsPrivlen*i:]
+		otsTypecode := uint(strTou32(layer[4:8]))
+		if slowLmsPrivateKey(layer) || (otsTypecode > LMOTS_SHA256_N32_W1 && otsTypecode <= LMOTS_SHA256_N32_W8) {
+			return true
+		}
+	}
+	return false
+}
+
+func FuzzParseOtsPublicKey(f *testing.F) {
+	otsPriv, _ := GenerateOtsPrivateKey(LMOTS_SHA256_N32_W4)
+	otsPub, _ := otsPriv.Public()
+	key, _ := hex.DecodeString(otsPub.String())
+	f.Add(key)
+	f.Add(append(u32Str(0), key[4:]...))
+	f.Fuzz(func(t *testing.T, key []byte) {
+		otsPub, err := ParseOtsPublicKey(hex.EncodeToString(key))
+		if err == nil && otsPub.String() != hex.EncodeToString(key) {
+			t.Errorf("String() = %s, want %x", otsPub.String(), key)
+		}
+	})
+}
+
+func FuzzParseOtsPrivateKey(f *testing.F) {
+	otsPriv, _ := GenerateOtsPrivateKey(LMOTS_SHA256_N32_W4)
+	key, _ := hex.DecodeString(privateHex(otsPriv))
+	f.Add(key)
+	f.Add(append(u32Str(0), key[4:]...))
+	f.Fuzz(func(t *testing.T, key []byte) {
+		otsPriv, err := ParseOtsPrivateKey(hex.EncodeToString(key))
+		if err == nil && privateHex(otsPriv) != hex.EncodeToString(key) {
+			t.Errorf("MarshalPrivate() = %s, want %x", privateHex(otsPriv), key)
+		}
+	})
+}
+
+func FuzzParseLmsPublicKey(f *testing.F) {
+	_, hssPub, _, _ := fuzzSeeds()
+	key := hssPub.lmsPub.serialize()
+	f.Add(key)
+	f.Add(bytes.Join([][]byte{key[:4], u32Str(0), key[8:]}, nil))
+	f.Fuzz(func(t *testing.T, key []byte) {
+		lmsPub, err := ParseLmsPublicKey(hex.EncodeToString(key))
+		if err == nil && !bytes.Equal(lmsPub.serialize(), key) {
+			t.Errorf("serialize() = %x, want %x", lmsPub.serialize(), key)
+		}
+	})
+}
+
+func FuzzParseLmsPrivateKey(f *testing.F) {
+	hssPriv, _, _, _ := fuzzSeeds()
+	key := hssPriv.lmsPriv[0].serialize()
+	f.Add(key)
+	f.Add(bytes.Join([][]byte{key[:4], u32Str(0), key[8:]}, nil))
+	f.Fuzz(func(t *testing.T, key []byte) {
+		if slowLmsPrivateKey(key) {
+			t.Skip()
+		}
+		lmsPriv, err := ParseLmsPrivateKey(hex.EncodeToString(key))
+		if err == nil && !bytes.Equal(lmsPriv.serialize(), key) {
+			t.Errorf("serialize() = %x, want %x", lmsPriv.serialize(), key)
+		}
+	})
+}
+
+func FuzzParseHssPublicKey(f *testing.F) {
+	_, hssPub, _, _ := fuzzSeeds()
+	key, _ := hex.DecodeString(hssPub.String())
+	f.Add(key)
+	f.Add(append(u32Str(0), key[4:]...))
+	f.Fuzz(func(t *testing.T, key []byte) {
+		hssPub, err := ParseHssPublicKey(hex.EncodeToString(key))
+		if err == nil && hssPub.String() != hex.EncodeToString(key) {
+			t.Errorf("String() = %s, want %x", hssPub.String(), key)
+		}
+	})
+}
+
+func FuzzParseHssPrivateKey(f *testing.F) {
+	// After one signature the key also holds the next tree of the bottom layer.
+	hssPriv, _ := GenerateHssPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W1, 2)
+	hssPriv.Sign([]byte("fuzz"))
+	key, _ := hex.DecodeString(privateHex(hssPriv))
+	f.Add(key)
+	f.Add(u32Str(0))
+	f.Fuzz(func(t *testing.T, key []byte) {
+		if slowHssPrivateKey(key) {
+			t.Skip()
+		}
+		hssPriv, err := ParseHssPrivateKey(hex.EncodeToString(key))
+		if err == nil && hssPriv.Public() == nil {
+			t.Error("parsed HSS private key has no public key")
+		}
+	})
+}
+
+func FuzzParseLmsSignature(f *testing.F) {
+	_, _, _, sig := fuzzSeeds()
+	hssSig, _ := ParseHssSignature(sig)
+	f.Add(hssSig.lmsSig[1].Marshal())
+	f.Fuzz(func(t *testing.T, lmsSig []byte) {
+		sig, err := ParseLmsSignature(lmsSig)
+		if err != nil {
+			return
+		}
+		if !bytes.Equal(sig.Marshal(), lmsSig) {
+			t.Errorf("Marshal() = %x, want %x", sig.Marshal(), lmsSig)
+		}
+		sig.Describe()
+	})
+}
+
+func FuzzParseHssSignature(f *testing.F) {
+	_, _, _, sig := fuzzSeeds()
+	f.Add(sig)
+	f.Fuzz(func(t *testing.T, hssSig []byte) {
+		sig, err := ParseHssSignature(hssSig)
+		if err != nil {
+			return
+		}
+		if !bytes.Equal(sig.Marshal(), hssSig) {
+			t.Errorf("Marshal() = %x, want %x", sig.Marshal(), hssSig)
+		}
+		sig.Describe()
+	})
+}
+
+func FuzzOtsVerify(f *testing.F) {
+	otsPriv, _ := GenerateOtsPrivateKey(LMOTS_SHA256_N32_W4)
+	otsPub, _ := otsPriv.Public()
+	key, _ := hex.DecodeString(otsPub.String())
+	otsSig, _ := otsPriv.Sign([]byte("fuzz"))
+	f.Add(key, []byte("fuzz"), otsSig)
+	f.Fuzz(func(t *testing.T, key []byte, message []byte, otsSig []byte) {
+		otsPub, err := ParseOtsPublicKey(hex.EncodeToString(key))
+		if err != nil {
+			otsPub = &OtsPublicKey{}
+		}
+		otsPub.Verify(message, otsSig)
+	})
+}
+
+func FuzzLmsVerify(f *testing.F) {
+	_, hssPub, message, sig := fuzzSeeds()
+	hssSig, _ := ParseHssSignature(sig)
+	f.Add(hssSig.lmsPub[0].serialize(), message, hssSig.lmsSig[1].Marshal())
+	f.Fuzz(func(t *testing.T, key []byte, message []byte, lmsSig []byte) {
+		lmsPub, err := parseLmsPublicKey(key)
+		if err != nil {
+			lmsPub = hssPub.lmsPub
+		}
+		lmsPub.Verify(message, lmsSig)
+	})
+}
+
+func FuzzHssVerify(f *testing.F) {
+	_, hssPub, message, sig := fuzzSeeds()
+	key, _ := hex.DecodeString(hssPub.String())
+	f.Add(key, message, sig)
+	f.Fuzz(func(t *testing.T, key []byte, message []byte, hssSig []byte) {
+		hssPub, err := ParseHssPublicKey(hex.EncodeToString(key))
+		if err != nil {
+			return
+		}
+		hssPub.Verify(message, hssSig)
+	})
+}
@@ -5,17 +5,28 @@
 package ldwm
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"math"
+	"math/big"
 )
 
 // HSS private key.
 type HssPrivateKey struct {
 	layer   int
+	k       int
 	lmsPriv []*LmsPrivateKey
 	lmsPub  []*LmsPublicKey
 	lmsSig  [][]byte
+	// The trees that will replace those of the layers below the top one. Their
+	// leaves are computed a few per signature, so that no signature computes a
+	// whole tree.
+	next []*LmsPrivateKey
 }
 
 // HSS private key.
@@ -26,18 +37,88 @@ type HssPublicKey struct {
 
 // Generates an HSS private key. The value of layer should satisfy 1 <= layer <= 8.
 func GenerateHssPrivateKey(lmsTypecode uint, otsTypecode uint, layer int) (*HssPrivateKey, error) {
+	lmsType, err := lmsParams(lmsTypecode)
+	if err != nil {
+		return nil, errors.New("hss: invalid LMS typecode")
+	}
+	return GenerateHssPrivateKeyWithK(lmsTypecode, otsTypecode, layer, defaultK(lmsType.h))
+}
+
+// Generates an HSS private key whose LMS trees use the BDS traversal algorithm with
+// parameter k (see GenerateLmsPrivateKeyWithK). The value of layer should satisfy
+// 1 <= layer <= 8.
+func GenerateHssPrivateKeyWithK(lmsTypecode uint, otsTypecode uint, layer int, k int) (*HssPrivateKey, error) {
+	return generateHssPrivateKey(context.Background(), lmsTypecode, otsTypecode, layer, k, rand.Reader, nil)
+}
+
+// Generates an HSS private key whose top LMS tree takes its identifier and SEED
+// from rand (see GenerateLmsPrivateKeyWithRand). The trees of the other layers,
+// including those that replace exhausted ones, are derived from the top tree, so
+// the same input always yields the same sequence of signatures. The value of
+// layer should satisfy 1 <= layer <= 8.
+func GenerateHssPrivateKeyWithRand(lmsTypecode uint, otsTypecode uint, layer int, rand io.Reader) (*HssPrivateKey, error) {
+	lmsType, err := lmsParams(lmsTypecode)
+	if err != nil {
+		return nil, errors.New("hss: invalid LMS typecode")
+	}
+	return generateHssPrivateKey(context.Background(), lmsTypecode, otsTypecode, layer, defaultK(lmsType.h), rand, nil)
+}
+
+// Generates an HSS private key like GenerateHssPrivateKey, calling progress, if
+// it is not nil, after each leaf of the trees of all layers, layer*2^h leaves in
+// total. Generation stops with the error of ctx once ctx is done. The value of
+// layer should satisfy 1 <= layer <= 8.
+func GenerateHssPrivateKeyWithContext(ctx context.Context, lmsTypecode uint, otsTypecode uint, layer int, progress Progress) (*HssPrivateKey, error) {
+	lmsType, err := lmsParams(lmsTypecode)
+	if err != nil {
+		return nil, errors.New("hss: invalid LMS typecode")
+	}
+	return generateHssPrivateKey(ctx, lmsTypecode, otsTypecode, layer, defaultK(lmsType.h), rand.Reader, progress)
+}
+
+func generateHssPrivateKey(ctx context.Context, lmsTypecode uint, otsTypecode uint, layer int, k int, rand io.Reader, progress Progress) (*HssPrivateKey, error) {
+	g := &keygen{ctx: ctx, progress: progress}
+	return g.hss(lmsTypecode, otsTypecode, layer, k, rand, nil)
+}
+
+// hss generates an HSS private key. The trees of its top layers may be given,
+// complete or under construction, as when resuming from a checkpoint. Otherwise
+// the top tree takes its identifier and SEED from rand, and the first tree of
+// every other layer is derived from it.
+func (g *keygen) hss(lmsTypecode uint, otsTypecode uint, layer int, k int, rand io.Reader, trees []*LmsPrivateKey) (*HssPrivateKey, error) {
 	if layer < 1 || layer > 8 {
 		return nil, errors.New("hss: layer should satisfy 1 <= layer <= 8")
 	}
 
 	hssPriv := new(HssPrivateKey)
 	hssPriv.layer = layer
+	hssPriv.k = k
 	hssPriv.lmsPriv = make([]*LmsPrivateKey, layer)
 	hssPriv.lmsPub = make([]*LmsPublicKey, layer)
 	hssPriv.lmsSig = make([][]byte, layer-1)
+	copy(hssPriv.lmsPriv, trees)
+	g.state = func() []byte { return hssCheckpoint(hssPriv, lmsTypecode, otsTypecode) }
 
 	for i := 0; i < layer; i++ {
-		hssPriv.lmsPriv[i], _ = GenerateLmsPrivateKey(lmsTypecode, otsTypecode)
+		if hssPriv.lmsPriv[i] == nil {
+			var lmsPriv *LmsPrivateKey
+			var err error
+			if i == 0 {
+				lmsPriv, err = newLmsPrivateKey(lmsTypecode, otsTypecode, k, rand)
+			} else {
+				lmsPriv, err = childTree(hssPriv.lmsPriv[0], i, new(big.Int), k)
+			}
+			if err != nil {
+				destroyAll(hssPriv.lmsPriv)
+				return nil, err
+			}
+			hssPriv.lmsPriv[i] = lmsPriv
+		}
+		g.total = uint64(layer) << uint(hssPriv.lmsPriv[i].height)
+		if err := g.build(hssPriv.lmsPriv[i]); err != nil {
+			destroyAll(hssPriv.lmsPriv)
+			return nil, err
+		}
 		hssPriv.lmsPub[i], _ = hssPriv.lmsPriv[i].Public()
 	}
 
@@ -48,16 +129,186 @@ func GenerateHssPrivateKey(lmsTypecode uint, otsTypecode uint, layer int) (*HssP
 	return hssPriv, nil
 }
 
-// Serializes the private key and converts it to a hexadecimal string.
-func (hssPriv *HssPrivateKey) String() string {
-	str := string(u32Str(hssPriv.layer))
-	for i := 0; i < hssPriv.layer-1; i++ {
-		hssPriv.lmsPriv[i].q--
-		str += string(hssPriv.lmsPriv[i].serialize())
-		hssPriv.lmsPriv[i].q++
+// destroyAll destroys the trees of a key whose generation failed.
+func destroyAll(lmsPriv []*LmsPrivateKey) {
+	for _, mt := range lmsPriv {
+		if mt != nil {
+			mt.Destroy()
+		}
 	}
-	str += string(hssPriv.lmsPriv[hssPriv.layer-1].serialize())
-	return fmt.Sprintf("%x", str)
+}
+
+// Returns the number of signatures the private key can still generate, or
+// math.MaxUint64 if the number does not fit in a uint64.
+func (hssPriv *HssPrivateKey) Remaining() uint64 {
+	// The leaves of all bottom trees are numbered consecutively. Every layer above
+	// the bottom one has already used the leaf that signed the current child.
+	total := new(big.Int)
+	used := new(big.Int)
+	for i, lmsPriv := range hssPriv.lmsPriv {
+		q := lmsPriv.q
+		if i < len(hssPriv.lmsPriv)-1 {
+			q--
+		}
+		total.Lsh(total.SetInt64(1), uint(lmsPriv.height*(i+1)))
+		used.Lsh(used, uint(lmsPriv.height)).Add(used, big.NewInt(int64(q)))
+	}
+	remaining := total.Sub(total, used)
+	if remaining.Sign() <= 0 {
+		return 0
+	}
+	if !remaining.IsUint64() {
+		return math.MaxUint64
+	}
+	return remaining.Uint64()
+}
+
+// index returns the number of leaves of bottom trees used so far, which is the
+// index of the next signature.
+func (hssPriv *HssPrivateKey) index() *big.Int {
+	used := new(big.Int)
+	for i, lmsPriv := range hssPriv.lmsPriv {
+		q := lmsPriv.q
+		if i < len(hssPriv.lmsPriv)-1 {
+			q--
+		}
+		used.Lsh(used, uint(lmsPriv.height)).Add(used, big.NewInt(int64(q)))
+	}
+	return used
+}
+
+// Moves the private key forward so that its next signature has index idx, the
+// leaves of all bottom trees being numbered consecutively as for Remaining. The
+// trees that stay in use are advanced with LmsPrivateKey.AdvanceTo, and those
+// below a layer that moves to another leaf are replaced by the trees signing
+// would have used. The key never moves backward.
+func (hssPriv *HssPrivateKey) AdvanceTo(idx uint64) error {
+	if hssPriv.layer < 1 || len(hssPriv.lmsPriv) != hssPriv.layer ||
+		len(hssPriv.lmsPub) != hssPriv.layer || len(hssPriv.lmsSig) != hssPriv.layer-1 {
+		return errors.New("hss: invalid HSS private key")
+	}
+	height := 0
+	for _, lmsPriv := range hssPriv.lmsPriv {
+		if len(lmsPriv.skSeed) != HashLength || lmsPriv.bds == nil {
+			return errors.New("hss: invalid HSS private key")
+		}
+		height += lmsPriv.height
+	}
+	target := new(big.Int).SetUint64(idx)
+	switch target.Cmp(hssPriv.index()) {
+	case -1:
+		return errors.New("hss: cannot move the private key backward")
+	case 0:
+		return nil
+	}
+	if target.Cmp(new(big.Int).Lsh(big.NewInt(1), uint(height))) > 0 {
+		return errors.New("hss: index out of range")
+	}
+
+	// As after signature idx-1, the bottom tree has used the leaves up to that
+	// of idx-1 and every layer above it the leaf that signed its child.
+	last := idx - 1
+	leaf := make([]uint64, hssPriv.layer)
+	shift := uint(0)
+	for i := hssPriv.layer - 1; i >= 0; i-- {
+		leaf[i] = (last >> shift) & (1<<uint(hssPriv.lmsPriv[i].height) - 1)
+		shift += uint(hssPriv.lmsPriv[i].height)
+	}
+	leaf[hssPriv.layer-1]++
+
+	j := 0
+	for ; j < hssPriv.layer-1; j++ {
+		if uint64(hssPriv.lmsPriv[j].q-1) != leaf[j] {
+			break
+		}
+	}
+	if err := hssPriv.lmsPriv[j].AdvanceTo(leaf[j]); err != nil {
+		return err
+	}
+	// The new trees are those signing would have used, started by prepare.
+	if j < hssPriv.layer-1 {
+		if err := hssPriv.prepare(); err != nil {
+			return err
+		}
+	}
+	for i := j + 1; i < hssPriv.layer; i++ {
+		lmsPriv, err := hssPriv.newTree(i)
+		if err != nil {
+			return err
+		}
+		lmsPub, _ := lmsPriv.Public()
+		lmsSig, err := hssPriv.lmsPriv[i-1].Sign(lmsPub.serialize())
+		if err != nil {
+			return err
+		}
+		hssPriv.lmsPriv[i].Destroy()
+		hssPriv.lmsPriv[i] = lmsPriv
+		hssPriv.lmsPub[i] = lmsPub
+		hssPriv.lmsSig[i-1] = lmsSig
+		if err := lmsPriv.AdvanceTo(leaf[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Serializes the private key. The result contains the secret seeds of all layers
+// and must be protected like the key itself; ParseHssPrivateKey reads it back
+// from hexadecimal. The next trees under construction follow the layers, each as
+// u32 length || I || SEED || root || BDS state, the root being zero until the
+// tree is complete, or as a zero length for a layer without one.
+func (hssPriv *HssPrivateKey) MarshalPrivate() ([]byte, error) {
+	if hssPriv.layer < 1 || len(hssPriv.lmsPriv) != hssPriv.layer {
+		return nil, errors.New("hss: invalid HSS private key")
+	}
+	key := [][]byte{u32Str(hssPriv.layer)}
+	for i, lmsPriv := range hssPriv.lmsPriv {
+		if len(lmsPriv.skSeed) != HashLength {
+			return nil, errors.New("hss: invalid HSS private key")
+		}
+		// The upper layers store the leaf that signed the current child, which
+		// is signed again when the key is parsed.
+		if i < hssPriv.layer-1 {
+			lmsPriv.q--
+			key = append(key, lmsPriv.serialize())
+			lmsPriv.q++
+		} else {
+			key = append(key, lmsPriv.serialize())
+		}
+	}
+	// Keys that have not signed yet have no next trees and end here.
+	for i := 1; i < len(hssPriv.next); i++ {
+		var tree []byte
+		if hssPriv.next[i] != nil {
+			tree = hssPriv.next[i].checkpointTree()
+		}
+		key = append(key, u32Str(len(tree)), tree)
+	}
+	return bytes.Join(key, []byte("")), nil
+}
+
+// Formats the private key as a placeholder, whatever the verb, so that it is
+// not printed by accident. The key has no String method, so that it is not
+// saved in that form by mistake either; use MarshalPrivate to serialize it.
+func (hssPriv *HssPrivateKey) Format(f fmt.State, verb rune) {
+	io.WriteString(f, "ldwm.HssPrivateKey(REDACTED)")
+}
+
+// Overwrites the secret seeds of all layers of the private key with zeros. The
+// key can no longer sign or be serialized afterwards.
+func (hssPriv *HssPrivateKey) Destroy() {
+	for _, lmsPriv := range hssPriv.lmsPriv {
+		lmsPriv.Destroy()
+	}
+	for _, lmsPriv := range hssPriv.next {
+		if lmsPriv != nil {
+			lmsPriv.Destroy()
+		}
+	}
+	hssPriv.lmsPriv = nil
+	hssPriv.next = nil
+	hssPriv.lmsPub = nil
+	hssPriv.lmsSig = nil
 }
 
 // Parses an HSS private key from a hexadecimal string.
@@ -66,7 +317,29 @@ func ParseHssPrivateKey(keyHex string) (*HssPrivateKey, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer zeroize(key)
+
+	return parseHssPrivateKey(key, useDefaultK)
+}
+
+// Parses an HSS private key from a hexadecimal string and uses the BDS traversal
+// algorithm with parameter k for its LMS trees.
+func ParseHssPrivateKeyWithK(keyHex string, k int) (*HssPrivateKey, error) {
+	key, err := hex.DecodeString(keyHex)
+	if err != nil {
+		return nil, err
+	}
+	defer zeroize(key)
 
+	if k < 0 {
+		return nil, errors.New("hss: invalid BDS parameter k")
+	}
+	return parseHssPrivateKey(key, k)
+}
+
+// parseHssPrivateKey parses an HSS private key; k == useDefaultK selects the
+// default BDS parameter of each tree.
+func parseHssPrivateKey(key []byte, k int) (*HssPrivateKey, error) {
 	if len(key) < 4 {
 		return nil, errors.New("hss: (parse error) invalid HSS private key")
 	}
@@ -74,7 +347,7 @@ func ParseHssPrivateKey(keyHex string) (*HssPrivateKey, error) {
 	L := strTou32(key[:4])
 	lmsPrivlen := 4 + 4 + 4 + IdentifierLength + HashLength
 
-	if len(key) != 4+lmsPrivlen*L {
+	if L < 1 || L > 8 || len(key) < 4+lmsPrivlen*L {
 		return nil, errors.New("hss: (parse error) invalid HSS private key")
 	}
 
@@ -85,12 +358,13 @@ func ParseHssPrivateKey(keyHex string) (*HssPrivateKey, error) {
 	hssPriv.lmsSig = make([][]byte, L-1)
 
 	for i := 0; i < L; i++ {
-		lmsPriv, err := parseLmsPrivateKey(key[4+lmsPrivlen*i : 4+lmsPrivlen*(i+1)])
+		lmsPriv, err := parseLmsPrivateKey(key[4+lmsPrivlen*i:4+lmsPrivlen*(i+1)], k)
 		if err != nil {
 			return nil, errors.New("hss: (parse error) invalid HSS private key")
 		}
 		hssPriv.lmsPriv[i] = lmsPriv
 	}
+	hssPriv.k = hssPriv.lmsPriv[0].bds.K()
 
 	for i := 0; i < L; i++ {
 		hssPriv.lmsPub[i], _ = hssPriv.lmsPriv[i].Public()
@@ -100,9 +374,55 @@ func ParseHssPrivateKey(keyHex string) (*HssPrivateKey, error) {
 		hssPriv.lmsSig[i], _ = hssPriv.lmsPriv[i].Sign(hssPriv.lmsPub[i+1].serialize())
 	}
 
+	if err := hssPriv.parseNext(key[4+lmsPrivlen*L:]); err != nil {
+		hssPriv.Destroy()
+		return nil, err
+	}
 	return hssPriv, nil
 }
 
+// parseNext parses the next trees that follow the layers of a serialized key.
+// Keys written before the next trees were kept have none. A next tree that is
+// not the one replacing the current tree of its layer, as after AdvanceTo, or
+// whose BDS parameter differs from that of the key is dropped and started again.
+func (hssPriv *HssPrivateKey) parseNext(b []byte) error {
+	invalid := errors.New("hss: (parse error) invalid HSS private key")
+	if len(b) == 0 {
+		return nil
+	}
+	top := hssPriv.lmsPriv[0]
+	hssPriv.next = make([]*LmsPrivateKey, hssPriv.layer)
+	for i := 1; i < hssPriv.layer; i++ {
+		if len(b) < 4 {
+			return invalid
+		}
+		length := strTou32(b[:4])
+		b = b[4:]
+		if length == 0 {
+			continue
+		}
+		if length > len(b) {
+			return invalid
+		}
+		lmsPriv, read, err := parseCheckpointTree(b[:length], top.lmsTypecode, top.otsTypecode)
+		if err != nil || read != length {
+			return invalid
+		}
+		b = b[length:]
+		seeds := childSeeds(top, i, hssPriv.treeNumber(i))
+		if bytes.Equal(lmsPriv.id, seeds[:IdentifierLength]) && lmsPriv.bds.K() == hssPriv.k {
+			hssPriv.next[i] = lmsPriv
+		} else {
+			lmsPriv.Destroy()
+		}
+		zeroize(seeds)
+	}
+	if len(b) != 0 {
+		return invalid
+	}
+	return nil
+}
+
 // Generates the HSS public key.
 func (hssPriv *HssPrivateKey) Public() *HssPublicKey {
 	hssPub := new(HssPublicKey)
@@ -129,6 +449,9 @@ func ParseHssPublicKey(keyHex string) (*HssPublicKey, error) {
 	}
 
 	L := strTou32(key[:4])
+	if L < 1 || L > 8 {
+		return nil, errors.New("hss: (parse error) invalid HSS public key")
+	}
 	hssPub := new(HssPublicKey)
 	hssPub.layer = L
 	hssPub.lmsPub, err = parseLmsPublicKey(key[4:])
@@ -139,8 +462,42 @@ func ParseHssPublicKey(keyHex string) (*HssPublicKey, error) {
 	return hssPub, nil
 }
 
+// Performs basic sanity checks on the HSS public key.
+// Returns nil if the HSS public key is valid, or else an error describing a problem.
+func (hssPub *HssPublicKey) Validate() error {
+	if hssPub.layer < 1 || hssPub.layer > 8 || hssPub.lmsPub == nil {
+		return errors.New("hss: invalid HSS public key")
+	}
+	return hssPub.lmsPub.Validate()
+}
+
 // Generates an HSS signature for a message and updates the private key.
 func (hssPriv *HssPrivateKey) Sign(message []byte) ([]byte, error) {
+	if err := checkMessage(message); err != nil {
+		return nil, err
+	}
+	return hssPriv.sign(bytesMessage(message))
+}
+
+// sign signs a message without checking it for the batch tag.
+func (hssPriv *HssPrivateKey) sign(message messageDigest) ([]byte, error) {
+	leaf, err := hssPriv.reserve()
+	if err != nil {
+		return nil, err
+	}
+	return leaf.sign(message)
+}
+
+// An hssLeaf is a leaf of the bottom LMS tree whose index has been consumed from
+// an HSS private key, together with the signed public keys of the layers above it.
+type hssLeaf struct {
+	prefix []byte
+	leaf   *lmsLeaf
+}
+
+// reserve consumes the next leaf of the bottom LMS tree, replacing exhausted
+// trees first, and updates the private key.
+func (hssPriv *HssPrivateKey) reserve() (*hssLeaf, error) {
 	if len(hssPriv.lmsPriv) != hssPriv.layer ||
 		len(hssPriv.lmsPub) != hssPriv.layer ||
 		len(hssPriv.lmsSig) != hssPriv.layer-1 {
@@ -150,12 +507,16 @@ func (hssPriv *HssPrivateKey) Sign(message []byte) ([]byte, error) {
 		if len(hssPriv.lmsPriv) == 1 {
 			return nil, errors.New("hss: attempted overuse of hss private key")
 		}
+		hssPriv.lmsPriv[len(hssPriv.lmsPriv)-1].Destroy()
 		hssPriv.lmsPriv = hssPriv.lmsPriv[:len(hssPriv.lmsPriv)-1]
 		hssPriv.lmsPub = hssPriv.lmsPub[:len(hssPriv.lmsPub)-1]
 		hssPriv.lmsSig = hssPriv.lmsSig[:len(hssPriv.lmsSig)-1]
 	}
 	for len(hssPriv.lmsPriv) < hssPriv.layer {
-		lmsPriv, _ := GenerateLmsPrivateKey(hssPriv.lmsPriv[0].lmsTypecode, hssPriv.lmsPriv[0].otsTypecode)
+		lmsPriv, err := hssPriv.newTree(len(hssPriv.lmsPriv))
+		if err != nil {
+			return nil, err
+		}
 		lmsPub, _ := lmsPriv.Public()
 		hssPriv.lmsPriv = append(hssPriv.lmsPriv, lmsPriv)
 		hssPriv.lmsPub = append(hssPriv.lmsPub, lmsPub)
@@ -163,69 +524,181 @@ func (hssPriv *HssPrivateKey) Sign(message []byte) ([]byte, error) {
 		hssPriv.lmsSig = append(hssPriv.lmsSig, lmsSig)
 	}
 
-	mSig, err := hssPriv.lmsPriv[len(hssPriv.lmsPriv)-1].Sign(message)
+	if err := hssPriv.prepare(); err != nil {
+		return nil, err
+	}
+	hssPriv.grow()
+
+	leaf, err := hssPriv.lmsPriv[len(hssPriv.lmsPriv)-1].reserve()
 	if err != nil {
 		return nil, err
 	}
 
-	hssSig := make([]byte, 4)
-	copy(hssSig, u32Str(hssPriv.layer-1))
+	prefix := make([]byte, 4)
+	copy(prefix, u32Str(hssPriv.layer-1))
 
 	for i := 0; i < hssPriv.layer-1; i++ {
-		hssSig = append(hssSig, hssPriv.lmsSig[i]...)
-		hssSig = append(hssSig, hssPriv.lmsPub[i+1].serialize()...)
+		prefix = append(prefix, hssPriv.lmsSig[i]...)
+		prefix = append(prefix, hssPriv.lmsPub[i+1].serialize()...)
 	}
 
-	hssSig = append(hssSig, mSig...)
+	return &hssLeaf{prefix: prefix, leaf: leaf}, nil
+}
 
-	return hssSig, nil
+// prepare starts a next tree for every layer below the top one that has none.
+func (hssPriv *HssPrivateKey) prepare() error {
+	if len(hssPriv.next) != hssPriv.layer {
+		hssPriv.next = make([]*LmsPrivateKey, hssPriv.layer)
+	}
+	for i := 1; i < hssPriv.layer; i++ {
+		if hssPriv.next[i] != nil {
+			continue
+		}
+		lmsPriv, err := childTree(hssPriv.lmsPriv[0], i, hssPriv.treeNumber(i), hssPriv.k)
+		if err != nil {
+			return err
+		}
+		hssPriv.next[i] = lmsPriv
+	}
+	return nil
+}
+
+// grow computes leaves of the next trees, spreading the leaves a tree still
+// lacks evenly over the signatures left before the tree it replaces is
+// exhausted. Unless the key was parsed or moved shortly before that, this is
+// one leaf per layer for most signatures.
+func (hssPriv *HssPrivateKey) grow() {
+	for i := 1; i < len(hssPriv.next); i++ {
+		mt := hssPriv.next[i]
+		if mt == nil || mt.bds.Initialized() {
+			continue
+		}
+		steps := big.NewInt(int64(powInt(2, mt.height) - mt.bds.InitLeaves()))
+		if left := hssPriv.remainingUnder(i); left.Sign() > 0 {
+			steps.Add(steps, left).Sub(steps, big.NewInt(1)).Div(steps, left)
+		}
+		for n := steps.Int64(); n > 0 && !mt.bds.Initialized(); n-- {
+			mt.bds.InitStep(lmsHasher{mt})
+		}
+	}
+}
+
+// remainingUnder returns the number of signatures the current tree of layer i
+// can still generate, counting the one being made.
+func (hssPriv *HssPrivateKey) remainingUnder(i int) *big.Int {
+	left := new(big.Int)
+	for _, lmsPriv := range hssPriv.lmsPriv[i:] {
+		left.Lsh(left, uint(lmsPriv.height)).Add(left, big.NewInt(int64(powInt(2, lmsPriv.height)-lmsPriv.q)))
+	}
+	return left
+}
+
+// newTree returns the tree that the current tree of layer i-1 signs next,
+// completing the next tree of layer i if it is that tree.
+func (hssPriv *HssPrivateKey) newTree(i int) (*LmsPrivateKey, error) {
+	lmsPriv, err := childTree(hssPriv.lmsPriv[0], i, hssPriv.treeNumber(i), hssPriv.k)
+	if err != nil {
+		return nil, err
+	}
+	if i < len(hssPriv.next) && hssPriv.next[i] != nil {
+		next := hssPriv.next[i]
+		hssPriv.next[i] = nil
+		if bytes.Equal(next.id, lmsPriv.id) {
+			lmsPriv.Destroy()
+			lmsPriv = next
+		} else {
+			next.Destroy()
+		}
+	}
+	lmsPriv.root = lmsPriv.bds.Init(lmsHasher{lmsPriv})
+	return lmsPriv, nil
+}
+
+// treeNumber returns the number of the tree of layer i, counting from 0, that
+// the current tree of layer i-1 signs with its next leaf. Each layer above
+// layer i-1 has already signed its current child with leaf q-1.
+func (hssPriv *HssPrivateKey) treeNumber(i int) *big.Int {
+	t := new(big.Int)
+	for j, lmsPriv := range hssPriv.lmsPriv[:i] {
+		q := lmsPriv.q
+		if j < i-1 {
+			q--
+		}
+		t.Lsh(t, uint(lmsPriv.height)).Add(t, big.NewInt(int64(q)))
+	}
+	return t
+}
+
+// childTree returns tree t of layer i, counting from 0, with the BDS parameter
+// k, leaving its tree to be computed by the caller. Its identifier and SEED are
+// derived from those of the top tree, I' and SEED', like the LM-OTS keys in the
+// pseudorandom key generation of RFC 8554, Appendix A:
+//
+//	I    = H(I' || u32str(i) || t || u16str(0xffff) || u8str(0xff) || SEED')[:16]
+//	SEED = H(I' || u32str(i) || t || u16str(0xfffe) || u8str(0xff) || SEED')
+//
+// where t takes 32 bytes, so that the inputs never coincide with those of the
+// LM-OTS keys and randomizers of the top tree.
+func childTree(top *LmsPrivateKey, i int, t *big.Int, k int) (*LmsPrivateKey, error) {
+	seeds := childSeeds(top, i, t)
+	defer zeroize(seeds)
+	return newLmsPrivateKey(top.lmsTypecode, top.otsTypecode, k, bytes.NewReader(seeds))
+}
+
+// childSeeds returns the identifier and SEED of tree t of layer i, as I || SEED.
+func childSeeds(top *LmsPrivateKey, i int, t *big.Int) []byte {
+	hash := lmsTypes[top.lmsTypecode].hash
+	input := bytes.Join([][]byte{top.id, u32Str(i), t.FillBytes(make([]byte, 32)), u16Str(0xffff), {0xff}, top.skSeed}, nil)
+	defer zeroize(input)
+	seeds := hash(input)[:IdentifierLength]
+	copy(input[IdentifierLength+4+32:], u16Str(0xfffe))
+	return append(seeds, hash(input)...)
+}
+
+// sign signs the message with the reserved leaf and assembles the HSS signature.
+func (leaf *hssLeaf) sign(message messageDigest) ([]byte, error) {
+	mSig, err := leaf.leaf.sign(message)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(leaf.prefix, mSig...), nil
 }
 
 // Verifies a message with its HSS signature.
 func (hssPub *HssPublicKey) Verify(message, hssSig []byte) error {
-	if len(hssSig) < 4 {
-		return errors.New("hss: invalid HSS signature")
-	}
+	return hssPub.verify(bytesMessage(message), hssSig, nil)
+}
 
-	L := strTou32(hssSig[:4]) + 1
-	if L != hssPub.layer {
+// verify verifies an HSS signature. The signatures of the intermediate LMS public
+// keys are looked up in and added to the cache if it is not nil.
+func (hssPub *HssPublicKey) verify(message messageDigest, hssSig []byte, cache *verifyCache) error {
+	err := hssPub.Validate()
+	if err != nil {
+		return err
+	}
+	sig, err := ParseHssSignature(hssSig)
+	if err != nil {
+		return err
+	}
+	if sig.Layers() != hssPub.layer {
 		return errors.New("hss: invalid HSS signature")
 	}
-	hssSig = hssSig[4:]
 
 	lmsPub := hssPub.lmsPub
-
-	for i := 0; i < L-1; i++ {
-		p := otsTypes[lmsPub.otsTypecode].p
-		n := otsTypes[lmsPub.otsTypecode].n
-		m := lmsTypes[lmsPub.lmsTypecode].m
-		h := lmsTypes[lmsPub.lmsTypecode].h
-		lmsSiglen := 4 + (4 + n + n*p) + 4 + h*m
-		if len(hssSig) < lmsSiglen {
-			return errors.New("hss: invalid HSS signature")
-		}
-		lmsSig := hssSig[:lmsSiglen]
-		hssSig = hssSig[lmsSiglen:]
-		if len(hssSig) < 8 {
-			return errors.New("hss: invalid HSS signature")
-		}
-		nextLmsPubLen := 4 + 4 + IdentifierLength + lmsTypes[uint(strTou32(hssSig[:4]))].m
-		if len(hssSig) < nextLmsPubLen {
-			return errors.New("hss: invalid HSS signature")
-		}
-		nextLmsPub := hssSig[:nextLmsPubLen]
-		hssSig = hssSig[nextLmsPubLen:]
-		err := lmsPub.Verify(nextLmsPub, lmsSig)
+	for i, nextLmsPub := range sig.lmsPub {
+		lmsSig := sig.lmsSig[i]
+		key := string(lmsPub.serialize()) + string(lmsSig.Marshal()) + string(nextLmsPub.serialize())
+		err := cache.do(key, func() error {
+			return lmsPub.verifySignature(bytesMessage(nextLmsPub.serialize()), lmsSig)
+		})
 		if err != nil {
 			return errors.New("hss: invalid LMS signature")
 		}
-		lmsPub, err = parseLmsPublicKey(nextLmsPub)
-		if err != nil {
-			return errors.New("hss: invalid LMS public key")
-		}
+		lmsPub = nextLmsPub
 	}
 
-	err := lmsPub.Verify(message, hssSig)
+	err = lmsPub.verifySignature(message, sig.lmsSig[len(sig.lmsSig)-1])
 	if err != nil {
 		return errors.New("hss: invalid LMS signature")
 	}
@@ -5,8 +5,11 @@
 package ldwm
 
 import (
+	"bytes"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
+	"math/big"
 	"testing"
 )
 
@@ -26,7 +29,7 @@ func TestHssKeyGeneration(t *testing.T) {
 				}
 				hssPub := hssPriv.Public()
 
-				phssPriv, pPrivErr := ParseHssPrivateKey(hssPriv.String())
+				phssPriv, pPrivErr := ParseHssPrivateKey(privateHex(hssPriv))
 				if pPrivErr != nil {
 					t.Errorf("failed to parse a private key when lmsTypecode = %d, otsTypecode = %d, L = %d", lmsTypecode, otsTypecode, L)
 				}
@@ -36,9 +39,9 @@ func TestHssKeyGeneration(t *testing.T) {
 					t.Errorf("failed to parse a public key when lmsTypecode = %d, otsTypecode = %d, L = %d", lmsTypecode, otsTypecode, L)
 				}
 
-				if hssPriv.String() != phssPriv.String() {
-					fmt.Println(hssPriv.String())
-					fmt.Println(phssPriv.String())
+				if privateHex(hssPriv) != privateHex(phssPriv) {
+					fmt.Println(privateHex(hssPriv))
+					fmt.Println(privateHex(phssPriv))
 					t.Errorf("parsed HSS private != HSS private key when lmsTypecode = %d, otsTypecode = %d, L = %d", lmsTypecode, otsTypecode, L)
 				}
 
@@ -991,3 +994,163 @@ func TestRfc8554TestCases(t *testing.T) {
 	testVector1(t)
 	testVector2(t)
 }
+
+func TestHssParseKeepsChildSignatures(t *testing.T) {
+	hssPriv, _ := GenerateHssPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W4, 3)
+	hssPriv.Sign([]byte("abc"))
+	phssPriv, err := ParseHssPrivateKey(privateHex(hssPriv))
+	if err != nil {
+		t.Fatalf("failed to parse private key: %v", err)
+	}
+	// Parsing signs the child public keys again with leaves that were already
+	// used, which must reproduce the original signatures.
+	for i := range hssPriv.lmsSig {
+		if !bytes.Equal(phssPriv.lmsSig[i], hssPriv.lmsSig[i]) {
+			t.Errorf("signature of child public key %d changed after parsing", i+1)
+		}
+	}
+}
+
+func TestHssChildTreeDerivation(t *testing.T) {
+	// The expected values were computed with Python's hashlib from the formulas
+	// in the comment of childTree and the key generation of RFC 8554, Appendix A,
+	// for I' = 00 01 ... 0f and SEED' = 10 11 ... 2f.
+	seed := make([]byte, IdentifierLength+HashLength)
+	for i := range seed {
+		seed[i] = byte(i)
+	}
+	hssPriv, err := GenerateHssPrivateKeyWithRand(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8, 2, bytes.NewReader(seed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		tree       int64
+		id, skSeed string
+	}{
+		{0, "a3fe6e4efd22bf97dbcb0acd28f970d2", "417f095ae8472daf9baa8d4fccc4ced101523817dcc94fdac4d43edee9414bcb"},
+		{31, "ae7ae50a23d87406390563f8cd574ace", "042e56c104b98465d33119e1c07f2e3d2736927e16a4030a342e91c2cc16aa12"},
+	}
+	for _, test := range tests {
+		seeds := childSeeds(hssPriv.lmsPriv[0], 1, big.NewInt(test.tree))
+		if got := hex.EncodeToString(seeds[:IdentifierLength]); got != test.id {
+			t.Errorf("I of tree %d of layer 1 = %s, want %s", test.tree, got, test.id)
+		}
+		if got := hex.EncodeToString(seeds[IdentifierLength:]); got != test.skSeed {
+			t.Errorf("SEED of tree %d of layer 1 = %s, want %s", test.tree, got, test.skSeed)
+		}
+	}
+	child := hssPriv.lmsPriv[1]
+	if hex.EncodeToString(child.id) != tests[0].id || hex.EncodeToString(child.skSeed) != tests[0].skSeed {
+		t.Error("the first tree of layer 1 was not derived from the top tree")
+	}
+	want := "a7b8e51317c9e448a78c194b602b00a955e83d18770e81f560e38cf8d5d961cf"
+	if got := hex.EncodeToString(hssPriv.lmsPub[1].t1); got != want {
+		t.Errorf("T[1] of tree 0 of layer 1 = %s, want %s", got, want)
+	}
+}
+
+func TestHssRemaining(t *testing.T) {
+	hssPriv, _ := GenerateHssPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8, 2)
+	for i := 0; i < 40; i++ {
+		if r := hssPriv.Remaining(); r != uint64(1024-i) {
+			t.Fatalf("Remaining() = %d after %d signatures, want %d", r, i, 1024-i)
+		}
+		hssPriv.Sign([]byte("abc"))
+	}
+	phssPriv, _ := ParseHssPrivateKey(privateHex(hssPriv))
+	if phssPriv.Remaining() != hssPriv.Remaining() {
+		t.Errorf("Remaining() = %d after parsing, want %d", phssPriv.Remaining(), hssPriv.Remaining())
+	}
+	tall, _ := GenerateHssPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8, 8)
+	if tall.Remaining() != 1<<40 {
+		t.Errorf("Remaining() = %d, want 2^40", tall.Remaining())
+	}
+}
+
+func TestHssDestroysReplacedTrees(t *testing.T) {
+	hssPriv, _ := GenerateHssPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W4, 2)
+	old := hssPriv.lmsPriv[1]
+	for i := 0; i <= 32; i++ {
+		hssPriv.Sign([]byte("abc"))
+	}
+	if hssPriv.lmsPriv[1] == old || old.skSeed != nil {
+		t.Error("the exhausted bottom tree was not destroyed when it was replaced")
+	}
+}
+
+func TestHssBuildsNextTreesAhead(t *testing.T) {
+	hssPriv, _ := GenerateHssPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8, 3)
+	hssPub := hssPriv.Public()
+	for i := 0; i < 100; i++ {
+		next := append([]*LmsPrivateKey(nil), hssPriv.next...)
+		leaves := make([]int, len(next))
+		for j, mt := range next {
+			if mt != nil {
+				leaves[j] = mt.bds.InitLeaves()
+			}
+		}
+		// The bottom tree is exhausted every 32 signatures; its replacement
+		// must be complete by then.
+		if i > 0 && i%32 == 0 && !next[2].bds.Initialized() {
+			t.Fatalf("the next bottom tree is incomplete before signature %d", i)
+		}
+		sig, err := hssPriv.Sign([]byte("abc"))
+		if err != nil || hssPub.Verify([]byte("abc"), sig) != nil {
+			t.Fatalf("invalid signature %d: %v", i, err)
+		}
+		if i > 0 && i%32 == 0 && hssPriv.lmsPriv[2] != next[2] {
+			t.Errorf("signature %d did not use the next bottom tree", i)
+		}
+		for j, mt := range next {
+			if mt != nil && mt == hssPriv.next[j] && mt.bds.InitLeaves()-leaves[j] > 1 {
+				t.Errorf("signature %d computed %d leaves of the next tree of layer %d", i, mt.bds.InitLeaves()-leaves[j], j)
+			}
+		}
+	}
+}
+
+func TestHssMarshalsNextTrees(t *testing.T) {
+	hssPriv, _ := GenerateHssPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8, 3)
+	for i := 0; i < 20; i++ {
+		hssPriv.Sign([]byte("abc"))
+	}
+	key, _ := hssPriv.MarshalPrivate()
+	phssPriv, err := ParseHssPrivateKey(hex.EncodeToString(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < 3; i++ {
+		if phssPriv.next[i] == nil || !bytes.Equal(phssPriv.next[i].id, hssPriv.next[i].id) ||
+			phssPriv.next[i].bds.InitLeaves() != hssPriv.next[i].bds.InitLeaves() {
+			t.Fatalf("the next tree of layer %d was not kept", i)
+		}
+	}
+
+	// A key written without the next trees still parses and starts them again.
+	lmsPrivlen := 4 + 4 + 4 + IdentifierLength + HashLength
+	old, err := ParseHssPrivateKey(hex.EncodeToString(key[:4+3*lmsPrivlen]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.next != nil {
+		t.Error("a key without next trees has some after parsing")
+	}
+	for i := 20; i < 40; i++ {
+		sig, _ := hssPriv.Sign([]byte("abc"))
+		psig, _ := phssPriv.Sign([]byte("abc"))
+		osig, _ := old.Sign([]byte("abc"))
+		if !bytes.Equal(sig, psig) || !bytes.Equal(sig, osig) {
+			t.Fatalf("signature %d differs after parsing", i)
+		}
+	}
+
+	if _, err := ParseHssPrivateKey(hex.EncodeToString(append(key, 0))); err == nil {
+		t.Error("a key with trailing data was accepted")
+	}
+	if err := hssPriv.AdvanceTo(100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseHssPrivateKey(privateHex(hssPriv)); err != nil {
+		t.Errorf("a moved key does not parse: %v", err)
+	}
+}
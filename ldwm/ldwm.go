@@ -6,6 +6,7 @@ package ldwm
 
 import (
 	"crypto/sha256"
+	"errors"
 	"math"
 )
 
@@ -74,6 +75,33 @@ var lmsTypes = map[uint]*lmsType{
 	uint(LMS_SHA256_M32_H25): {sha256.Size, 25, sha256Hash},
 }
 
+// Returns the parameters of an LM-OTS typecode, or an error if the typecode is
+// not supported. Typecodes read from keys and signatures are checked with it
+// before any table lookup.
+func otsParams(otsTypecode uint) (*otsType, error) {
+	if t := otsTypes[otsTypecode]; t != nil {
+		return t, nil
+	}
+	return nil, errors.New("lmots: invalid LM-OTS typecode")
+}
+
+// Returns the parameters of an LMS typecode, or an error if the typecode is not
+// supported.
+func lmsParams(lmsTypecode uint) (*lmsType, error) {
+	if t := lmsTypes[lmsTypecode]; t != nil {
+		return t, nil
+	}
+	return nil, errors.New("lms: invalid LMS typecode")
+}
+
+// Overwrites b with zeros. It is used to clear secret values once they are no
+// longer needed.
+func zeroize(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
 func u32Str(i int) []byte {
 	str := [4]byte{byte((i & 0xff000000) >> 24),
 		byte((i & 0x00ff0000) >> 16),
@@ -126,6 +154,44 @@ func sibing(i int) int {
 
 }
 
+// A chainHasher computes H(I || u32str(q) || u16str(i) || u8str(j) || tmp) for
+// the Winternitz chains of a single LM-OTS key pair. The I || u32str(q) prefix
+// is shared by every chain hash of the key pair, so it is written once into a
+// reusable block and only i, j and the chaining value change between calls.
+// Unlike the PRF of the xmss package, the prefix is not kept as a SHA-256
+// midstate: the whole 55-byte input fits in a single block, so there is no
+// compression to skip. The gain is the copying and allocation of the inputs
+// of every step (BenchmarkChainHasher against BenchmarkChainJoin).
+type chainHasher struct {
+	buf  []byte
+	hash func([]byte) []byte
+}
+
+func newChainHasher(I []byte, q int, n int, hash func([]byte) []byte) *chainHasher {
+	c := new(chainHasher)
+	c.buf = make([]byte, IdentifierLength+4+2+1+n)
+	copy(c.buf, I)
+	copy(c.buf[IdentifierLength:], u32Str(q))
+	c.hash = hash
+	return c
+}
+
+// sum returns H(I || u32str(q) || u16str(i) || u8str(j) || tmp).
+func (c *chainHasher) sum(i int, j int, tmp []byte) []byte {
+	copy(c.buf[IdentifierLength+4:], u16Str(i))
+	c.buf[IdentifierLength+6] = byte(j)
+	copy(c.buf[IdentifierLength+7:], tmp)
+	return c.hash(c.buf)
+}
+
+// iterate applies the chain function to tmp for j = from, ..., to-1 in chain i.
+func (c *chainHasher) iterate(tmp []byte, i int, from int, to int) []byte {
+	for j := from; j < to; j++ {
+		tmp = c.sum(i, j, tmp)
+	}
+	return tmp
+}
+
 func sha256Hash(message []byte) []byte {
 	digest := sha256.Sum256(message)
 	return digest[:]
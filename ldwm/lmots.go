@@ -7,9 +7,11 @@ package ldwm
 import (
 	"bytes"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"strings"
 )
 
@@ -49,24 +51,35 @@ type OtsPublicKey struct {
 
 // Generates an LM-OTS private key.
 func GenerateOtsPrivateKey(otsTypecode uint) (*OtsPrivateKey, error) {
+	return GenerateOtsPrivateKeyWithRand(otsTypecode, rand.Reader)
+}
+
+// Generates an LM-OTS private key whose identifier I and SEED are read, in this
+// order, from rand. The same input always yields the same key.
+func GenerateOtsPrivateKeyWithRand(otsTypecode uint, rand io.Reader) (*OtsPrivateKey, error) {
+	if _, err := otsParams(otsTypecode); err != nil {
+		return nil, err
+	}
+
 	I := make([]byte, IdentifierLength)
-	_, err := rand.Read(I)
+	_, err := io.ReadFull(rand, I)
 	if err != nil {
 		return nil, err
 	}
 
 	seed := make([]byte, HashLength)
-	_, err = rand.Read(seed)
+	_, err = io.ReadFull(rand, seed)
 	if err != nil {
 		return nil, err
 	}
+	defer zeroize(seed)
 
 	return generateOtsPrivateKey(otsTypecode, 0, I, seed)
 }
 
 func generateOtsPrivateKey(otsTypecode uint, q int, I []byte, seed []byte) (*OtsPrivateKey, error) {
-	if otsTypecode < LMOTS_SHA256_N32_W1 || otsTypecode > LMOTS_SHA256_N32_W8 {
-		return nil, errors.New("lmots: invalid LM-OTS typecode")
+	if _, err := otsParams(otsTypecode); err != nil {
+		return nil, err
 	}
 	otsPriv := new(OtsPrivateKey)
 	otsPriv.otsTypecode = otsTypecode
@@ -78,13 +91,13 @@ func generateOtsPrivateKey(otsTypecode uint, q int, I []byte, seed []byte) (*Ots
 		return nil, errors.New("lmots: invalid identifier I")
 	}
 	otsPriv.id = I
-	otsPriv.seed = seed
+	otsPriv.seed = make([]byte, HashLength)
+	copy(otsPriv.seed, seed)
 
-	otsPriv.x = make([]byte, 0)
-	hash := otsTypes[otsTypecode].hash
+	otsPriv.x = make([]byte, 0, p*otsTypes[otsTypecode].n)
+	c := newChainHasher(I, q, otsTypes[otsTypecode].n, otsTypes[otsTypecode].hash)
 	for i := 0; i < p; i++ {
-		tmp := hash(bytes.Join([][]byte{I, u32Str(q), u16Str(i), u8Str(0xff), seed}, []byte("")))
-		otsPriv.x = append(otsPriv.x, tmp...)
+		otsPriv.x = append(otsPriv.x, c.sum(i, 0xff, seed)...)
 	}
 
 	return otsPriv, nil
@@ -108,11 +121,9 @@ func (otsPriv *OtsPrivateKey) Public() (*OtsPublicKey, error) {
 	// compute K
 	y := make([]byte, p*n)
 	hash := otsTypes[otsPub.otsTypecode].hash
+	c := newChainHasher(otsPub.id, otsPub.q, n, hash)
 	for i := 0; i < p; i++ {
-		tmp := otsPriv.x[i*n : (i+1)*n]
-		for j := 0; j < int(powInt(2, w)-1); j++ {
-			tmp = hash(bytes.Join([][]byte{otsPub.id, u32Str(otsPub.q), u16Str(i), u8Str(j), tmp}, []byte("")))
-		}
+		tmp := c.iterate(otsPriv.x[i*n:(i+1)*n], i, 0, powInt(2, w)-1)
 		copy(y[i*n:(i+1)*n], tmp)
 	}
 	otsPub.k = hash(bytes.Join([][]byte{otsPub.id, u32Str(otsPub.q), u16Str(D_PBLC), y}, []byte("")))
@@ -133,7 +144,7 @@ func ParseOtsPublicKey(keyHex string) (*OtsPublicKey, error) {
 
 	otsPub := new(OtsPublicKey)
 	otsTypecode := uint(strTou32(key[:4]))
-	if otsTypecode > LMOTS_SHA256_N32_W8 {
+	if _, err := otsParams(otsTypecode); err != nil {
 		return nil, errors.New("lmots: (parse error) invalid LM-OTS public key")
 	}
 	otsPub.otsTypecode = otsTypecode
@@ -163,7 +174,7 @@ func ParseOtsPrivateKey(keyhex string) (*OtsPrivateKey, error) {
 
 	otsPriv := new(OtsPrivateKey)
 	otsTypecode := uint(strTou32(key[:4]))
-	if otsTypecode > LMOTS_SHA256_N32_W8 {
+	if _, err := otsParams(otsTypecode); err != nil {
 		return nil, errors.New("lmots: (parse error) invalid LM-OTS private key")
 	}
 	otsPriv.otsTypecode = otsTypecode
@@ -177,20 +188,40 @@ func ParseOtsPrivateKey(keyhex string) (*OtsPrivateKey, error) {
 	otsPriv.q = strTou32(key[4+IdentifierLength : 4+IdentifierLength+4])
 	otsPriv.seed = key[4+IdentifierLength+4:]
 
-	otsPriv.x = make([]byte, 0)
-	hash := otsTypes[otsTypecode].hash
+	otsPriv.x = make([]byte, 0, p*otsTypes[otsTypecode].n)
+	c := newChainHasher(otsPriv.id, otsPriv.q, otsTypes[otsTypecode].n, otsTypes[otsTypecode].hash)
 	for i := 0; i < p; i++ {
-		tmp := hash(bytes.Join([][]byte{otsPriv.id, u32Str(otsPriv.q), u16Str(i), u8Str(0xff), otsPriv.seed}, []byte("")))
-		otsPriv.x = append(otsPriv.x, tmp...)
+		otsPriv.x = append(otsPriv.x, c.sum(i, 0xff, otsPriv.seed)...)
 	}
 
 	return otsPriv, nil
 }
 
-// Serializes the private key and converts it to a hexadecimal string.
-func (otsPriv *OtsPrivateKey) String() string {
-	return fmt.Sprintf("%x", strings.Join([]string{string(u32Str(int(otsPriv.otsTypecode))),
-		string(otsPriv.id), string(u32Str(otsPriv.q)), string(otsPriv.seed)}, ""))
+// Serializes the private key. The result contains the secret seed and must be
+// protected like the key itself; ParseOtsPrivateKey reads it back from hexadecimal.
+func (otsPriv *OtsPrivateKey) MarshalPrivate() ([]byte, error) {
+	err := otsPriv.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Join([][]byte{u32Str(int(otsPriv.otsTypecode)), otsPriv.id, u32Str(otsPriv.q),
+		otsPriv.seed}, []byte("")), nil
+}
+
+// Formats the private key as a placeholder, whatever the verb, so that it is
+// not printed by accident. The key has no String method, so that it is not
+// saved in that form by mistake either; use MarshalPrivate to serialize it.
+func (otsPriv *OtsPrivateKey) Format(f fmt.State, verb rune) {
+	io.WriteString(f, "ldwm.OtsPrivateKey(REDACTED)")
+}
+
+// Overwrites the secret values of the private key with zeros. The key can no
+// longer sign or be serialized afterwards.
+func (otsPriv *OtsPrivateKey) Destroy() {
+	zeroize(otsPriv.seed)
+	zeroize(otsPriv.x)
+	otsPriv.seed = nil
+	otsPriv.x = nil
 }
 
 // Serializes the public key and converts it to a hexadecimal string.
@@ -202,9 +233,10 @@ func (otsPub *OtsPublicKey) String() string {
 // Performs basic sanity checks on the LM-OTS private key.
 // Returns nil if the LM-OTS private key is valid, or else an error describing a problem.
 func (otsPriv *OtsPrivateKey) Validate() error {
-	switch {
-	case otsPriv.otsTypecode > LMOTS_SHA256_N32_W8:
+	if _, err := otsParams(otsPriv.otsTypecode); err != nil {
 		return errors.New("lmots: invalid key params")
+	}
+	switch {
 	case len(otsPriv.id) != 16:
 		return errors.New("lmots: invalid identifier I")
 	case otsPriv.q < 0:
@@ -221,9 +253,10 @@ func (otsPriv *OtsPrivateKey) Validate() error {
 // Performs basic sanity checks on the LM-OTS public key.
 // Returns nil if the LM-OTS public key is valid, or else an error describing a problem.
 func (otsPub *OtsPublicKey) Validate() error {
-	switch {
-	case otsPub.otsTypecode > LMOTS_SHA256_N32_W8:
+	if _, err := otsParams(otsPub.otsTypecode); err != nil {
 		return errors.New("lmots: invalid LM-OTS key params")
+	}
+	switch {
 	case len(otsPub.id) != 16:
 		return errors.New("lmots: invalid identifier I")
 	case otsPub.q < 0:
@@ -237,6 +270,27 @@ func (otsPub *OtsPublicKey) Validate() error {
 
 // Generates a One Time Signature from an LM-OTS private key and a message.
 func (otsPriv *OtsPrivateKey) Sign(message []byte) ([]byte, error) {
+	return otsPriv.SignWithRand(message, rand.Reader)
+}
+
+// Generates a One Time Signature from an LM-OTS private key and a message, and
+// reads the randomizer C from rand.
+func (otsPriv *OtsPrivateKey) SignWithRand(message []byte, rand io.Reader) ([]byte, error) {
+	err := otsPriv.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	C := make([]byte, otsTypes[otsPriv.otsTypecode].n)
+	_, err = io.ReadFull(rand, C)
+	if err != nil {
+		return nil, err
+	}
+	return otsPriv.sign(bytesMessage(message), C)
+}
+
+// sign signs the message with the randomizer C.
+func (otsPriv *OtsPrivateKey) sign(message messageDigest, C []byte) ([]byte, error) {
 	err := otsPriv.Validate()
 	if err != nil {
 		return nil, err
@@ -247,27 +301,32 @@ func (otsPriv *OtsPrivateKey) Sign(message []byte) ([]byte, error) {
 	ls := otsTypes[otsPriv.otsTypecode].ls
 	n := otsTypes[otsPriv.otsTypecode].n
 
-	C := make([]byte, n)
-	_, err = rand.Read(C)
+	hash := otsTypes[otsPriv.otsTypecode].hash
+	Q, err := message(bytes.Join([][]byte{otsPriv.id, u32Str(otsPriv.q), u16Str(D_MESG), C}, []byte("")))
 	if err != nil {
 		return nil, err
 	}
-
-	hash := otsTypes[otsPriv.otsTypecode].hash
-	Q := hash(bytes.Join([][]byte{otsPriv.id, u32Str(otsPriv.q), u16Str(D_MESG), C, message}, []byte("")))
+	Qc := append(Q, u16Str(cksm(Q, w, n, ls))...)
 	y := make([]byte, p*n)
+	c := newChainHasher(otsPriv.id, otsPriv.q, n, hash)
 	for i := 0; i < p; i++ {
-		a := coef(append(Q, u16Str(cksm(Q, w, n, ls))...), i, w)
-		tmp := otsPriv.x[i*n : (i+1)*n]
-		for j := 0; j < a; j++ {
-			tmp = hash(bytes.Join([][]byte{otsPriv.id, u32Str(otsPriv.q), u16Str(i), u8Str(j), tmp}, []byte("")))
-		}
+		a := coef(Qc, i, w)
+		tmp := c.iterate(otsPriv.x[i*n:(i+1)*n], i, 0, a)
 		copy(y[i*n:(i+1)*n], tmp)
 	}
 
 	return bytes.Join([][]byte{u32Str(int(otsPriv.otsTypecode)), C, y}, []byte("")), nil
 }
 
+// Derives the randomizer C of the LM-OTS signature of leaf q from SEED as
+// H(I || u32str(q) || u16str(0xfffd) || u8str(0xff) || SEED), following the
+// pseudorandom key generation of RFC 8554, Appendix A. A leaf that signs the
+// same message twice, as when the signatures of the child public keys of an HSS
+// key are recomputed, then produces the same signature.
+func otsRandomizer(otsTypecode uint, q int, I []byte, seed []byte) []byte {
+	return newChainHasher(I, q, otsTypes[otsTypecode].n, otsTypes[otsTypecode].hash).sum(0xfffd, 0xff, seed)
+}
+
 // Verifies a message with its LM-OTS signature.
 func (otsPub *OtsPublicKey) Verify(message, otsSig []byte) error {
 	err := otsPub.Validate()
@@ -275,12 +334,12 @@ func (otsPub *OtsPublicKey) Verify(message, otsSig []byte) error {
 		return err
 	}
 
-	kc, kcErr := otsKeyCandidate(message, otsSig, otsPub.otsTypecode, otsPub.id, otsPub.q)
+	kc, kcErr := otsKeyCandidate(bytesMessage(message), otsSig, otsPub.otsTypecode, otsPub.id, otsPub.q)
 	if kcErr != nil {
 		return kcErr
 	}
 
-	if !bytes.Equal(kc, otsPub.k) {
+	if subtle.ConstantTimeCompare(kc, otsPub.k) != 1 {
 		return errors.New("lmots: invalid LM-OTS signature")
 	}
 
@@ -288,7 +347,7 @@ func (otsPub *OtsPublicKey) Verify(message, otsSig []byte) error {
 }
 
 // Computes an LM-OTS public key candidate.
-func otsKeyCandidate(message []byte, otsSig []byte, otsTypecode uint, I []byte, q int) ([]byte, error) {
+func otsKeyCandidate(message messageDigest, otsSig []byte, otsTypecode uint, I []byte, q int) ([]byte, error) {
 	if len(otsSig) < 4 {
 		return nil, errors.New("lmots: invalid LM-OTS signature")
 	}
@@ -297,6 +356,9 @@ func otsKeyCandidate(message []byte, otsSig []byte, otsTypecode uint, I []byte,
 	if otsSigType != otsTypecode {
 		return nil, errors.New("lmots: invalid LM-OTS signature")
 	}
+	if _, err := otsParams(otsSigType); err != nil {
+		return nil, err
+	}
 
 	n := otsTypes[otsSigType].n
 	p := otsTypes[otsSigType].p
@@ -312,14 +374,16 @@ func otsKeyCandidate(message []byte, otsSig []byte, otsTypecode uint, I []byte,
 
 	//Compute Kc as follow
 	hash := otsTypes[otsSigType].hash
-	Q := hash(bytes.Join([][]byte{I, u32Str(q), u16Str(D_MESG), C, message}, []byte("")))
+	Q, err := message(bytes.Join([][]byte{I, u32Str(q), u16Str(D_MESG), C}, []byte("")))
+	if err != nil {
+		return nil, err
+	}
+	Qc := append(Q, u16Str(cksm(Q, w, n, ls))...)
 	z := make([]byte, p*n)
+	c := newChainHasher(I, q, n, hash)
 	for i := 0; i < p; i++ {
-		a := coef(append(Q, u16Str(cksm(Q, w, n, ls))...), i, w)
-		tmp := y[i*n : (i+1)*n]
-		for j := a; j < powInt(2, w)-1; j++ {
-			tmp = hash(bytes.Join([][]byte{I, u32Str(q), u16Str(i), u8Str(j), tmp}, []byte("")))
-		}
+		a := coef(Qc, i, w)
+		tmp := c.iterate(y[i*n:(i+1)*n], i, a, powInt(2, w)-1)
 		copy(z[i*n:(i+1)*n], tmp)
 	}
 	kc := hash(bytes.Join([][]byte{I, u32Str(q), u16Str(D_PBLC), z}, []byte("")))
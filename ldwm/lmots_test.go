@@ -23,7 +23,7 @@ func TestOtsKeyGeneration(t *testing.T) {
 			t.Errorf("failed to generate the public key when w = %d", w)
 		}
 
-		parsedPriv, pPrivErr := ParseOtsPrivateKey(otsPriv.String())
+		parsedPriv, pPrivErr := ParseOtsPrivateKey(privateHex(otsPriv))
 		if pPrivErr != nil {
 			t.Errorf("failed to parse a private key when w = %d", w)
 		}
@@ -69,3 +69,90 @@ func TestOtsSignAndVerify(t *testing.T) {
 		}
 	}
 }
+
+func TestChainHasher(t *testing.T) {
+	I := make([]byte, IdentifierLength)
+	tmp := make([]byte, HashLength)
+	for i := range I {
+		I[i] = byte(i)
+	}
+	for i := range tmp {
+		tmp[i] = byte(0xa0 + i)
+	}
+	c := newChainHasher(I, 7, HashLength, sha256Hash)
+	for _, i := range []int{0, 1, 66, 264} {
+		for _, j := range []int{0, 1, 254, 0xff} {
+			want := sha256Hash(bytes.Join([][]byte{I, u32Str(7), u16Str(i), u8Str(j), tmp}, []byte("")))
+			if got := c.sum(i, j, tmp); !bytes.Equal(got, want) {
+				t.Errorf("chain hash mismatch when i = %d, j = %d", i, j)
+			}
+		}
+	}
+	want := tmp
+	for j := 3; j < 15; j++ {
+		want = sha256Hash(bytes.Join([][]byte{I, u32Str(7), u16Str(5), u8Str(j), want}, []byte("")))
+	}
+	if got := c.iterate(tmp, 5, 3, 15); !bytes.Equal(got, want) {
+		t.Errorf("chain iteration mismatch")
+	}
+}
+
+func TestOtsRandomizer(t *testing.T) {
+	lmsPriv, _ := GenerateLmsPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8)
+	lmsPub, _ := lmsPriv.Public()
+	I := append([]byte(nil), lmsPriv.id...)
+	seed := append([]byte(nil), lmsPriv.skSeed...)
+	copyHex := privateHex(lmsPriv)
+
+	for q := 0; q < 3; q++ {
+		lmsSig, _ := lmsPriv.Sign([]byte("message"))
+		sig, err := ParseLmsSignature(lmsSig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// C = H(I || u32str(q) || u16str(0xfffd) || u8str(0xff) || SEED)
+		want := sha256Hash(bytes.Join([][]byte{I, u32Str(q), {0xff, 0xfd}, {0xff}, seed}, []byte("")))
+		if !bytes.Equal(sig.c, want) {
+			t.Errorf("C = %x at q = %d, want %x", sig.c, q, want)
+		}
+		if lmsPub.Verify([]byte("message"), lmsSig) != nil {
+			t.Errorf("invalid signature at q = %d", q)
+		}
+	}
+
+	// Signing the same message with the same leaf gives the same signature,
+	// which is what makes it safe to sign the child public keys of an HSS key
+	// again after parsing.
+	a, _ := ParseLmsPrivateKey(copyHex)
+	b, _ := ParseLmsPrivateKey(copyHex)
+	sigA, _ := a.Sign([]byte("message"))
+	sigB, _ := b.Sign([]byte("message"))
+	if !bytes.Equal(sigA, sigB) {
+		t.Error("the same leaf signed the same message differently")
+	}
+	sigC, _ := b.Sign([]byte("message"))
+	if bytes.Equal(sigA[4:4+4+HashLength], sigC[4:4+4+HashLength]) {
+		t.Error("two leaves used the same randomizer")
+	}
+}
+
+// The chain benchmarks compute one full Winternitz chain of LMOTS_SHA256_N32_W8,
+// with chainHasher and by joining the inputs of every step as before it.
+func BenchmarkChainHasher(b *testing.B) {
+	I := make([]byte, IdentifierLength)
+	tmp := make([]byte, HashLength)
+	c := newChainHasher(I, 7, HashLength, sha256Hash)
+	for i := 0; i < b.N; i++ {
+		c.iterate(tmp, 3, 0, 255)
+	}
+}
+
+func BenchmarkChainJoin(b *testing.B) {
+	I := make([]byte, IdentifierLength)
+	for i := 0; i < b.N; i++ {
+		tmp := make([]byte, HashLength)
+		for j := 0; j < 255; j++ {
+			tmp = sha256Hash(bytes.Join([][]byte{I, u32Str(7), u16Str(3), u8Str(j), tmp}, []byte("")))
+		}
+	}
+}
@@ -6,10 +6,15 @@ package ldwm
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+
+	"github.com/lingyunzhao/pqcrypto/merkle"
 )
 
 // LMS private key.
@@ -21,8 +26,7 @@ type LmsPrivateKey struct {
 	id          []byte
 	root        []byte
 	skSeed      []byte
-	authPath    [][]byte
-	stacks      []*stack
+	bds         *merkle.BDS
 }
 
 // LMS public key.
@@ -38,23 +42,95 @@ type LmsPublicKey struct {
 
 // Geenerates an LMS private key.
 func GenerateLmsPrivateKey(lmsTypecode uint, otsTypecode uint) (*LmsPrivateKey, error) {
-	if lmsTypecode < LMS_SHA256_M32_H5 || lmsTypecode > LMS_SHA256_M32_H25 {
-		return nil, errors.New("lms: invalid LMS typecode")
+	lmsType, err := lmsParams(lmsTypecode)
+	if err != nil {
+		return nil, err
+	}
+	return GenerateLmsPrivateKeyWithK(lmsTypecode, otsTypecode, defaultK(lmsType.h))
+}
+
+// Generates an LMS private key whose authentication paths are computed by the BDS
+// traversal algorithm with parameter k. The top k levels of the tree are kept in
+// memory and (h-k)/2 leaves are computed per signature, so a larger k trades
+// private key memory for signing time. k should satisfy 0 <= k <= h and h-k even.
+func GenerateLmsPrivateKeyWithK(lmsTypecode uint, otsTypecode uint, k int) (*LmsPrivateKey, error) {
+	return generateLmsPrivateKey(lmsTypecode, otsTypecode, k, rand.Reader)
+}
+
+// Generates an LMS private key whose identifier I and SEED are read, in this
+// order, from rand. The same input always yields the same key.
+func GenerateLmsPrivateKeyWithRand(lmsTypecode uint, otsTypecode uint, rand io.Reader) (*LmsPrivateKey, error) {
+	lmsType, err := lmsParams(lmsTypecode)
+	if err != nil {
+		return nil, err
+	}
+	return generateLmsPrivateKey(lmsTypecode, otsTypecode, defaultK(lmsType.h), rand)
+}
+
+// Generates an LMS private key like GenerateLmsPrivateKey, calling progress, if
+// it is not nil, after each of the 2^h leaves of the tree. Generation stops
+// with the error of ctx once ctx is done.
+func GenerateLmsPrivateKeyWithContext(ctx context.Context, lmsTypecode uint, otsTypecode uint, progress Progress) (*LmsPrivateKey, error) {
+	lmsType, err := lmsParams(lmsTypecode)
+	if err != nil {
+		return nil, err
+	}
+	lmsPriv, err := newLmsPrivateKey(lmsTypecode, otsTypecode, defaultK(lmsType.h), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	g := &keygen{ctx: ctx, progress: progress}
+	return g.lms(lmsPriv)
+}
+
+// lms completes the tree of a new LMS private key.
+func (g *keygen) lms(lmsPriv *LmsPrivateKey) (*LmsPrivateKey, error) {
+	g.total = uint64(powInt(2, lmsPriv.height))
+	g.state = func() []byte { return lmsCheckpoint(lmsPriv) }
+	if err := g.build(lmsPriv); err != nil {
+		lmsPriv.Destroy()
+		return nil, err
+	}
+	return lmsPriv, nil
+}
+
+func generateLmsPrivateKey(lmsTypecode uint, otsTypecode uint, k int, rand io.Reader) (*LmsPrivateKey, error) {
+	lmsPriv, err := newLmsPrivateKey(lmsTypecode, otsTypecode, k, rand)
+	if err != nil {
+		return nil, err
+	}
+	lmsPriv.root = lmsPriv.bds.Init(lmsHasher{lmsPriv})
+	return lmsPriv, nil
+}
+
+// newLmsPrivateKey reads I and SEED from rand like generateLmsPrivateKey, but
+// leaves the tree to be computed by the caller.
+func newLmsPrivateKey(lmsTypecode uint, otsTypecode uint, k int, rand io.Reader) (*LmsPrivateKey, error) {
+	lmsType, err := lmsParams(lmsTypecode)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := otsParams(otsTypecode); err != nil {
+		return nil, err
+	}
+	if !validK(lmsType.h, k) {
+		return nil, errors.New("lms: invalid BDS parameter k")
 	}
 
 	I := make([]byte, IdentifierLength)
-	_, err := rand.Read(I)
+	_, err = io.ReadFull(rand, I)
 	if err != nil {
 		return nil, err
 	}
 
 	skSeed := make([]byte, HashLength)
-	_, err = rand.Read(skSeed)
+	_, err = io.ReadFull(rand, skSeed)
 	if err != nil {
 		return nil, err
 	}
+	defer zeroize(skSeed)
 
-	return generateMerkleTree(I, skSeed, lmsTypecode, otsTypecode), nil
+	return newMerkleTree(I, skSeed, lmsTypecode, otsTypecode, k), nil
 }
 
 // Generates the LMS public key.
@@ -73,12 +149,60 @@ func (lmsPriv *LmsPrivateKey) Public() (*LmsPublicKey, error) {
 	return lmsPub, nil
 }
 
-// Serializes the private key and converts it to a hexadecimal string.
-func (lmsPriv *LmsPrivateKey) String() string {
-	str := string(u32Str(int(lmsPriv.lmsTypecode))) + string(u32Str(int(lmsPriv.otsTypecode))) +
-		string(u32Str(lmsPriv.q)) + string(lmsPriv.id) + string(lmsPriv.skSeed)
-	str = fmt.Sprintf("%x", str)
-	return str
+// Returns the number of signatures the private key can still generate.
+func (lmsPriv *LmsPrivateKey) Remaining() uint64 {
+	if lmsPriv.bds == nil || lmsPriv.q >= powInt(2, lmsPriv.height) {
+		return 0
+	}
+	return uint64(powInt(2, lmsPriv.height) - lmsPriv.q)
+}
+
+// Moves the private key forward so that its next signature uses leaf q. The
+// leaves before q are skipped and never used; q = 2^h exhausts the key. The
+// authentication path of leaf q is computed in about h*2^(h/2) leaf
+// computations, and the key never moves backward.
+func (lmsPriv *LmsPrivateKey) AdvanceTo(q uint64) error {
+	if len(lmsPriv.skSeed) != HashLength || lmsPriv.bds == nil {
+		return errors.New("lms: invalid LMS private key")
+	}
+	if q < uint64(lmsPriv.q) {
+		return errors.New("lms: cannot move the private key backward")
+	}
+	leaves := uint64(powInt(2, lmsPriv.height))
+	if q > leaves {
+		return errors.New("lms: leaf index out of range")
+	}
+	if q < leaves && q > uint64(lmsPriv.q) {
+		if err := lmsPriv.bds.Advance(lmsPriv.q, int(q), lmsHasher{lmsPriv}); err != nil {
+			return err
+		}
+	}
+	lmsPriv.q = int(q)
+	return nil
+}
+
+// Serializes the private key. The result contains the secret seed and must be
+// protected like the key itself; ParseLmsPrivateKey reads it back from hexadecimal.
+func (lmsPriv *LmsPrivateKey) MarshalPrivate() ([]byte, error) {
+	if len(lmsPriv.skSeed) != HashLength {
+		return nil, errors.New("lms: invalid LMS private key")
+	}
+	return lmsPriv.serialize(), nil
+}
+
+// Formats the private key as a placeholder, whatever the verb, so that it is
+// not printed by accident. The key has no String method, so that it is not
+// saved in that form by mistake either; use MarshalPrivate to serialize it.
+func (lmsPriv *LmsPrivateKey) Format(f fmt.State, verb rune) {
+	io.WriteString(f, "ldwm.LmsPrivateKey(REDACTED)")
+}
+
+// Overwrites the secret seed of the private key with zeros. The key can no
+// longer sign or be serialized afterwards.
+func (lmsPriv *LmsPrivateKey) Destroy() {
+	zeroize(lmsPriv.skSeed)
+	lmsPriv.skSeed = nil
+	lmsPriv.bds = nil
 }
 
 func (lmsPriv *LmsPrivateKey) serialize() []byte {
@@ -103,11 +227,28 @@ func ParseLmsPrivateKey(keyHex string) (*LmsPrivateKey, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer zeroize(key)
+
+	return parseLmsPrivateKey(key, useDefaultK)
+}
+
+// Parses an LMS private key from a hexadecimal string and uses the BDS traversal
+// algorithm with parameter k for its authentication paths.
+func ParseLmsPrivateKeyWithK(keyHex string, k int) (*LmsPrivateKey, error) {
+	key, err := hex.DecodeString(keyHex)
+	if err != nil {
+		return nil, err
+	}
+	defer zeroize(key)
 
-	return parseLmsPrivateKey(key)
+	if k < 0 {
+		return nil, errors.New("lms: invalid BDS parameter k")
+	}
+	return parseLmsPrivateKey(key, k)
 }
 
-func parseLmsPrivateKey(key []byte) (*LmsPrivateKey, error) {
+// parseLmsPrivateKey parses an LMS private key; k == useDefaultK selects the default BDS parameter.
+func parseLmsPrivateKey(key []byte, k int) (*LmsPrivateKey, error) {
 
 	if len(key) < 8 {
 		return nil, errors.New("lms: (parse error) invalid LMS private key")
@@ -120,20 +261,26 @@ func parseLmsPrivateKey(key []byte) (*LmsPrivateKey, error) {
 		return nil, errors.New("lms: (parse error) invalid LMS private key")
 	}
 	q := strTou32(key[8:12])
-	if lmsTypecode < LMS_SHA256_M32_H5 ||
-		lmsTypecode > LMS_SHA256_M32_H25 ||
-		q < 0 || q >= powInt(2, lmsTypes[lmsTypecode].h) ||
-		otsTypecode > LMOTS_SHA256_N32_W8 {
+	lmsType, err := lmsParams(lmsTypecode)
+	if err != nil {
+		return nil, errors.New("lms: (parse error) invalid LMS private key")
+	}
+	if _, err := otsParams(otsTypecode); err != nil || q >= powInt(2, lmsType.h) {
 		return nil, errors.New("lms: (parse error) invalid LMS private key")
 	}
 
 	I := key[12 : 12+IdentifierLength]
 	skSeed := key[12+IdentifierLength:]
 
-	lmsPriv := generateMerkleTree(I, skSeed, lmsTypecode, otsTypecode)
-
-	for i := 0; i < q; i++ {
-		lmsPriv.traversal()
+	if k == useDefaultK {
+		k = defaultK(lmsTypes[lmsTypecode].h)
+	}
+	if !validK(lmsTypes[lmsTypecode].h, k) {
+		return nil, errors.New("lms: invalid BDS parameter k")
+	}
+	lmsPriv := generateMerkleTree(I, skSeed, lmsTypecode, otsTypecode, k)
+	if err := lmsPriv.AdvanceTo(uint64(q)); err != nil {
+		return nil, err
 	}
 
 	return lmsPriv, nil
@@ -171,111 +318,137 @@ func parseLmsPublicKey(key []byte) (*LmsPublicKey, error) {
 
 // Generates an LMS signature from an LMS private key and a message, and updates the private key.
 func (lmsPriv *LmsPrivateKey) Sign(message []byte) ([]byte, error) {
-	err := lmsPriv.Validate()
+	if err := checkMessage(message); err != nil {
+		return nil, err
+	}
+	return lmsPriv.sign(bytesMessage(message))
+}
+
+// sign signs a message without checking it for the batch tag.
+func (lmsPriv *LmsPrivateKey) sign(message messageDigest) ([]byte, error) {
+	leaf, err := lmsPriv.reserve()
 	if err != nil {
 		return nil, err
 	}
+	return leaf.sign(message)
+}
 
-	h := lmsTypes[lmsPriv.lmsTypecode].h
-	m := lmsTypes[lmsPriv.lmsTypecode].m
+// An lmsLeaf is a leaf whose index has been consumed from an LMS private key,
+// together with its authentication path. It holds everything needed to finish
+// the signature without the private key. Its copy of the SEED is cleared once
+// it has signed.
+type lmsLeaf struct {
+	lmsTypecode uint
+	otsTypecode uint
+	id          []byte
+	skSeed      []byte
+	q           int
+	path        []byte
+}
 
-	otsPriv, _ := generateOtsPrivateKey(lmsPriv.otsTypecode, lmsPriv.q, lmsPriv.id, lmsPriv.skSeed)
-	otsSig, sigErr := otsPriv.Sign(message)
-	if sigErr != nil {
+// reserve consumes the next leaf of the private key and advances the traversal.
+func (lmsPriv *LmsPrivateKey) reserve() (*lmsLeaf, error) {
+	err := lmsPriv.Validate()
+	if err != nil {
 		return nil, err
 	}
 
-	path := make([]byte, h*m)
+	h := lmsTypes[lmsPriv.lmsTypecode].h
+	m := lmsTypes[lmsPriv.lmsTypecode].m
+
+	leaf := new(lmsLeaf)
+	leaf.lmsTypecode = lmsPriv.lmsTypecode
+	leaf.otsTypecode = lmsPriv.otsTypecode
+	leaf.id = lmsPriv.id
+	leaf.skSeed = make([]byte, HashLength)
+	copy(leaf.skSeed, lmsPriv.skSeed)
+	leaf.q = lmsPriv.q
+	leaf.path = make([]byte, h*m)
+	auth := lmsPriv.bds.AuthPath()
 	for i := 0; i < h; i++ {
-		copy(path[i*m:(i+1)*m], lmsPriv.authPath[i])
+		copy(leaf.path[i*m:(i+1)*m], auth[i])
 	}
 	lmsPriv.traversal()
 
-	return bytes.Join([][]byte{u32Str(lmsPriv.q - 1), otsSig, u32Str(int(lmsPriv.lmsTypecode)), path}, []byte("")), nil
+	return leaf, nil
 }
 
-// Verifies a message with its LMS signature.
-func (lmsPub *LmsPublicKey) Verify(message, lmsSig []byte) error {
-	err := lmsPub.Validate()
+// sign computes the LM-OTS signature of the leaf and assembles the LMS signature.
+func (leaf *lmsLeaf) sign(message messageDigest) ([]byte, error) {
+	defer zeroize(leaf.skSeed)
+	otsPriv, err := generateOtsPrivateKey(leaf.otsTypecode, leaf.q, leaf.id, leaf.skSeed)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	tc, tcErr := candidateLmsRoot(message, lmsSig, lmsPub.id, lmsPub.lmsTypecode, lmsPub.otsTypecode)
-	if tcErr != nil {
-		return tcErr
+	defer otsPriv.Destroy()
+	otsSig, err := otsPriv.sign(message, otsRandomizer(leaf.otsTypecode, leaf.q, leaf.id, leaf.skSeed))
+	if err != nil {
+		return nil, err
 	}
 
-	if !bytes.Equal(tc, lmsPub.t1) {
-		return errors.New("lms: invalid LMS signature")
-	}
+	return bytes.Join([][]byte{u32Str(leaf.q), otsSig, u32Str(int(leaf.lmsTypecode)), leaf.path}, []byte("")), nil
+}
 
-	return nil
+// Verifies a message with its LMS signature.
+func (lmsPub *LmsPublicKey) Verify(message, lmsSig []byte) error {
+	return lmsPub.verify(bytesMessage(message), lmsSig)
 }
 
-// Computes an LMS public key candidate from a message, signature, identifier, and algorithm typecodes.
-func candidateLmsRoot(message []byte, lmsSig []byte, I []byte, lmsTypecode uint, otsTypecode uint) ([]byte, error) {
-	if len(lmsSig) < 8 {
-		return nil, errors.New("lms: invalid LMS signature")
+func (lmsPub *LmsPublicKey) verify(message messageDigest, lmsSig []byte) error {
+	sig, err := ParseLmsSignature(lmsSig)
+	if err != nil {
+		return errors.New("lms: invalid LMS signature")
 	}
+	return lmsPub.verifySignature(message, sig)
+}
 
-	q := strTou32(lmsSig[:4])
-	otsSigType := uint(strTou32(lmsSig[4:8]))
-	if otsSigType != otsTypecode {
-		return nil, errors.New("lms: invalid LMS signature")
+func (lmsPub *LmsPublicKey) verifySignature(message messageDigest, sig *LmsSignature) error {
+	err := lmsPub.Validate()
+	if err != nil {
+		return err
 	}
 
-	n := otsTypes[otsSigType].n
-	p := otsTypes[otsSigType].p
-
-	if len(lmsSig) < 12+n*(p+1) {
-		return nil, errors.New("lms: invalid LMS signature")
+	if sig.lmsTypecode != lmsPub.lmsTypecode || sig.otsTypecode != lmsPub.otsTypecode {
+		return errors.New("lms: invalid LMS signature")
 	}
 
-	otsSig := lmsSig[4 : 8+n*(p+1)]
-
-	lmsSigType := uint(strTou32(lmsSig[8+n*(p+1) : 12+n*(p+1)]))
-	if lmsSigType != lmsTypecode {
-		return nil, errors.New("lms: invalid LMS signature")
+	tc, tcErr := candidateLmsRoot(message, sig, lmsPub.id)
+	if tcErr != nil {
+		return tcErr
 	}
 
-	m := lmsTypes[lmsSigType].m
-	h := lmsTypes[lmsSigType].h
-
-	if q < 0 || q >= powInt(2, h) || len(lmsSig) != 12+n*(p+1)+m*h {
-		return nil, errors.New("lms: invalid LMS signature")
+	if subtle.ConstantTimeCompare(tc, lmsPub.t1) != 1 {
+		return errors.New("lms: invalid LMS signature")
 	}
 
-	path := lmsSig[len(lmsSig)-m*h:]
+	return nil
+}
 
-	kc, err := otsKeyCandidate(message, otsSig, otsTypecode, I, q)
+// Computes an LMS public key candidate from a message, signature and identifier.
+func candidateLmsRoot(message messageDigest, sig *LmsSignature, I []byte) ([]byte, error) {
+	kc, err := otsKeyCandidate(message, sig.OtsSignature(), sig.otsTypecode, I, sig.q)
 	if err != nil {
 		return nil, err
 	}
 
-	node := powInt(2, h) + q
-	hash := lmsTypes[lmsSigType].hash
-	tmp := hash(bytes.Join([][]byte{I, u32Str(node), u16Str(D_LEAF), kc}, []byte("")))
-	for i := 0; node > 1; i = i + 1 {
-		if node%2 == 1 {
-			tmp = hash(bytes.Join([][]byte{I, u32Str(int(node / 2)), u16Str(D_INTR), path[i*m : (i+1)*m], tmp}, []byte("")))
-		} else {
-			tmp = hash(bytes.Join([][]byte{I, u32Str(int(node / 2)), u16Str(D_INTR), tmp, path[i*m : (i+1)*m]}, []byte("")))
-		}
-		node = int(node / 2)
-	}
-
-	return tmp[:], nil
+	h := lmsTypes[sig.lmsTypecode].h
+	hash := lmsTypes[sig.lmsTypecode].hash
+	leaf := hash(bytes.Join([][]byte{I, u32Str(powInt(2, h) + sig.q), u16Str(D_LEAF), kc}, []byte("")))
+	return merkle.RootFromAuthPath(leaf, sig.q, sig.Path(), func(left []byte, right []byte, height int, idx int) []byte {
+		return hash(bytes.Join([][]byte{I, u32Str(powInt(2, h-height) + idx), u16Str(D_INTR), left, right}, []byte("")))
+	}), nil
 }
 
 // Performs basic sanity checks on the LMS private key.
 // Returns nil if the LMS private key is valid, or else an error describing a problem.
 func (lmsPriv *LmsPrivateKey) Validate() error {
-	if lmsPriv.lmsTypecode < LMS_SHA256_M32_H5 ||
-		lmsPriv.lmsTypecode > LMS_SHA256_M32_H25 ||
-		lmsPriv.q < 0 || lmsPriv.q >= powInt(2, lmsTypes[lmsPriv.lmsTypecode].h) ||
-		len(lmsPriv.skSeed) != HashLength ||
-		lmsPriv.otsTypecode > LMOTS_SHA256_N32_W8 || len(lmsPriv.id) != IdentifierLength {
+	lmsType, err := lmsParams(lmsPriv.lmsTypecode)
+	if err != nil {
+		return errors.New("lms: invalid LMS private key")
+	}
+	if _, err := otsParams(lmsPriv.otsTypecode); err != nil ||
+		lmsPriv.q < 0 || lmsPriv.q >= powInt(2, lmsType.h) ||
+		len(lmsPriv.skSeed) != HashLength || len(lmsPriv.id) != IdentifierLength {
 		return errors.New("lms: invalid LMS private key")
 	}
 	return nil
@@ -284,11 +457,13 @@ func (lmsPriv *LmsPrivateKey) Validate() error {
 // Performs basic sanity checks on the LMS public key.
 // Returns nil if the LMS public key is valid, or else an error describing a problem.
 func (lmsPub *LmsPublicKey) Validate() error {
-	if lmsPub.lmsTypecode < LMS_SHA256_M32_H5 ||
-		lmsPub.lmsTypecode > LMS_SHA256_M32_H25 ||
-		lmsPub.otsTypecode > LMOTS_SHA256_N32_W8 ||
+	lmsType, err := lmsParams(lmsPub.lmsTypecode)
+	if err != nil {
+		return errors.New("lms: invalid LMS public key")
+	}
+	if _, err := otsParams(lmsPub.otsTypecode); err != nil ||
 		len(lmsPub.id) != IdentifierLength ||
-		len(lmsPub.t1) != lmsTypes[lmsPub.lmsTypecode].m {
+		len(lmsPub.t1) != lmsType.m {
 		return errors.New("lms: invalid LMS public key")
 	}
 	return nil
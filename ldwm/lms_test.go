@@ -5,6 +5,7 @@
 package ldwm
 
 import (
+	"bytes"
 	"io/ioutil"
 	"testing"
 )
@@ -20,7 +21,7 @@ func TestLmsKeyGeneration(t *testing.T) {
 			}
 			lmsPub, _ := lmsPriv.Public()
 
-			ParseLmsPrivateKey(lmsPriv.String())
+			ParseLmsPrivateKey(privateHex(lmsPriv))
 			ParseLmsPublicKey(lmsPub.String())
 
 			lmsPub, pubErr := lmsPriv.Public()
@@ -28,7 +29,7 @@ func TestLmsKeyGeneration(t *testing.T) {
 				t.Errorf("failed to generate the public key when lmstypecode = %d, otstypecode = %d", lmsTypecode, otsTypecode)
 			}
 
-			parsedPriv, pPrivErr := ParseLmsPrivateKey(lmsPriv.String())
+			parsedPriv, pPrivErr := ParseLmsPrivateKey(privateHex(lmsPriv))
 			if pPrivErr != nil {
 				t.Errorf("failed to parse a private key when lmstypecode = %d, otstypecode = %d", lmsTypecode, otsTypecode)
 			}
@@ -38,7 +39,7 @@ func TestLmsKeyGeneration(t *testing.T) {
 				t.Errorf("failed to parse a public key when lmstypecode = %d, otstypecode = %d", lmsTypecode, otsTypecode)
 			}
 
-			if lmsPriv.String() != parsedPriv.String() {
+			if privateHex(lmsPriv) != privateHex(parsedPriv) {
 				t.Errorf("parsed LMS private != LMS private key when lmstypecode = %d, otstypecode = %d", lmsTypecode, otsTypecode)
 			}
 
@@ -73,3 +74,117 @@ func TestLmsSignandVerify(t *testing.T) {
 		}
 	}
 }
+
+func merkleNode(mt *LmsPrivateKey, height int, idx int) []byte {
+	if height == 0 {
+		return mt.leaf(idx)
+	}
+	return mt.node(merkleNode(mt, height-1, 2*idx), merkleNode(mt, height-1, 2*idx+1), height, idx)
+}
+
+func TestLmsTraversal(t *testing.T) {
+	for _, k := range []int{1, 3, 5} {
+		lmsPriv, err := GenerateLmsPrivateKeyWithK(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W1, k)
+		if err != nil {
+			t.Fatalf("failed to generate a private key when k = %d", k)
+		}
+		if !bytes.Equal(merkleNode(lmsPriv, 5, 0), lmsPriv.root) {
+			t.Errorf("invalid root when k = %d", k)
+		}
+		for q := 0; q < 32; q++ {
+			for i := 0; i < 5; i++ {
+				if !bytes.Equal(lmsPriv.bds.AuthPath()[i], merkleNode(lmsPriv, i, (q>>uint(i))^1)) {
+					t.Errorf("invalid authentication path when k = %d, q = %d, level = %d", k, q, i)
+				}
+			}
+			lmsPriv.traversal()
+		}
+	}
+
+	message := []byte("Hello, world!")
+	for _, k := range []int{0, 2, 4, 10} {
+		lmsPriv, _ := GenerateLmsPrivateKeyWithK(LMS_SHA256_M32_H10, LMOTS_SHA256_N32_W1, k)
+		lmsPub, _ := lmsPriv.Public()
+		for q := 0; q < 1024; q++ {
+			if lmsPriv.Remaining() != uint64(1024-q) {
+				t.Fatalf("Remaining() = %d, want %d", lmsPriv.Remaining(), 1024-q)
+			}
+			lmsSig, err := lmsPriv.Sign(message)
+			if err != nil {
+				t.Fatalf("lmssign error when k = %d, q = %d", k, q)
+			}
+			if lmsPub.Verify(message, lmsSig) != nil {
+				t.Fatalf("verify error when k = %d, q = %d", k, q)
+			}
+		}
+		if _, err := lmsPriv.Sign(message); err == nil {
+			t.Errorf("signed with an exhausted private key when k = %d", k)
+		}
+		if lmsPriv.Remaining() != 0 {
+			t.Errorf("exhausted private key has %d signatures remaining", lmsPriv.Remaining())
+		}
+	}
+
+	for _, k := range []int{-1, 4, 7} {
+		if _, err := GenerateLmsPrivateKeyWithK(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W1, k); err == nil {
+			t.Errorf("accepted an invalid BDS parameter k = %d", k)
+		}
+	}
+}
+
+func TestParseWithKZero(t *testing.T) {
+	lmsPriv, _ := GenerateLmsPrivateKey(LMS_SHA256_M32_H10, LMOTS_SHA256_N32_W1)
+	lmsPriv.Sign([]byte("abc"))
+	if p, err := ParseLmsPrivateKeyWithK(privateHex(lmsPriv), 0); err != nil || p.bds.K() != 0 {
+		t.Errorf("ParseLmsPrivateKeyWithK did not keep k = 0: %v", err)
+	}
+	if p, _ := ParseLmsPrivateKey(privateHex(lmsPriv)); p.bds.K() != defaultK(10) {
+		t.Errorf("ParseLmsPrivateKey used k = %d, want %d", p.bds.K(), defaultK(10))
+	}
+
+	hssPriv, _ := GenerateHssPrivateKey(LMS_SHA256_M32_H10, LMOTS_SHA256_N32_W1, 2)
+	hssPriv.Sign([]byte("abc"))
+	p, err := ParseHssPrivateKeyWithK(privateHex(hssPriv), 0)
+	if err != nil {
+		t.Fatalf("ParseHssPrivateKeyWithK failed when k = 0: %v", err)
+	}
+	for i, mt := range p.lmsPriv {
+		if mt.bds.K() != 0 {
+			t.Errorf("tree %d uses k = %d, want 0", i, mt.bds.K())
+		}
+	}
+	for _, k := range []int{-1, -2} {
+		if _, err := ParseLmsPrivateKeyWithK(privateHex(lmsPriv), k); err == nil {
+			t.Errorf("ParseLmsPrivateKeyWithK accepted k = %d", k)
+		}
+		if _, err := ParseHssPrivateKeyWithK(privateHex(hssPriv), k); err == nil {
+			t.Errorf("ParseHssPrivateKeyWithK accepted k = %d", k)
+		}
+	}
+}
+
+func TestLmsRemaining(t *testing.T) {
+	lmsPriv, _ := GenerateLmsPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W4)
+	for i := 0; i < 3; i++ {
+		if r := lmsPriv.Remaining(); r != uint64(32-i) {
+			t.Fatalf("Remaining() = %d after %d signatures, want %d", r, i, 32-i)
+		}
+		lmsPriv.Sign([]byte("message"))
+	}
+	if loaded, _ := ParseLmsPrivateKey(privateHex(lmsPriv)); loaded.Remaining() != 29 {
+		t.Errorf("Remaining() = %d after parsing, want 29", loaded.Remaining())
+	}
+	lmsPriv.AdvanceTo(31)
+	if lmsPriv.Remaining() != 1 {
+		t.Errorf("Remaining() = %d after AdvanceTo(31), want 1", lmsPriv.Remaining())
+	}
+	lmsPriv.Sign([]byte("message"))
+	if lmsPriv.Remaining() != 0 {
+		t.Errorf("Remaining() = %d after the last signature, want 0", lmsPriv.Remaining())
+	}
+	destroyed, _ := GenerateLmsPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W4)
+	destroyed.Destroy()
+	if destroyed.Remaining() != 0 {
+		t.Errorf("Remaining() = %d after Destroy, want 0", destroyed.Remaining())
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ldwm
+
+import (
+	"context"
+	"testing"
+)
+
+func TestGenerateWithContext(t *testing.T) {
+	var calls, last, total uint64
+	progress := func(done uint64, all uint64) {
+		calls++
+		if done != last+1 {
+			t.Fatalf("progress went from %d to %d", last, done)
+		}
+		last, total = done, all
+	}
+	lmsPriv, err := GenerateLmsPrivateKeyWithContext(context.Background(), LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8, progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 32 || last != 32 || total != 32 {
+		t.Errorf("LMS progress ended at %d/%d after %d calls, want 32/32", last, total, calls)
+	}
+	lmsPub, _ := lmsPriv.Public()
+	sig, _ := lmsPriv.Sign([]byte("abc"))
+	if lmsPub.Verify([]byte("abc"), sig) != nil {
+		t.Error("invalid signature")
+	}
+
+	calls, last = 0, 0
+	hssPriv, err := GenerateHssPrivateKeyWithContext(context.Background(), LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8, 3, progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 96 || last != 96 || total != 96 {
+		t.Errorf("HSS progress ended at %d/%d after %d calls, want 96/96", last, total, calls)
+	}
+	sig, _ = hssPriv.Sign([]byte("abc"))
+	if hssPriv.Public().Verify([]byte("abc"), sig) != nil {
+		t.Error("invalid signature")
+	}
+}
+
+func TestGenerateWithContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var done uint64
+	_, err := GenerateHssPrivateKeyWithContext(ctx, LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8, 2, func(d uint64, total uint64) {
+		if done = d; d == 40 {
+			cancel()
+		}
+	})
+	if err != context.Canceled || done != 40 {
+		t.Errorf("generation returned %v after %d leaves, want context.Canceled after 40", err, done)
+	}
+	if _, err := GenerateLmsPrivateKeyWithContext(ctx, LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8, nil); err != context.Canceled {
+		t.Errorf("generation with a canceled context returned %v", err)
+	}
+}
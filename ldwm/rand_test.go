@@ -0,0 +1,143 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ldwm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strings"
+	"testing"
+)
+
+// A testRand is a deterministic stream of SHA-256(seed || counter) blocks.
+type testRand struct {
+	seed    string
+	counter uint32
+	buf     []byte
+}
+
+func newTestRand(seed string) *testRand {
+	return &testRand{seed: seed}
+}
+
+func (r *testRand) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(r.buf) == 0 {
+			block := sha256.Sum256(append([]byte(r.seed), u32Str(int(r.counter))...))
+			r.buf = block[:]
+			r.counter++
+		}
+		c := copy(p[n:], r.buf)
+		r.buf = r.buf[c:]
+		n += c
+	}
+	return n, nil
+}
+
+func TestGenerateWithRand(t *testing.T) {
+	// RFC 8554, Test Case 2: the top-level LMS key is determined by I and SEED.
+	I, _ := hex.DecodeString("d08fabd4a2091ff0a8cb4ed834e74534")
+	seed, _ := hex.DecodeString("558b8966c48ae9cb898b423c83443aae014a72f1b1ab5cc85cf1d892903b5439")
+	lmsPriv, err := GenerateLmsPrivateKeyWithRand(LMS_SHA256_M32_H10, LMOTS_SHA256_N32_W4, bytes.NewReader(append(I, seed...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lmsPub, _ := lmsPriv.Public()
+	want := "0000000600000003d08fabd4a2091ff0a8cb4ed834e74534" +
+		"32a58885cd9ba0431235466bff9651c6c92124404d45fa53cf161c28f1ad5a8e"
+	if lmsPub.String() != want {
+		t.Errorf("public key = %s, want %s", lmsPub.String(), want)
+	}
+
+	otsPriv1, _ := GenerateOtsPrivateKeyWithRand(LMOTS_SHA256_N32_W4, newTestRand("ots"))
+	otsPriv2, _ := GenerateOtsPrivateKeyWithRand(LMOTS_SHA256_N32_W4, newTestRand("ots"))
+	if privateHex(otsPriv1) != privateHex(otsPriv2) {
+		t.Error("the same input yielded different LM-OTS private keys")
+	}
+	sig1, _ := otsPriv1.SignWithRand([]byte("message"), newTestRand("C"))
+	sig2, _ := otsPriv2.SignWithRand([]byte("message"), newTestRand("C"))
+	if !bytes.Equal(sig1, sig2) {
+		t.Error("the same randomizer yielded different LM-OTS signatures")
+	}
+	otsPub, _ := otsPriv1.Public()
+	if err := otsPub.Verify([]byte("message"), sig1); err != nil {
+		t.Errorf("invalid LM-OTS signature: %v", err)
+	}
+
+	lmsPriv1, _ := GenerateLmsPrivateKeyWithRand(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8, newTestRand("lms"))
+	lmsPriv2, _ := GenerateLmsPrivateKeyWithRand(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8, newTestRand("lms"))
+	lmsPriv3, _ := GenerateLmsPrivateKeyWithRand(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8, newTestRand("other"))
+	if privateHex(lmsPriv1) != privateHex(lmsPriv2) || privateHex(lmsPriv1) == privateHex(lmsPriv3) {
+		t.Error("LMS private keys do not depend on the input only")
+	}
+}
+
+func TestGenerateHssWithRand(t *testing.T) {
+	hssPriv1, _ := GenerateHssPrivateKeyWithRand(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8, 2, newTestRand("hss"))
+	hssPriv2, _ := GenerateHssPrivateKeyWithRand(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8, 2, newTestRand("hss"))
+	hssPub := hssPriv1.Public()
+	if hssPub.String() != hssPriv2.Public().String() {
+		t.Fatal("the same input yielded different HSS public keys")
+	}
+
+	// The 33rd signature uses a new bottom tree, derived from the top one.
+	for i := 0; i < 33; i++ {
+		sig1, err1 := hssPriv1.Sign([]byte("message"))
+		sig2, err2 := hssPriv2.Sign([]byte("message"))
+		if err1 != nil || err2 != nil || !bytes.Equal(sig1, sig2) {
+			t.Fatalf("signature %d differs: %v, %v", i, err1, err2)
+		}
+		if err := hssPub.Verify([]byte("message"), sig1); err != nil {
+			t.Fatalf("invalid signature %d: %v", i, err)
+		}
+	}
+}
+
+func TestGenerateWithShortRand(t *testing.T) {
+	short := func() io.Reader { return strings.NewReader("too short") }
+	if _, err := GenerateOtsPrivateKeyWithRand(LMOTS_SHA256_N32_W4, short()); err == nil {
+		t.Error("GenerateOtsPrivateKeyWithRand succeeded with a short input")
+	}
+	if _, err := GenerateLmsPrivateKeyWithRand(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W4, short()); err == nil {
+		t.Error("GenerateLmsPrivateKeyWithRand succeeded with a short input")
+	}
+	if _, err := GenerateHssPrivateKeyWithRand(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W4, 2, short()); err == nil {
+		t.Error("GenerateHssPrivateKeyWithRand succeeded with a short input")
+	}
+	otsPriv, _ := GenerateOtsPrivateKey(LMOTS_SHA256_N32_W4)
+	if _, err := otsPriv.SignWithRand([]byte("message"), short()); err == nil {
+		t.Error("SignWithRand succeeded with a short input")
+	}
+}
+
+func TestHssChildTreesAreDerived(t *testing.T) {
+	// Only the top tree is read from rand.
+	input := bytes.Repeat([]byte{7}, IdentifierLength+HashLength)
+	hssPriv1, err := GenerateHssPrivateKeyWithRand(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8, 2, bytes.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	top := hssPriv1.lmsPriv[0]
+	digest := sha256.Sum256(bytes.Join([][]byte{top.id, u32Str(1), make([]byte, 32), {0xff, 0xff, 0xff}, top.skSeed}, nil))
+	if !bytes.Equal(hssPriv1.lmsPriv[1].id, digest[:IdentifierLength]) {
+		t.Error("the identifier of the first child tree is not derived from the top tree")
+	}
+
+	// A parsed key derives the same trees once the bottom tree is exhausted.
+	hssPriv2, err := ParseHssPrivateKey(privateHex(hssPriv1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 70; i++ {
+		sig1, err1 := hssPriv1.Sign([]byte("message"))
+		sig2, err2 := hssPriv2.Sign([]byte("message"))
+		if err1 != nil || err2 != nil || !bytes.Equal(sig1, sig2) {
+			t.Fatalf("signature %d differs: %v, %v", i, err1, err2)
+		}
+	}
+}
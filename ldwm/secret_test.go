@@ -0,0 +1,113 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ldwm
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// privateHex serializes a private key to the hexadecimal form read by the Parse functions.
+func privateHex(key interface{ MarshalPrivate() ([]byte, error) }) string {
+	b, _ := key.MarshalPrivate()
+	return hex.EncodeToString(b)
+}
+
+func TestPrivateKeysAreRedacted(t *testing.T) {
+	otsPriv, _ := GenerateOtsPrivateKey(LMOTS_SHA256_N32_W4)
+	lmsPriv, _ := GenerateLmsPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8)
+	hssPriv, _ := GenerateHssPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8, 2)
+
+	keys := []struct {
+		key    interface{}
+		secret []byte
+	}{
+		{otsPriv, otsPriv.seed},
+		{lmsPriv, lmsPriv.skSeed},
+		{hssPriv, hssPriv.lmsPriv[1].skSeed},
+	}
+	for _, k := range keys {
+		if _, ok := k.key.(fmt.Stringer); ok {
+			t.Errorf("%T has a String method", k.key)
+		}
+		for _, verb := range []string{"%v", "%+v", "%#v", "%s", "%x", "%X", "%q"} {
+			out := fmt.Sprintf(verb, k.key)
+			if !strings.Contains(out, "REDACTED") ||
+				strings.Contains(strings.ToLower(out), hex.EncodeToString(k.secret)) {
+				t.Errorf("%T formatted with %s as %q", k.key, verb, out)
+			}
+		}
+	}
+}
+
+func TestDestroy(t *testing.T) {
+	otsPriv, _ := GenerateOtsPrivateKey(LMOTS_SHA256_N32_W4)
+	lmsPriv, _ := GenerateLmsPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8)
+	hssPriv, _ := GenerateHssPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8, 2)
+	secrets := [][]byte{otsPriv.seed, otsPriv.x, lmsPriv.skSeed, hssPriv.lmsPriv[0].skSeed, hssPriv.lmsPriv[1].skSeed}
+
+	keys := []interface {
+		Sign([]byte) ([]byte, error)
+		MarshalPrivate() ([]byte, error)
+		Destroy()
+	}{otsPriv, lmsPriv, hssPriv}
+	for _, key := range keys {
+		key.Destroy()
+		if _, err := key.Sign([]byte("message")); err == nil {
+			t.Errorf("%T signed after Destroy", key)
+		}
+		if _, err := key.MarshalPrivate(); err == nil {
+			t.Errorf("%T was serialized after Destroy", key)
+		}
+	}
+	for i, secret := range secrets {
+		if !bytes.Equal(secret, make([]byte, len(secret))) {
+			t.Errorf("secret %d was not cleared", i)
+		}
+	}
+	if hssPriv.Remaining() != 0 {
+		t.Errorf("Remaining() = %d after Destroy, want 0", hssPriv.Remaining())
+	}
+}
+
+func TestSignerDestroy(t *testing.T) {
+	hssPriv, _ := GenerateHssPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8, 2)
+	hssPub := hssPriv.Public()
+	s := NewHssSigner(hssPriv)
+
+	// A leaf reserved before Destroy still produces a valid signature.
+	leaf, err := s.reserve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Destroy()
+	sig, err := leaf.sign(bytesMessage([]byte("message")))
+	if err != nil || hssPub.Verify([]byte("message"), sig) != nil {
+		t.Errorf("reserved leaf failed after Destroy: %v", err)
+	}
+	if _, err := s.Sign([]byte("message")); err == nil {
+		t.Error("signer signed after Destroy")
+	}
+	if _, err := s.MarshalPrivate(); err == nil {
+		t.Error("signer serialized the key after Destroy")
+	}
+}
+
+func TestSignedLeafIsCleared(t *testing.T) {
+	lmsPriv, _ := GenerateLmsPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8)
+	leaf, _ := lmsPriv.reserve()
+	if _, err := leaf.sign(bytesMessage([]byte("message"))); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(leaf.skSeed, make([]byte, HashLength)) {
+		t.Error("the SEED of the leaf was not cleared after signing")
+	}
+	if _, err := lmsPriv.Sign([]byte("message")); err != nil {
+		t.Errorf("the private key was cleared with its leaf: %v", err)
+	}
+}
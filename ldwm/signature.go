@@ -0,0 +1,206 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ldwm
+
+import (
+	"bytes"
+	"errors"
+)
+
+// LMS signature.
+type LmsSignature struct {
+	// The leaf number of the LM-OTS key pair.
+	q           int
+	otsTypecode uint
+	// The n-byte randomizer of the LM-OTS signature.
+	c []byte
+	// p n-byte strings of the LM-OTS signature.
+	y           []byte
+	lmsTypecode uint
+	// h m-byte nodes of the authentication path.
+	path []byte
+}
+
+// HSS signature.
+type HssSignature struct {
+	// The LMS signatures of every layer, starting with the root. The signature
+	// of layer i < L-1 signs the public key of layer i+1.
+	lmsSig []*LmsSignature
+	// The LMS public keys of the layers below the root.
+	lmsPub []*LmsPublicKey
+}
+
+// Parses an LMS signature. The signature must contain no trailing data.
+func ParseLmsSignature(lmsSig []byte) (*LmsSignature, error) {
+	sig, rest, err := parseLmsSignature(lmsSig)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("lms: invalid LMS signature")
+	}
+	return sig, nil
+}
+
+// parseLmsSignature parses the LMS signature at the start of lmsSig and returns
+// the bytes that follow it.
+func parseLmsSignature(lmsSig []byte) (*LmsSignature, []byte, error) {
+	if len(lmsSig) < 8 {
+		return nil, nil, errors.New("lms: invalid LMS signature")
+	}
+	otsTypecode := uint(strTou32(lmsSig[4:8]))
+	otsType, err := otsParams(otsTypecode)
+	if err != nil {
+		return nil, nil, errors.New("lms: invalid LM-OTS typecode")
+	}
+	n := otsType.n
+	p := otsType.p
+	if len(lmsSig) < 12+n*(p+1) {
+		return nil, nil, errors.New("lms: invalid LMS signature")
+	}
+	lmsTypecode := uint(strTou32(lmsSig[8+n*(p+1) : 12+n*(p+1)]))
+	lmsType, err := lmsParams(lmsTypecode)
+	if err != nil {
+		return nil, nil, err
+	}
+	m := lmsType.m
+	h := lmsType.h
+	siglen := 12 + n*(p+1) + m*h
+	q := strTou32(lmsSig[:4])
+	if len(lmsSig) < siglen || q >= powInt(2, h) {
+		return nil, nil, errors.New("lms: invalid LMS signature")
+	}
+
+	buf := make([]byte, siglen)
+	copy(buf, lmsSig)
+	sig := new(LmsSignature)
+	sig.q = q
+	sig.otsTypecode = otsTypecode
+	sig.c = buf[8 : 8+n]
+	sig.y = buf[8+n : 8+n*(p+1)]
+	sig.lmsTypecode = lmsTypecode
+	sig.path = buf[12+n*(p+1) : siglen]
+	return sig, lmsSig[siglen:], nil
+}
+
+// Serializes the LMS signature.
+func (sig *LmsSignature) Marshal() []byte {
+	return bytes.Join([][]byte{u32Str(sig.q), sig.OtsSignature(), u32Str(int(sig.lmsTypecode)), sig.path}, []byte(""))
+}
+
+// Returns the leaf number q.
+func (sig *LmsSignature) Q() int {
+	return sig.q
+}
+
+// Returns the LMS typecode.
+func (sig *LmsSignature) LmsTypecode() uint {
+	return sig.lmsTypecode
+}
+
+// Returns the LM-OTS typecode.
+func (sig *LmsSignature) OtsTypecode() uint {
+	return sig.otsTypecode
+}
+
+// Returns the randomizer C of the LM-OTS signature.
+func (sig *LmsSignature) C() []byte {
+	return sig.c
+}
+
+// Returns the LM-OTS signature, which can be verified with the OtsPublicKey of leaf q.
+func (sig *LmsSignature) OtsSignature() []byte {
+	return bytes.Join([][]byte{u32Str(int(sig.otsTypecode)), sig.c, sig.y}, []byte(""))
+}
+
+// Returns the p n-byte strings y[0], ..., y[p-1] of the LM-OTS signature.
+func (sig *LmsSignature) Y() [][]byte {
+	return split(sig.y, otsTypes[sig.otsTypecode].n)
+}
+
+// Returns the authentication path from leaf q to the root, starting at the leaf.
+func (sig *LmsSignature) Path() [][]byte {
+	return split(sig.path, lmsTypes[sig.lmsTypecode].m)
+}
+
+// Parses an HSS signature. The signature must contain no trailing data.
+func ParseHssSignature(hssSig []byte) (*HssSignature, error) {
+	if len(hssSig) < 4 {
+		return nil, errors.New("hss: invalid HSS signature")
+	}
+	L := strTou32(hssSig[:4]) + 1
+	if L < 1 || L > 8 {
+		return nil, errors.New("hss: invalid HSS signature")
+	}
+	hssSig = hssSig[4:]
+
+	sig := new(HssSignature)
+	for i := 0; i < L-1; i++ {
+		lmsSig, rest, err := parseLmsSignature(hssSig)
+		if err != nil {
+			return nil, errors.New("hss: invalid HSS signature")
+		}
+		if len(rest) < 4 {
+			return nil, errors.New("hss: invalid HSS signature")
+		}
+		lmsType, err := lmsParams(uint(strTou32(rest[:4])))
+		if err != nil {
+			return nil, errors.New("hss: invalid HSS signature")
+		}
+		lmsPublen := 4 + 4 + IdentifierLength + lmsType.m
+		if len(rest) < lmsPublen {
+			return nil, errors.New("hss: invalid HSS signature")
+		}
+		lmsPub, err := parseLmsPublicKey(append([]byte(nil), rest[:lmsPublen]...))
+		if err != nil {
+			return nil, errors.New("hss: invalid HSS signature")
+		}
+		sig.lmsSig = append(sig.lmsSig, lmsSig)
+		sig.lmsPub = append(sig.lmsPub, lmsPub)
+		hssSig = rest[lmsPublen:]
+	}
+
+	lmsSig, err := ParseLmsSignature(hssSig)
+	if err != nil {
+		return nil, errors.New("hss: invalid HSS signature")
+	}
+	sig.lmsSig = append(sig.lmsSig, lmsSig)
+	return sig, nil
+}
+
+// Serializes the HSS signature.
+func (sig *HssSignature) Marshal() []byte {
+	buf := u32Str(len(sig.lmsSig) - 1)
+	for i, lmsPub := range sig.lmsPub {
+		buf = append(buf, sig.lmsSig[i].Marshal()...)
+		buf = append(buf, lmsPub.serialize()...)
+	}
+	return append(buf, sig.lmsSig[len(sig.lmsSig)-1].Marshal()...)
+}
+
+// Returns the number of layers L.
+func (sig *HssSignature) Layers() int {
+	return len(sig.lmsSig)
+}
+
+// Returns the LMS signatures of the L layers, starting with the root. The last
+// one signs the message and the others sign the public key of the next layer.
+func (sig *HssSignature) LmsSignatures() []*LmsSignature {
+	return sig.lmsSig
+}
+
+// Returns the signed LMS public keys of the L-1 layers below the root.
+func (sig *HssSignature) LmsPublicKeys() []*LmsPublicKey {
+	return sig.lmsPub
+}
+
+// split splits b into n-byte strings.
+func split(b []byte, n int) [][]byte {
+	list := make([][]byte, 0, len(b)/n)
+	for i := 0; i+n <= len(b); i += n {
+		list = append(list, b[i:i+n])
+	}
+	return list
+}
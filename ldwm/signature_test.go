@@ -0,0 +1,51 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ldwm
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseHssSignature(t *testing.T) {
+	hssPriv, _ := GenerateHssPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W4, 2)
+	hssPriv.Sign([]byte("message"))
+	hssSig, _ := hssPriv.Sign([]byte("message"))
+
+	sig, err := ParseHssSignature(hssSig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sig.Marshal(), hssSig) {
+		t.Error("Marshal(ParseHssSignature(sig)) != sig")
+	}
+	if sig.Layers() != 2 || len(sig.LmsPublicKeys()) != 1 {
+		t.Fatalf("layers = %d, public keys = %d", sig.Layers(), len(sig.LmsPublicKeys()))
+	}
+	if sig.LmsPublicKeys()[0].String() != hssPriv.lmsPub[1].String() {
+		t.Error("wrong signed public key")
+	}
+
+	lmsSig := sig.LmsSignatures()[1]
+	if lmsSig.Q() != 1 || lmsSig.LmsTypecode() != LMS_SHA256_M32_H5 || lmsSig.OtsTypecode() != LMOTS_SHA256_N32_W4 {
+		t.Errorf("q = %d, typecodes = %d, %d", lmsSig.Q(), lmsSig.LmsTypecode(), lmsSig.OtsTypecode())
+	}
+	if len(lmsSig.C()) != 32 || len(lmsSig.Y()) != 67 || len(lmsSig.Path()) != 5 {
+		t.Errorf("C has %d bytes, y has %d elements, path has %d nodes", len(lmsSig.C()), len(lmsSig.Y()), len(lmsSig.Path()))
+	}
+	if !bytes.Equal(lmsSig.OtsSignature()[4:4+32], lmsSig.C()) {
+		t.Error("OtsSignature does not start with C")
+	}
+	if err := hssPriv.lmsPub[1].Verify([]byte("message"), lmsSig.Marshal()); err != nil {
+		t.Error("re-marshaled LMS signature does not verify")
+	}
+
+	if _, err := ParseHssSignature(append(hssSig, 0)); err == nil {
+		t.Error("signature with trailing data accepted")
+	}
+	if _, err := ParseLmsSignature(lmsSig.Marshal()[:100]); err == nil {
+		t.Error("truncated LMS signature accepted")
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ldwm
+
+import (
+	"sync"
+
+	"github.com/lingyunzhao/pqcrypto/internal/parallel"
+)
+
+// The result of signing one of the messages passed to SignMany.
+type SignResult = parallel.SignResult
+
+// An LMS signer wraps an LMS private key so that it can be used from multiple
+// goroutines. Leaf indices are allocated and the private key is updated under a
+// lock, while the LM-OTS signatures of different leaves are computed in parallel.
+//
+// The private key must not be used directly while it is wrapped by a signer.
+type LmsSigner struct {
+	mu      sync.Mutex
+	lmsPriv *LmsPrivateKey
+}
+
+// Creates a signer for an LMS private key.
+func NewLmsSigner(lmsPriv *LmsPrivateKey) *LmsSigner {
+	return &LmsSigner{lmsPriv: lmsPriv}
+}
+
+func (s *LmsSigner) reserve() (*lmsLeaf, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lmsPriv.reserve()
+}
+
+// Generates an LMS signature for a message. It is safe to call Sign concurrently.
+func (s *LmsSigner) Sign(message []byte) ([]byte, error) {
+	if err := checkMessage(message); err != nil {
+		return nil, err
+	}
+	return s.sign(bytesMessage(message))
+}
+
+// sign signs a message without checking it for the batch tag.
+func (s *LmsSigner) sign(message messageDigest) ([]byte, error) {
+	leaf, err := s.reserve()
+	if err != nil {
+		return nil, err
+	}
+	return leaf.sign(message)
+}
+
+// Signs the messages in parallel and sends the results on the returned channel
+// in the order they complete. The channel is closed once every message has been
+// handled.
+func (s *LmsSigner) SignMany(messages [][]byte) <-chan SignResult {
+	return parallel.SignMany(messages, s.Sign)
+}
+
+// Signs a batch of messages with a single leaf (see SignBatch of the private key).
+func (s *LmsSigner) SignBatch(messages [][]byte) ([][]byte, error) {
+	return signBatch(messages, s.sign)
+}
+
+// Serializes the current private key (see MarshalPrivate of the private key).
+// The key already accounts for the signatures that are still being computed.
+func (s *LmsSigner) MarshalPrivate() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lmsPriv.MarshalPrivate()
+}
+
+// Destroys the wrapped private key. Signatures whose leaves have already been
+// reserved are still completed.
+func (s *LmsSigner) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lmsPriv.Destroy()
+}
+
+// An HSS signer wraps an HSS private key so that it can be used from multiple
+// goroutines. Leaf indices are allocated and the private key is updated under a
+// lock, while the LM-OTS signatures of different leaves are computed in parallel.
+//
+// The private key must not be used directly while it is wrapped by a signer.
+type HssSigner struct {
+	mu      sync.Mutex
+	hssPriv *HssPrivateKey
+}
+
+// Creates a signer for an HSS private key.
+func NewHssSigner(hssPriv *HssPrivateKey) *HssSigner {
+	return &HssSigner{hssPriv: hssPriv}
+}
+
+func (s *HssSigner) reserve() (*hssLeaf, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hssPriv.reserve()
+}
+
+// Generates an HSS signature for a message. It is safe to call Sign concurrently.
+func (s *HssSigner) Sign(message []byte) ([]byte, error) {
+	if err := checkMessage(message); err != nil {
+		return nil, err
+	}
+	return s.sign(bytesMessage(message))
+}
+
+// sign signs a message without checking it for the batch tag.
+func (s *HssSigner) sign(message messageDigest) ([]byte, error) {
+	leaf, err := s.reserve()
+	if err != nil {
+		return nil, err
+	}
+	return leaf.sign(message)
+}
+
+// Signs the messages in parallel and sends the results on the returned channel
+// in the order they complete. The channel is closed once every message has been
+// handled.
+func (s *HssSigner) SignMany(messages [][]byte) <-chan SignResult {
+	return parallel.SignMany(messages, s.Sign)
+}
+
+// Signs a batch of messages with a single leaf (see SignBatch of the private key).
+func (s *HssSigner) SignBatch(messages [][]byte) ([][]byte, error) {
+	return signBatch(messages, s.sign)
+}
+
+// Serializes the current private key (see MarshalPrivate of the private key).
+// The key already accounts for the signatures that are still being computed.
+func (s *HssSigner) MarshalPrivate() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hssPriv.MarshalPrivate()
+}
+
+// Destroys the wrapped private key. Signatures whose leaves have already been
+// reserved are still completed.
+func (s *HssSigner) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hssPriv.Destroy()
+}
@@ -0,0 +1,84 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ldwm
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestLmsSigner(t *testing.T) {
+	lmsPriv, _ := GenerateLmsPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W4)
+	lmsPub, _ := lmsPriv.Public()
+	s := NewLmsSigner(lmsPriv)
+
+	seen := make(map[int]bool)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for j := 0; j < 4; j++ {
+				msg := []byte(fmt.Sprintf("message %d-%d", g, j))
+				sig, err := s.Sign(msg)
+				if err != nil {
+					t.Errorf("failed to sign: %v", err)
+					return
+				}
+				if lmsPub.Verify(msg, sig) != nil {
+					t.Errorf("invalid signature for %q", msg)
+				}
+				mu.Lock()
+				q := strTou32(sig[:4])
+				if seen[q] {
+					t.Errorf("leaf %d used twice", q)
+				}
+				seen[q] = true
+				mu.Unlock()
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if _, err := s.Sign([]byte("abc")); err == nil {
+		t.Errorf("exhausted LMS private key signed a message")
+	}
+}
+
+func TestHssSigner(t *testing.T) {
+	hssPriv, _ := GenerateHssPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W4, 2)
+	hssPub := hssPriv.Public()
+	s := NewHssSigner(hssPriv)
+
+	// The batch crosses the boundary between the first two bottom trees.
+	messages := make([][]byte, 40)
+	for i := range messages {
+		messages[i] = []byte(fmt.Sprintf("batch %d", i))
+	}
+	done := make(map[int]bool)
+	for res := range s.SignMany(messages) {
+		if res.Err != nil {
+			t.Fatalf("failed to sign message %d: %v", res.Index, res.Err)
+		}
+		if hssPub.Verify(messages[res.Index], res.Signature) != nil {
+			t.Errorf("invalid signature for message %d", res.Index)
+		}
+		done[res.Index] = true
+	}
+	if len(done) != len(messages) {
+		t.Errorf("got %d results, want %d", len(done), len(messages))
+	}
+
+	phssPriv, err := ParseHssPrivateKey(privateHex(s))
+	if err != nil {
+		t.Fatalf("failed to parse private key: %v", err)
+	}
+	sig, _ := phssPriv.Sign([]byte("abc"))
+	if hssPub.Verify([]byte("abc"), sig) != nil {
+		t.Errorf("invalid signature using parsed private key")
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ldwm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+)
+
+// A messageDigest returns H(prefix || message) for the message being signed or
+// verified, where prefix is I || u32str(q) || u16str(D_MESG) || C. LM-OTS hashes
+// the message last, so it can be absorbed from a stream.
+type messageDigest func(prefix []byte) ([]byte, error)
+
+func bytesMessage(message []byte) messageDigest {
+	return func(prefix []byte) ([]byte, error) {
+		return sha256Hash(bytes.Join([][]byte{prefix, message}, []byte(""))), nil
+	}
+}
+
+// A streamMessage reads the message from r the first time it is hashed and
+// records the read error.
+type streamMessage struct {
+	r   io.Reader
+	err error
+}
+
+func (s *streamMessage) digest(prefix []byte) ([]byte, error) {
+	d := sha256.New()
+	d.Write(prefix)
+	_, s.err = io.Copy(d, s.r)
+	if s.err != nil {
+		return nil, s.err
+	}
+	return d.Sum(nil), nil
+}
+
+// Generates an LMS signature of the message read from r, and updates the private
+// key. The leaf is consumed before r is read, so it is not reused if reading fails
+// or the message starts with the batch tag.
+func (lmsPriv *LmsPrivateKey) SignReader(r io.Reader) ([]byte, error) {
+	leaf, err := lmsPriv.reserve()
+	if err != nil {
+		return nil, err
+	}
+	s := &streamMessage{r: &batchTagReader{r: r}}
+	return leaf.sign(s.digest)
+}
+
+// Verifies the message read from r with its LMS signature. r is not read if the
+// signature is malformed.
+func (lmsPub *LmsPublicKey) VerifyReader(r io.Reader, lmsSig []byte) error {
+	s := &streamMessage{r: r}
+	err := lmsPub.verify(s.digest, lmsSig)
+	if s.err != nil {
+		return s.err
+	}
+	return err
+}
+
+// Generates an HSS signature of the message read from r, and updates the private
+// key. The leaf is consumed before r is read, so it is not reused if reading fails
+// or the message starts with the batch tag.
+func (hssPriv *HssPrivateKey) SignReader(r io.Reader) ([]byte, error) {
+	leaf, err := hssPriv.reserve()
+	if err != nil {
+		return nil, err
+	}
+	s := &streamMessage{r: &batchTagReader{r: r}}
+	return leaf.sign(s.digest)
+}
+
+// Verifies the message read from r with its HSS signature. r is not read if the
+// signature is malformed.
+func (hssPub *HssPublicKey) VerifyReader(r io.Reader, hssSig []byte) error {
+	s := &streamMessage{r: r}
+	err := hssPub.verify(s.digest, hssSig, nil)
+	if s.err != nil {
+		return s.err
+	}
+	return err
+}
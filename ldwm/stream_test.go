@@ -0,0 +1,70 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ldwm
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, errors.New("read failed")
+}
+
+func TestLmsSignReader(t *testing.T) {
+	lmsPriv, _ := GenerateLmsPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8)
+	lmsPub, _ := lmsPriv.Public()
+	message := bytes.Repeat([]byte("0123456789abcdef"), 1<<16)
+
+	sig, err := lmsPriv.SignReader(bytes.NewReader(message))
+	if err != nil {
+		t.Fatalf("SignReader failed: %v", err)
+	}
+	if lmsPub.Verify(message, sig) != nil {
+		t.Errorf("Verify rejected a signature from SignReader")
+	}
+	sig, _ = lmsPriv.Sign(message)
+	if lmsPub.VerifyReader(bytes.NewReader(message), sig) != nil {
+		t.Errorf("VerifyReader rejected a signature from Sign")
+	}
+	if lmsPub.VerifyReader(io.LimitReader(bytes.NewReader(message), int64(len(message)-1)), sig) == nil {
+		t.Errorf("VerifyReader accepted a truncated message")
+	}
+	if err := lmsPub.VerifyReader(errReader{}, sig); err == nil || err.Error() != "read failed" {
+		t.Errorf("VerifyReader with a failing reader = %v", err)
+	}
+
+	q := lmsPriv.q
+	if _, err := lmsPriv.SignReader(errReader{}); err == nil {
+		t.Errorf("SignReader with a failing reader succeeded")
+	}
+	if lmsPriv.q != q+1 {
+		t.Errorf("SignReader with a failing reader did not consume the leaf")
+	}
+}
+
+func TestHssSignReader(t *testing.T) {
+	hssPriv, _ := GenerateHssPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8, 2)
+	hssPub := hssPriv.Public()
+	message := bytes.Repeat([]byte("0123456789abcdef"), 1<<16)
+
+	sig, err := hssPriv.SignReader(bytes.NewReader(message))
+	if err != nil {
+		t.Fatalf("SignReader failed: %v", err)
+	}
+	if hssPub.Verify(message, sig) != nil {
+		t.Errorf("Verify rejected a signature from SignReader")
+	}
+	if hssPub.VerifyReader(bytes.NewReader(message), sig) != nil {
+		t.Errorf("VerifyReader rejected a signature from SignReader")
+	}
+	if hssPub.VerifyReader(bytes.NewReader(message[1:]), sig) == nil {
+		t.Errorf("VerifyReader accepted a wrong message")
+	}
+}
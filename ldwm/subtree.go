@@ -0,0 +1,240 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ldwm
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"math"
+	"math/big"
+
+	"github.com/lingyunzhao/pqcrypto/merkle"
+)
+
+// The tree of an LMS private key can be computed by several workers, each
+// running one subtree job, and assembled from their results:
+//
+//	job:    u32 lmsTypecode || u32 otsTypecode || u32 s || u32 j || u32 m || I || SEED
+//	result: u32 lmsTypecode || u32 otsTypecode || u32 s || u32 j || u32 m || I || node ...
+//
+// where job j covers the subtree of height s over the leaves j*2^s to
+// (j+1)*2^s-1, and its result holds the 2^(s-m) nodes of that subtree on level
+// m, from left to right.
+
+// subtreeJob is a decoded subtree job.
+type subtreeJob struct {
+	mt     *LmsPrivateKey
+	s      int
+	j      int
+	m      int
+	header []byte
+}
+
+// Splits the generation of a new LMS private key into 2^(h-height) subtree
+// jobs, one for each subtree of the given height, with a new random identifier
+// and SEED. A job contains the SEED and must be protected like the key itself.
+func NewLmsSubtreeJobs(lmsTypecode uint, otsTypecode uint, height int) ([][]byte, error) {
+	lmsType, err := lmsParams(lmsTypecode)
+	if err != nil {
+		return nil, err
+	}
+	if height < 0 || height > lmsType.h {
+		return nil, errors.New("lms: invalid subtree height")
+	}
+	mt, err := newLmsPrivateKey(lmsTypecode, otsTypecode, defaultK(lmsType.h), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	defer mt.Destroy()
+	m := merkle.JoinLevel(lmsType.h, defaultK(lmsType.h))
+	if m > height {
+		m = height
+	}
+	jobs := make([][]byte, powInt(2, lmsType.h-height))
+	for j := range jobs {
+		jobs[j] = bytes.Join([][]byte{u32Str(int(lmsTypecode)), u32Str(int(otsTypecode)), u32Str(height),
+			u32Str(j), u32Str(m), mt.id, mt.skSeed}, []byte(""))
+	}
+	return jobs, nil
+}
+
+// Runs a subtree job written by NewLmsSubtreeJobs and returns its result,
+// calling progress, if it is not nil, after each of the 2^height leaves of the
+// subtree. It stops with the error of ctx once ctx is done.
+func RunLmsSubtreeJob(ctx context.Context, job []byte, progress Progress) ([]byte, error) {
+	sj, err := parseSubtreeJob(job)
+	if err != nil {
+		return nil, err
+	}
+	defer sj.mt.Destroy()
+	g := &keygen{ctx: ctx, progress: progress, total: uint64(powInt(2, sj.s))}
+	h := progressHasher{lmsHasher{sj.mt}, g}
+	count := powInt(2, sj.s-sj.m)
+	nodes := make([][]byte, count)
+	for i := range nodes {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		nodes[i] = merkle.SubtreeRoot(sj.m, sj.j*count+i, h)
+	}
+	return bytes.Join(append([][]byte{sj.header}, nodes...), []byte("")), nil
+}
+
+// Assembles an LMS private key from the results of all the subtree jobs
+// written by one call to NewLmsSubtreeJobs, results[i] being the result of
+// jobs[i]. Each result must answer its job, and every node of it is recomputed
+// from the leaves below it, which rejects any wrong result but costs as much as
+// generating the key in one piece. AssembleLmsPrivateKeyWithChecks recomputes
+// fewer nodes. The key has the tree, root and authentication path of a key
+// generated in one piece.
+func AssembleLmsPrivateKey(jobs [][]byte, results [][]byte) (*LmsPrivateKey, error) {
+	return AssembleLmsPrivateKeyWithChecks(jobs, results, math.MaxInt)
+}
+
+// Assembles an LMS private key like AssembleLmsPrivateKey, but only recomputes
+// checks distinct nodes of each result, chosen at random, from the leaves below
+// them. A result holds c = 2^(s-m) nodes; if b of them are wrong, it passes with
+// probability (c-b)/c * (c-b-1)/(c-1) * ... over the checks factors, at most
+// ((c-b)/c)^checks, so a single wrong node is caught with probability only
+// checks/c; the signatures made with the leaves below a wrong node do not
+// verify. Use it only with workers that are trusted to compute their results
+// correctly. With checks >= c every node is recomputed, as
+// AssembleLmsPrivateKey does.
+func AssembleLmsPrivateKeyWithChecks(jobs [][]byte, results [][]byte, checks int) (*LmsPrivateKey, error) {
+	invalid := errors.New("lms: invalid subtree result")
+	if checks < 1 {
+		return nil, errors.New("lms: invalid number of checks")
+	}
+	if len(jobs) == 0 || len(jobs) != len(results) {
+		return nil, errors.New("lms: missing subtree results")
+	}
+	first, err := parseSubtreeJob(jobs[0])
+	if err != nil {
+		return nil, err
+	}
+	defer first.mt.Destroy()
+	mt := first.mt
+	if len(jobs) != powInt(2, mt.height-first.s) {
+		return nil, errors.New("lms: missing subtree results")
+	}
+
+	count := powInt(2, first.s-first.m)
+	nodes := make([][]byte, len(jobs)*count)
+	for i, job := range jobs {
+		sj, err := parseSubtreeJob(job)
+		if err != nil {
+			return nil, err
+		}
+		sj.mt.Destroy()
+		if !bytes.Equal(job[:12], jobs[0][:12]) || !bytes.Equal(job[16:], jobs[0][16:]) || nodes[sj.j*count] != nil {
+			return nil, errors.New("lms: subtree jobs of different keys")
+		}
+		result := results[i]
+		if len(result) != len(sj.header)+count*HashLength || !bytes.Equal(result[:len(sj.header)], sj.header) {
+			return nil, invalid
+		}
+		for x := 0; x < count; x++ {
+			node := make([]byte, HashLength)
+			copy(node, result[len(sj.header)+x*HashLength:])
+			nodes[sj.j*count+x] = node
+		}
+
+		picked, err := pickNodes(count, checks)
+		if err != nil {
+			return nil, err
+		}
+		for _, x := range picked {
+			if subtle.ConstantTimeCompare(merkle.SubtreeRoot(sj.m, sj.j*count+x, lmsHasher{mt}), nodes[sj.j*count+x]) != 1 {
+				return nil, invalid
+			}
+		}
+	}
+
+	lmsPriv := newMerkleTree(mt.id, mt.skSeed, mt.lmsTypecode, mt.otsTypecode, defaultK(mt.height))
+	lmsPriv.bds, err = merkle.NewBDSFromNodes(lmsPriv.height, defaultK(lmsPriv.height), first.m, nodes, lmsHasher{lmsPriv})
+	if err != nil {
+		lmsPriv.Destroy()
+		return nil, invalid
+	}
+	lmsPriv.root = lmsPriv.bds.Root()
+	return lmsPriv, nil
+}
+
+// pickNodes returns checks distinct indices below count chosen at random, or all
+// of them if checks >= count.
+func pickNodes(count int, checks int) ([]int, error) {
+	if checks >= count {
+		picked := make([]int, count)
+		for x := range picked {
+			picked[x] = x
+		}
+		return picked, nil
+	}
+	// A partial Fisher-Yates shuffle, storing only the moved entries.
+	moved := make(map[int]int)
+	picked := make([]int, checks)
+	for x := range picked {
+		r, err := rand.Int(rand.Reader, big.NewInt(int64(count-x)))
+		if err != nil {
+			return nil, err
+		}
+		y := x + int(r.Int64())
+		picked[x], moved[y] = entry(moved, y), entry(moved, x)
+	}
+	return picked, nil
+}
+
+// entry returns the entry x of a shuffled identity permutation.
+func entry(moved map[int]int, x int) int {
+	if v, ok := moved[x]; ok {
+		return v
+	}
+	return x
+}
+
+// parseSubtreeJob decodes a subtree job.
+func parseSubtreeJob(job []byte) (*subtreeJob, error) {
+	invalid := errors.New("lms: invalid subtree job")
+	if len(job) != 20+IdentifierLength+HashLength {
+		return nil, invalid
+	}
+	lmsTypecode := uint(strTou32(job[:4]))
+	otsTypecode := uint(strTou32(job[4:8]))
+	lmsType, err := lmsParams(lmsTypecode)
+	if err != nil {
+		return nil, invalid
+	}
+	if _, err := otsParams(otsTypecode); err != nil {
+		return nil, invalid
+	}
+	sj := &subtreeJob{s: strTou32(job[8:12]), j: strTou32(job[12:16]), m: strTou32(job[16:20]),
+		header: job[:20+IdentifierLength]}
+	if sj.s > lmsType.h || sj.j >= powInt(2, lmsType.h-sj.s) || sj.m > sj.s ||
+		sj.m > merkle.JoinLevel(lmsType.h, defaultK(lmsType.h)) {
+		return nil, invalid
+	}
+	sj.mt = newMerkleTree(job[20:20+IdentifierLength], job[20+IdentifierLength:], lmsTypecode, otsTypecode,
+		defaultK(lmsType.h))
+	return sj, nil
+}
+
+// A progressHasher reports every leaf it computes to the Progress function of
+// a keygen.
+type progressHasher struct {
+	lmsHasher
+	g *keygen
+}
+
+func (t progressHasher) Leaf(idx int) []byte {
+	leaf := t.lmsHasher.Leaf(idx)
+	t.g.done++
+	if t.g.progress != nil {
+		t.g.progress(t.g.done, t.g.total)
+	}
+	return leaf
+}
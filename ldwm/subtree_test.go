@@ -0,0 +1,157 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ldwm
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func runJobs(t *testing.T, jobs [][]byte) [][]byte {
+	results := make([][]byte, len(jobs))
+	for i, job := range jobs {
+		var leaves uint64
+		result, err := RunLmsSubtreeJob(context.Background(), job, func(done uint64, total uint64) {
+			leaves = done
+			if total != 4 {
+				t.Fatalf("job %d reported %d leaves in total, want 4", i, total)
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if leaves != 4 {
+			t.Fatalf("job %d computed %d leaves, want 4", i, leaves)
+		}
+		results[i] = result
+	}
+	return results
+}
+
+func TestAssembleLmsPrivateKey(t *testing.T) {
+	jobs, err := NewLmsSubtreeJobs(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 8 {
+		t.Fatalf("got %d jobs, want 8", len(jobs))
+	}
+	results := runJobs(t, jobs)
+	lmsPriv, err := AssembleLmsPrivateKey(jobs, results)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A key parsed from the seeds computes its tree in one piece.
+	key, _ := lmsPriv.MarshalPrivate()
+	whole, _ := parseLmsPrivateKey(key, useDefaultK)
+	if !bytes.Equal(lmsPriv.root, whole.root) || !bytes.Equal(lmsPriv.bds.Marshal(HashLength), whole.bds.Marshal(HashLength)) {
+		t.Fatal("assembled key differs from one generated in one piece")
+	}
+	lmsPub, _ := lmsPriv.Public()
+	for i := 0; i < 32; i++ {
+		sig, err := lmsPriv.Sign([]byte("abc"))
+		if err != nil || lmsPub.Verify([]byte("abc"), sig) != nil {
+			t.Fatalf("invalid signature %d: %v", i, err)
+		}
+		if wholeSig, _ := whole.Sign([]byte("abc")); !bytes.Equal(sig, wholeSig) {
+			t.Fatalf("signature %d differs from one of a key generated in one piece", i)
+		}
+	}
+}
+
+func TestAssembleRejectsInvalidResults(t *testing.T) {
+	jobs, _ := NewLmsSubtreeJobs(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8, 2)
+	results := runJobs(t, jobs)
+	other, _ := NewLmsSubtreeJobs(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8, 2)
+	otherResults := runJobs(t, other)
+
+	replaced := func(i int, result []byte) [][]byte {
+		r := append([][]byte(nil), results...)
+		r[i] = result
+		return r
+	}
+	// A result for the leaves of another job, relabeled as the answer to job 3.
+	relabeled := append([]byte(nil), results[2]...)
+	copy(relabeled[12:16], u32Str(3))
+	// A result computed with other seeds, relabeled with the identifier of the key.
+	forged := append([]byte(nil), otherResults[3]...)
+	copy(forged[20:20+IdentifierLength], results[3][20:])
+
+	for name, r := range map[string][][]byte{
+		"missing":   results[:7],
+		"swapped":   append([][]byte{results[1], results[0]}, results[2:]...),
+		"truncated": replaced(3, results[3][:len(results[3])-1]),
+		"other key": replaced(3, otherResults[3]),
+		"relabeled": replaced(3, relabeled),
+		"forged":    replaced(3, forged),
+	} {
+		if _, err := AssembleLmsPrivateKey(jobs, r); err == nil {
+			t.Errorf("assembled a key from a %s result", name)
+		}
+	}
+	mixed := append(append([][]byte(nil), jobs[:4]...), other[4:]...)
+	if _, err := AssembleLmsPrivateKey(mixed, append(append([][]byte(nil), results[:4]...), otherResults[4:]...)); err == nil {
+		t.Error("assembled a key from the jobs of two keys")
+	}
+	duplicated := append(append([][]byte(nil), jobs[:7]...), jobs[0])
+	if _, err := AssembleLmsPrivateKey(duplicated, append(append([][]byte(nil), results[:7]...), results[0])); err == nil {
+		t.Error("assembled a key from a duplicated job")
+	}
+}
+
+func TestAssembleWithChecks(t *testing.T) {
+	// A single job of height 5 holds the 8 nodes of level 2.
+	jobs, _ := NewLmsSubtreeJobs(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8, 5)
+	result, err := RunLmsSubtreeJob(context.Background(), jobs[0], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AssembleLmsPrivateKeyWithChecks(jobs, [][]byte{result}, 8); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AssembleLmsPrivateKeyWithChecks(jobs, [][]byte{result}, 0); err == nil {
+		t.Error("assembled a key without checks")
+	}
+
+	// Checking every node, as AssembleLmsPrivateKey does, always finds a single
+	// wrong one, which a single check only finds with probability 1/8.
+	wrong := append([]byte(nil), result...)
+	wrong[len(wrong)-1] ^= 1
+	for i := 0; i < 10; i++ {
+		if _, err := AssembleLmsPrivateKeyWithChecks(jobs, [][]byte{wrong}, 8); err == nil {
+			t.Fatal("assembled a key from a result with a wrong node")
+		}
+		if _, err := AssembleLmsPrivateKey(jobs, [][]byte{wrong}); err == nil {
+			t.Fatal("AssembleLmsPrivateKey assembled a key from a result with a wrong node")
+		}
+	}
+	for _, picked := range [][]int{mustPick(t, 8, 3), mustPick(t, 8, 8), mustPick(t, 1<<30, 5)} {
+		seen := make(map[int]bool)
+		for _, x := range picked {
+			if seen[x] {
+				t.Errorf("node %d picked twice in %v", x, picked)
+			}
+			seen[x] = true
+		}
+	}
+}
+
+func mustPick(t *testing.T, count int, checks int) []int {
+	picked, err := pickNodes(count, checks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(picked) != checks {
+		t.Fatalf("picked %d nodes, want %d", len(picked), checks)
+	}
+	for _, x := range picked {
+		if x < 0 || x >= count {
+			t.Fatalf("picked node %d of %d", x, count)
+		}
+	}
+	return picked
+}
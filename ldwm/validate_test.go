@@ -0,0 +1,178 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ldwm
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// with returns a copy of b with the bytes at off replaced by x.
+func with(b []byte, off int, x []byte) []byte {
+	c := append([]byte(nil), b...)
+	copy(c[off:], x)
+	return c
+}
+
+func TestGenerateRejectsInvalidParameters(t *testing.T) {
+	if _, err := GenerateOtsPrivateKey(0); err == nil {
+		t.Error("GenerateOtsPrivateKey accepted LM-OTS typecode 0")
+	}
+	if _, err := GenerateLmsPrivateKey(LMS_SHA256_M32_H5, 0); err == nil {
+		t.Error("GenerateLmsPrivateKey accepted LM-OTS typecode 0")
+	}
+	if _, err := GenerateLmsPrivateKey(LMS_SHA256_M32_H25+1, LMOTS_SHA256_N32_W4); err == nil {
+		t.Error("GenerateLmsPrivateKey accepted an unknown LMS typecode")
+	}
+	if _, err := GenerateHssPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8+1, 2); err == nil {
+		t.Error("GenerateHssPrivateKey accepted an unknown LM-OTS typecode")
+	}
+	if _, err := GenerateHssPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W4, 9); err == nil {
+		t.Error("GenerateHssPrivateKey accepted 9 layers")
+	}
+}
+
+func TestParseKeysRejectInvalidInput(t *testing.T) {
+	otsPriv, _ := GenerateOtsPrivateKey(LMOTS_SHA256_N32_W4)
+	otsPub, _ := otsPriv.Public()
+	otsPrivKey, _ := hex.DecodeString(privateHex(otsPriv))
+	otsPubKey, _ := hex.DecodeString(otsPub.String())
+	hssPriv, _ := GenerateHssPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W4, 2)
+	hssPrivKey, _ := hex.DecodeString(privateHex(hssPriv))
+	hssPubKey, _ := hex.DecodeString(hssPriv.Public().String())
+	lmsPrivKey := hssPriv.lmsPriv[0].serialize()
+	lmsPubKey := hssPriv.lmsPub[0].serialize()
+
+	tests := []struct {
+		name  string
+		parse func(string) error
+		key   []byte
+	}{
+		{"LM-OTS public key with typecode 0", parseOtsPub, with(otsPubKey, 0, u32Str(0))},
+		{"LM-OTS public key with unknown typecode", parseOtsPub, with(otsPubKey, 0, u32Str(LMOTS_SHA256_N32_W8+1))},
+		{"truncated LM-OTS public key", parseOtsPub, otsPubKey[:len(otsPubKey)-1]},
+		{"LM-OTS private key with typecode 0", parseOtsPriv, with(otsPrivKey, 0, u32Str(0))},
+		{"truncated LM-OTS private key", parseOtsPriv, otsPrivKey[:3]},
+		{"LMS public key with LMS typecode 0", parseLmsPub, with(lmsPubKey, 0, u32Str(0))},
+		{"LMS public key with LM-OTS typecode 0", parseLmsPub, with(lmsPubKey, 4, u32Str(0))},
+		{"LMS public key with short root", parseLmsPub, lmsPubKey[:len(lmsPubKey)-1]},
+		{"LMS public key without root", parseLmsPub, lmsPubKey[:8+IdentifierLength]},
+		{"LMS private key with LMS typecode 0", parseLmsPriv, with(lmsPrivKey, 0, u32Str(0))},
+		{"LMS private key with LM-OTS typecode 0", parseLmsPriv, with(lmsPrivKey, 4, u32Str(0))},
+		{"LMS private key with q = 2^h", parseLmsPriv, with(lmsPrivKey, 8, u32Str(32))},
+		{"truncated LMS private key", parseLmsPriv, lmsPrivKey[:len(lmsPrivKey)-1]},
+		{"HSS public key with 0 layers", parseHssPub, with(hssPubKey, 0, u32Str(0))},
+		{"HSS public key with 9 layers", parseHssPub, with(hssPubKey, 0, u32Str(9))},
+		{"HSS public key with LM-OTS typecode 0", parseHssPub, with(hssPubKey, 8, u32Str(0))},
+		{"HSS private key with 0 layers", parseHssPriv, u32Str(0)},
+		{"HSS private key with a missing layer", parseHssPriv, with(hssPrivKey, 0, u32Str(3))},
+		{"HSS private key with LM-OTS typecode 0", parseHssPriv, with(hssPrivKey, 8, u32Str(0))},
+	}
+	for _, test := range tests {
+		if err := test.parse(hex.EncodeToString(test.key)); err == nil {
+			t.Errorf("accepted %s", test.name)
+		}
+	}
+}
+
+func parseOtsPub(s string) error  { _, err := ParseOtsPublicKey(s); return err }
+func parseOtsPriv(s string) error { _, err := ParseOtsPrivateKey(s); return err }
+func parseLmsPub(s string) error  { _, err := ParseLmsPublicKey(s); return err }
+func parseLmsPriv(s string) error { _, err := ParseLmsPrivateKey(s); return err }
+func parseHssPub(s string) error  { _, err := ParseHssPublicKey(s); return err }
+func parseHssPriv(s string) error { _, err := ParseHssPrivateKey(s); return err }
+
+func TestValidateRejectsInvalidKeys(t *testing.T) {
+	lmsPriv, _ := GenerateLmsPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W4)
+	lmsPub, _ := lmsPriv.Public()
+
+	pub := *lmsPub
+	pub.otsTypecode = 0
+	if pub.Validate() == nil {
+		t.Error("LMS public key with LM-OTS typecode 0 is valid")
+	}
+	pub = *lmsPub
+	pub.lmsTypecode = LMS_SHA256_M32_H25 + 1
+	if pub.Validate() == nil {
+		t.Error("LMS public key with an unknown LMS typecode is valid")
+	}
+	priv := *lmsPriv
+	priv.otsTypecode = 0
+	if priv.Validate() == nil {
+		t.Error("LMS private key with LM-OTS typecode 0 is valid")
+	}
+	if _, err := priv.Sign([]byte("abc")); err == nil {
+		t.Error("signed with an LMS private key with LM-OTS typecode 0")
+	}
+	otsPub := &OtsPublicKey{otsTypecode: 0, id: make([]byte, IdentifierLength), k: make([]byte, HashLength)}
+	if otsPub.Validate() == nil {
+		t.Error("LM-OTS public key with typecode 0 is valid")
+	}
+
+	for _, hssPub := range []*HssPublicKey{{}, {layer: 1}, {layer: 9, lmsPub: lmsPub}, {layer: 1, lmsPub: &pub}} {
+		if hssPub.Validate() == nil || hssPub.Verify([]byte("abc"), nil) == nil {
+			t.Errorf("invalid HSS public key %+v accepted", hssPub)
+		}
+	}
+}
+
+func TestParseSignaturesRejectInvalidInput(t *testing.T) {
+	hssPriv, _ := GenerateHssPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W4, 2)
+	hssPub := hssPriv.Public()
+	message := []byte("abc")
+	hssSig, _ := hssPriv.Sign(message)
+	sig, _ := ParseHssSignature(hssSig)
+	lmsSig := sig.lmsSig[1].Marshal()
+	n, p := HashLength, otsTypes[LMOTS_SHA256_N32_W4].p
+	lmsTypeOff := 8 + n*(p+1)
+	// Offset of the signed public key of layer 1 in the HSS signature.
+	pubOff := 4 + len(sig.lmsSig[0].Marshal())
+
+	lmsTests := []struct {
+		name string
+		sig  []byte
+	}{
+		{"short signature", lmsSig[:7]},
+		{"LM-OTS typecode 0", with(lmsSig, 4, u32Str(0))},
+		{"unknown LM-OTS typecode", with(lmsSig, 4, u32Str(LMOTS_SHA256_N32_W8+1))},
+		{"LMS typecode 0", with(lmsSig, lmsTypeOff, u32Str(0))},
+		{"q = 2^h", with(lmsSig, 0, u32Str(32))},
+		{"truncated path", lmsSig[:len(lmsSig)-1]},
+		{"trailing data", append(append([]byte(nil), lmsSig...), 0)},
+		{"other LMS typecode", with(lmsSig, lmsTypeOff, u32Str(LMS_SHA256_M32_H10))},
+	}
+	for _, test := range lmsTests {
+		if _, err := ParseLmsSignature(test.sig); err == nil {
+			t.Errorf("ParseLmsSignature accepted a signature with %s", test.name)
+		}
+		if sig.lmsPub[0].Verify(message, test.sig) == nil {
+			t.Errorf("LMS signature with %s verified", test.name)
+		}
+	}
+
+	hssTests := []struct {
+		name string
+		sig  []byte
+	}{
+		{"short signature", hssSig[:3]},
+		{"9 layers", with(hssSig, 0, u32Str(8))},
+		{"1 layer", with(hssSig, 0, u32Str(0))},
+		{"LMS typecode 0 in a signed public key", with(hssSig, pubOff, u32Str(0))},
+		{"LM-OTS typecode 0 in a signed public key", with(hssSig, pubOff+4, u32Str(0))},
+		{"trailing data", append(append([]byte(nil), hssSig...), 0)},
+	}
+	for _, test := range hssTests {
+		if _, err := ParseHssSignature(test.sig); err == nil {
+			t.Errorf("ParseHssSignature accepted a signature with %s", test.name)
+		}
+		if hssPub.Verify(message, test.sig) == nil {
+			t.Errorf("HSS signature with %s verified", test.name)
+		}
+	}
+	if !bytes.Equal(sig.Marshal(), hssSig) || hssPub.Verify(message, hssSig) != nil {
+		t.Error("valid HSS signature rejected")
+	}
+}
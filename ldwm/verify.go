@@ -0,0 +1,75 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ldwm
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/lingyunzhao/pqcrypto/internal/parallel"
+)
+
+// A verifyCache remembers the results of LMS verifications shared by many HSS
+// signatures, so that each signed intermediate public key is checked once.
+type verifyCache struct {
+	mu      sync.Mutex
+	entries map[string]*verifyEntry
+}
+
+type verifyEntry struct {
+	once sync.Once
+	err  error
+}
+
+func newVerifyCache() *verifyCache {
+	return &verifyCache{entries: make(map[string]*verifyEntry)}
+}
+
+// do returns the result of verify for key, calling it only the first time key
+// is seen. A nil cache always calls verify.
+func (c *verifyCache) do(key string, verify func() error) error {
+	if c == nil {
+		return verify()
+	}
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	if !ok {
+		e = new(verifyEntry)
+		c.entries[key] = e
+	}
+	c.mu.Unlock()
+	e.once.Do(func() { e.err = verify() })
+	return e.err
+}
+
+// verifyMany calls verify for every message and signature pair in parallel and
+// returns the results in the order of the messages.
+func verifyMany(messages, sigs [][]byte, verify func(message, sig []byte) error) []error {
+	errs := make([]error, len(messages))
+	parallel.Do(len(messages), func(j int) {
+		if j >= len(sigs) {
+			errs[j] = errors.New("ldwm: missing signature")
+			return
+		}
+		errs[j] = verify(messages[j], sigs[j])
+	})
+	return errs
+}
+
+// Verifies messages with their LMS signatures in parallel. The i-th error is nil
+// if sigs[i] is a valid signature of messages[i].
+func (lmsPub *LmsPublicKey) VerifyBatch(messages, sigs [][]byte) []error {
+	return verifyMany(messages, sigs, lmsPub.Verify)
+}
+
+// Verifies messages with their HSS signatures in parallel. The i-th error is nil
+// if sigs[i] is a valid signature of messages[i]. The signatures of intermediate
+// LMS public keys shared by several signatures are verified only once.
+func (hssPub *HssPublicKey) VerifyBatch(messages, sigs [][]byte) []error {
+	cache := newVerifyCache()
+	return verifyMany(messages, sigs, func(message, sig []byte) error {
+		return hssPub.verify(bytesMessage(message), sig, cache)
+	})
+}
@@ -0,0 +1,66 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ldwm
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHssVerifyBatch(t *testing.T) {
+	hssPriv, _ := GenerateHssPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W4, 2)
+	hssPub := hssPriv.Public()
+
+	messages := make([][]byte, 40)
+	sigs := make([][]byte, len(messages))
+	for i := range messages {
+		messages[i] = []byte(fmt.Sprintf("message %d", i))
+		sigs[i], _ = hssPriv.Sign(messages[i])
+	}
+	// A wrong message, a tampered upper-layer signature and a truncated signature.
+	messages[3] = []byte("other")
+	sigs[5] = append([]byte{}, sigs[5]...)
+	sigs[5][20] ^= 1
+	sigs[7] = sigs[7][:100]
+
+	errs := hssPub.VerifyBatch(messages, sigs)
+	for i, err := range errs {
+		if bad := i == 3 || i == 5 || i == 7; bad != (err != nil) {
+			t.Errorf("VerifyBatch()[%d] = %v", i, err)
+		}
+	}
+
+	// The 40 signatures use two bottom trees, so their upper layers carry two
+	// distinct signed public keys, plus the tampered one.
+	cache := newVerifyCache()
+	for i := range messages[:8] {
+		hssPub.verify(bytesMessage(messages[i]), sigs[i], cache)
+	}
+	for i := range messages[30:] {
+		hssPub.verify(bytesMessage(messages[30+i]), sigs[30+i], cache)
+	}
+	if len(cache.entries) != 3 {
+		t.Errorf("cache holds %d entries, want 3", len(cache.entries))
+	}
+
+	if errs := hssPub.VerifyBatch(messages[:2], sigs[:1]); errs[0] != nil || errs[1] == nil {
+		t.Errorf("VerifyBatch with a missing signature = %v", errs)
+	}
+}
+
+func TestLmsVerifyBatch(t *testing.T) {
+	lmsPriv, _ := GenerateLmsPrivateKey(LMS_SHA256_M32_H5, LMOTS_SHA256_N32_W8)
+	lmsPub, _ := lmsPriv.Public()
+	messages := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	sigs := make([][]byte, len(messages))
+	for i := range messages {
+		sigs[i], _ = lmsPriv.Sign(messages[i])
+	}
+	sigs[1], sigs[2] = sigs[2], sigs[1]
+	errs := lmsPub.VerifyBatch(messages, sigs)
+	if errs[0] != nil || errs[1] == nil || errs[2] == nil {
+		t.Errorf("VerifyBatch() = %v", errs)
+	}
+}
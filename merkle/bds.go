@@ -0,0 +1,566 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package merkle
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+)
+
+// A treehash is one of the h-k treehash instances of the BDS algorithm. It
+// computes the next right authentication node on its level.
+type treehash struct {
+	height     int
+	nextIdx    int
+	stackUsage int
+	completed  bool
+	node       []byte
+}
+
+// A BDS holds the authentication path of the next leaf of a tree together with
+// the nodes the BDS traversal algorithm of [BDS08] keeps for computing the
+// following paths. The top k levels of the tree are retained in memory, and
+// (h-k)/2 leaves are computed per leaf by the treehash instances of the lower
+// levels.
+//
+// A state built by Init also caches the nodes on level ceil(h/2), so that Seek
+// can jump to any leaf with about h*2^(h/2) leaf computations.
+//
+// [BDS08]: https://eprint.iacr.org/2008/014.pdf
+type BDS struct {
+	height      int
+	k           int
+	root        []byte
+	auth        [][]byte
+	keep        [][]byte
+	retain      [][]byte
+	treehash    []*treehash
+	stack       [][]byte
+	stackLevels []int
+	nextLeaf    int
+	cache       [][]byte
+}
+
+// DefaultK returns the BDS parameter used when none is given: the smallest
+// k >= 2 such that h-k is even.
+func DefaultK(height int) int {
+	return 2 + height%2
+}
+
+// ValidK reports whether k is a valid BDS parameter for a tree of the given
+// height, that is 0 <= k <= height and height-k even.
+func ValidK(height int, k int) bool {
+	return k >= 0 && k <= height && (height-k)%2 == 0
+}
+
+// NewBDS returns the traversal state of an empty tree. Its leaves are added
+// with InitStep or Init.
+func NewBDS(height int, k int) (*BDS, error) {
+	if height < 1 || height > 30 || !ValidK(height, k) {
+		return nil, errors.New("merkle: invalid BDS parameters")
+	}
+	return newBDS(height, k), nil
+}
+
+func newBDS(height int, k int) *BDS {
+	s := new(BDS)
+	s.height = height
+	s.k = k
+	s.auth = make([][]byte, height)
+	s.keep = make([][]byte, height/2)
+	s.retain = make([][]byte, (1<<uint(k))-k-1)
+	s.treehash = make([]*treehash, height-k)
+	for i := range s.treehash {
+		s.treehash[i] = &treehash{height: i, completed: true}
+	}
+	return s
+}
+
+// JoinLevel returns the highest level from whose nodes NewBDSFromNodes builds
+// the state of a tree with BDS parameter k: the level of the nodes cached for
+// Seek or the lowest level retained by the BDS algorithm, whichever is lower.
+func JoinLevel(height int, k int) int {
+	if c := (height + 1) / 2; c < height-k {
+		return c
+	}
+	return height - k
+}
+
+// NewBDSFromNodes returns the state Init would build for a tree whose nodes on
+// the given level, at most JoinLevel(height, k), are known, for example because
+// subtrees were computed separately. Only the nodes below that level on the
+// authentication path of the first leaf are computed from leaves, about
+// 2^(level+1) of them.
+func NewBDSFromNodes(height int, k int, level int, nodes [][]byte, h Hasher) (*BDS, error) {
+	s, err := NewBDS(height, k)
+	if err != nil {
+		return nil, err
+	}
+	if level < 0 || level > JoinLevel(height, k) || len(nodes) != 1<<uint(height-level) {
+		return nil, errors.New("merkle: invalid nodes")
+	}
+	// levels[i] holds the nodes on level level+i.
+	levels := [][][]byte{nodes}
+	for l := level; l < height; l++ {
+		below := levels[len(levels)-1]
+		above := make([][]byte, len(below)/2)
+		for j := range above {
+			above[j] = h.Node(below[2*j], below[2*j+1], l+1, j)
+		}
+		levels = append(levels, above)
+	}
+	node := func(l int, idx int) []byte {
+		if l < level {
+			return SubtreeRoot(l, idx, h)
+		}
+		return levels[l-level][idx]
+	}
+
+	for i := range s.auth {
+		s.auth[i] = node(i, 1)
+	}
+	for i, th := range s.treehash {
+		if 3 < 1<<uint(height-i) {
+			th.node = node(i, 3)
+		}
+	}
+	for l := height - k; l <= height-2; l++ {
+		for idx := 3; idx < 1<<uint(height-l); idx += 2 {
+			s.retain[s.retainOffset(l)+(idx-3)>>1] = node(l, idx)
+		}
+	}
+	s.cache = append([][]byte(nil), levels[s.cacheHeight()-level]...)
+	s.root = levels[height-level][0]
+	s.nextLeaf = 1 << uint(height)
+	return s, nil
+}
+
+// Height returns the height of the tree.
+func (s *BDS) Height() int {
+	return s.height
+}
+
+// K returns the BDS parameter k.
+func (s *BDS) K() int {
+	return s.k
+}
+
+// budget returns the number of treehash updates done per leaf.
+func (s *BDS) budget() int {
+	return (s.height - s.k) >> 1
+}
+
+func (s *BDS) retainOffset(height int) int {
+	return (1 << uint(s.height-1-height)) + height - s.height
+}
+
+// cacheHeight returns the level of the nodes kept for Seek.
+func (s *BDS) cacheHeight() int {
+	return (s.height + 1) / 2
+}
+
+// Init adds the remaining leaves of the tree and returns its root.
+func (s *BDS) Init(h Hasher) []byte {
+	for !s.Initialized() {
+		s.InitStep(h)
+	}
+	return s.root
+}
+
+// InitContext adds the remaining leaves of the tree like Init, calling step if
+// it is not nil after each leaf. It checks ctx before every leaf and returns
+// the error of ctx once ctx is done, or the error of step if there is one,
+// leaving the tree under construction; Init or InitContext resumes it.
+func (s *BDS) InitContext(ctx context.Context, h Hasher, step func() error) ([]byte, error) {
+	for !s.Initialized() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		s.InitStep(h)
+		if step != nil {
+			if err := step(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return s.root, nil
+}
+
+// Initialized reports whether every leaf of the tree has been added.
+func (s *BDS) Initialized() bool {
+	return s.nextLeaf == 1<<uint(s.height)
+}
+
+// InitLeaves returns the number of leaves added so far.
+func (s *BDS) InitLeaves() int {
+	return s.nextLeaf
+}
+
+// InitStep adds the next leaf to the tree under construction and records the
+// nodes needed for the first authentication path. Adding the last leaf
+// completes the root.
+func (s *BDS) InitStep(h Hasher) {
+	if s.Initialized() {
+		return
+	}
+	idx := s.nextLeaf
+	if idx == 0 {
+		s.cache = make([][]byte, 1<<uint(s.height-s.cacheHeight()))
+	}
+	s.stack = append(s.stack, h.Leaf(idx))
+	s.stackLevels = append(s.stackLevels, 0)
+	if s.height-s.k > 0 && idx == 3 {
+		s.treehash[0].node = s.stack[len(s.stack)-1]
+	}
+	for len(s.stack) > 1 && s.stackLevels[len(s.stack)-1] == s.stackLevels[len(s.stack)-2] {
+		top := len(s.stack) - 1
+		nodeh := s.stackLevels[top]
+		if idx>>uint(nodeh) == 1 {
+			s.auth[nodeh] = s.stack[top]
+		} else if nodeh < s.height-s.k && idx>>uint(nodeh) == 3 {
+			s.treehash[nodeh].node = s.stack[top]
+		} else if nodeh >= s.height-s.k {
+			s.retain[s.retainOffset(nodeh)+((idx>>uint(nodeh))-3)>>1] = s.stack[top]
+		}
+		s.stack[top-1] = h.Node(s.stack[top-1], s.stack[top], nodeh+1, idx>>uint(nodeh+1))
+		s.stackLevels[top-1]++
+		s.stack = s.stack[:top]
+		s.stackLevels = s.stackLevels[:top]
+		// A construction resumed from Marshal has no cache.
+		if nodeh+1 == s.cacheHeight() && s.cache != nil {
+			s.cache[idx>>uint(nodeh+1)] = s.stack[top-1]
+		}
+	}
+	s.nextLeaf++
+	if s.Initialized() {
+		s.root = s.stack[0]
+		s.stack = s.stack[:0]
+		s.stackLevels = s.stackLevels[:0]
+	}
+}
+
+// Root returns the root computed by Init. It is nil for a state restored with
+// ParseBDS, which does not store the root.
+func (s *BDS) Root() []byte {
+	return s.root
+}
+
+// AuthPath returns the authentication path of the next leaf, starting with the
+// sibling of the leaf. The nodes must not be modified.
+func (s *BDS) AuthPath() [][]byte {
+	return append([][]byte(nil), s.auth...)
+}
+
+// Next advances the authentication path from that of leaf idx to that of leaf
+// idx+1. It does nothing for the last leaf.
+func (s *BDS) Next(idx int, h Hasher) {
+	if idx < 1<<uint(s.height)-1 {
+		s.round(idx, h)
+		s.update(s.budget(), h)
+	}
+}
+
+// Seek sets the state to that of leaf idx of an initialized tree, whatever the
+// current leaf. The authentication path, the nodes kept for the next ones and
+// the treehash nodes are computed from the cached nodes on level ceil(h/2), or
+// from the leaves below that level, so Seek computes about h*2^(h/2) leaves. A
+// state restored with ParseBDS has no cache, and its first Seek computes every
+// leaf of the tree once.
+func (s *BDS) Seek(idx int, h Hasher) error {
+	if !s.Initialized() {
+		return errors.New("merkle: the tree is not initialized")
+	}
+	if idx < 0 || idx >= 1<<uint(s.height) {
+		return errors.New("merkle: invalid leaf index")
+	}
+	if s.cache == nil {
+		c := s.cacheHeight()
+		cache := make([][]byte, 1<<uint(s.height-c))
+		for i := range cache {
+			cache[i] = SubtreeRoot(c, i, h)
+		}
+		s.cache = cache
+	}
+
+	for i := range s.auth {
+		s.auth[i] = s.node(i, (idx>>uint(i))^1, h)
+	}
+	// round reads the node kept on level i when it leaves the right half of the
+	// node on level i+1, which is the ancestor of idx if idx is in it.
+	for i := range s.keep {
+		s.keep[i] = nil
+	}
+	for i := 0; i < s.height-1; i++ {
+		if (idx>>uint(i))&1 == 1 && (idx>>uint(i+1))&1 == 0 {
+			s.keep[i>>1] = s.node(i, idx>>uint(i), h)
+		}
+	}
+	// The next right node on level i is the sibling of the first left node on
+	// that level after the ancestor of idx. The retained nodes of the top
+	// levels never change.
+	for i, th := range s.treehash {
+		th.completed = true
+		th.stackUsage = 0
+		th.node = nil
+		th.nextIdx = 0
+		if next := 2*(idx>>uint(i+1)) + 3; next < 1<<uint(s.height-i) {
+			th.node = s.node(i, next, h)
+			th.nextIdx = (next+1)<<uint(i) - 1
+		}
+	}
+	s.stack = s.stack[:0]
+	s.stackLevels = s.stackLevels[:0]
+	return nil
+}
+
+// Advance moves the state from that of leaf from to that of leaf to, with Next
+// when the leaves are close and with Seek otherwise.
+func (s *BDS) Advance(from int, to int, h Hasher) error {
+	if from < 0 || to < from || to >= 1<<uint(s.height) {
+		return errors.New("merkle: invalid leaf index")
+	}
+	seek := s.height << uint(s.cacheHeight())
+	if s.cache == nil {
+		seek += 1 << uint(s.height)
+	}
+	if (to-from)*(s.budget()+1) <= seek {
+		for ; from < to; from++ {
+			s.Next(from, h)
+		}
+		return nil
+	}
+	return s.Seek(to, h)
+}
+
+// node returns the node with index idx on level height, computed from the
+// cache above its level and from the leaves below.
+func (s *BDS) node(height int, idx int, h Hasher) []byte {
+	c := s.cacheHeight()
+	if height < c {
+		return SubtreeRoot(height, idx, h)
+	}
+	nodes := append([][]byte(nil), s.cache[idx<<uint(height-c):(idx+1)<<uint(height-c)]...)
+	for level := c; level < height; level++ {
+		first := idx << uint(height-level-1)
+		for j := 0; j < len(nodes)/2; j++ {
+			nodes[j] = h.Node(nodes[2*j], nodes[2*j+1], level+1, first+j)
+		}
+		nodes = nodes[:len(nodes)/2]
+	}
+	return nodes[0]
+}
+
+// round updates the authentication path from that of leaf leafIdx to that of
+// the following leaf.
+func (s *BDS) round(leafIdx int, h Hasher) {
+	tau := s.height
+	for i := 0; i < s.height; i++ {
+		if (leafIdx>>uint(i))&1 == 0 {
+			tau = i
+			break
+		}
+	}
+	var left, right []byte
+	if tau > 0 {
+		left = s.auth[tau-1]
+		right = s.keep[(tau-1)>>1]
+	}
+	if (leafIdx>>uint(tau+1))&1 == 0 && tau < s.height-1 {
+		s.keep[tau>>1] = s.auth[tau]
+	}
+	if tau == 0 {
+		s.auth[0] = h.Leaf(leafIdx)
+		return
+	}
+	s.auth[tau] = h.Node(left, right, tau, leafIdx>>uint(tau))
+	for i := 0; i < tau; i++ {
+		if i < s.height-s.k {
+			s.auth[i] = s.treehash[i].node
+		} else {
+			s.auth[i] = s.retain[s.retainOffset(i)+((leafIdx>>uint(i))-1)>>1]
+		}
+	}
+	for i := 0; i < tau && i < s.height-s.k; i++ {
+		startidx := leafIdx + 1 + 3*(1<<uint(i))
+		if startidx < 1<<uint(s.height) {
+			s.treehash[i].nextIdx = startidx
+			s.treehash[i].completed = false
+			s.treehash[i].stackUsage = 0
+		}
+	}
+}
+
+// update spends up to updates leaf computations on the treehash instances,
+// always advancing the one with the lowest node on the stack.
+func (s *BDS) update(updates int, h Hasher) {
+	for j := 0; j < updates; j++ {
+		lmin := s.height
+		level := s.height - s.k
+		for i := 0; i < s.height-s.k; i++ {
+			var low int
+			switch {
+			case s.treehash[i].completed:
+				low = s.height
+			case s.treehash[i].stackUsage == 0:
+				low = i
+			default:
+				low = s.minHeightOnStack(s.treehash[i])
+			}
+			if low < lmin {
+				level = i
+				lmin = low
+			}
+		}
+		if level == s.height-s.k {
+			return
+		}
+		s.treehashUpdate(s.treehash[level], h)
+	}
+}
+
+func (s *BDS) minHeightOnStack(th *treehash) int {
+	r := s.height
+	for i := 0; i < th.stackUsage; i++ {
+		if s.stackLevels[len(s.stack)-i-1] < r {
+			r = s.stackLevels[len(s.stack)-i-1]
+		}
+	}
+	return r
+}
+
+func (s *BDS) treehashUpdate(th *treehash, h Hasher) {
+	nd := h.Leaf(th.nextIdx)
+	nodeheight := 0
+	for th.stackUsage > 0 && s.stackLevels[len(s.stack)-1] == nodeheight {
+		top := len(s.stack) - 1
+		nd = h.Node(s.stack[top], nd, nodeheight+1, th.nextIdx>>uint(nodeheight+1))
+		nodeheight++
+		th.stackUsage--
+		s.stack = s.stack[:top]
+		s.stackLevels = s.stackLevels[:top]
+	}
+	if nodeheight == th.height {
+		th.node = nd
+		th.completed = true
+	} else {
+		s.stack = append(s.stack, nd)
+		s.stackLevels = append(s.stackLevels, nodeheight)
+		th.stackUsage++
+		th.nextIdx++
+	}
+}
+
+// Marshal encodes the traversal state, including a tree under construction,
+// for nodes of n bytes. Nodes that have not been computed yet are encoded as
+// n zero bytes. The root is not included.
+func (s *BDS) Marshal(n int) []byte {
+	fixed := func(x []byte) []byte {
+		if x == nil {
+			return make([]byte, n)
+		}
+		return x
+	}
+	b := binary.BigEndian.AppendUint32(nil, uint32(s.k))
+	b = binary.BigEndian.AppendUint32(b, uint32(s.nextLeaf))
+	for _, x := range s.auth {
+		b = append(b, fixed(x)...)
+	}
+	for _, x := range s.keep {
+		b = append(b, fixed(x)...)
+	}
+	for _, x := range s.retain {
+		b = append(b, fixed(x)...)
+	}
+	for _, th := range s.treehash {
+		b = binary.BigEndian.AppendUint32(b, uint32(th.nextIdx))
+		b = binary.BigEndian.AppendUint32(b, uint32(th.stackUsage))
+		if th.completed {
+			b = append(b, 1)
+		} else {
+			b = append(b, 0)
+		}
+		b = append(b, fixed(th.node)...)
+	}
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s.stack)))
+	for i, x := range s.stack {
+		b = binary.BigEndian.AppendUint32(b, uint32(s.stackLevels[i]))
+		b = append(b, x...)
+	}
+	return b
+}
+
+// ParseBDS decodes a traversal state encoded by Marshal for a tree of the given
+// height and returns it with the number of bytes read.
+func ParseBDS(b []byte, n int, height int) (*BDS, int, error) {
+	invalid := errors.New("merkle: invalid BDS state")
+	if len(b) < 8 || height < 1 || height > 30 {
+		return nil, 0, invalid
+	}
+	u32 := func(x []byte) int {
+		return int(binary.BigEndian.Uint32(x))
+	}
+	k := u32(b[:4])
+	if !ValidK(height, k) {
+		return nil, 0, invalid
+	}
+	s := newBDS(height, k)
+	s.nextLeaf = u32(b[4:8])
+	read := 8
+	node := func() []byte {
+		x := make([]byte, n)
+		copy(x, b[read:read+n])
+		read += n
+		return x
+	}
+	if len(b) < read+(len(s.auth)+len(s.keep)+len(s.retain))*n+len(s.treehash)*(9+n)+4 {
+		return nil, 0, invalid
+	}
+	for i := range s.auth {
+		s.auth[i] = node()
+	}
+	for i := range s.keep {
+		s.keep[i] = node()
+	}
+	for i := range s.retain {
+		s.retain[i] = node()
+	}
+	for _, th := range s.treehash {
+		th.nextIdx = u32(b[read : read+4])
+		th.stackUsage = u32(b[read+4 : read+8])
+		if b[read+8] > 1 {
+			return nil, 0, invalid
+		}
+		th.completed = b[read+8] == 1
+		read += 9
+		th.node = node()
+	}
+	stackLen := u32(b[read : read+4])
+	read += 4
+	if stackLen < 0 || stackLen > height+1 || len(b) < read+stackLen*(4+n) {
+		return nil, 0, invalid
+	}
+	s.stack = make([][]byte, stackLen)
+	s.stackLevels = make([]int, stackLen)
+	for i := 0; i < stackLen; i++ {
+		s.stackLevels[i] = u32(b[read : read+4])
+		read += 4
+		s.stack[i] = node()
+		if s.stackLevels[i] < 0 || s.stackLevels[i] >= height {
+			return nil, 0, invalid
+		}
+	}
+	usage := 0
+	for _, th := range s.treehash {
+		if th.nextIdx < 0 || th.nextIdx >= 1<<uint(height) || th.stackUsage < 0 || th.stackUsage > height {
+			return nil, 0, invalid
+		}
+		usage += th.stackUsage
+	}
+	if usage > stackLen || s.nextLeaf < 0 || s.nextLeaf > 1<<uint(height) {
+		return nil, 0, invalid
+	}
+	return s, read, nil
+}
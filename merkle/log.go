@@ -0,0 +1,128 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package merkle
+
+import "errors"
+
+// A LogHasher computes the nodes of a log tree. RFC 9162 defines them as
+//
+//	HashLeaf(d) = H(0x00 || d)
+//	HashChildren(l, r) = H(0x01 || l || r)
+//
+// for a hash function H.
+type LogHasher interface {
+	HashLeaf(data []byte) []byte
+	HashChildren(left []byte, right []byte) []byte
+}
+
+// A LogTree is a Merkle tree over any number of leaves, built as in RFC 9162:
+// the leaves are paired level by level and a node without a sibling is
+// promoted to the next level unchanged. Every node is kept in memory.
+type LogTree struct {
+	h LogHasher
+	// The levels of the tree, leaves first.
+	levels [][][]byte
+}
+
+// NewLogTree returns a log tree over the given leaves.
+func NewLogTree(h LogHasher, leaves ...[]byte) *LogTree {
+	t := &LogTree{h: h, levels: [][][]byte{nil}}
+	for _, data := range leaves {
+		t.Append(data)
+	}
+	return t
+}
+
+// Append adds a leaf to the tree and returns its index.
+func (t *LogTree) Append(data []byte) int {
+	t.levels[0] = append(t.levels[0], t.h.HashLeaf(data))
+	for i := 0; len(t.levels[i]) > 1; i++ {
+		if i+1 == len(t.levels) {
+			t.levels = append(t.levels, nil)
+		}
+		level := t.levels[i]
+		j := (len(level) - 1) / 2
+		var node []byte
+		if 2*j+1 < len(level) {
+			node = t.h.HashChildren(level[2*j], level[2*j+1])
+		} else {
+			node = level[2*j]
+		}
+		if j < len(t.levels[i+1]) {
+			t.levels[i+1][j] = node
+		} else {
+			t.levels[i+1] = append(t.levels[i+1], node)
+		}
+	}
+	return t.Size() - 1
+}
+
+// Size returns the number of leaves.
+func (t *LogTree) Size() int {
+	return len(t.levels[0])
+}
+
+// Root returns the root of the tree, or nil if it has no leaves.
+func (t *LogTree) Root() []byte {
+	top := t.levels[len(t.levels)-1]
+	if len(top) == 0 {
+		return nil
+	}
+	return top[0]
+}
+
+// InclusionProof returns the inclusion proof of leaf index, starting with the
+// sibling of the leaf. Promoted nodes have no sibling on their level, so the
+// proof has InclusionProofLen(index, Size()) nodes.
+func (t *LogTree) InclusionProof(index int) ([][]byte, error) {
+	if index < 0 || index >= t.Size() {
+		return nil, errors.New("merkle: leaf index out of range")
+	}
+	var proof [][]byte
+	for _, level := range t.levels[:len(t.levels)-1] {
+		if index^1 < len(level) {
+			proof = append(proof, level[index^1])
+		}
+		index /= 2
+	}
+	return proof, nil
+}
+
+// InclusionProofLen returns the number of nodes in the inclusion proof of leaf
+// index in a tree of size leaves.
+func InclusionProofLen(index int, size int) int {
+	l := 0
+	for ; size > 1; size = (size + 1) / 2 {
+		if index%2 == 1 || index+1 < size {
+			l++
+		}
+		index /= 2
+	}
+	return l
+}
+
+// RootFromInclusionProof recomputes the root of a tree of size leaves from leaf
+// index and its inclusion proof. The proof is valid if the result equals the
+// root of the tree.
+func RootFromInclusionProof(h LogHasher, data []byte, index int, size int, proof [][]byte) ([]byte, error) {
+	if size <= 0 || index < 0 || index >= size {
+		return nil, errors.New("merkle: leaf index out of range")
+	}
+	if len(proof) != InclusionProofLen(index, size) {
+		return nil, errors.New("merkle: invalid inclusion proof length")
+	}
+	node := h.HashLeaf(data)
+	for ; size > 1; size = (size + 1) / 2 {
+		if index%2 == 1 {
+			node = h.HashChildren(proof[0], node)
+			proof = proof[1:]
+		} else if index+1 < size {
+			node = h.HashChildren(node, proof[0])
+			proof = proof[1:]
+		}
+		index /= 2
+	}
+	return node, nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package merkle implements binary Merkle trees with pluggable hash functions.
+//
+// Trees of a fixed height h, as used by hash-based signatures, hash every node
+// together with its position. They are described by a Hasher, and this package
+// computes their roots and authentication paths, traverses the authentication
+// paths of consecutive leaves with the BDS algorithm and recomputes roots from
+// authentication paths.
+//
+// Log trees over any number of leaves are built as in RFC 9162 and support
+// appending leaves, inclusion proofs and their verification.
+package merkle
+
+// A Hasher computes the leaves and interior nodes of a Merkle tree of fixed
+// height. Leaves are on level 0 and the root is node 0 on level h.
+type Hasher interface {
+	// Leaf returns the value of the leaf with index idx.
+	Leaf(idx int) []byte
+	// Node returns the value of the node with index idx on level height from
+	// the values of its two children.
+	Node(left []byte, right []byte, height int, idx int) []byte
+}
+
+// A NodeFunc computes the node with index idx on level height from the values
+// of its two children, like Hasher.Node.
+type NodeFunc func(left []byte, right []byte, height int, idx int) []byte
+
+// SubtreeRoot returns the value of the node with index idx on level height,
+// computed from the 2^height leaves below it with the treehash algorithm.
+func SubtreeRoot(height int, idx int, h Hasher) []byte {
+	stack := make([][]byte, 0, height+1)
+	levels := make([]int, 0, height+1)
+	first := idx << uint(height)
+	for i := first; i < first+1<<uint(height); i++ {
+		node := h.Leaf(i)
+		level := 0
+		for len(stack) > 0 && levels[len(stack)-1] == level {
+			node = h.Node(stack[len(stack)-1], node, level+1, i>>uint(level+1))
+			level++
+			stack = stack[:len(stack)-1]
+			levels = levels[:len(levels)-1]
+		}
+		stack = append(stack, node)
+		levels = append(levels, level)
+	}
+	return stack[0]
+}
+
+// Root returns the root of the tree of the given height.
+func Root(height int, h Hasher) []byte {
+	return SubtreeRoot(height, 0, h)
+}
+
+// AuthPath returns the authentication path of leaf idx, starting with the
+// sibling of the leaf. It computes every leaf of the tree once.
+func AuthPath(height int, idx int, h Hasher) [][]byte {
+	path := make([][]byte, height)
+	for i := range path {
+		path[i] = SubtreeRoot(i, (idx>>uint(i))^1, h)
+	}
+	return path
+}
+
+// RootFromAuthPath recomputes the root of a tree from the value of leaf idx and
+// its authentication path.
+func RootFromAuthPath(leaf []byte, idx int, path [][]byte, node NodeFunc) []byte {
+	for i, sibling := range path {
+		if (idx>>uint(i))&1 == 0 {
+			leaf = node(leaf, sibling, i+1, idx>>uint(i+1))
+		} else {
+			leaf = node(sibling, leaf, i+1, idx>>uint(i+1))
+		}
+	}
+	return leaf
+}
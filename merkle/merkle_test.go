@@ -0,0 +1,309 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package merkle
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// testHasher hashes every node with its position.
+type testHasher struct {
+	leaves int
+}
+
+func (t *testHasher) Leaf(idx int) []byte {
+	t.leaves++
+	d := sha256.Sum256(binary.BigEndian.AppendUint32([]byte("leaf"), uint32(idx)))
+	return d[:]
+}
+
+func (t *testHasher) Node(left []byte, right []byte, height int, idx int) []byte {
+	return node(left, right, height, idx)
+}
+
+func node(left []byte, right []byte, height int, idx int) []byte {
+	b := binary.BigEndian.AppendUint32([]byte("node"), uint32(height))
+	b = binary.BigEndian.AppendUint32(b, uint32(idx))
+	d := sha256.Sum256(bytes.Join([][]byte{b, left, right}, nil))
+	return d[:]
+}
+
+// naiveNode computes a node recursively.
+func naiveNode(t Hasher, height int, idx int) []byte {
+	if height == 0 {
+		return t.Leaf(idx)
+	}
+	return t.Node(naiveNode(t, height-1, 2*idx), naiveNode(t, height-1, 2*idx+1), height, idx)
+}
+
+func TestAuthPath(t *testing.T) {
+	h := new(testHasher)
+	for height := 1; height <= 5; height++ {
+		root := Root(height, h)
+		if !bytes.Equal(root, naiveNode(h, height, 0)) {
+			t.Fatalf("wrong root when height = %d", height)
+		}
+		for idx := 0; idx < 1<<uint(height); idx++ {
+			path := AuthPath(height, idx, h)
+			if !bytes.Equal(RootFromAuthPath(h.Leaf(idx), idx, path, node), root) {
+				t.Errorf("wrong authentication path when height = %d, idx = %d", height, idx)
+			}
+			if !bytes.Equal(SubtreeRoot(1, idx>>1, h), naiveNode(h, 1, idx>>1)) {
+				t.Errorf("wrong subtree root when height = %d, idx = %d", height, idx)
+			}
+		}
+	}
+}
+
+func TestBDS(t *testing.T) {
+	h := new(testHasher)
+	for height := 1; height <= 8; height++ {
+		for k := 0; k <= height; k++ {
+			if !ValidK(height, k) {
+				if _, err := NewBDS(height, k); err == nil {
+					t.Errorf("NewBDS accepted an invalid k when height = %d, k = %d", height, k)
+				}
+				continue
+			}
+			s, err := NewBDS(height, k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(s.Init(h), Root(height, h)) {
+				t.Fatalf("wrong root when height = %d, k = %d", height, k)
+			}
+			for idx := 0; idx < 1<<uint(height); idx++ {
+				auth := s.AuthPath()
+				for i, x := range AuthPath(height, idx, h) {
+					if !bytes.Equal(auth[i], x) {
+						t.Fatalf("wrong authentication node when height = %d, k = %d, idx = %d, i = %d", height, k, idx, i)
+					}
+				}
+				h.leaves = 0
+				s.Next(idx, h)
+				if h.leaves > (height-k)/2+1 {
+					t.Errorf("%d leaves computed when height = %d, k = %d, idx = %d", h.leaves, height, k, idx)
+				}
+			}
+		}
+	}
+}
+
+func TestBDSMarshal(t *testing.T) {
+	h := new(testHasher)
+	const height, n = 6, 32
+	s, _ := NewBDS(height, 2)
+	for i := 0; i < 20; i++ {
+		s.InitStep(h)
+	}
+	// Resume the construction of the tree from its encoding.
+	b := s.Marshal(n)
+	s, read, err := ParseBDS(append(b, 0xff), n, height)
+	if err != nil || read != len(b) {
+		t.Fatalf("failed to parse the BDS state: %v", err)
+	}
+	if s.InitLeaves() != 20 || !bytes.Equal(s.Init(h), Root(height, h)) {
+		t.Fatal("wrong root after resuming the construction")
+	}
+	for idx := 0; idx < 1<<height; idx++ {
+		b := s.Marshal(n)
+		s, _, err = ParseBDS(b, n, height)
+		if err != nil {
+			t.Fatalf("failed to parse the BDS state when idx = %d: %v", idx, err)
+		}
+		if !bytes.Equal(s.Marshal(n), b) {
+			t.Fatalf("wrong encoding when idx = %d", idx)
+		}
+		if !bytes.Equal(RootFromAuthPath(h.Leaf(idx), idx, s.AuthPath(), node), Root(height, h)) {
+			t.Fatalf("wrong authentication path when idx = %d", idx)
+		}
+		s.Next(idx, h)
+	}
+	if _, _, err := ParseBDS(b[:len(b)-1], n, height); err == nil {
+		t.Error("ParseBDS accepted a truncated state")
+	}
+	// The completed flag of the first treehash instance follows the
+	// authentication path, the kept and retained nodes, nextIdx and stackUsage.
+	flag := 8 + (height+height/2+1)*n + 8
+	b[flag] = 0x7f
+	if _, _, err := ParseBDS(b, n, height); err == nil {
+		t.Error("ParseBDS accepted a completed flag other than 0 and 1")
+	}
+}
+
+func TestBDSInitContext(t *testing.T) {
+	h := new(testHasher)
+	const height = 6
+	s, _ := NewBDS(height, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	steps := 0
+	root, err := s.InitContext(ctx, h, func() error {
+		if steps++; steps == 20 {
+			cancel()
+		}
+		return nil
+	})
+	if err != context.Canceled || root != nil || s.InitLeaves() != 20 {
+		t.Fatalf("InitContext returned %v after %d leaves, want context.Canceled after 20", err, s.InitLeaves())
+	}
+	stop := errors.New("stop")
+	if _, err := s.InitContext(context.Background(), h, func() error { return stop }); err != stop || s.InitLeaves() != 21 {
+		t.Fatalf("InitContext returned %v after %d leaves, want the error of step after 21", err, s.InitLeaves())
+	}
+	root, err = s.InitContext(context.Background(), h, nil)
+	if err != nil || !bytes.Equal(root, Root(height, h)) {
+		t.Fatal("wrong root after resuming the construction")
+	}
+}
+
+func TestNewBDSFromNodes(t *testing.T) {
+	h := new(testHasher)
+	for height := 1; height <= 8; height++ {
+		for k := height % 2; k <= height; k += 2 {
+			want, _ := NewBDS(height, k)
+			want.Init(h)
+			for level := 0; level <= JoinLevel(height, k); level++ {
+				nodes := make([][]byte, 1<<uint(height-level))
+				for i := range nodes {
+					nodes[i] = SubtreeRoot(level, i, h)
+				}
+				s, err := NewBDSFromNodes(height, k, level, nodes, h)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(s.Marshal(32), want.Marshal(32)) || !bytes.Equal(s.Root(), want.Root()) ||
+					!bytes.Equal(bytes.Join(s.cache, nil), bytes.Join(want.cache, nil)) {
+					t.Errorf("state built from level %d differs from Init when h = %d, k = %d", level, height, k)
+				}
+			}
+			if _, err := NewBDSFromNodes(height, k, JoinLevel(height, k)+1, nil, h); err == nil {
+				t.Errorf("NewBDSFromNodes accepted nodes above the join level when h = %d, k = %d", height, k)
+			}
+		}
+	}
+}
+
+func TestBDSSeek(t *testing.T) {
+	h := new(testHasher)
+	for height := 1; height <= 8; height++ {
+		for k := height % 2; k <= height; k += 2 {
+			s, _ := NewBDS(height, k)
+			root := s.Init(h)
+			parsed, _, _ := ParseBDS(s.Marshal(32), 32, height)
+			for _, s := range []*BDS{s, parsed} {
+				for seek := 0; seek < 1<<uint(height); seek++ {
+					h.leaves = 0
+					if err := s.Seek(seek, h); err != nil {
+						t.Fatal(err)
+					}
+					if max := 1<<uint(height) + height<<uint((height+1)/2); h.leaves > max {
+						t.Errorf("%d leaves computed when height = %d, k = %d, seek = %d", h.leaves, height, k, seek)
+					}
+					// The traversal goes on from the new leaf.
+					for idx := seek; idx < 1<<uint(height); idx++ {
+						if !bytes.Equal(RootFromAuthPath(h.Leaf(idx), idx, s.AuthPath(), node), root) {
+							t.Fatalf("wrong authentication path when height = %d, k = %d, seek = %d, idx = %d", height, k, seek, idx)
+						}
+						s.Next(idx, h)
+					}
+				}
+			}
+		}
+	}
+
+	// Seek computes about h*2^(h/2) leaves once the tree is built.
+	const height = 16
+	s, _ := NewBDS(height, 2)
+	root := s.Init(h)
+	h.leaves = 0
+	s.Seek(40000, h)
+	if h.leaves > height<<(height/2) {
+		t.Errorf("Seek computed %d leaves", h.leaves)
+	}
+	if err := s.Advance(40000, 39999, h); err == nil {
+		t.Error("Advance moved backward")
+	}
+	if err := s.Advance(40000, 1<<height, h); err == nil {
+		t.Error("Advance moved past the last leaf")
+	}
+	from := 40000
+	for _, to := range []int{40001, 40010, 60000} {
+		if err := s.Advance(from, to, h); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(RootFromAuthPath(h.Leaf(to), to, s.AuthPath(), node), root) {
+			t.Fatalf("wrong authentication path after advancing to %d", to)
+		}
+		from = to
+	}
+}
+
+type logHasher struct{}
+
+func (logHasher) HashLeaf(data []byte) []byte {
+	d := sha256.Sum256(append([]byte{0x00}, data...))
+	return d[:]
+}
+
+func (logHasher) HashChildren(left []byte, right []byte) []byte {
+	d := sha256.Sum256(bytes.Join([][]byte{{0x01}, left, right}, nil))
+	return d[:]
+}
+
+// mth computes the Merkle tree hash of RFC 9162, section 2.1.1.
+func mth(leaves [][]byte) []byte {
+	if len(leaves) == 1 {
+		return logHasher{}.HashLeaf(leaves[0])
+	}
+	k := 1
+	for 2*k < len(leaves) {
+		k *= 2
+	}
+	return logHasher{}.HashChildren(mth(leaves[:k]), mth(leaves[k:]))
+}
+
+func TestLogTree(t *testing.T) {
+	tree := NewLogTree(logHasher{})
+	if tree.Root() != nil {
+		t.Error("empty tree has a root")
+	}
+	var leaves [][]byte
+	for size := 1; size <= 40; size++ {
+		leaves = append(leaves, []byte(fmt.Sprintf("entry %d", size-1)))
+		if tree.Append(leaves[size-1]) != size-1 || tree.Size() != size {
+			t.Fatalf("wrong index when size = %d", size)
+		}
+		root := mth(leaves)
+		if !bytes.Equal(tree.Root(), root) || !bytes.Equal(NewLogTree(logHasher{}, leaves...).Root(), root) {
+			t.Fatalf("root differs from RFC 9162 when size = %d", size)
+		}
+		for i := range leaves {
+			proof, err := tree.InclusionProof(i)
+			if err != nil || len(proof) != InclusionProofLen(i, size) {
+				t.Fatalf("wrong inclusion proof when size = %d, i = %d", size, i)
+			}
+			r, err := RootFromInclusionProof(logHasher{}, leaves[i], i, size, proof)
+			if err != nil || !bytes.Equal(r, root) {
+				t.Errorf("invalid inclusion proof when size = %d, i = %d", size, i)
+			}
+			r, _ = RootFromInclusionProof(logHasher{}, []byte("other"), i, size, proof)
+			if bytes.Equal(r, root) {
+				t.Errorf("inclusion proof verified a wrong leaf when size = %d, i = %d", size, i)
+			}
+		}
+	}
+	if _, err := tree.InclusionProof(tree.Size()); err == nil {
+		t.Error("InclusionProof accepted an index out of range")
+	}
+	if _, err := RootFromInclusionProof(logHasher{}, nil, 0, 3, nil); err == nil {
+		t.Error("RootFromInclusionProof accepted a short proof")
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xmss
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The ACVP harness runs the XMSS and XMSS^MT sigVer vector sets in
+// testdata/acvp through the package API. The files use the layout of the LMS
+// vector sets of the ACVP server, with the parameter set named by xmssMode
+// ("XMSS-SHA2_10_256", "XMSSMT-SHA2_20/4_256", ...) and public keys and
+// signatures encoded as in RFC 8391. Every test case runs as a subtest named
+// after its tgId and tcId.
+
+type acvphex []byte
+
+func (b *acvphex) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	x, err := hex.DecodeString(s)
+	*b = x
+	return err
+}
+
+type acvpvectorset struct {
+	Algorithm  string `json:"algorithm"`
+	Mode       string `json:"mode"`
+	TestGroups []*struct {
+		TgID      int     `json:"tgId"`
+		XmssMode  string  `json:"xmssMode"`
+		PublicKey acvphex `json:"publicKey"`
+		Tests     []*struct {
+			TcID       int     `json:"tcId"`
+			Message    acvphex `json:"message"`
+			Signature  acvphex `json:"signature"`
+			TestPassed *bool   `json:"testPassed"`
+			Reason     string  `json:"reason"`
+		} `json:"tests"`
+	} `json:"testGroups"`
+}
+
+func readacvp(path string) (*acvpvectorset, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var wrapped []json.RawMessage
+	if json.Unmarshal(data, &wrapped) == nil {
+		if len(wrapped) != 2 {
+			return nil, fmt.Errorf("%s: unexpected ACVP array of length %d", path, len(wrapped))
+		}
+		data = wrapped[1]
+	}
+	vs := new(acvpvectorset)
+	if err := json.Unmarshal(data, vs); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return vs, nil
+}
+
+// acvpverifier returns the verification function of the public key of the
+// named parameter set.
+func acvpverifier(mode string, pk []byte) (func(m, sig []byte) bool, bool) {
+	for oid, name := range xmssnames {
+		if name == mode {
+			xpk, err := ParsePK(hex.EncodeToString(pk))
+			if err != nil || xpk.oid != oid {
+				return func(m, sig []byte) bool { return false }, true
+			}
+			return xpk.Verify, true
+		}
+	}
+	for oid, name := range xmssmtnames {
+		if name == mode {
+			mtpk, err := ParseMTPK(hex.EncodeToString(pk))
+			if err != nil || mtpk.oid != oid {
+				return func(m, sig []byte) bool { return false }, true
+			}
+			return mtpk.Verify, true
+		}
+	}
+	return nil, false
+}
+
+func TestACVP(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "acvp", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		vs, err := readacvp(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if vs.Algorithm != "XMSS" && vs.Algorithm != "XMSS^MT" {
+			continue
+		}
+		t.Run(strings.TrimSuffix(filepath.Base(path), ".json"), func(t *testing.T) {
+			for _, tg := range vs.TestGroups {
+				for _, tc := range tg.Tests {
+					tg, tc := tg, tc
+					t.Run(fmt.Sprintf("tgId=%d/tcId=%d", tg.TgID, tc.TcID), func(t *testing.T) {
+						if vs.Mode != "sigVer" {
+							t.Skipf("unsupported mode %s", vs.Mode)
+						}
+						verify, ok := acvpverifier(tg.XmssMode, tg.PublicKey)
+						if !ok {
+							t.Skipf("unsupported parameter set %s", tg.XmssMode)
+						}
+						if tc.TestPassed == nil {
+							t.Fatal("missing testPassed")
+						}
+						if verify(tc.Message, tc.Signature) != *tc.TestPassed {
+							t.Errorf("verification = %v, want testPassed = %v (%s)", !*tc.TestPassed, *tc.TestPassed, tc.Reason)
+						}
+					})
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xmss
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAdvanceTo(t *testing.T) {
+	xsk, xpk, _ := KeyGen(XMSSSHA2H10W256)
+	key := privatehex(xsk)
+
+	// The signatures are deterministic, so a key moved forward signs exactly as
+	// one that signed its way there.
+	for _, idx := range []int{0, 1, 2, 70, 100} {
+		advanced, _ := ParseSK(key)
+		if err := advanced.AdvanceTo(uint64(idx)); err != nil {
+			t.Fatal(err)
+		}
+		for xsk.mt.idx < idx {
+			xsk.Sign([]byte("skipped"))
+		}
+		sig, err := advanced.Sign([]byte("message"))
+		if err != nil || !xpk.Verify([]byte("message"), sig) {
+			t.Fatalf("invalid signature after AdvanceTo(%d): %v", idx, err)
+		}
+		want, _ := xsk.Sign([]byte("message"))
+		if !bytes.Equal(sig, want) {
+			t.Errorf("AdvanceTo(%d) signed differently from a key that signed %d messages", idx, idx)
+		}
+	}
+
+	if err := xsk.AdvanceTo(1023); err != nil {
+		t.Fatal(err)
+	}
+	sig, err := xsk.Sign([]byte("message"))
+	if err != nil || !xpk.Verify([]byte("message"), sig) {
+		t.Fatalf("invalid signature with the last leaf: %v", err)
+	}
+	if err := xsk.AdvanceTo(10); err == nil {
+		t.Error("AdvanceTo moved the key backward")
+	}
+	if err := xsk.AdvanceTo(1025); err == nil {
+		t.Error("AdvanceTo moved past the last leaf")
+	}
+	if err := xsk.AdvanceTo(1024); err != nil || xsk.Remaining() != 0 {
+		t.Errorf("AdvanceTo(1024) did not exhaust the key: %v", err)
+	}
+	if _, err := xsk.Sign([]byte("message")); err == nil {
+		t.Error("signed with an exhausted key")
+	}
+}
+
+func TestMTAdvanceTo(t *testing.T) {
+	mtsk, mtpk, _ := MTkeyGen(XMSSMTSHA2H20D4W256)
+	key := privatehex(mtsk)
+
+	for _, idx := range []uint64{5, 31, 32, 33, 60} {
+		advanced, _ := ParseMTSK(key)
+		if err := advanced.AdvanceTo(idx); err != nil {
+			t.Fatal(err)
+		}
+		for mtsk.idx < idx {
+			mtsk.Sign([]byte("skipped"))
+		}
+		sig, err := advanced.Sign([]byte("message"))
+		if err != nil || !mtpk.Verify([]byte("message"), sig) {
+			t.Fatalf("invalid signature after AdvanceTo(%d): %v", idx, err)
+		}
+		want, _ := mtsk.Sign([]byte("message"))
+		if !bytes.Equal(sig, want) {
+			t.Errorf("AdvanceTo(%d) signed differently from a key that signed %d messages", idx, idx)
+		}
+	}
+
+	// Moving to another tree on an upper layer replaces the trees below it.
+	for _, idx := range []uint64{1000, 1024, 40000, 1<<20 - 1} {
+		if err := mtsk.AdvanceTo(idx); err != nil {
+			t.Fatal(err)
+		}
+		sig, err := mtsk.Sign([]byte("message"))
+		if err != nil || !mtpk.Verify([]byte("message"), sig) {
+			t.Fatalf("invalid signature after AdvanceTo(%d): %v", idx, err)
+		}
+		if s, _ := ParseMTSignature(XMSSMTSHA2H20D4W256, sig); s.Idx() != idx {
+			t.Errorf("AdvanceTo(%d) signed with index %d", idx, s.Idx())
+		}
+	}
+	if err := mtsk.AdvanceTo(100); err == nil {
+		t.Error("AdvanceTo moved the key backward")
+	}
+	if err := mtsk.AdvanceTo(1<<20 + 1); err == nil {
+		t.Error("AdvanceTo moved past the last signature")
+	}
+	if mtsk.Remaining() != 0 {
+		t.Errorf("Remaining() = %d after the last signature", mtsk.Remaining())
+	}
+	if _, err := mtsk.Sign([]byte("message")); err == nil {
+		t.Error("signed with an exhausted key")
+	}
+}
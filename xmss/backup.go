@@ -0,0 +1,129 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xmss
+
+import (
+	"bytes"
+	"errors"
+)
+
+// A backup holds the parameter set, the index and the three seeds of a private
+// key; every tree is recomputed from them by RestoreSK and RestoreMTSK:
+//
+//	XMSS:    oid (4 bytes) || idx (4 bytes) || SK_SEED || SK_PRF || PUB_SEED
+//	XMSS^MT: oid (4 bytes) || idx (8 bytes) || SK_SEED || SK_PRF || PUB_SEED
+//
+// The index is a high-water mark: Backup(reserve) records the index the key
+// will have after reserve more signatures, so that one backup can be written
+// ahead of a batch of signatures and a key restored from it never reuses one
+// of their leaves.
+
+// highWater returns idx+reserve, capped at the number of leaves.
+func highWater(idx uint64, reserve uint64, leaves uint64) uint64 {
+	if idx >= leaves || reserve >= leaves-idx {
+		return leaves
+	}
+	return idx + reserve
+}
+
+// Backup returns a seed-only backup of the private key whose index is the
+// current one plus reserve. It contains the secret seeds and must be protected
+// like the key itself; RestoreSK rebuilds the key from it.
+func (xsk *SK) Backup(reserve uint64) ([]byte, error) {
+	xmssty, err := xmssparams(xsk.oid)
+	if err != nil || xsk.destroyed() {
+		return nil, errors.New("xmss: invalid XMSS private key")
+	}
+	idx := highWater(uint64(xsk.mt.idx), reserve, uint64(pow2(xmssty.h)))
+	return bytes.Join([][]byte{toByte(uint64(xsk.oid), 4), toByte(idx, 4),
+		xsk.mt.skseed, xsk.skprf, xsk.mt.seed}, []byte("")), nil
+}
+
+// RestoreSK rebuilds an XMSS private key from a backup written by SK.Backup.
+// The key is ready to sign with the leaf at the index of the backup.
+func RestoreSK(backup []byte) (*SK, error) {
+	if len(backup) < 4+4 {
+		return nil, errors.New("xmss: invalid XMSS backup")
+	}
+	oid := strToUint(backup[:4])
+	xmssty, err := xmssparams(oid)
+	if err != nil {
+		return nil, errors.New("xmss: invalid XMSS backup")
+	}
+	n := xmssty.n
+	if len(backup) != 4+4+3*n {
+		return nil, errors.New("xmss: invalid XMSS backup")
+	}
+	idx := strToInt(backup[4:8])
+	if idx >= pow2(xmssty.h) {
+		return nil, errors.New("xmss: no signatures left in the XMSS backup")
+	}
+	skseed := backup[8 : 8+n]
+	skprf := backup[8+n : 8+2*n]
+	seed := backup[8+2*n:]
+
+	xsk, _, err := xmsskeyGen(oid, defaultK(xmssty.h), skseed, seed, skprf, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := xsk.AdvanceTo(uint64(idx)); err != nil {
+		return nil, err
+	}
+	return xsk, nil
+}
+
+// Backup returns a seed-only backup of the private key whose index is the
+// current one plus reserve. It contains the secret seeds and must be protected
+// like the key itself; RestoreMTSK rebuilds the key from it.
+func (mtsk *MTSK) Backup(reserve uint64) ([]byte, error) {
+	mtty, xmssty, err := xmssmtparams(mtsk.oid)
+	if err != nil || mtsk.destroyed() {
+		return nil, errors.New("xmss-mt: invalid XMSS^MT private key")
+	}
+	idx := highWater(mtsk.idx, reserve, 1<<uint(mtty.d*xmssty.h))
+	return bytes.Join([][]byte{toByte(uint64(mtsk.oid), 4), toByte(idx, 8),
+		mtsk.skseed, mtsk.skprf, mtsk.seed}, []byte("")), nil
+}
+
+// RestoreMTSK rebuilds an XMSS^MT private key from a backup written by
+// MTSK.Backup. The key is ready to sign with the leaf at the index of the
+// backup, and the trees of every layer are those the original key used there.
+func RestoreMTSK(backup []byte) (*MTSK, error) {
+	if len(backup) < 4+8 {
+		return nil, errors.New("xmss-mt: invalid XMSS^MT backup")
+	}
+	oid := strToUint(backup[:4])
+	mtty, xmssty, err := xmssmtparams(oid)
+	if err != nil {
+		return nil, errors.New("xmss-mt: invalid XMSS^MT backup")
+	}
+	n := xmssty.n
+	d := mtty.d
+	xh := uint(xmssty.h)
+	if len(backup) != 4+8+3*n {
+		return nil, errors.New("xmss-mt: invalid XMSS^MT backup")
+	}
+	idx := strToUint64(backup[4:12])
+	if idx >= 1<<(uint(d)*xh) {
+		return nil, errors.New("xmss-mt: no signatures left in the XMSS^MT backup")
+	}
+
+	mtsk := new(MTSK)
+	mtsk.oid = oid
+	mtsk.idx = idx
+	mtsk.skseed = make([]byte, n)
+	copy(mtsk.skseed, backup[12:12+n])
+	mtsk.skprf = make([]byte, n)
+	copy(mtsk.skprf, backup[12+n:12+2*n])
+	mtsk.seed = make([]byte, n)
+	copy(mtsk.seed, backup[12+2*n:])
+
+	mtsk.xsk = make([]*SK, d)
+	mtsk.chainsig = make([][]byte, d-1)
+	if err := mtsk.moveTo(idx); err != nil {
+		return nil, err
+	}
+	return mtsk, nil
+}
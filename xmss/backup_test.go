@@ -0,0 +1,112 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xmss
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRestoreSK(t *testing.T) {
+	xsk, xpk, _ := KeyGen(xmssSHA2H5W256)
+	for i := 0; i < 12; i++ {
+		if i%5 == 0 {
+			backup, err := xsk.Backup(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			restored, err := RestoreSK(backup)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Signatures are deterministic, so the restored key signs exactly
+			// as the original.
+			original, _ := ParseSK(privatehex(xsk))
+			got, _ := restored.Sign([]byte("message"))
+			want, _ := original.Sign([]byte("message"))
+			if !bytes.Equal(got, want) {
+				t.Fatalf("key restored at index %d signs differently from the original", i)
+			}
+		}
+		xsk.Sign([]byte("message"))
+	}
+
+	backup, _ := xsk.Backup(3)
+	restored, err := RestoreSK(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := restored.Sign([]byte("message"))
+	if err != nil || !xpk.Verify([]byte("message"), sig) {
+		t.Fatalf("invalid signature from a restored key: %v", err)
+	}
+	if s, _ := ParseSignature(xmssSHA2H5W256, sig); s.Idx() != 15 {
+		t.Errorf("restored key signed with leaf %d, want 15", s.Idx())
+	}
+
+	backup, _ = xsk.Backup(1 << 40)
+	if _, err := RestoreSK(backup); err == nil {
+		t.Error("restored a key with no signatures left")
+	}
+}
+
+func TestRestoreMTSK(t *testing.T) {
+	mtsk, mtpk, _ := MTkeyGen(XMSSMTSHA2H20D4W256)
+	// The bottom tree has 32 leaves; restore before, at and after its end.
+	for i := 0; i < 34; i++ {
+		if i == 0 || i == 1 || i == 31 || i == 32 || i == 33 {
+			backup, err := mtsk.Backup(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			restored, err := RestoreMTSK(backup)
+			if err != nil {
+				t.Fatal(err)
+			}
+			original, _ := ParseMTSK(privatehex(mtsk))
+			got, _ := restored.Sign([]byte("message"))
+			want, _ := original.Sign([]byte("message"))
+			if !bytes.Equal(got, want) {
+				t.Fatalf("key restored at index %d signs differently from the original", i)
+			}
+		}
+		mtsk.Sign([]byte("message"))
+	}
+
+	backup, _ := mtsk.Backup(1000)
+	restored, err := RestoreMTSK(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := restored.Sign([]byte("message"))
+	if err != nil || !mtpk.Verify([]byte("message"), sig) {
+		t.Fatalf("invalid signature from a restored key: %v", err)
+	}
+	if s, _ := ParseMTSignature(XMSSMTSHA2H20D4W256, sig); s.Idx() != 1034 {
+		t.Errorf("restored key signed with leaf %d, want 1034", s.Idx())
+	}
+}
+
+func TestRestoreRejectsInvalidBackup(t *testing.T) {
+	xsk, _, _ := KeyGen(xmssSHA2H5W256)
+	mtsk, _, _ := MTkeyGen(XMSSMTSHA2H20D4W256)
+	xbackup, _ := xsk.Backup(0)
+	mtbackup, _ := mtsk.Backup(0)
+
+	for _, b := range [][]byte{nil, xbackup[:len(xbackup)-1], append(xbackup, 0), with(xbackup, 0, []byte{0xff}), mtbackup} {
+		if _, err := RestoreSK(b); err == nil {
+			t.Errorf("RestoreSK accepted %x", b)
+		}
+	}
+	for _, b := range [][]byte{nil, mtbackup[:len(mtbackup)-1], append(mtbackup, 0), with(mtbackup, 0, []byte{0xff}), xbackup} {
+		if _, err := RestoreMTSK(b); err == nil {
+			t.Errorf("RestoreMTSK accepted %x", b)
+		}
+	}
+	mtsk.Destroy()
+	if _, err := mtsk.Backup(0); err == nil {
+		t.Error("backed up a destroyed key")
+	}
+}
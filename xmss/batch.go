@@ -0,0 +1,209 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xmss
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"io"
+
+	"github.com/lingyunzhao/pqcrypto/merkle"
+	"golang.org/x/crypto/sha3"
+)
+
+// Batch signatures sign many messages with a single leaf. The messages are the
+// leaves of a Merkle tree built as in RFC 9162: leaf hashes are H(0x00 || m) and
+// interior nodes H(0x01 || left || right), where a node without a sibling is
+// promoted to the next level unchanged. H is the hash family of the XMSS type
+// with an n-byte output. Only the message
+//
+//	"xmss batch" || toByte(size, 4) || root
+//
+// is signed. The signature of a member is
+//
+//	toByte(index, 4) || toByte(size, 4) || inclusion path || signature of the root
+//
+// The root is signed as an ordinary message, so Sign, SignReader and the Sign
+// methods of the signers refuse messages that start with the tag "xmss batch":
+// their signatures would also verify as batch signatures of any message under
+// a forged batch root. Such messages can only be signed as members of a batch.
+
+var batchTag = []byte("xmss batch")
+
+var errBatchTag = errors.New("xmss: the message starts with the batch tag; sign it with SignBatch")
+
+const maxBatchSize = 1 << 31
+
+// batchHasher computes the nodes of a batch tree.
+type batchHasher int
+
+func (hsty batchHasher) size() int {
+	switch int(hsty) {
+	case sha2w512, shake256:
+		return 64
+	}
+	return 32
+}
+
+func (hsty batchHasher) sum(prefix byte, data ...[]byte) []byte {
+	switch int(hsty) {
+	case sha2w256:
+		d := sha256.New()
+		d.Write([]byte{prefix})
+		for _, x := range data {
+			d.Write(x)
+		}
+		return d.Sum(nil)
+	case sha2w512:
+		d := sha512.New()
+		d.Write([]byte{prefix})
+		for _, x := range data {
+			d.Write(x)
+		}
+		return d.Sum(nil)
+	}
+	var x sha3.ShakeHash
+	if int(hsty) == shake128 {
+		x = sha3.NewShake128()
+	} else {
+		x = sha3.NewShake256()
+	}
+	x.Write([]byte{prefix})
+	for _, y := range data {
+		x.Write(y)
+	}
+	digest := make([]byte, hsty.size())
+	x.Read(digest)
+	return digest
+}
+
+func (hsty batchHasher) HashLeaf(message []byte) []byte {
+	return hsty.sum(0x00, message)
+}
+
+func (hsty batchHasher) HashChildren(left []byte, right []byte) []byte {
+	return hsty.sum(0x01, left, right)
+}
+
+func batchRootMessage(size int, root []byte) []byte {
+	return bytes.Join([][]byte{batchTag, toByte(uint64(size), 4), root}, []byte(""))
+}
+
+// checkMessage refuses a message that starts with the batch tag.
+func checkMessage(message []byte) error {
+	if bytes.HasPrefix(message, batchTag) {
+		return errBatchTag
+	}
+	return nil
+}
+
+// A batchTagReader reads a message that must not start with the batch tag. Its
+// first Read fails with errBatchTag if it does.
+type batchTagReader struct {
+	r       io.Reader
+	checked bool
+}
+
+func (t *batchTagReader) Read(p []byte) (int, error) {
+	if !t.checked {
+		t.checked = true
+		head := make([]byte, len(batchTag))
+		n, err := io.ReadFull(t.r, head)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		if err := checkMessage(head[:n]); err != nil {
+			return 0, err
+		}
+		t.r = io.MultiReader(bytes.NewReader(head[:n]), t.r)
+	}
+	return t.r.Read(p)
+}
+
+func signBatch(hsty batchHasher, messages [][]byte, sign func(msghash) ([]byte, error)) ([][]byte, error) {
+	if len(messages) == 0 || len(messages) > maxBatchSize {
+		return nil, errors.New("xmss: invalid batch size")
+	}
+
+	tree := merkle.NewLogTree(hsty, messages...)
+	rootsig, err := sign(bytesmsg(batchRootMessage(len(messages), tree.Root())))
+	if err != nil {
+		return nil, err
+	}
+
+	sigs := make([][]byte, len(messages))
+	for i := range messages {
+		proof, _ := tree.InclusionProof(i)
+		sig := bytes.Join([][]byte{toByte(uint64(i), 4), toByte(uint64(len(messages)), 4)}, []byte(""))
+		sig = append(sig, twoDto1D(proof)...)
+		sigs[i] = append(sig, rootsig...)
+	}
+	return sigs, nil
+}
+
+// batchMember recomputes the message signed by the root signature of a batch
+// member and returns it with the root signature.
+func batchMember(hsty batchHasher, message []byte, sig []byte) ([]byte, []byte, bool) {
+	if len(sig) < 8 {
+		return nil, nil, false
+	}
+	index := strToInt(sig[:4])
+	size := strToInt(sig[4:8])
+	if size <= 0 || index < 0 || index >= size {
+		return nil, nil, false
+	}
+	n := hsty.size()
+	plen := merkle.InclusionProofLen(index, size)
+	if len(sig) < 8+plen*n {
+		return nil, nil, false
+	}
+	root, err := merkle.RootFromInclusionProof(hsty, message, index, size, oneDto2D(sig[8:8+plen*n], plen, n))
+	if err != nil {
+		return nil, nil, false
+	}
+	return batchRootMessage(size, root), sig[8+plen*n:], true
+}
+
+// SignBatch signs a batch of messages with a single leaf of the XMSS private key
+// and returns one signature per message, to be checked with VerifyBatchMember.
+func (xsk *SK) SignBatch(messages [][]byte) ([][]byte, error) {
+	xmssty, err := xmssparams(xsk.oid)
+	if err != nil {
+		return nil, errors.New("xmss: invalid XMSS private key")
+	}
+	return signBatch(batchHasher(xmssty.hsty), messages, xsk.sign)
+}
+
+// VerifyBatchMember verifies a message with its batch signature generated by SignBatch.
+func (xpk *PK) VerifyBatchMember(message, sig []byte) bool {
+	xmssty, err := xmssparams(xpk.oid)
+	if err != nil {
+		return false
+	}
+	m, rootsig, ok := batchMember(batchHasher(xmssty.hsty), message, sig)
+	return ok && xpk.Verify(m, rootsig)
+}
+
+// SignBatch signs a batch of messages with a single leaf of the XMSS^MT private
+// key and returns one signature per message, to be checked with VerifyBatchMember.
+func (mtsk *MTSK) SignBatch(messages [][]byte) ([][]byte, error) {
+	_, xmssty, err := xmssmtparams(mtsk.oid)
+	if err != nil {
+		return nil, errors.New("xmss-mt: invalid XMSS^MT private key")
+	}
+	return signBatch(batchHasher(xmssty.hsty), messages, mtsk.sign)
+}
+
+// VerifyBatchMember verifies a message with its batch signature generated by SignBatch.
+func (mtpk *MTPK) VerifyBatchMember(message, sig []byte) bool {
+	_, xmssty, err := xmssmtparams(mtpk.oid)
+	if err != nil {
+		return false
+	}
+	m, rootsig, ok := batchMember(batchHasher(xmssty.hsty), message, sig)
+	return ok && mtpk.Verify(m, rootsig)
+}
@@ -0,0 +1,95 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xmss
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestSignBatch(t *testing.T) {
+	xsk, xpk, _ := KeyGen(XMSSSHA2H10W256)
+	for _, size := range []int{1, 2, 3, 7, 16} {
+		messages := make([][]byte, size)
+		for i := range messages {
+			messages[i] = []byte(fmt.Sprintf("entry %d of %d", i, size))
+		}
+		sigs, err := xsk.SignBatch(messages)
+		if err != nil {
+			t.Fatalf("failed to sign batch when size = %d: %v", size, err)
+		}
+		for i, sig := range sigs {
+			if !xpk.VerifyBatchMember(messages[i], sig) {
+				t.Errorf("invalid batch signature when size = %d, i = %d", size, i)
+			}
+			if size > 1 && xpk.VerifyBatchMember(messages[(i+1)%size], sig) {
+				t.Errorf("batch signature verified another member when size = %d, i = %d", size, i)
+			}
+		}
+	}
+	if xsk.mt.idx != 5 {
+		t.Errorf("batches used %d leaves, want 5", xsk.mt.idx)
+	}
+	if _, err := xsk.SignBatch(nil); err == nil {
+		t.Errorf("signed an empty batch")
+	}
+	if xpk.VerifyBatchMember([]byte("abc"), []byte{0, 0, 0, 1}) {
+		t.Errorf("verified a truncated batch signature")
+	}
+}
+
+func TestMTSignBatch(t *testing.T) {
+	mtsk, mtpk, _ := MTkeyGen(XMSSMTSHA2H20D4W256)
+	messages := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	sigs, err := NewMTSigner(mtsk).SignBatch(messages)
+	if err != nil {
+		t.Fatalf("failed to sign batch: %v", err)
+	}
+	for i, sig := range sigs {
+		if !mtpk.VerifyBatchMember(messages[i], sig) {
+			t.Errorf("invalid batch signature when i = %d", i)
+		}
+	}
+}
+
+func TestSignRefusesBatchTag(t *testing.T) {
+	xsk, xpk, _ := KeyGen(xmssSHA2H5W256)
+	sigs, _ := xsk.SignBatch([][]byte{[]byte("a"), []byte("b")})
+
+	// A signature of the root message of another batch would make its members
+	// verify.
+	forged := batchRootMessage(1, batchHasher(sha2w256).HashLeaf([]byte("forged")))
+	if _, err := xsk.Sign(forged); err == nil {
+		t.Fatal("signed a message with the batch tag")
+	}
+	if _, err := NewSigner(xsk).Sign(forged); err == nil {
+		t.Error("the signer signed a message with the batch tag")
+	}
+	if _, err := xsk.SignReader(bytes.NewReader(forged)); err == nil {
+		t.Error("signed a message with the batch tag read from a stream")
+	}
+	if xsk.mt.idx != 2 {
+		t.Errorf("%d leaves were used, want 2", xsk.mt.idx)
+	}
+
+	for _, message := range [][]byte{batchTag[:4], []byte("batch"), nil} {
+		sig, err := xsk.SignReader(bytes.NewReader(message))
+		if err != nil || !xpk.Verify(message, sig) {
+			t.Errorf("failed to sign %q: %v", message, err)
+		}
+	}
+	if !xpk.VerifyBatchMember([]byte("b"), sigs[1]) {
+		t.Error("invalid batch signature")
+	}
+
+	mtsk, _, _ := MTkeyGen(XMSSMTSHA2H20D4W256)
+	if _, err := mtsk.Sign(forged); err == nil {
+		t.Error("signed a message with the batch tag with an XMSS^MT key")
+	}
+	if _, err := NewMTSigner(mtsk).Sign(forged); err == nil {
+		t.Error("the XMSS^MT signer signed a message with the batch tag")
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xmss
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+
+	"github.com/lingyunzhao/pqcrypto/merkle"
+)
+
+// A checkpoint holds the state of a key generation, from which it can be
+// resumed:
+//
+//	XMSS:    oid (4 bytes) || SK_SEED || SK_PRF || PUB_SEED || tree
+//	XMSS^MT: oid (4 bytes) || SK_SEED || SK_PRF || PUB_SEED || count (4 bytes) || tree ...
+//
+// where each tree is its root followed by its BDS state, the root being zero
+// until the tree is complete, and the trees of an XMSS^MT checkpoint are those
+// of the count layers started so far, from the bottom up.
+
+// Checkpoint receives the checkpoints of a key generation. Each checkpoint
+// supersedes the previous ones, so a sink only needs to keep the latest; it
+// contains the secret seeds of the key and must be protected like the key
+// itself. An error stops the generation and is returned by it.
+type Checkpoint func(checkpoint []byte) error
+
+// KeyGenWithCheckpoints generates an XMSS key pair like KeyGenWithContext,
+// passing a checkpoint to checkpoint every interval leaves and once more when
+// ctx is done. ResumeKeyGen continues from any of them and yields the key pair
+// an uninterrupted run would have.
+func KeyGenWithCheckpoints(ctx context.Context, oid uint, interval uint64, checkpoint Checkpoint, progress Progress) (*SK, *PK, error) {
+	xmssty, err := xmssparams(oid)
+	if err != nil {
+		return nil, nil, err
+	}
+	g := &keygen{ctx: ctx, progress: progress, interval: interval, checkpoint: checkpoint}
+	return keyGen(g, oid, defaultK(xmssty.h), rand.Reader)
+}
+
+// ResumeKeyGen resumes the generation of an XMSS key pair from a checkpoint
+// written by KeyGenWithCheckpoints or ResumeKeyGen, and keeps writing
+// checkpoints as they do. The progress counts the leaves computed before the
+// checkpoint.
+func ResumeKeyGen(ctx context.Context, state []byte, interval uint64, checkpoint Checkpoint, progress Progress) (*SK, *PK, error) {
+	invalid := errors.New("xmss: invalid XMSS checkpoint")
+	if len(state) < 4 {
+		return nil, nil, invalid
+	}
+	oid := strToUint(state[:4])
+	xmssty, err := xmssparams(oid)
+	if err != nil {
+		return nil, nil, invalid
+	}
+	n := xmssty.n
+	if len(state) < 4+3*n {
+		return nil, nil, invalid
+	}
+	skseed, skprf, seed := state[4:4+n], state[4+n:4+2*n], state[4+2*n:4+3*n]
+	xsk, read, err := parseCheckpointTree(state[4+3*n:], oid, skseed, seed, skprf, 0)
+	if err != nil || read != len(state)-4-3*n {
+		return nil, nil, invalid
+	}
+	g := &keygen{ctx: ctx, progress: progress, interval: interval, checkpoint: checkpoint}
+	g.done = uint64(xsk.mt.bds.InitLeaves())
+	return g.xmss(xsk)
+}
+
+// MTkeyGenWithCheckpoints generates an XMSS^MT key pair like
+// MTkeyGenWithContext, passing a checkpoint to checkpoint every interval leaves
+// and once more when ctx is done. ResumeMTkeyGen continues from any of them and
+// yields the key pair an uninterrupted run would have.
+func MTkeyGenWithCheckpoints(ctx context.Context, oid uint, interval uint64, checkpoint Checkpoint, progress Progress) (*MTSK, *MTPK, error) {
+	_, xmssty, err := xmssmtparams(oid)
+	if err != nil {
+		return nil, nil, err
+	}
+	g := &keygen{ctx: ctx, progress: progress, interval: interval, checkpoint: checkpoint}
+	return mtkeyGen(g, oid, defaultK(xmssty.h), rand.Reader)
+}
+
+// ResumeMTkeyGen resumes the generation of an XMSS^MT key pair from a
+// checkpoint written by MTkeyGenWithCheckpoints or ResumeMTkeyGen, and keeps
+// writing checkpoints as they do. The progress counts the leaves computed
+// before the checkpoint.
+func ResumeMTkeyGen(ctx context.Context, state []byte, interval uint64, checkpoint Checkpoint, progress Progress) (*MTSK, *MTPK, error) {
+	invalid := errors.New("xmss-mt: invalid XMSS^MT checkpoint")
+	if len(state) < 4 {
+		return nil, nil, invalid
+	}
+	oid := strToUint(state[:4])
+	mtty, xmssty, err := xmssmtparams(oid)
+	if err != nil {
+		return nil, nil, invalid
+	}
+	n := xmssty.n
+	d := mtty.d
+	if len(state) < 4+3*n+4 {
+		return nil, nil, invalid
+	}
+	count := strToInt(state[4+3*n : 4+3*n+4])
+	if count < 1 || count > d {
+		return nil, nil, invalid
+	}
+
+	mtsk := new(MTSK)
+	mtsk.oid = oid
+	mtsk.skseed = make([]byte, n)
+	copy(mtsk.skseed, state[4:4+n])
+	mtsk.skprf = make([]byte, n)
+	copy(mtsk.skprf, state[4+n:4+2*n])
+	mtsk.seed = make([]byte, n)
+	copy(mtsk.seed, state[4+2*n:4+3*n])
+	mtsk.xsk = make([]*SK, d)
+	state = state[4+3*n+4:]
+
+	g := &keygen{ctx: ctx, progress: progress, interval: interval, checkpoint: checkpoint}
+	for i := 0; i < count; i++ {
+		xsk, read, err := parseCheckpointTree(state, mtty.xmssty, mtsk.skseed, mtsk.seed, mtsk.skprf, i)
+		// Only the last tree may be under construction, and all share k.
+		if err == nil && ((i < count-1 && xsk.mt.root == nil) || (i > 0 && xsk.mt.bds.K() != mtsk.xsk[0].mt.bds.K())) {
+			xsk.Destroy()
+			err = invalid
+		}
+		if err != nil {
+			mtsk.xsk = mtsk.xsk[:i]
+			mtsk.Destroy()
+			return nil, nil, invalid
+		}
+		mtsk.xsk[i] = xsk
+		state = state[read:]
+		g.done += uint64(xsk.mt.bds.InitLeaves())
+	}
+	if len(state) != 0 {
+		mtsk.xsk = mtsk.xsk[:count]
+		mtsk.Destroy()
+		return nil, nil, invalid
+	}
+	return g.mt(mtsk, mtsk.xsk[0].mt.bds.K())
+}
+
+// xmssCheckpoint encodes the checkpoint of the generation of an XMSS key.
+func xmssCheckpoint(xsk *SK) []byte {
+	return bytes.Join([][]byte{toByte(uint64(xsk.oid), 4), xsk.mt.skseed, xsk.skprf, xsk.mt.seed,
+		xsk.mt.checkpointTree()}, []byte(""))
+}
+
+// mtCheckpoint encodes the checkpoint of the generation of an XMSS^MT key
+// whose layers have been started up to the first nil tree.
+func mtCheckpoint(mtsk *MTSK) []byte {
+	var trees [][]byte
+	for _, xsk := range mtsk.xsk {
+		if xsk == nil {
+			break
+		}
+		trees = append(trees, xsk.mt.checkpointTree())
+	}
+	return bytes.Join(append([][]byte{toByte(uint64(mtsk.oid), 4), mtsk.skseed, mtsk.skprf, mtsk.seed,
+		toByte(uint64(len(trees)), 4)}, trees...), []byte(""))
+}
+
+func (mt *xmsstree) checkpointTree() []byte {
+	n := len(mt.seed)
+	root := mt.root
+	if root == nil {
+		root = make([]byte, n)
+	}
+	return bytes.Join([][]byte{root, mt.bds.Marshal(n)}, []byte(""))
+}
+
+// parseCheckpointTree decodes tree 0 of a layer from a checkpoint and returns
+// it with the number of bytes read.
+func parseCheckpointTree(b []byte, oid uint, skseed []byte, seed []byte, skprf []byte, layer int) (*SK, int, error) {
+	xmssty := xmsstypes[oid]
+	n := xmssty.n
+	if len(b) < n {
+		return nil, 0, errors.New("xmss: invalid checkpoint")
+	}
+	bds, read, err := merkle.ParseBDS(b[n:], n, xmssty.h)
+	if err != nil {
+		return nil, 0, err
+	}
+	xsk := newxsk(oid, bds.K(), skseed, seed, skprf, layer, 0)
+	xsk.mt.bds = bds
+	if bds.Initialized() {
+		xsk.mt.root = make([]byte, n)
+		copy(xsk.mt.root, b[:n])
+	}
+	return xsk, n + read, nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xmss
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// interrupted generates a key with checkpoints every 5 leaves and cancels the
+// generation after the given number of leaves. It returns the checkpoints.
+func interrupted(t *testing.T, leaves uint64, generate func(context.Context, Checkpoint, Progress) error) [][]byte {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var checkpoints [][]byte
+	err := generate(ctx, func(checkpoint []byte) error {
+		checkpoints = append(checkpoints, append([]byte(nil), checkpoint...))
+		return nil
+	}, func(done uint64, total uint64) {
+		if done == leaves {
+			cancel()
+		}
+	})
+	if err != context.Canceled {
+		t.Fatalf("interrupted generation returned %v", err)
+	}
+	return checkpoints
+}
+
+func TestResumeKeyGen(t *testing.T) {
+	checkpoints := interrupted(t, 17, func(ctx context.Context, checkpoint Checkpoint, progress Progress) error {
+		_, _, err := KeyGenWithCheckpoints(ctx, xmssSHA2H5W256, 5, checkpoint, progress)
+		return err
+	})
+	// Checkpoints after leaves 5, 10 and 15, and when the context was canceled.
+	if len(checkpoints) != 4 {
+		t.Fatalf("got %d checkpoints, want 4", len(checkpoints))
+	}
+	for i, checkpoint := range checkpoints {
+		var first uint64
+		xsk, xpk, err := ResumeKeyGen(context.Background(), checkpoint, 0, nil, func(done uint64, total uint64) {
+			if first == 0 {
+				first = done
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []uint64{6, 11, 16, 18}[i]; first != want {
+			t.Errorf("resumed generation started at leaf %d, want %d", first, want)
+		}
+		// A key restored from the seeds has the tree of an uninterrupted run.
+		backup, _ := xsk.Backup(0)
+		whole, _ := RestoreSK(backup)
+		if privatehex(xsk) != privatehex(whole) || xpk.String() != whole.Public().String() {
+			t.Errorf("key resumed from checkpoint %d differs from an uninterrupted run", i)
+		}
+	}
+}
+
+func TestResumeMTkeyGen(t *testing.T) {
+	// The bottom tree has 32 leaves, so the generation is interrupted in the
+	// second layer.
+	checkpoints := interrupted(t, 40, func(ctx context.Context, checkpoint Checkpoint, progress Progress) error {
+		_, _, err := MTkeyGenWithCheckpoints(ctx, XMSSMTSHA2H20D4W256, 5, checkpoint, progress)
+		return err
+	})
+	if len(checkpoints) != 9 {
+		t.Fatalf("got %d checkpoints, want 9", len(checkpoints))
+	}
+	for i, checkpoint := range checkpoints {
+		mtsk, mtpk, err := ResumeMTkeyGen(context.Background(), checkpoint, 0, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		backup, _ := mtsk.Backup(0)
+		whole, _ := RestoreMTSK(backup)
+		if privatehex(mtsk) != privatehex(whole) || mtpk.String() != whole.Public().String() {
+			t.Errorf("key resumed from checkpoint %d differs from an uninterrupted run", i)
+		}
+		mtsig, _ := mtsk.Sign([]byte("message"))
+		if !mtpk.Verify([]byte("message"), mtsig) {
+			t.Errorf("invalid signature from the key resumed from checkpoint %d", i)
+		}
+	}
+}
+
+func TestCheckpointErrorStopsKeyGen(t *testing.T) {
+	stop := errors.New("disk full")
+	_, _, err := MTkeyGenWithCheckpoints(context.Background(), XMSSMTSHA2H20D4W256, 10,
+		func([]byte) error { return stop }, nil)
+	if err != stop {
+		t.Errorf("generation returned %v, want the error of the checkpoint", err)
+	}
+}
+
+func TestResumeRejectsInvalidCheckpoint(t *testing.T) {
+	xmss := interrupted(t, 10, func(ctx context.Context, checkpoint Checkpoint, progress Progress) error {
+		_, _, err := KeyGenWithCheckpoints(ctx, xmssSHA2H5W256, 0, checkpoint, progress)
+		return err
+	})[0]
+	mt := interrupted(t, 40, func(ctx context.Context, checkpoint Checkpoint, progress Progress) error {
+		_, _, err := MTkeyGenWithCheckpoints(ctx, XMSSMTSHA2H20D4W256, 0, checkpoint, progress)
+		return err
+	})[0]
+	for _, b := range [][]byte{nil, xmss[:len(xmss)-1], append(xmss, 0), mt} {
+		if _, _, err := ResumeKeyGen(context.Background(), b, 0, nil, nil); err == nil {
+			t.Errorf("ResumeKeyGen accepted %x", b)
+		}
+	}
+	for _, b := range [][]byte{nil, mt[:len(mt)-1], append(mt, 0), xmss} {
+		if _, _, err := ResumeMTkeyGen(context.Background(), b, 0, nil, nil); err == nil {
+			t.Errorf("ResumeMTkeyGen accepted %x", b)
+		}
+	}
+}
@@ -0,0 +1,390 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xmss
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// The descriptions below hold the decoded fields of keys and signatures. They
+// are meant for debugging and tooling: String renders a description as text and
+// encoding/json renders it as JSON. Byte strings are given in hexadecimal and
+// private seeds are never included.
+
+var xmssnames = map[uint]string{
+	uint(XMSSSHA2H10W256):  "XMSS-SHA2_10_256",
+	uint(XMSSSHA2H16W256):  "XMSS-SHA2_16_256",
+	uint(XMSSSHA2H20W256):  "XMSS-SHA2_20_256",
+	uint(XMSSSHA2H10W512):  "XMSS-SHA2_10_512",
+	uint(XMSSSHA2H16W512):  "XMSS-SHA2_16_512",
+	uint(XMSSSHA2H20W512):  "XMSS-SHA2_20_512",
+	uint(XMSSSHAKEH10W256): "XMSS-SHAKE_10_256",
+	uint(XMSSSHAKEH16W256): "XMSS-SHAKE_16_256",
+	uint(XMSSSHAKEH20W256): "XMSS-SHAKE_20_256",
+	uint(XMSSSHAKEH10W512): "XMSS-SHAKE_10_512",
+	uint(XMSSSHAKEH16W512): "XMSS-SHAKE_16_512",
+	uint(XMSSSHAKEH20W512): "XMSS-SHAKE_20_512",
+}
+
+var xmssmtnames = map[uint]string{
+	uint(XMSSMTSHA2H20D2W256):   "XMSSMT-SHA2_20/2_256",
+	uint(XMSSMTSHA2H20D4W256):   "XMSSMT-SHA2_20/4_256",
+	uint(XMSSMTSHA2H40D2W256):   "XMSSMT-SHA2_40/2_256",
+	uint(XMSSMTSHA2H40D4W256):   "XMSSMT-SHA2_40/4_256",
+	uint(XMSSMTSHA2H40D8W256):   "XMSSMT-SHA2_40/8_256",
+	uint(XMSSMTSHA2H60D3W256):   "XMSSMT-SHA2_60/3_256",
+	uint(XMSSMTSHA2H60D6W256):   "XMSSMT-SHA2_60/6_256",
+	uint(XMSSMTSHA2H60D12W256):  "XMSSMT-SHA2_60/12_256",
+	uint(XMSSMTSHA2H20D2W512):   "XMSSMT-SHA2_20/2_512",
+	uint(XMSSMTSHA2H20D4W512):   "XMSSMT-SHA2_20/4_512",
+	uint(XMSSMTSHA2H40D2W512):   "XMSSMT-SHA2_40/2_512",
+	uint(XMSSMTSHA2H40D4W512):   "XMSSMT-SHA2_40/4_512",
+	uint(XMSSMTSHA2H40D8W512):   "XMSSMT-SHA2_40/8_512",
+	uint(XMSSMTSHA2H60D3W512):   "XMSSMT-SHA2_60/3_512",
+	uint(XMSSMTSHA2H60D6W512):   "XMSSMT-SHA2_60/6_512",
+	uint(XMSSMTSHA2H60D12W512):  "XMSSMT-SHA2_60/12_512",
+	uint(XMSSMTSHAKEH20D2W256):  "XMSSMT-SHAKE_20/2_256",
+	uint(XMSSMTSHAKEH20D4W256):  "XMSSMT-SHAKE_20/4_256",
+	uint(XMSSMTSHAKEH40D2W256):  "XMSSMT-SHAKE_40/2_256",
+	uint(XMSSMTSHAKEH40D4W256):  "XMSSMT-SHAKE_40/4_256",
+	uint(XMSSMTSHAKEH40D8W256):  "XMSSMT-SHAKE_40/8_256",
+	uint(XMSSMTSHAKEH60D3W256):  "XMSSMT-SHAKE_60/3_256",
+	uint(XMSSMTSHAKEH60D6W256):  "XMSSMT-SHAKE_60/6_256",
+	uint(XMSSMTSHAKEH60D12W256): "XMSSMT-SHAKE_60/12_256",
+	uint(XMSSMTSHAKEH20D2W512):  "XMSSMT-SHAKE_20/2_512",
+	uint(XMSSMTSHAKEH20D4W512):  "XMSSMT-SHAKE_20/4_512",
+	uint(XMSSMTSHAKEH40D2W512):  "XMSSMT-SHAKE_40/2_512",
+	uint(XMSSMTSHAKEH40D4W512):  "XMSSMT-SHAKE_40/4_512",
+	uint(XMSSMTSHAKEH40D8W512):  "XMSSMT-SHAKE_40/8_512",
+	uint(XMSSMTSHAKEH60D3W512):  "XMSSMT-SHAKE_60/3_512",
+	uint(XMSSMTSHAKEH60D6W512):  "XMSSMT-SHAKE_60/6_512",
+	uint(XMSSMTSHAKEH60D12W512): "XMSSMT-SHAKE_60/12_512",
+}
+
+func typename(names map[uint]string, oid uint) string {
+	if name, ok := names[oid]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown (0x%08x)", oid)
+}
+
+// A SignatureDescription holds the fields of an XMSS signature.
+type SignatureDescription struct {
+	Type     string   `json:"type"`
+	Idx      int      `json:"idx"`
+	R        string   `json:"r"`
+	WOTS     []string `json:"wots"`
+	AuthPath []string `json:"authPath"`
+}
+
+// A MTSignatureDescription holds the fields of an XMSS^MT signature. Layers
+// holds the reduced XMSS signatures from the bottom layer up.
+type MTSignatureDescription struct {
+	Type   string                   `json:"type"`
+	Idx    uint64                   `json:"idx"`
+	R      string                   `json:"r"`
+	Layers []*MTLayerSigDescription `json:"layers"`
+}
+
+// A MTLayerSigDescription holds the fields of the reduced XMSS signature of
+// one layer of an XMSS^MT signature.
+type MTLayerSigDescription struct {
+	Layer    int      `json:"layer"`
+	Tree     uint64   `json:"tree"`
+	Leaf     int      `json:"leaf"`
+	WOTS     []string `json:"wots"`
+	AuthPath []string `json:"authPath"`
+}
+
+// A PKDescription holds the fields of an XMSS public key.
+type PKDescription struct {
+	Type string `json:"type"`
+	Root string `json:"root"`
+	Seed string `json:"seed"`
+}
+
+// A MTPKDescription holds the fields of an XMSS^MT public key.
+type MTPKDescription struct {
+	Type   string `json:"type"`
+	Layers int    `json:"layers"`
+	Root   string `json:"root"`
+	Seed   string `json:"seed"`
+}
+
+// A SKDescription holds the public fields of an XMSS private key. Idx is the
+// index of the next leaf to be used.
+type SKDescription struct {
+	Type      string `json:"type"`
+	Idx       int    `json:"idx"`
+	Remaining uint64 `json:"remaining"`
+	Root      string `json:"root"`
+	Seed      string `json:"seed"`
+}
+
+// A MTSKDescription holds the public fields of an XMSS^MT private key. Trees
+// holds the current tree of every layer from the bottom layer up.
+type MTSKDescription struct {
+	Type      string               `json:"type"`
+	Layers    int                  `json:"layers"`
+	Idx       uint64               `json:"idx"`
+	Remaining uint64               `json:"remaining"`
+	Root      string               `json:"root"`
+	Seed      string               `json:"seed"`
+	Trees     []*MTTreeDescription `json:"trees"`
+}
+
+// A MTTreeDescription describes the current tree of one layer of an XMSS^MT
+// private key. Leaf is the index of the next leaf to be used in the tree.
+type MTTreeDescription struct {
+	Layer int    `json:"layer"`
+	Tree  uint64 `json:"tree"`
+	Leaf  int    `json:"leaf"`
+	Root  string `json:"root"`
+}
+
+// DescribeSignature decodes an XMSS signature of type oid without verifying it.
+func DescribeSignature(oid uint, xsig []byte) (*SignatureDescription, error) {
+	sig, err := ParseSignature(oid, xsig)
+	if err != nil {
+		return nil, err
+	}
+	return sig.Describe(), nil
+}
+
+// DescribeMTSignature decodes an XMSS^MT signature of type oid without
+// verifying it.
+func DescribeMTSignature(oid uint, mtsig []byte) (*MTSignatureDescription, error) {
+	sig, err := ParseMTSignature(oid, mtsig)
+	if err != nil {
+		return nil, err
+	}
+	return sig.Describe(), nil
+}
+
+// Describe describes the XMSS signature.
+func (sig *Signature) Describe() *SignatureDescription {
+	return &SignatureDescription{
+		Type:     typename(xmssnames, sig.oid),
+		Idx:      sig.idx,
+		R:        hex.EncodeToString(sig.r),
+		WOTS:     hexlist(sig.wots),
+		AuthPath: hexlist(sig.authpath),
+	}
+}
+
+// Describe describes the XMSS^MT signature.
+func (sig *MTSignature) Describe() *MTSignatureDescription {
+	desc := &MTSignatureDescription{
+		Type: typename(xmssmtnames, sig.oid),
+		Idx:  sig.idx,
+		R:    hex.EncodeToString(sig.r),
+	}
+	for i, layer := range sig.layers {
+		tree, leaf := sig.Tree(i)
+		desc.Layers = append(desc.Layers, &MTLayerSigDescription{
+			Layer:    i,
+			Tree:     tree,
+			Leaf:     leaf,
+			WOTS:     hexlist(layer.wots),
+			AuthPath: hexlist(layer.authpath),
+		})
+	}
+	return desc
+}
+
+// DescribeSignature decodes an XMSS signature made with the key without
+// verifying it.
+func (xpk *PK) DescribeSignature(xsig []byte) (*SignatureDescription, error) {
+	return DescribeSignature(xpk.oid, xsig)
+}
+
+// DescribeSignature decodes an XMSS^MT signature made with the key without
+// verifying it.
+func (mtpk *MTPK) DescribeSignature(mtsig []byte) (*MTSignatureDescription, error) {
+	return DescribeMTSignature(mtpk.oid, mtsig)
+}
+
+// Describe describes the XMSS public key.
+func (xpk *PK) Describe() *PKDescription {
+	return &PKDescription{
+		Type: typename(xmssnames, xpk.oid),
+		Root: hex.EncodeToString(xpk.root),
+		Seed: hex.EncodeToString(xpk.seed),
+	}
+}
+
+// Describe describes the XMSS^MT public key.
+func (mtpk *MTPK) Describe() *MTPKDescription {
+	desc := &MTPKDescription{
+		Type: typename(xmssmtnames, mtpk.oid),
+		Root: hex.EncodeToString(mtpk.root),
+		Seed: hex.EncodeToString(mtpk.seed),
+	}
+	if xmssmttypes[mtpk.oid] != nil {
+		desc.Layers = xmssmttypes[mtpk.oid].d
+	}
+	return desc
+}
+
+// Describe describes the public fields of the XMSS private key.
+func (xsk *SK) Describe() *SKDescription {
+	return &SKDescription{
+		Type:      typename(xmssnames, xsk.oid),
+		Idx:       xsk.mt.idx,
+		Remaining: xsk.Remaining(),
+		Root:      hex.EncodeToString(xsk.mt.root),
+		Seed:      hex.EncodeToString(xsk.mt.seed),
+	}
+}
+
+// Describe describes the public fields of the XMSS^MT private key.
+func (mtsk *MTSK) Describe() *MTSKDescription {
+	desc := &MTSKDescription{
+		Type:      typename(xmssmtnames, mtsk.oid),
+		Layers:    len(mtsk.xsk),
+		Idx:       mtsk.idx,
+		Remaining: mtsk.Remaining(),
+		Root:      hex.EncodeToString(mtsk.root),
+		Seed:      hex.EncodeToString(mtsk.seed),
+	}
+	for i, xsk := range mtsk.xsk {
+		desc.Trees = append(desc.Trees, &MTTreeDescription{
+			Layer: i,
+			Tree:  uint64(xsk.mt.idxtree),
+			Leaf:  xsk.mt.idx,
+			Root:  hex.EncodeToString(xsk.mt.root),
+		})
+	}
+	return desc
+}
+
+func (desc *SignatureDescription) String() string {
+	w := new(textwriter)
+	w.line("XMSS signature")
+	w = w.indent()
+	w.field("type", desc.Type)
+	w.field("idx", desc.Idx)
+	w.field("r", desc.R)
+	w.list("wots", desc.WOTS)
+	w.list("auth", desc.AuthPath)
+	return w.String()
+}
+
+func (desc *MTSignatureDescription) String() string {
+	w := new(textwriter)
+	w.line("XMSS^MT signature")
+	w = w.indent()
+	w.field("type", desc.Type)
+	w.field("idx", desc.Idx)
+	w.field("r", desc.R)
+	for _, layer := range desc.Layers {
+		w.line(fmt.Sprintf("layer %d", layer.Layer))
+		lw := w.indent()
+		lw.field("tree", layer.Tree)
+		lw.field("leaf", layer.Leaf)
+		lw.list("wots", layer.WOTS)
+		lw.list("auth", layer.AuthPath)
+	}
+	return w.String()
+}
+
+func (desc *PKDescription) String() string {
+	w := new(textwriter)
+	w.line("XMSS public key")
+	w = w.indent()
+	w.field("type", desc.Type)
+	w.field("root", desc.Root)
+	w.field("seed", desc.Seed)
+	return w.String()
+}
+
+func (desc *MTPKDescription) String() string {
+	w := new(textwriter)
+	w.line("XMSS^MT public key")
+	w = w.indent()
+	w.field("type", desc.Type)
+	w.field("layers", desc.Layers)
+	w.field("root", desc.Root)
+	w.field("seed", desc.Seed)
+	return w.String()
+}
+
+func (desc *SKDescription) String() string {
+	w := new(textwriter)
+	w.line("XMSS private key")
+	w = w.indent()
+	w.field("type", desc.Type)
+	w.field("idx", desc.Idx)
+	w.field("remaining", desc.Remaining)
+	w.field("root", desc.Root)
+	w.field("seed", desc.Seed)
+	return w.String()
+}
+
+func (desc *MTSKDescription) String() string {
+	w := new(textwriter)
+	w.line("XMSS^MT private key")
+	w = w.indent()
+	w.field("type", desc.Type)
+	w.field("layers", desc.Layers)
+	w.field("idx", desc.Idx)
+	w.field("remaining", desc.Remaining)
+	w.field("root", desc.Root)
+	w.field("seed", desc.Seed)
+	for _, tree := range desc.Trees {
+		w.line(fmt.Sprintf("layer %d", tree.Layer))
+		tw := w.indent()
+		tw.field("tree", tree.Tree)
+		tw.field("leaf", tree.Leaf)
+		tw.field("root", tree.Root)
+	}
+	return w.String()
+}
+
+// A textwriter renders descriptions as indented "name: value" lines.
+type textwriter struct {
+	b      *strings.Builder
+	prefix string
+}
+
+func (w *textwriter) builder() *strings.Builder {
+	if w.b == nil {
+		w.b = new(strings.Builder)
+	}
+	return w.b
+}
+
+func (w *textwriter) indent() *textwriter {
+	return &textwriter{w.builder(), w.prefix + "  "}
+}
+
+func (w *textwriter) line(s string) {
+	fmt.Fprintf(w.builder(), "%s%s\n", w.prefix, s)
+}
+
+func (w *textwriter) field(name string, value interface{}) {
+	w.line(fmt.Sprintf("%-10s %v", name+":", value))
+}
+
+func (w *textwriter) list(name string, values []string) {
+	for i, value := range values {
+		w.field(fmt.Sprintf("%s[%d]", name, i), value)
+	}
+}
+
+func (w *textwriter) String() string {
+	return w.builder().String()
+}
+
+// hexlist encodes byte strings in hexadecimal.
+func hexlist(list [][]byte) []string {
+	strs := make([]string, len(list))
+	for i, b := range list {
+		strs[i] = hex.EncodeToString(b)
+	}
+	return strs
+}
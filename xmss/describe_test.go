@@ -0,0 +1,65 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xmss
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDescribeSignature(t *testing.T) {
+	xsk, xpk, _ := KeyGen(XMSSSHA2H10W256)
+	xsk.Sign([]byte("message 0"))
+	xsig, _ := xsk.Sign([]byte("message 1"))
+
+	desc, err := DescribeSignature(XMSSSHA2H10W256, xsig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.Type != "XMSS-SHA2_10_256" || desc.Idx != 1 || len(desc.WOTS) != 67 || len(desc.AuthPath) != 10 {
+		t.Errorf("unexpected description:\n%s", desc)
+	}
+	if _, err := json.Marshal(desc); err != nil {
+		t.Error(err)
+	}
+	if _, err := DescribeSignature(XMSSSHA2H10W256, xsig[1:]); err == nil {
+		t.Error("truncated signature accepted")
+	}
+	if !strings.Contains(xpk.Describe().String(), xpk.Describe().Root) {
+		t.Error("text description lacks the root")
+	}
+}
+
+func TestDescribeMTSignature(t *testing.T) {
+	mtsk, mtpk, _ := MTkeyGen(XMSSMTSHA2H20D4W256)
+	for i := 0; i < 40; i++ {
+		mtsk.Sign([]byte("message"))
+	}
+	mtsig, _ := mtsk.Sign([]byte("message"))
+	if !mtpk.Verify([]byte("message"), mtsig) {
+		t.Fatal("invalid signature after the first bottom tree")
+	}
+
+	desc, err := DescribeMTSignature(XMSSMTSHA2H20D4W256, mtsig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.Idx != 40 || len(desc.Layers) != 4 {
+		t.Fatalf("unexpected description:\n%s", desc)
+	}
+	// 40 = 1*32 + 8 with trees of height 5.
+	if desc.Layers[0].Tree != 1 || desc.Layers[0].Leaf != 8 || desc.Layers[1].Tree != 0 || desc.Layers[1].Leaf != 1 {
+		t.Errorf("unexpected layers:\n%s", desc)
+	}
+	if len(desc.Layers[3].AuthPath) != 5 {
+		t.Errorf("layer 3 has %d authentication path nodes", len(desc.Layers[3].AuthPath))
+	}
+
+	skdesc := mtsk.Describe()
+	if skdesc.Idx != 41 || skdesc.Trees[0].Leaf != 9 || skdesc.Trees[0].Tree != 1 {
+		t.Errorf("unexpected private key description:\n%s", skdesc)
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xmss
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// The fuzz targets feed arbitrary bytes to the parsers and verifiers, which
+// must return an error or false rather than panic. Parsed keys and signatures
+// must serialize back to their input. Run one with, for example,
+//
+//	go test -run '^$' -fuzz FuzzMTVerify ./xmss
+
+var fuzzmessage = []byte("fuzz")
+
+// fuzzxmss returns a valid XMSS key pair and a signature of fuzzmessage.
+func fuzzxmss() (*SK, *PK, []byte) {
+	xsk, xpk, _ := KeyGen(XMSSSHA2H10W256)
+	xsk.Sign(fuzzmessage)
+	sig, _ := xsk.Sign(fuzzmessage)
+	return xsk, xpk, sig
+}
+
+// fuzzxmssmt returns a valid XMSS^MT key pair and a signature of fuzzmessage
+// made after the first bottom tree.
+func fuzzxmssmt() (*MTSK, *MTPK, []byte) {
+	mtsk, mtpk, _ := MTkeyGen(XMSSMTSHA2H20D4W256)
+	for i := 0; i < 33; i++ {
+		mtsk.Sign(fuzzmessage)
+	}
+	sig, _ := mtsk.Sign(fuzzmessage)
+	return mtsk, mtpk, sig
+}
+
+func FuzzParseSK(f *testing.F) {
+	xsk, _, _ := fuzzxmss()
+	f.Add(xsk.serialize())
+	f.Fuzz(func(t *testing.T, sk []byte) {
+		xsk, err := ParseSK(hex.EncodeToString(sk))
+		if err == nil && !bytes.Equal(xsk.serialize(), sk) {
+			t.Errorf("serialize() = %x, want %x", xsk.serialize(), sk)
+		}
+	})
+}
+
+func FuzzParsePK(f *testing.F) {
+	_, xpk, _ := fuzzxmss()
+	f.Add(xpk.serialize())
+	f.Fuzz(func(t *testing.T, pk []byte) {
+		xpk, err := ParsePK(hex.EncodeToString(pk))
+		if err == nil && !bytes.Equal(xpk.serialize(), pk) {
+			t.Errorf("serialize() = %x, want %x", xpk.serialize(), pk)
+		}
+	})
+}
+
+// slowMTSK reports whether the XMSS^MT private key is of another parameter set
+// than the smallest ones, with n = 32 and trees of height 5. Larger keys make
+// the fuzzer spend its time minimizing each new input.
+func slowMTSK(sk []byte) bool {
+	if len(sk) < 4 {
+		return false
+	}
+	_, xmssty, err := xmssmtparams(strToUint(sk[:4]))
+	return err == nil && (xmssty.n != 32 || xmssty.h != 5)
+}
+
+func FuzzParseMTSK(f *testing.F) {
+	mtsk, _, _ := fuzzxmssmt()
+	f.Add(mtsk.serialize())
+	f.Fuzz(func(t *testing.T, sk []byte) {
+		if slowMTSK(sk) {
+			t.Skip()
+		}
+		mtsk, err := ParseMTSK(hex.EncodeToString(sk))
+		if err == nil && !bytes.Equal(mtsk.serialize(), sk) {
+			t.Errorf("serialize() = %x, want %x", mtsk.serialize(), sk)
+		}
+	})
+}
+
+func FuzzParseMTPK(f *testing.F) {
+	_, mtpk, _ := fuzzxmssmt()
+	f.Add(mtpk.serialize())
+	f.Add(append(toByte(uint64(XMSSMTSHAKEH60D12W512), 4), make([]byte, 128)...))
+	f.Fuzz(func(t *testing.T, pk []byte) {
+		mtpk, err := ParseMTPK(hex.EncodeToString(pk))
+		if err == nil && !bytes.Equal(mtpk.serialize(), pk) {
+			t.Errorf("serialize() = %x, want %x", mtpk.serialize(), pk)
+		}
+	})
+}
+
+func FuzzParseSignature(f *testing.F) {
+	_, _, sig := fuzzxmss()
+	f.Add(uint32(XMSSSHA2H10W256), sig)
+	f.Fuzz(func(t *testing.T, oid uint32, xsig []byte) {
+		sig, err := ParseSignature(uint(oid), xsig)
+		if err != nil {
+			return
+		}
+		if !bytes.Equal(sig.Marshal(), xsig) {
+			t.Errorf("Marshal() = %x, want %x", sig.Marshal(), xsig)
+		}
+		sig.Describe()
+	})
+}
+
+func FuzzParseMTSignature(f *testing.F) {
+	_, _, sig := fuzzxmssmt()
+	f.Add(uint32(XMSSMTSHA2H20D4W256), sig)
+	f.Fuzz(func(t *testing.T, oid uint32, mtsig []byte) {
+		sig, err := ParseMTSignature(uint(oid), mtsig)
+		if err != nil {
+			return
+		}
+		if !bytes.Equal(sig.Marshal(), mtsig) {
+			t.Errorf("Marshal() = %x, want %x", sig.Marshal(), mtsig)
+		}
+		sig.Describe()
+	})
+}
+
+func FuzzVerify(f *testing.F) {
+	_, xpk, sig := fuzzxmss()
+	f.Add(xpk.serialize(), fuzzmessage, sig)
+	f.Fuzz(func(t *testing.T, pk []byte, message []byte, xsig []byte) {
+		if p, err := ParsePK(hex.EncodeToString(pk)); err == nil {
+			xpk = p
+		}
+		xpk.Verify(message, xsig)
+	})
+}
+
+func FuzzMTVerify(f *testing.F) {
+	_, mtpk, sig := fuzzxmssmt()
+	f.Add(mtpk.serialize(), fuzzmessage, sig)
+	f.Fuzz(func(t *testing.T, pk []byte, message []byte, mtsig []byte) {
+		if p, err := ParseMTPK(hex.EncodeToString(pk)); err == nil {
+			mtpk = p
+		}
+		mtpk.Verify(message, mtsig)
+	})
+}
+
+func FuzzParseWOTSPSK(f *testing.F) {
+	wsk, _ := WOTSPGenSK(WOTSPSHA2W256)
+	sk, _ := hex.DecodeString(privatehex(wsk))
+	f.Add(sk)
+	f.Fuzz(func(t *testing.T, sk []byte) {
+		wsk, err := ParseWOTSPSK(hex.EncodeToString(sk))
+		if err == nil && privatehex(wsk) != hex.EncodeToString(sk) {
+			t.Errorf("MarshalPrivate() = %s, want %x", privatehex(wsk), sk)
+		}
+	})
+}
+
+func FuzzWOTSPVerify(f *testing.F) {
+	wsk, _ := WOTSPGenSK(WOTSPSHA2W256)
+	adrs := make([]byte, 32)
+	seed := make([]byte, 32)
+	message := make([]byte, 32)
+	wpk, _ := wsk.Public(adrs, seed)
+	pk, _ := hex.DecodeString(wpk.String())
+	wsig, _ := wsk.Sign(message, adrs, seed)
+	f.Add(pk, message, adrs, wsig)
+	f.Fuzz(func(t *testing.T, pk []byte, message []byte, adrs []byte, wsig []byte) {
+		if p, err := ParseWOTSPPK(hex.EncodeToString(pk)); err == nil {
+			if p.String() != hex.EncodeToString(pk) {
+				t.Errorf("String() = %s, want %x", p.String(), pk)
+			}
+			wpk = p
+		}
+		wpk.Verify(message, adrs, wsig)
+	})
+}
@@ -0,0 +1,175 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xmss
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+)
+
+// Private keys written by String before the BDS traversal held the Treehash
+// stacks of the log traversal instead of the BDS state:
+//
+//	XMSS:    oid (4 bytes) || SK_PRF || idx (4 bytes) || layer (4 bytes) || tree
+//	         || SK_SEED || PUB_SEED
+//	XMSS^MT: oid (4 bytes) || idx (8 bytes) || PUB_SEED || SK_SEED || SK_PRF
+//	         || d * (length (4 bytes) || idx (4 bytes) || tree)
+//	         || d-1 signatures of the roots of the layers below the top
+//
+// where tree is idxtree (4 bytes) || root || authentication path || h * (length
+// (4 bytes) || stack). ParseLegacySK and ParseLegacyMTSK keep only the
+// parameter set, the index, the seeds and the root, and rebuild the key as
+// RestoreSK and RestoreMTSK do.
+
+var errLegacySHAKE = errors.New("xmss: legacy SHAKE keys used 16-byte hashes and cannot be converted; generate a new key")
+
+// ParseLegacySK parses an XMSS private key in the hexadecimal format written by
+// String before the BDS traversal, which ParseSK does not read. The tree is
+// computed again from the seeds, which takes as long as KeyGen, and its root
+// must match the one stored in the key. The key is ready to sign with its next
+// leaf; save it with MarshalPrivate and load it with ParseSK from then on.
+//
+// Keys of the SHAKE parameter sets cannot be converted: their hashes were 16
+// bytes long instead of n.
+func ParseLegacySK(sk string) (*SK, error) {
+	invalid := errors.New("xmss: invalid legacy XMSS private key")
+	skbytes, err := hex.DecodeString(sk)
+	if err != nil {
+		return nil, err
+	}
+	defer zeroize(skbytes)
+	if len(skbytes) < 4 {
+		return nil, invalid
+	}
+	oid := strToUint(skbytes[:4])
+	xmssty, err := xmssparams(oid)
+	if err != nil {
+		return nil, invalid
+	}
+	if xmssty.hsty == shake128 || xmssty.hsty == shake256 {
+		return nil, errLegacySHAKE
+	}
+	n := xmssty.n
+	if len(skbytes) < 4+n+8 {
+		return nil, invalid
+	}
+	skprf := skbytes[4 : 4+n]
+	idx := strToInt(skbytes[4+n : 8+n])
+	if strToInt(skbytes[8+n:12+n]) != 0 {
+		return nil, invalid
+	}
+	root, rest := parseLegacyTree(skbytes[12+n:], n, xmssty.h)
+	if root == nil || len(rest) != 2*n {
+		return nil, invalid
+	}
+	skseed, seed := rest[:n], rest[n:]
+
+	backup := bytes.Join([][]byte{toByte(uint64(oid), 4), toByte(uint64(idx), 4), skseed, skprf, seed}, []byte(""))
+	defer zeroize(backup)
+	xsk, err := RestoreSK(backup)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(xsk.Public().root, root) != 1 {
+		xsk.Destroy()
+		return nil, errors.New("xmss: the root of the legacy XMSS private key does not match its seeds")
+	}
+	return xsk, nil
+}
+
+// ParseLegacyMTSK parses an XMSS^MT private key in the hexadecimal format
+// written by String before the BDS traversal, which ParseMTSK does not read. The
+// trees on the path to the next leaf are computed again from the seeds, as by
+// RestoreMTSK, and the root must match the one stored in the key. Save the key
+// with MarshalPrivate and load it with ParseMTSK from then on.
+//
+// Keys of the SHAKE parameter sets cannot be converted: their hashes were 16
+// bytes long instead of n.
+func ParseLegacyMTSK(sk string) (*MTSK, error) {
+	invalid := errors.New("xmss-mt: invalid legacy XMSS^MT private key")
+	skbytes, err := hex.DecodeString(sk)
+	if err != nil {
+		return nil, err
+	}
+	defer zeroize(skbytes)
+	if len(skbytes) < 4+8 {
+		return nil, invalid
+	}
+	oid := strToUint(skbytes[:4])
+	mtty, xmssty, err := xmssmtparams(oid)
+	if err != nil {
+		return nil, invalid
+	}
+	if xmssty.hsty == shake128 || xmssty.hsty == shake256 {
+		return nil, errLegacySHAKE
+	}
+	n := xmssty.n
+	if len(skbytes) < 4+8+3*n {
+		return nil, invalid
+	}
+	idx := skbytes[4:12]
+	seed := skbytes[12 : 12+n]
+	skseed := skbytes[12+n : 12+2*n]
+	skprf := skbytes[12+2*n : 12+3*n]
+	rest := skbytes[12+3*n:]
+	var root []byte
+	for i := 0; i < mtty.d; i++ {
+		if len(rest) < 4 {
+			return nil, invalid
+		}
+		length := strToInt(rest[:4])
+		rest = rest[4:]
+		if length < 4 || len(rest) < length {
+			return nil, invalid
+		}
+		var tail []byte
+		root, tail = parseLegacyTree(rest[4:length], n, xmssty.h)
+		if root == nil || len(tail) != 0 {
+			return nil, invalid
+		}
+		rest = rest[length:]
+	}
+	if len(rest) != (mtty.d-1)*(xmssty.h+xmssty.l)*n {
+		return nil, invalid
+	}
+
+	backup := bytes.Join([][]byte{toByte(uint64(oid), 4), idx, skseed, skprf, seed}, []byte(""))
+	defer zeroize(backup)
+	mtsk, err := RestoreMTSK(backup)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(mtsk.Public().root, root) != 1 {
+		mtsk.Destroy()
+		return nil, errors.New("xmss-mt: the root of the legacy XMSS^MT private key does not match its seeds")
+	}
+	return mtsk, nil
+}
+
+// parseLegacyTree reads the tree of a legacy private key and returns its root
+// and the bytes that follow it, or a nil root if the tree is malformed.
+func parseLegacyTree(b []byte, n int, h int) ([]byte, []byte) {
+	if len(b) < 4+n+n*h {
+		return nil, nil
+	}
+	root := b[4 : 4+n]
+	b = b[4+n+n*h:]
+	for i := 0; i < h; i++ {
+		if len(b) < 4 {
+			return nil, nil
+		}
+		length := strToInt(b[:4])
+		b = b[4:]
+		// A stack is its number of nodes, height and leaf index (4 bytes each),
+		// followed by the nodes: height and index (4 bytes each) || content.
+		if length < 12 || len(b) < length || strToInt(b[:4])*(8+n) != length-12 {
+			return nil, nil
+		}
+		b = b[length:]
+	}
+	return root, b
+}
@@ -0,0 +1,93 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xmss
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The keys in testdata/legacy were written by String before the BDS traversal:
+// an XMSS-SHA2_5_256 key after 3 signatures and an XMSS^MT-SHA2_20/4_256 key
+// after 40, with the public keys generated alongside them.
+func readLegacy(t *testing.T, name string) (string, string) {
+	sk, err := os.ReadFile(filepath.Join("testdata", "legacy", name+".sk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, err := os.ReadFile(filepath.Join("testdata", "legacy", name+".pk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(sk)), strings.TrimSpace(string(pk))
+}
+
+func TestParseLegacySK(t *testing.T) {
+	sk, pk := readLegacy(t, "xmss-sha2_5_256")
+	if _, err := ParseSK(sk); err == nil {
+		t.Fatal("ParseSK accepted a legacy private key")
+	}
+	xsk, err := ParseLegacySK(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xpk, _ := ParsePK(pk)
+	if xsk.Public().String() != pk || xsk.Remaining() != 32-3 {
+		t.Fatalf("converted key has public key %s and %d signatures left", xsk.Public(), xsk.Remaining())
+	}
+	sig, err := xsk.Sign([]byte("message"))
+	if err != nil || !xpk.Verify([]byte("message"), sig) {
+		t.Fatalf("converted key does not sign for its public key: %v", err)
+	}
+	if strToInt(sig[:4]) != 3 {
+		t.Error("converted key does not sign with the next leaf")
+	}
+	reparsed, err := ParseSK(privatehex(xsk))
+	if err != nil || reparsed.Remaining() != xsk.Remaining() {
+		t.Errorf("converted key does not parse with ParseSK: %v", err)
+	}
+
+	// A changed SK_SEED no longer yields the stored root, and a truncated key
+	// is refused.
+	modified := []byte(sk)
+	modified[len(modified)-64-1] ^= 1
+	if _, err := ParseLegacySK(string(modified)); err == nil {
+		t.Error("converted a legacy key whose seed does not match its root")
+	}
+	if _, err := ParseLegacySK(sk[:len(sk)-2]); err == nil {
+		t.Error("converted a truncated legacy key")
+	}
+	shake := "00000" + "00f" + sk[8:]
+	if _, err := ParseLegacySK(shake); err != errLegacySHAKE {
+		t.Errorf("legacy SHAKE key: %v", err)
+	}
+}
+
+func TestParseLegacyMTSK(t *testing.T) {
+	sk, pk := readLegacy(t, "xmssmt-sha2_20-4_256")
+	if _, err := ParseMTSK(sk); err == nil {
+		t.Fatal("ParseMTSK accepted a legacy private key")
+	}
+	mtsk, err := ParseLegacyMTSK(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mtpk, _ := ParseMTPK(pk)
+	if mtsk.Public().String() != pk || mtsk.Remaining() != 1<<20-40 {
+		t.Fatalf("converted key has public key %s and %d signatures left", mtsk.Public(), mtsk.Remaining())
+	}
+	sig, err := mtsk.Sign([]byte("message"))
+	if err != nil || !mtpk.Verify([]byte("message"), sig) {
+		t.Fatalf("converted key does not sign for its public key: %v", err)
+	}
+	if _, err := ParseMTSK(privatehex(mtsk)); err != nil {
+		t.Errorf("converted key does not parse with ParseMTSK: %v", err)
+	}
+	if _, err := ParseLegacyMTSK(sk[:len(sk)-2]); err == nil {
+		t.Error("converted a truncated legacy key")
+	}
+}
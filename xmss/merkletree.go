@@ -6,129 +6,95 @@ package xmss
 
 import (
 	"bytes"
-	"math"
-)
 
-type node struct {
-	content []byte
-	height  int
-	idx     int
-}
+	"github.com/lingyunzhao/pqcrypto/merkle"
+)
 
-func (nd *node) serialize() []byte {
-	return bytes.Join([][]byte{toByte(uint64(nd.height), 4), toByte(uint64(nd.idx), 4), nd.content}, []byte(""))
+// defaultK returns the BDS parameter used when none is given.
+func defaultK(height int) int {
+	return merkle.DefaultK(height)
 }
 
-func parsenode(ndbytes []byte) *node {
-	nd := new(node)
-	nd.height = strToInt(ndbytes[:4])
-	nd.idx = strToInt(ndbytes[4:8])
-	nd.content = make([]byte, len(ndbytes[8:]))
-	copy(nd.content, ndbytes[8:])
-	return nd
+func validK(height int, k int) bool {
+	return merkle.ValidK(height, k)
 }
 
-type stack struct {
-	nodes   []*node
-	height  int
-	leafidx int
+// An xmsstree is a single XMSS tree of an XMSS or XMSS^MT private key, with
+// the state of its authentication path traversal.
+type xmsstree struct {
+	height    int
+	idx       int
+	hsty      int
+	layer     int
+	idxtree   uint64
+	wotspty   uint
+	root      []byte
+	skseed    []byte
+	seed      []byte
+	bds       *merkle.BDS
+	seedprf   *prfKey
+	skseedprf *prfKey
 }
 
-func (s *stack) serialize() []byte {
-	nds := []byte{}
-	for i := 0; i < len(s.nodes); i++ {
-		nds = append(nds, s.nodes[i].serialize()...)
+// reducedSK serializes a tree of an XMSS^MT private key. The tree index takes 8
+// bytes, as there are up to 2^55 trees on a layer. A tree under construction
+// has a zero root.
+func (mt *xmsstree) reducedSK() []byte {
+	n := len(mt.seed)
+	root := mt.root
+	if root == nil {
+		root = make([]byte, n)
 	}
-	return bytes.Join([][]byte{toByte(uint64(len(s.nodes)), 4), toByte(uint64(s.height), 4), toByte(uint64(s.leafidx), 4), nds}, []byte(""))
+	return bytes.Join([][]byte{toByte(uint64(mt.idx), 4), toByte(mt.idxtree, 8),
+		root, mt.bds.Marshal(n)}, []byte(""))
 }
 
-func parsestack(sbytes []byte, n int) *stack {
-	ndlen := strToInt(sbytes[:4])
-	s := new(stack)
-	s.nodes = make([]*node, ndlen)
-	s.height = strToInt(sbytes[4:8])
-	s.leafidx = strToInt(sbytes[8:12])
-	n += 8
-	if n*ndlen != len(sbytes[12:]) {
-		return nil
-	}
-	for i := 0; i < ndlen; i++ {
-		s.nodes[i] = parsenode(sbytes[12+n*i : 12+n*(i+1)])
-	}
-	return s
+func (mt *xmsstree) serialize() []byte {
+	return bytes.Join([][]byte{toByte(uint64(mt.idx), 4), toByte(uint64(mt.layer), 4), toByte(mt.idxtree, 4),
+		mt.root, mt.bds.Marshal(len(mt.root)), mt.skseed, mt.seed}, []byte(""))
 }
 
-type merkle struct {
-	height   int
-	idx      int
-	hsty     int
-	layer    int
-	idxtree  int
-	wotspty  uint
-	root     []byte
-	skseed   []byte
-	seed     []byte
-	authpath [][]byte
-	stacks   []*stack
-}
-
-func (mt *merkle) reducedSK() []byte {
-	ss := make([]byte, 0)
-	for i := 0; i < len(mt.stacks); i++ {
-		tmps := mt.stacks[i].serialize()
-		ss = append(ss, toByte(uint64(len(tmps)), 4)...)
-		ss = append(ss, tmps...)
-	}
-	return bytes.Join([][]byte{toByte(uint64(mt.idx), 4), toByte(uint64(mt.idxtree), 4),
-		mt.root, twoDto1D(mt.authpath), ss}, []byte(""))
-}
-
-func (mt *merkle) serialize() []byte {
-	ss := make([]byte, 0)
-	for i := 0; i < len(mt.stacks); i++ {
-		tmps := mt.stacks[i].serialize()
-		ss = append(ss, toByte(uint64(len(tmps)), 4)...)
-		ss = append(ss, tmps...)
+// destroy clears the secret seed of the tree and the PRF keyed with it.
+func (mt *xmsstree) destroy() {
+	zeroize(mt.skseed)
+	mt.skseed = nil
+	if mt.skseedprf != nil {
+		mt.skseedprf.destroy()
 	}
-	return bytes.Join([][]byte{toByte(uint64(mt.idx), 4), toByte(uint64(mt.layer), 4), toByte(uint64(mt.idxtree), 4),
-		mt.root, twoDto1D(mt.authpath), ss, mt.skseed, mt.seed}, []byte(""))
+	mt.bds = nil
 }
 
+// parseReducedSK parses a tree serialized by reducedSK.
 func parseReducedSK(mtbytes []byte, layer int, skseed []byte, seed []byte, skprf []byte, xmssty uint) *SK {
 	wotspty := xmsstowotsp(xmssty)
 	n := xmsstypes[xmssty].n
 	h := xmsstypes[xmssty].h
 	hsty := xmsstypes[xmssty].hsty
-	if len(mtbytes) < 4+4+4+n+n*h {
+	if len(mtbytes) < 4+8+n {
 		return nil
 	}
-	mt := new(merkle)
+	mt := new(xmsstree)
 	mt.idx = strToInt(mtbytes[:4])
 	mtbytes = mtbytes[4:]
 	mt.layer = layer
-	mt.idxtree = strToInt(mtbytes[:4])
-	mtbytes = mtbytes[4:]
+	mt.idxtree = strToUint64(mtbytes[:8])
+	mtbytes = mtbytes[8:]
 	mt.root = make([]byte, n)
 	copy(mt.root, mtbytes[:n])
 	mtbytes = mtbytes[n:]
-	mt.authpath = oneDto2D(mtbytes[:n*h], h, n)
-	mtbytes = mtbytes[n*h:]
 
-	mt.stacks = make([]*stack, h)
-	for i := 0; i < h; i++ {
-		if len(mtbytes) < 4 {
-			return nil
-		}
-		slen := strToInt(mtbytes[:4])
-		mtbytes = mtbytes[4:]
-		if len(mtbytes) < slen {
-			return nil
-		}
-		mt.stacks[i] = parsestack(mtbytes[:slen], n)
-		if mt.stacks[i] == nil {
+	var read int
+	var err error
+	mt.bds, read, err = merkle.ParseBDS(mtbytes, n, h)
+	if err != nil || read != len(mtbytes) {
+		return nil
+	}
+	if !mt.bds.Initialized() {
+		// The root of a tree under construction is written as zeros.
+		if !bytes.Equal(mt.root, make([]byte, n)) {
 			return nil
 		}
-		mtbytes = mtbytes[slen:]
+		mt.root = nil
 	}
 	mt.skseed = make([]byte, n)
 	copy(mt.skseed, skseed)
@@ -138,6 +104,8 @@ func parseReducedSK(mtbytes []byte, layer int, skseed []byte, seed []byte, skprf
 	mt.hsty = hsty
 	mt.wotspty = wotspty
 	mt.height = h
+	mt.seedprf = newPRFKey(mt.seed, hsty)
+	mt.skseedprf = newPRFKey(mt.skseed, hsty)
 
 	xsk := new(SK)
 	xsk.oid = xmssty
@@ -147,39 +115,28 @@ func parseReducedSK(mtbytes []byte, layer int, skseed []byte, seed []byte, skprf
 	return xsk
 }
 
-func parsemerkle(mtbytes []byte, n int, h int, hsty int, wotspty uint) *merkle {
-	if len(mtbytes) < 4+4+4+n+n*h {
+func parsemerkle(mtbytes []byte, n int, h int, hsty int, wotspty uint) *xmsstree {
+	if len(mtbytes) < 4+4+4+n {
 		return nil
 	}
-	mt := new(merkle)
+	mt := new(xmsstree)
 	mt.idx = strToInt(mtbytes[:4])
 	mtbytes = mtbytes[4:]
 	mt.layer = strToInt(mtbytes[:4])
 	mtbytes = mtbytes[4:]
-	mt.idxtree = strToInt(mtbytes[:4])
+	mt.idxtree = uint64(strToUint(mtbytes[:4]))
 	mtbytes = mtbytes[4:]
 	mt.root = make([]byte, n)
 	copy(mt.root, mtbytes[:n])
 	mtbytes = mtbytes[n:]
-	mt.authpath = oneDto2D(mtbytes[:n*h], h, n)
-	mtbytes = mtbytes[n*h:]
 
-	mt.stacks = make([]*stack, h)
-	for i := 0; i < h; i++ {
-		if len(mtbytes) < 4 {
-			return nil
-		}
-		slen := strToInt(mtbytes[:4])
-		mtbytes = mtbytes[4:]
-		if len(mtbytes) < slen {
-			return nil
-		}
-		mt.stacks[i] = parsestack(mtbytes[:slen], n)
-		if mt.stacks[i] == nil {
-			return nil
-		}
-		mtbytes = mtbytes[slen:]
+	var read int
+	var err error
+	mt.bds, read, err = merkle.ParseBDS(mtbytes, n, h)
+	if err != nil {
+		return nil
 	}
+	mtbytes = mtbytes[read:]
 	if len(mtbytes) != 2*n {
 		return nil
 	}
@@ -192,11 +149,15 @@ func parsemerkle(mtbytes []byte, n int, h int, hsty int, wotspty uint) *merkle {
 	mt.hsty = hsty
 	mt.wotspty = wotspty
 	mt.height = h
+	mt.seedprf = newPRFKey(mt.seed, hsty)
+	mt.skseedprf = newPRFKey(mt.skseed, hsty)
 	return mt
 }
 
-func genMTree(height int, skseed []byte, seed []byte, hsty int, wotspty uint, layer int, idxtree int) *merkle {
-	mt := new(merkle)
+// newMTree returns a tree that has not been computed yet. Its leaves are added
+// by bds.InitStep or complete, and its root is nil until complete is called.
+func newMTree(height int, k int, skseed []byte, seed []byte, hsty int, wotspty uint, layer int, idxtree uint64) *xmsstree {
+	mt := new(xmsstree)
 	mt.height = height
 	mt.skseed = make([]byte, len(skseed))
 	copy(mt.skseed, skseed)
@@ -207,135 +168,73 @@ func genMTree(height int, skseed []byte, seed []byte, hsty int, wotspty uint, la
 	mt.idx = 0
 	mt.layer = layer
 	mt.idxtree = idxtree
-	mt.stacks = make([]*stack, height)
-	mt.authpath = make([][]byte, height)
-	s := new(stack)
-	s.nodes = make([]*node, 0)
-	s.height = height
-	s.leafidx = 0
-	for i := 0; i < height; i++ {
-		s.update(1, skseed, seed, hsty, wotspty, layer, idxtree)
-		mt.stacks[i] = new(stack)
-		mt.stacks[i].height = i
-		mt.stacks[i].leafidx = 1 << uint(i)
-		mt.stacks[i].nodes = make([]*node, 0)
-		mt.stacks[i].push(s.top())
-		s.update(1<<uint(i+1)-1, skseed, seed, hsty, wotspty, layer, idxtree)
-		mt.authpath[i] = s.top().content
-	}
-	s.update(1, skseed, seed, hsty, wotspty, layer, idxtree)
-	mt.root = s.top().content
+	mt.seedprf = newPRFKey(mt.seed, hsty)
+	mt.skseedprf = newPRFKey(mt.skseed, hsty)
+	mt.bds, _ = merkle.NewBDS(height, k)
 	return mt
 }
 
-func (mt *merkle) refresh() {
-	for i := 0; i < mt.height; i++ {
-		if ((mt.idx+1)/pow2(i))*pow2(i) == (mt.idx + 1) {
-			copy(mt.authpath[i], mt.stacks[i].top().content)
-			startnode := ((mt.idx + 1) + pow2(i)) ^ pow2(i)
-			mt.stacks[i].init(startnode, i)
-		}
+// complete adds the leaves the tree still lacks and sets its root.
+func (mt *xmsstree) complete() {
+	if mt.root == nil {
+		mt.root = mt.bds.Init(xmssHasher{mt})
 	}
 }
 
-func (mt *merkle) build() {
-	for i := 0; i < 2*mt.height-1; i++ {
-		min := math.MaxInt32
-		focus := 0
-		for h := 0; h < mt.height; h++ {
-			low := mt.stacks[h].low()
-			if low < min {
-				min = low
-				focus = h
-			}
-		}
-		mt.stacks[focus].update(1, mt.skseed, mt.seed, mt.hsty, mt.wotspty, mt.layer, mt.idxtree)
-	}
-}
-
-func (mt *merkle) traversal() {
-	mt.refresh()
-	mt.build()
-	mt.idx++
-}
-
-func (s *stack) init(startnode int, height int) {
-	s.leafidx = startnode
-	s.height = height
-	s.nodes = s.nodes[:0]
-}
-
-func (s *stack) push(nd *node) {
-	s.nodes = append(s.nodes, nd)
-}
-
-func (s *stack) pop() *node {
-	nd := s.nodes[len(s.nodes)-1]
-	s.nodes = s.nodes[:len(s.nodes)-1]
-	return nd
+func (mt *xmsstree) leaf(idx int) []byte {
+	wadrs := toByte(0, addrlen)
+	set(wadrs, otsAddr, addrtype)
+	set(wadrs, int64(idx), otsaddr)
+	set(wadrs, int64(mt.layer), layeraddr)
+	set(wadrs, int64(mt.idxtree), treeaddr)
+	wsk, _ := wotspGenSK(getseed(mt.skseedprf, wadrs), mt.wotspty)
+	wpk := wsk.wotspGenPK(wadrs, mt.seed)
+	wsk.Destroy()
+	set(wadrs, ltreeAddr, addrtype)
+	set(wadrs, int64(idx), ltreeaddr)
+	return wpk.ltree(wadrs, mt.seedprf)
 }
 
-func (s *stack) top() *node {
-	return s.nodes[len(s.nodes)-1]
+func (mt *xmsstree) node(left []byte, right []byte, height int, idx int) []byte {
+	adrs := toByte(0, addrlen)
+	set(adrs, hashtreeAddr, addrtype)
+	set(adrs, int64(mt.layer), layeraddr)
+	set(adrs, int64(mt.idxtree), treeaddr)
+	set(adrs, int64(height-1), treeheight)
+	set(adrs, int64(idx), treeindex)
+	return randhash(left, right, mt.seedprf, adrs)
 }
 
-func (s *stack) nexttop() *node {
-	return s.nodes[len(s.nodes)-2]
+func (mt *xmsstree) traversal() {
+	mt.bds.Next(mt.idx, xmssHasher{mt})
+	mt.idx++
 }
 
-func (s *stack) low() int {
-	if len(s.nodes) == 0 {
-		return s.height
+// advance moves the tree forward to leaf idx, which may be one past the last
+// leaf.
+func (mt *xmsstree) advance(idx int) error {
+	to := idx
+	if to > pow2(mt.height)-1 {
+		to = pow2(mt.height) - 1
 	}
-	if s.top().height == s.height {
-		return math.MaxInt32
-	}
-	min := math.MaxInt32
-	for i := 0; i < len(s.nodes); i++ {
-		if s.nodes[i].height < min {
-			min = s.nodes[i].height
+	if to > mt.idx {
+		if err := mt.bds.Advance(mt.idx, to, xmssHasher{mt}); err != nil {
+			return err
 		}
 	}
-	return min
+	mt.idx = idx
+	return nil
 }
 
-func (s *stack) update(n int, skseed []byte, seed []byte, hsty int, wotspty uint, layer int, idxtree int) {
-	if len(s.nodes) > 0 && s.top().height == s.height {
-		return
-	}
+// xmssHasher computes the leaves and interior nodes of an XMSS tree.
+type xmssHasher struct {
+	mt *xmsstree
+}
 
-	adrs := toByte(0, addrlen)
-	set(adrs, hashtreeAddr, addrtype)
-	set(adrs, int64(layer), layeraddr)
-	set(adrs, int64(idxtree), treeaddr)
-	for i := 0; i < n; i++ {
-		if len(s.nodes) >= 2 && s.nexttop().height == s.top().height {
-			right := s.pop()
-			left := s.pop()
-			nd := new(node)
-			nd.idx = right.idx >> 1
-			nd.height = right.height + 1
-			set(adrs, int64(right.height), treeheight)
-			set(adrs, int64(nd.idx), treeindex)
-			nd.content = randhash(left.content, right.content, seed, adrs, hsty)
-			s.push(nd)
-			continue
-		}
-		wadrs := toByte(0, addrlen)
-		set(wadrs, otsAddr, addrtype)
-		set(wadrs, int64(s.leafidx), otsaddr)
-		set(wadrs, int64(layer), layeraddr)
-		set(wadrs, int64(idxtree), treeaddr)
-		wsk, _ := wotspGenSK(getseed(skseed, wadrs, hsty), wotspty)
-		wpk := wsk.wotspGenPK(wadrs, seed)
-		set(wadrs, ltreeAddr, addrtype)
-		set(wadrs, int64(s.leafidx), ltreeaddr)
-		ndcontent := wpk.ltree(wadrs)
-		lnd := new(node)
-		lnd.content = ndcontent
-		lnd.idx = s.leafidx
-		lnd.height = 0
-		s.push(lnd)
-		s.leafidx++
-	}
+func (t xmssHasher) Leaf(idx int) []byte {
+	return t.mt.leaf(idx)
+}
+
+func (t xmssHasher) Node(left []byte, right []byte, height int, idx int) []byte {
+	return t.mt.node(left, right, height, idx)
 }
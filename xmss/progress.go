@@ -0,0 +1,63 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xmss
+
+import (
+	"context"
+)
+
+// Progress receives the progress of a key generation: the number of leaves
+// computed so far and the total number of leaves of the trees the key needs.
+// It is called after every leaf, from the goroutine that generates the key,
+// and should return quickly.
+type Progress func(done uint64, total uint64)
+
+// A keygen computes the trees of a new private key, stopping once its context
+// is done and reporting every leaf to its Progress function. If it has a
+// Checkpoint function, it passes it the state of the generation every interval
+// leaves and when the context is done.
+type keygen struct {
+	ctx        context.Context
+	done       uint64
+	total      uint64
+	progress   Progress
+	interval   uint64
+	checkpoint Checkpoint
+	// state encodes the checkpoint of the generation.
+	state func() []byte
+}
+
+// build computes the remaining leaves of a tree and sets its root.
+func (g *keygen) build(mt *xmsstree) error {
+	if mt.root != nil {
+		return nil
+	}
+	root, err := mt.bds.InitContext(g.ctx, xmssHasher{mt}, func() error {
+		g.done++
+		if g.progress != nil {
+			g.progress(g.done, g.total)
+		}
+		if g.interval > 0 && g.done%g.interval == 0 && !mt.bds.Initialized() {
+			return g.save()
+		}
+		return nil
+	})
+	if err != nil {
+		if err == g.ctx.Err() {
+			g.save()
+		}
+		return err
+	}
+	mt.root = root
+	return nil
+}
+
+// save passes the state of the generation to the Checkpoint function.
+func (g *keygen) save() error {
+	if g.checkpoint == nil {
+		return nil
+	}
+	return g.checkpoint(g.state())
+}
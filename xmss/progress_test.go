@@ -0,0 +1,61 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xmss
+
+import (
+	"context"
+	"testing"
+)
+
+func TestKeyGenWithContext(t *testing.T) {
+	var calls, last, total uint64
+	progress := func(done uint64, all uint64) {
+		calls++
+		if done != last+1 {
+			t.Fatalf("progress went from %d to %d", last, done)
+		}
+		last, total = done, all
+	}
+	xsk, xpk, err := KeyGenWithContext(context.Background(), xmssSHA2H5W256, progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 32 || last != 32 || total != 32 {
+		t.Errorf("XMSS progress ended at %d/%d after %d calls, want 32/32", last, total, calls)
+	}
+	sig, _ := xsk.Sign([]byte("message"))
+	if !xpk.Verify([]byte("message"), sig) {
+		t.Error("invalid signature")
+	}
+
+	calls, last = 0, 0
+	mtsk, mtpk, err := MTkeyGenWithContext(context.Background(), XMSSMTSHA2H20D4W256, progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 128 || last != 128 || total != 128 {
+		t.Errorf("XMSS^MT progress ended at %d/%d after %d calls, want 128/128", last, total, calls)
+	}
+	sig, _ = mtsk.Sign([]byte("message"))
+	if !mtpk.Verify([]byte("message"), sig) {
+		t.Error("invalid signature")
+	}
+}
+
+func TestKeyGenWithContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var done uint64
+	_, _, err := MTkeyGenWithContext(ctx, XMSSMTSHA2H20D4W256, func(d uint64, total uint64) {
+		if done = d; d == 40 {
+			cancel()
+		}
+	})
+	if err != context.Canceled || done != 40 {
+		t.Errorf("generation returned %v after %d leaves, want context.Canceled after 40", err, done)
+	}
+	if _, _, err := KeyGenWithContext(ctx, xmssSHA2H5W256, nil); err != context.Canceled {
+		t.Errorf("generation with a canceled context returned %v", err)
+	}
+}
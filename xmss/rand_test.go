@@ -0,0 +1,92 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xmss
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"strings"
+	"testing"
+)
+
+// A testrand is a deterministic stream of SHA-256(seed || counter) blocks.
+type testrand struct {
+	seed    string
+	counter uint64
+	buf     []byte
+}
+
+func newtestrand(seed string) *testrand {
+	return &testrand{seed: seed}
+}
+
+func (r *testrand) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(r.buf) == 0 {
+			block := sha256.Sum256(append([]byte(r.seed), toByte(r.counter, 8)...))
+			r.buf = block[:]
+			r.counter++
+		}
+		c := copy(p[n:], r.buf)
+		r.buf = r.buf[c:]
+		n += c
+	}
+	return n, nil
+}
+
+func TestKeyGenWithRand(t *testing.T) {
+	// The seeds are read in the order PUB_SEED, SK_SEED, SK_PRF.
+	seed := bytes.Repeat([]byte{1}, 32)
+	skseed := bytes.Repeat([]byte{2}, 32)
+	skprf := bytes.Repeat([]byte{3}, 32)
+	input := bytes.Join([][]byte{seed, skseed, skprf}, nil)
+	xsk, xpk, err := KeyGenWithRand(xmssSHA2H5W256, bytes.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(xpk.seed, seed) || !bytes.Equal(xsk.mt.skseed, skseed) || !bytes.Equal(xsk.skprf, skprf) {
+		t.Error("KeyGenWithRand read the seeds in the wrong order")
+	}
+	mtsk, mtpk, err := MTkeyGenWithRand(XMSSMTSHA2H20D4W256, bytes.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(mtpk.seed, seed) || !bytes.Equal(mtsk.skseed, skseed) || !bytes.Equal(mtsk.skprf, skprf) {
+		t.Error("MTkeyGenWithRand read the seeds in the wrong order")
+	}
+
+	xsk1, _, _ := KeyGenWithRand(xmssSHAKEH5W256, newtestrand("xmss"))
+	xsk2, _, _ := KeyGenWithRand(xmssSHAKEH5W256, newtestrand("xmss"))
+	xsk3, _, _ := KeyGenWithRand(xmssSHAKEH5W256, newtestrand("other"))
+	if privatehex(xsk1) != privatehex(xsk2) || privatehex(xsk1) == privatehex(xsk3) {
+		t.Error("XMSS private keys do not depend on the input only")
+	}
+	mtsk1, _, _ := MTkeyGenWithRand(XMSSMTSHA2H20D4W256, newtestrand("xmssmt"))
+	mtsk2, _, _ := MTkeyGenWithRand(XMSSMTSHA2H20D4W256, newtestrand("xmssmt"))
+	if privatehex(mtsk1) != privatehex(mtsk2) {
+		t.Error("the same input yielded different XMSS^MT private keys")
+	}
+
+	wsk, _ := WOTSPGenSKWithRand(WOTSPSHA2W256, bytes.NewReader(seed))
+	want, _ := WOTSPSKFromSeed(WOTSPSHA2W256, seed)
+	if privatehex(wsk) != privatehex(want) {
+		t.Error("WOTSPGenSKWithRand does not match WOTSPSKFromSeed")
+	}
+}
+
+func TestKeyGenWithShortRand(t *testing.T) {
+	short := func() io.Reader { return strings.NewReader("too short") }
+	if _, _, err := KeyGenWithRand(xmssSHA2H5W256, short()); err == nil {
+		t.Error("KeyGenWithRand succeeded with a short input")
+	}
+	if _, _, err := MTkeyGenWithRand(XMSSMTSHA2H20D4W256, short()); err == nil {
+		t.Error("MTkeyGenWithRand succeeded with a short input")
+	}
+	if _, err := WOTSPGenSKWithRand(WOTSPSHA2W256, short()); err == nil {
+		t.Error("WOTSPGenSKWithRand succeeded with a short input")
+	}
+}
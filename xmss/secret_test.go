@@ -0,0 +1,121 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xmss
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// privatehex serializes a private key to the hexadecimal form read by the Parse functions.
+func privatehex(key interface{ MarshalPrivate() ([]byte, error) }) string {
+	b, _ := key.MarshalPrivate()
+	return hex.EncodeToString(b)
+}
+
+func TestPrivateKeysAreRedacted(t *testing.T) {
+	xsk, _, _ := KeyGen(xmssSHA2H5W256)
+	mtsk, _, _ := MTkeyGen(XMSSMTSHA2H20D4W256)
+	wsk, _ := WOTSPGenSK(WOTSPSHAKEW256)
+
+	keys := []struct {
+		key    interface{}
+		secret []byte
+	}{
+		{xsk, xsk.skprf},
+		{xsk, xsk.mt.skseed},
+		{mtsk, mtsk.skseed},
+		{wsk, wsk.seed},
+	}
+	for _, k := range keys {
+		if _, ok := k.key.(fmt.Stringer); ok {
+			t.Errorf("%T has a String method", k.key)
+		}
+		for _, verb := range []string{"%v", "%+v", "%#v", "%s", "%x", "%X", "%q"} {
+			out := fmt.Sprintf(verb, k.key)
+			if !strings.Contains(out, "REDACTED") ||
+				strings.Contains(strings.ToLower(out), hex.EncodeToString(k.secret)) {
+				t.Errorf("%T formatted with %s as %q", k.key, verb, out)
+			}
+		}
+	}
+}
+
+func TestDestroy(t *testing.T) {
+	xsk, _, _ := KeyGen(xmssSHA2H5W256)
+	mtsk, _, _ := MTkeyGen(XMSSMTSHA2H20D4W256)
+	wsk, _ := WOTSPGenSK(WOTSPSHA2W256)
+	secrets := [][]byte{xsk.skprf, xsk.mt.skseed, xsk.mt.skseedprf.state,
+		mtsk.skseed, mtsk.skprf, mtsk.xsk[0].mt.skseed, wsk.seed, wsk.sk[0]}
+
+	keys := []interface {
+		Sign([]byte) ([]byte, error)
+		MarshalPrivate() ([]byte, error)
+		Destroy()
+	}{xsk, mtsk}
+	for _, key := range keys {
+		key.Destroy()
+		if _, err := key.Sign([]byte("message")); err == nil {
+			t.Errorf("%T signed after Destroy", key)
+		}
+		if _, err := key.MarshalPrivate(); err == nil {
+			t.Errorf("%T was serialized after Destroy", key)
+		}
+	}
+	wsk.Destroy()
+	if _, err := wsk.Sign(make([]byte, 32), make([]byte, 32), make([]byte, 32)); err == nil {
+		t.Error("WOTS+ private key signed after Destroy")
+	}
+	if _, err := wsk.MarshalPrivate(); err == nil {
+		t.Error("WOTS+ private key was serialized after Destroy")
+	}
+	for i, secret := range secrets {
+		if !bytes.Equal(secret, make([]byte, len(secret))) {
+			t.Errorf("secret %d was not cleared", i)
+		}
+	}
+}
+
+func TestSignerDestroy(t *testing.T) {
+	mtsk, mtpk, _ := MTkeyGen(XMSSMTSHA2H20D4W256)
+	s := NewMTSigner(mtsk)
+
+	// A leaf reserved before Destroy still produces a valid signature.
+	l, err := s.reserve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Destroy()
+	sig, err := mtsk.signLeaf(l, bytesmsg([]byte("message")))
+	if err != nil || !mtpk.Verify([]byte("message"), sig) {
+		t.Errorf("reserved leaf failed after Destroy: %v", err)
+	}
+	if _, err := s.Sign([]byte("message")); err == nil {
+		t.Error("signer signed after Destroy")
+	}
+	if _, err := s.MarshalPrivate(); err == nil {
+		t.Error("signer serialized the key after Destroy")
+	}
+}
+
+func TestSignedLeafIsCleared(t *testing.T) {
+	xsk, _, _ := KeyGen(xmssSHA2H5W256)
+	l, _ := xsk.reserve()
+	secrets := [][]byte{l.skprf, l.skseedprf.state}
+	if _, err := xsk.signLeaf(l, bytesmsg([]byte("message"))); err != nil {
+		t.Fatal(err)
+	}
+	for i, secret := range secrets {
+		if !bytes.Equal(secret, make([]byte, len(secret))) {
+			t.Errorf("secret %d of the leaf was not cleared after signing", i)
+		}
+	}
+	if _, err := xsk.Sign([]byte("message")); err != nil {
+		t.Errorf("the private key was cleared with its leaf: %v", err)
+	}
+}
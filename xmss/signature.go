@@ -0,0 +1,176 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xmss
+
+import (
+	"bytes"
+	"errors"
+)
+
+// A Signature represents an XMSS signature.
+type Signature struct {
+	oid uint
+	idx int
+	r   []byte
+	ReducedSignature
+}
+
+// A ReducedSignature is the WOTS+ signature and authentication path of one
+// XMSS tree, without index and randomness.
+type ReducedSignature struct {
+	wots     [][]byte
+	authpath [][]byte
+}
+
+// A MTSignature represents an XMSS^MT signature.
+type MTSignature struct {
+	oid    uint
+	idx    uint64
+	r      []byte
+	layers []*ReducedSignature
+}
+
+// ParseSignature parses an XMSS signature of type oid. The signature must
+// contain no trailing data.
+func ParseSignature(oid uint, xsig []byte) (*Signature, error) {
+	xmssty, err := xmssparams(oid)
+	if err != nil {
+		return nil, err
+	}
+	n := xmssty.n
+	l := xmssty.l
+	h := xmssty.h
+	if len(xsig) != 4+n+l*n+h*n {
+		return nil, errors.New("xmss: invalid XMSS signature")
+	}
+	idx := strToInt(xsig[:4])
+	if idx < 0 || idx >= pow2(h) {
+		return nil, errors.New("xmss: invalid XMSS signature")
+	}
+	buf := make([]byte, len(xsig))
+	copy(buf, xsig)
+	sig := new(Signature)
+	sig.oid = oid
+	sig.idx = idx
+	sig.r = buf[4 : 4+n]
+	sig.ReducedSignature = *parseReducedSignature(buf[4+n:], n, l, h)
+	return sig, nil
+}
+
+func parseReducedSignature(b []byte, n int, l int, h int) *ReducedSignature {
+	return &ReducedSignature{oneDto2D(b[:l*n], l, n), oneDto2D(b[l*n:(l+h)*n], h, n)}
+}
+
+// ParseMTSignature parses an XMSS^MT signature of type oid. The signature must
+// contain no trailing data.
+func ParseMTSignature(oid uint, mtsig []byte) (*MTSignature, error) {
+	mtty, xmssty, err := xmssmtparams(oid)
+	if err != nil {
+		return nil, err
+	}
+	d := mtty.d
+	n := xmssty.n
+	l := xmssty.l
+	xh := xmssty.h
+	idxlen := ceil(float64(d*xh) / 8)
+	if len(mtsig) != idxlen+n+(xh+l)*n*d {
+		return nil, errors.New("xmss-mt: invalid XMSS^MT signature")
+	}
+	idx := strToUint64(mtsig[:idxlen])
+	if d*xh < 64 && idx >= 1<<uint(d*xh) {
+		return nil, errors.New("xmss-mt: invalid XMSS^MT signature")
+	}
+	buf := make([]byte, len(mtsig))
+	copy(buf, mtsig)
+	sig := new(MTSignature)
+	sig.oid = oid
+	sig.idx = idx
+	sig.r = buf[idxlen : idxlen+n]
+	buf = buf[idxlen+n:]
+	for i := 0; i < d; i++ {
+		sig.layers = append(sig.layers, parseReducedSignature(buf[(xh+l)*n*i:], n, l, xh))
+	}
+	return sig, nil
+}
+
+// Marshal serializes the signature.
+func (sig *Signature) Marshal() []byte {
+	return bytes.Join([][]byte{toByte(uint64(sig.idx), 4), sig.r, sig.ReducedSignature.Marshal()}, []byte(""))
+}
+
+// Marshal serializes the reduced signature.
+func (sig *ReducedSignature) Marshal() []byte {
+	return append(twoDto1D(sig.wots), twoDto1D(sig.authpath)...)
+}
+
+// Marshal serializes the signature.
+func (sig *MTSignature) Marshal() []byte {
+	h := xmssmttypes[sig.oid].d * xmsstypes[xmssmttypes[sig.oid].xmssty].h
+	buf := append(toByte(sig.idx, ceil(float64(h)/8)), sig.r...)
+	for _, layer := range sig.layers {
+		buf = append(buf, layer.Marshal()...)
+	}
+	return buf
+}
+
+// Oid returns the XMSS type of the signature.
+func (sig *Signature) Oid() uint {
+	return sig.oid
+}
+
+// Idx returns the index of the leaf that made the signature.
+func (sig *Signature) Idx() int {
+	return sig.idx
+}
+
+// R returns the randomness used for the message hash.
+func (sig *Signature) R() []byte {
+	return sig.r
+}
+
+// WOTS returns the len n-byte strings of the WOTS+ signature.
+func (sig *ReducedSignature) WOTS() [][]byte {
+	return sig.wots
+}
+
+// AuthPath returns the authentication path from the leaf to the root, starting
+// at the leaf.
+func (sig *ReducedSignature) AuthPath() [][]byte {
+	return sig.authpath
+}
+
+// Oid returns the XMSS^MT type of the signature.
+func (sig *MTSignature) Oid() uint {
+	return sig.oid
+}
+
+// Idx returns the index of the leaf that made the signature, counted over all
+// bottom layer trees.
+func (sig *MTSignature) Idx() uint64 {
+	return sig.idx
+}
+
+// R returns the randomness used for the message hash.
+func (sig *MTSignature) R() []byte {
+	return sig.r
+}
+
+// Layers returns the reduced XMSS signatures of the d layers, starting with the
+// bottom layer. The bottom one signs the message and the others sign the root
+// of the tree below.
+func (sig *MTSignature) Layers() []*ReducedSignature {
+	return sig.layers
+}
+
+// Tree returns the index of the tree at the given layer that made the
+// signature, and the index of the leaf in that tree.
+func (sig *MTSignature) Tree(layer int) (uint64, int) {
+	xh := uint(xmsstypes[xmssmttypes[sig.oid].xmssty].h)
+	idx := sig.idx
+	for i := 0; i < layer; i++ {
+		idx >>= xh
+	}
+	return idx >> xh, int(idx & (1<<xh - 1))
+}
@@ -0,0 +1,62 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xmss
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseSignature(t *testing.T) {
+	xsk, _, _ := KeyGen(XMSSSHA2H10W256)
+	xsk.Sign([]byte("message"))
+	xsig, _ := xsk.Sign([]byte("message"))
+
+	sig, err := ParseSignature(XMSSSHA2H10W256, xsig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sig.Marshal(), xsig) {
+		t.Error("Marshal(ParseSignature(sig)) != sig")
+	}
+	if sig.Oid() != XMSSSHA2H10W256 || sig.Idx() != 1 || len(sig.R()) != 32 ||
+		len(sig.WOTS()) != 67 || len(sig.AuthPath()) != 10 {
+		t.Errorf("oid = %d, idx = %d, %d WOTS+ elements, %d nodes", sig.Oid(), sig.Idx(), len(sig.WOTS()), len(sig.AuthPath()))
+	}
+	if _, err := ParseSignature(XMSSSHA2H10W256, append(xsig, 0)); err == nil {
+		t.Error("signature with trailing data accepted")
+	}
+	if _, err := ParseSignature(XMSSSHA2H16W256, xsig); err == nil {
+		t.Error("signature accepted for the wrong oid")
+	}
+}
+
+func TestParseMTSignature(t *testing.T) {
+	mtsk, _, _ := MTkeyGen(XMSSMTSHA2H20D4W256)
+	for i := 0; i < 33; i++ {
+		mtsk.Sign([]byte("message"))
+	}
+	mtsig, _ := mtsk.Sign([]byte("message"))
+
+	sig, err := ParseMTSignature(XMSSMTSHA2H20D4W256, mtsig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sig.Marshal(), mtsig) {
+		t.Error("Marshal(ParseMTSignature(sig)) != sig")
+	}
+	if sig.Idx() != 33 || len(sig.Layers()) != 4 || len(sig.Layers()[2].AuthPath()) != 5 {
+		t.Errorf("idx = %d, %d layers", sig.Idx(), len(sig.Layers()))
+	}
+	if tree, leaf := sig.Tree(0); tree != 1 || leaf != 1 {
+		t.Errorf("layer 0: tree = %d, leaf = %d", tree, leaf)
+	}
+	if tree, leaf := sig.Tree(1); tree != 0 || leaf != 1 {
+		t.Errorf("layer 1: tree = %d, leaf = %d", tree, leaf)
+	}
+	if _, err := ParseMTSignature(XMSSMTSHA2H20D4W256, mtsig[:len(mtsig)-1]); err == nil {
+		t.Error("truncated signature accepted")
+	}
+}
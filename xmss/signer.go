@@ -0,0 +1,151 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xmss
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/lingyunzhao/pqcrypto/internal/parallel"
+)
+
+// SignResult is the result of signing one of the messages passed to SignMany.
+type SignResult = parallel.SignResult
+
+// Signer wraps an XMSS private key so that it can be used from multiple
+// goroutines. Leaf indices are allocated and the private key is updated under a
+// lock, while the WOTS+ signatures of different leaves are computed in parallel.
+// The private key must not be used directly while it is wrapped by a Signer.
+type Signer struct {
+	mu  sync.Mutex
+	xsk *SK
+}
+
+// NewSigner returns a Signer for an XMSS private key.
+func NewSigner(xsk *SK) *Signer {
+	return &Signer{xsk: xsk}
+}
+
+func (s *Signer) reserve() (*leafsig, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.xsk.reserve()
+}
+
+// Sign generates an XMSS signature. It is safe to call Sign concurrently.
+func (s *Signer) Sign(message []byte) ([]byte, error) {
+	if err := checkMessage(message); err != nil {
+		return nil, err
+	}
+	return s.sign(bytesmsg(message))
+}
+
+// sign signs a message without checking it for the batch tag.
+func (s *Signer) sign(message msghash) ([]byte, error) {
+	l, err := s.reserve()
+	if err != nil {
+		return nil, err
+	}
+	return s.xsk.signLeaf(l, message)
+}
+
+// SignMany signs the messages in parallel and sends the results on the returned
+// channel in the order they complete. The channel is closed once every message
+// has been handled.
+func (s *Signer) SignMany(messages [][]byte) <-chan SignResult {
+	return parallel.SignMany(messages, s.Sign)
+}
+
+// SignBatch signs a batch of messages with a single leaf (see SK.SignBatch).
+func (s *Signer) SignBatch(messages [][]byte) ([][]byte, error) {
+	xmssty, err := xmssparams(s.xsk.oid)
+	if err != nil {
+		return nil, errors.New("xmss: invalid XMSS private key")
+	}
+	return signBatch(batchHasher(xmssty.hsty), messages, s.sign)
+}
+
+// MarshalPrivate serializes the current private key (see SK.MarshalPrivate).
+// The key already accounts for the signatures that are still being computed.
+func (s *Signer) MarshalPrivate() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.xsk.MarshalPrivate()
+}
+
+// Destroy destroys the wrapped private key. Signatures whose leaves have already
+// been reserved are still completed.
+func (s *Signer) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.xsk.Destroy()
+}
+
+// MTSigner wraps an XMSS^MT private key so that it can be used from multiple
+// goroutines in the same way as Signer.
+type MTSigner struct {
+	mu   sync.Mutex
+	mtsk *MTSK
+}
+
+// NewMTSigner returns an MTSigner for an XMSS^MT private key.
+func NewMTSigner(mtsk *MTSK) *MTSigner {
+	return &MTSigner{mtsk: mtsk}
+}
+
+func (s *MTSigner) reserve() (*mtleafsig, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mtsk.reserve()
+}
+
+// Sign generates an XMSS^MT signature. It is safe to call Sign concurrently.
+func (s *MTSigner) Sign(message []byte) ([]byte, error) {
+	if err := checkMessage(message); err != nil {
+		return nil, err
+	}
+	return s.sign(bytesmsg(message))
+}
+
+// sign signs a message without checking it for the batch tag.
+func (s *MTSigner) sign(message msghash) ([]byte, error) {
+	l, err := s.reserve()
+	if err != nil {
+		return nil, err
+	}
+	return s.mtsk.signLeaf(l, message)
+}
+
+// SignMany signs the messages in parallel and sends the results on the returned
+// channel in the order they complete. The channel is closed once every message
+// has been handled.
+func (s *MTSigner) SignMany(messages [][]byte) <-chan SignResult {
+	return parallel.SignMany(messages, s.Sign)
+}
+
+// SignBatch signs a batch of messages with a single leaf (see MTSK.SignBatch).
+func (s *MTSigner) SignBatch(messages [][]byte) ([][]byte, error) {
+	_, xmssty, err := xmssmtparams(s.mtsk.oid)
+	if err != nil {
+		return nil, errors.New("xmss-mt: invalid XMSS^MT private key")
+	}
+	return signBatch(batchHasher(xmssty.hsty), messages, s.sign)
+}
+
+// MarshalPrivate serializes the current private key (see MTSK.MarshalPrivate).
+// The key already accounts for the signatures that are still being computed.
+func (s *MTSigner) MarshalPrivate() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mtsk.MarshalPrivate()
+}
+
+// Destroy destroys the wrapped private key. Signatures whose leaves have already
+// been reserved are still completed.
+func (s *MTSigner) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mtsk.Destroy()
+}
@@ -0,0 +1,101 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xmss
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestSigner(t *testing.T) {
+	xsk, xpk, _ := KeyGen(XMSSSHA2H10W256)
+	s := NewSigner(xsk)
+	seen := make(map[int]bool)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for j := 0; j < 4; j++ {
+				msg := []byte(fmt.Sprintf("message %d-%d", g, j))
+				xsig, err := s.Sign(msg)
+				if err != nil {
+					t.Errorf("failed to sign: %v", err)
+					return
+				}
+				if !xpk.Verify(msg, xsig) {
+					t.Errorf("invalid signature for %q", msg)
+				}
+				mu.Lock()
+				idx := strToInt(xsig[:4])
+				if seen[idx] {
+					t.Errorf("index %d used twice", idx)
+				}
+				seen[idx] = true
+				mu.Unlock()
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	messages := make([][]byte, 20)
+	for i := range messages {
+		messages[i] = []byte(fmt.Sprintf("batch %d", i))
+	}
+	done := make(map[int]bool)
+	for res := range s.SignMany(messages) {
+		if res.Err != nil {
+			t.Fatalf("failed to sign message %d: %v", res.Index, res.Err)
+		}
+		if !xpk.Verify(messages[res.Index], res.Signature) {
+			t.Errorf("invalid signature for message %d", res.Index)
+		}
+		if idx := strToInt(res.Signature[:4]); seen[idx] {
+			t.Errorf("index %d used twice", idx)
+		} else {
+			seen[idx] = true
+		}
+		done[res.Index] = true
+	}
+	if len(done) != len(messages) {
+		t.Errorf("got %d results, want %d", len(done), len(messages))
+	}
+
+	sxsk, err := ParseSK(privatehex(s))
+	if err != nil {
+		t.Fatalf("failed to parse private key: %v", err)
+	}
+	if sxsk.mt.idx != 52 {
+		t.Errorf("parsed index = %d, want 52", sxsk.mt.idx)
+	}
+}
+
+func TestMTSigner(t *testing.T) {
+	mtsk, mtpk, _ := MTkeyGen(XMSSMTSHA2H20D4W256)
+	s := NewMTSigner(mtsk)
+	messages := make([][]byte, 16)
+	for i := range messages {
+		messages[i] = []byte(fmt.Sprintf("batch %d", i))
+	}
+	seen := make(map[string]bool)
+	for res := range s.SignMany(messages) {
+		if res.Err != nil {
+			t.Fatalf("failed to sign message %d: %v", res.Index, res.Err)
+		}
+		if !mtpk.Verify(messages[res.Index], res.Signature) {
+			t.Errorf("invalid signature for message %d", res.Index)
+		}
+		idx := string(res.Signature[:3])
+		if seen[idx] {
+			t.Errorf("index %x used twice", idx)
+		}
+		seen[idx] = true
+	}
+	if len(seen) != len(messages) {
+		t.Errorf("got %d results, want %d", len(seen), len(messages))
+	}
+}
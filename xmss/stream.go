@@ -0,0 +1,63 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xmss
+
+import (
+	"io"
+)
+
+// A msghash returns H_msg(key, M) for the message M being signed or verified.
+// H_msg hashes the message last, so it can be absorbed from a stream.
+type msghash func(key []byte, hsty int) ([]byte, error)
+
+func bytesmsg(message []byte) msghash {
+	return func(key []byte, hsty int) ([]byte, error) {
+		return fn(message, key, hsty, hmsg), nil
+	}
+}
+
+func readermsg(r io.Reader) msghash {
+	return func(key []byte, hsty int) ([]byte, error) {
+		f := newFnhash(key, hsty, hmsg)
+		if _, err := io.Copy(f, r); err != nil {
+			return nil, err
+		}
+		return f.sum(), nil
+	}
+}
+
+// SignReader generates an XMSS signature of the message read from r and updates
+// the XMSS private key. The leaf is consumed before r is read, so it is not
+// reused if reading fails or the message starts with the batch tag.
+func (xsk *SK) SignReader(r io.Reader) ([]byte, error) {
+	l, err := xsk.reserve()
+	if err != nil {
+		return nil, err
+	}
+	return xsk.signLeaf(l, readermsg(&batchTagReader{r: r}))
+}
+
+// VerifyReader verifies an XMSS signature of the message read from r. It returns
+// false if reading fails.
+func (xpk *PK) VerifyReader(r io.Reader, xsig []byte) bool {
+	return xpk.verify(readermsg(r), xsig)
+}
+
+// SignReader generates an XMSS^MT signature of the message read from r and
+// updates the XMSS^MT private key. The leaf is consumed before r is read, so it
+// is not reused if reading fails or the message starts with the batch tag.
+func (mtsk *MTSK) SignReader(r io.Reader) ([]byte, error) {
+	l, err := mtsk.reserve()
+	if err != nil {
+		return nil, err
+	}
+	return mtsk.signLeaf(l, readermsg(&batchTagReader{r: r}))
+}
+
+// VerifyReader verifies an XMSS^MT signature of the message read from r. It
+// returns false if reading fails.
+func (mtpk *MTPK) VerifyReader(r io.Reader, mtsig []byte) bool {
+	return mtpk.verify(readermsg(r), mtsig, nil)
+}
@@ -0,0 +1,67 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xmss
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, errors.New("read failed")
+}
+
+func TestSignReader(t *testing.T) {
+	xsk, xpk, _ := KeyGen(XMSSSHA2H10W256)
+	message := bytes.Repeat([]byte("0123456789abcdef"), 1<<16)
+
+	xsig, err := xsk.SignReader(bytes.NewReader(message))
+	if err != nil {
+		t.Fatalf("SignReader failed: %v", err)
+	}
+	if !xpk.Verify(message, xsig) {
+		t.Errorf("Verify rejected a signature from SignReader")
+	}
+	xsig, _ = xsk.Sign(message)
+	if !xpk.VerifyReader(bytes.NewReader(message), xsig) {
+		t.Errorf("VerifyReader rejected a signature from Sign")
+	}
+	if xpk.VerifyReader(bytes.NewReader(message[1:]), xsig) {
+		t.Errorf("VerifyReader accepted a wrong message")
+	}
+	if xpk.VerifyReader(errReader{}, xsig) {
+		t.Errorf("VerifyReader accepted a failing reader")
+	}
+
+	idx := xsk.mt.idx
+	if _, err := xsk.SignReader(errReader{}); err == nil {
+		t.Errorf("SignReader with a failing reader succeeded")
+	}
+	if xsk.mt.idx != idx+1 {
+		t.Errorf("SignReader with a failing reader did not consume the leaf")
+	}
+}
+
+func TestMTSignReader(t *testing.T) {
+	mtsk, mtpk, _ := MTkeyGen(XMSSMTSHA2H20D4W256)
+	message := bytes.Repeat([]byte("0123456789abcdef"), 1<<16)
+
+	mtsig, err := mtsk.SignReader(bytes.NewReader(message))
+	if err != nil {
+		t.Fatalf("SignReader failed: %v", err)
+	}
+	if !mtpk.Verify(message, mtsig) {
+		t.Errorf("Verify rejected a signature from SignReader")
+	}
+	if !mtpk.VerifyReader(bytes.NewReader(message), mtsig) {
+		t.Errorf("VerifyReader rejected a signature from SignReader")
+	}
+	if mtpk.VerifyReader(bytes.NewReader(message[1:]), mtsig) {
+		t.Errorf("VerifyReader accepted a wrong message")
+	}
+}
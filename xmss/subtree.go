@@ -0,0 +1,244 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xmss
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"math"
+	"math/big"
+
+	"github.com/lingyunzhao/pqcrypto/merkle"
+)
+
+// The tree of an XMSS private key can be computed by several workers, each
+// running one subtree job, and assembled from their results:
+//
+//	job:    oid || s || j || m (4 bytes each) || SK_SEED || SK_PRF || PUB_SEED
+//	result: oid || s || j || m (4 bytes each) || PUB_SEED || node ...
+//
+// where job j covers the subtree of height s over the leaves j*2^s to
+// (j+1)*2^s-1, and its result holds the 2^(s-m) nodes of that subtree on level
+// m, from left to right.
+
+// A subtreeJob is a decoded subtree job.
+type subtreeJob struct {
+	xsk    *SK
+	s      int
+	j      int
+	m      int
+	header []byte
+}
+
+// NewSubtreeJobs splits the generation of a new XMSS key pair into 2^(h-height)
+// subtree jobs, one for each subtree of the given height, with new random
+// seeds. A job contains the secret seeds and must be protected like the
+// private key itself.
+func NewSubtreeJobs(oid uint, height int) ([][]byte, error) {
+	xmssty, err := xmssparams(oid)
+	if err != nil {
+		return nil, err
+	}
+	if height < 0 || height > xmssty.h {
+		return nil, errors.New("xmss: invalid subtree height")
+	}
+	n := xmssty.n
+	seeds := make([]byte, 3*n)
+	if _, err := io.ReadFull(rand.Reader, seeds); err != nil {
+		return nil, err
+	}
+	defer zeroize(seeds)
+	m := merkle.JoinLevel(xmssty.h, defaultK(xmssty.h))
+	if m > height {
+		m = height
+	}
+	jobs := make([][]byte, pow2(xmssty.h-height))
+	for j := range jobs {
+		jobs[j] = bytes.Join([][]byte{toByte(uint64(oid), 4), toByte(uint64(height), 4), toByte(uint64(j), 4),
+			toByte(uint64(m), 4), seeds}, []byte(""))
+	}
+	return jobs, nil
+}
+
+// RunSubtreeJob runs a subtree job written by NewSubtreeJobs and returns its
+// result, calling progress, if it is not nil, after each of the 2^height leaves
+// of the subtree. It stops with the error of ctx once ctx is done.
+func RunSubtreeJob(ctx context.Context, job []byte, progress Progress) ([]byte, error) {
+	sj, err := parseSubtreeJob(job)
+	if err != nil {
+		return nil, err
+	}
+	defer sj.xsk.Destroy()
+	g := &keygen{ctx: ctx, progress: progress, total: uint64(pow2(sj.s))}
+	h := progressHasher{xmssHasher{sj.xsk.mt}, g}
+	count := pow2(sj.s - sj.m)
+	nodes := make([][]byte, count)
+	for i := range nodes {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		nodes[i] = merkle.SubtreeRoot(sj.m, sj.j*count+i, h)
+	}
+	return bytes.Join(append([][]byte{sj.header}, nodes...), []byte("")), nil
+}
+
+// AssembleSK assembles an XMSS key pair from the results of all the subtree
+// jobs written by one call to NewSubtreeJobs, results[i] being the result of
+// jobs[i]. Each result must answer its job, and every node of it is recomputed
+// from the leaves below it, which rejects any wrong result but costs as much as
+// generating the key in one piece. AssembleSKWithChecks recomputes fewer nodes.
+// The private key has the tree, root and authentication path of a key
+// generated in one piece.
+func AssembleSK(jobs [][]byte, results [][]byte) (*SK, *PK, error) {
+	return AssembleSKWithChecks(jobs, results, math.MaxInt)
+}
+
+// AssembleSKWithChecks assembles an XMSS key pair like AssembleSK, but only
+// recomputes checks distinct nodes of each result, chosen at random, from the
+// leaves below them. A result holds c = 2^(s-m) nodes; if b of them are wrong,
+// it passes with probability (c-b)/c * (c-b-1)/(c-1) * ... over the checks
+// factors, at most ((c-b)/c)^checks, so a single wrong node is caught with
+// probability only checks/c; the signatures made with the leaves below a wrong
+// node do not verify. Use it only with workers that are trusted to compute their
+// results correctly. With checks >= c every node is recomputed, as AssembleSK
+// does.
+func AssembleSKWithChecks(jobs [][]byte, results [][]byte, checks int) (*SK, *PK, error) {
+	invalid := errors.New("xmss: invalid subtree result")
+	if checks < 1 {
+		return nil, nil, errors.New("xmss: invalid number of checks")
+	}
+	if len(jobs) == 0 || len(jobs) != len(results) {
+		return nil, nil, errors.New("xmss: missing subtree results")
+	}
+	first, err := parseSubtreeJob(jobs[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	defer first.xsk.Destroy()
+	mt := first.xsk.mt
+	if len(jobs) != pow2(mt.height-first.s) {
+		return nil, nil, errors.New("xmss: missing subtree results")
+	}
+
+	n := len(mt.seed)
+	count := pow2(first.s - first.m)
+	nodes := make([][]byte, len(jobs)*count)
+	for i, job := range jobs {
+		sj, err := parseSubtreeJob(job)
+		if err != nil {
+			return nil, nil, err
+		}
+		sj.xsk.Destroy()
+		if !bytes.Equal(job[:8], jobs[0][:8]) || !bytes.Equal(job[12:], jobs[0][12:]) || nodes[sj.j*count] != nil {
+			return nil, nil, errors.New("xmss: subtree jobs of different keys")
+		}
+		result := results[i]
+		if len(result) != len(sj.header)+count*n || !bytes.Equal(result[:len(sj.header)], sj.header) {
+			return nil, nil, invalid
+		}
+		for x := 0; x < count; x++ {
+			node := make([]byte, n)
+			copy(node, result[len(sj.header)+x*n:])
+			nodes[sj.j*count+x] = node
+		}
+
+		picked, err := pickNodes(count, checks)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, x := range picked {
+			if subtle.ConstantTimeCompare(merkle.SubtreeRoot(sj.m, sj.j*count+x, xmssHasher{mt}), nodes[sj.j*count+x]) != 1 {
+				return nil, nil, invalid
+			}
+		}
+	}
+
+	k := defaultK(mt.height)
+	xsk := newxsk(first.xsk.oid, k, mt.skseed, mt.seed, first.xsk.skprf, 0, 0)
+	xsk.mt.bds, err = merkle.NewBDSFromNodes(mt.height, k, first.m, nodes, xmssHasher{xsk.mt})
+	if err != nil {
+		xsk.Destroy()
+		return nil, nil, invalid
+	}
+	xsk.mt.root = xsk.mt.bds.Root()
+	return xsk, xsk.Public(), nil
+}
+
+// pickNodes returns checks distinct indices below count chosen at random, or all
+// of them if checks >= count.
+func pickNodes(count int, checks int) ([]int, error) {
+	if checks >= count {
+		picked := make([]int, count)
+		for x := range picked {
+			picked[x] = x
+		}
+		return picked, nil
+	}
+	// A partial Fisher-Yates shuffle, storing only the moved entries.
+	moved := make(map[int]int)
+	picked := make([]int, checks)
+	for x := range picked {
+		r, err := rand.Int(rand.Reader, big.NewInt(int64(count-x)))
+		if err != nil {
+			return nil, err
+		}
+		y := x + int(r.Int64())
+		picked[x], moved[y] = entry(moved, y), entry(moved, x)
+	}
+	return picked, nil
+}
+
+// entry returns the entry x of a shuffled identity permutation.
+func entry(moved map[int]int, x int) int {
+	if v, ok := moved[x]; ok {
+		return v
+	}
+	return x
+}
+
+// parseSubtreeJob decodes a subtree job.
+func parseSubtreeJob(job []byte) (*subtreeJob, error) {
+	invalid := errors.New("xmss: invalid subtree job")
+	if len(job) < 16 {
+		return nil, invalid
+	}
+	oid := strToUint(job[:4])
+	xmssty, err := xmssparams(oid)
+	if err != nil {
+		return nil, invalid
+	}
+	n := xmssty.n
+	if len(job) != 16+3*n {
+		return nil, invalid
+	}
+	sj := &subtreeJob{s: strToInt(job[4:8]), j: strToInt(job[8:12]), m: strToInt(job[12:16])}
+	if sj.s > xmssty.h || sj.j >= pow2(xmssty.h-sj.s) || sj.m > sj.s ||
+		sj.m > merkle.JoinLevel(xmssty.h, defaultK(xmssty.h)) {
+		return nil, invalid
+	}
+	sj.header = bytes.Join([][]byte{job[:16], job[16+2*n:]}, []byte(""))
+	sj.xsk = newxsk(oid, defaultK(xmssty.h), job[16:16+n], job[16+2*n:], job[16+n:16+2*n], 0, 0)
+	return sj, nil
+}
+
+// A progressHasher reports every leaf it computes to the Progress function of
+// a keygen.
+type progressHasher struct {
+	xmssHasher
+	g *keygen
+}
+
+func (t progressHasher) Leaf(idx int) []byte {
+	leaf := t.xmssHasher.Leaf(idx)
+	t.g.done++
+	if t.g.progress != nil {
+		t.g.progress(t.g.done, t.g.total)
+	}
+	return leaf
+}
@@ -0,0 +1,133 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xmss
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func runjobs(t *testing.T, jobs [][]byte) [][]byte {
+	results := make([][]byte, len(jobs))
+	for i, job := range jobs {
+		var leaves uint64
+		result, err := RunSubtreeJob(context.Background(), job, func(done uint64, total uint64) {
+			leaves = done
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if leaves != 4 {
+			t.Fatalf("job %d computed %d leaves, want 4", i, leaves)
+		}
+		results[i] = result
+	}
+	return results
+}
+
+func TestAssembleSK(t *testing.T) {
+	jobs, err := NewSubtreeJobs(xmssSHA2H5W256, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 8 {
+		t.Fatalf("got %d jobs, want 8", len(jobs))
+	}
+	xsk, xpk, err := AssembleSK(jobs, runjobs(t, jobs))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// KeyGenWithRand reads PUB_SEED, SK_SEED and SK_PRF, in this order.
+	n := len(xsk.mt.seed)
+	seeds := jobs[0][16:]
+	whole, wholepk, _ := KeyGenWithRand(xmssSHA2H5W256, bytes.NewReader(bytes.Join([][]byte{seeds[2*n:], seeds[:2*n]}, nil)))
+	if privatehex(xsk) != privatehex(whole) || xpk.String() != wholepk.String() {
+		t.Fatal("assembled key pair differs from one generated in one piece")
+	}
+	for i := 0; i < 32; i++ {
+		sig, err := xsk.Sign([]byte("message"))
+		if err != nil || !xpk.Verify([]byte("message"), sig) {
+			t.Fatalf("invalid signature %d: %v", i, err)
+		}
+	}
+}
+
+func TestAssembleSKRejectsInvalidResults(t *testing.T) {
+	jobs, _ := NewSubtreeJobs(xmssSHA2H5W256, 2)
+	results := runjobs(t, jobs)
+	other, _ := NewSubtreeJobs(xmssSHA2H5W256, 2)
+	otherresults := runjobs(t, other)
+
+	replaced := func(i int, result []byte) [][]byte {
+		r := append([][]byte(nil), results...)
+		r[i] = result
+		return r
+	}
+	// A result for the leaves of another job, relabeled as the answer to job 3.
+	relabeled := append([]byte(nil), results[2]...)
+	copy(relabeled[8:12], toByte(3, 4))
+	// A result computed with other seeds, relabeled with the PUB_SEED of the key.
+	forged := append([]byte(nil), otherresults[3]...)
+	copy(forged[16:16+32], results[3][16:])
+
+	for name, r := range map[string][][]byte{
+		"missing":   results[:7],
+		"swapped":   append([][]byte{results[1], results[0]}, results[2:]...),
+		"truncated": replaced(3, results[3][:len(results[3])-1]),
+		"other key": replaced(3, otherresults[3]),
+		"relabeled": replaced(3, relabeled),
+		"forged":    replaced(3, forged),
+	} {
+		if _, _, err := AssembleSK(jobs, r); err == nil {
+			t.Errorf("assembled a key from a %s result", name)
+		}
+	}
+	mixed := append(append([][]byte(nil), jobs[:4]...), other[4:]...)
+	if _, _, err := AssembleSK(mixed, append(append([][]byte(nil), results[:4]...), otherresults[4:]...)); err == nil {
+		t.Error("assembled a key from the jobs of two keys")
+	}
+}
+
+func TestAssembleSKWithChecks(t *testing.T) {
+	jobs, _ := NewSubtreeJobs(xmssSHA2H5W256, 2)
+	results := runjobs(t, jobs)
+	if _, _, err := AssembleSKWithChecks(jobs, results, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := AssembleSKWithChecks(jobs, results, 0); err == nil {
+		t.Error("assembled a key without checks")
+	}
+
+	// Checking every node, as AssembleSK does, always finds a single wrong one.
+	wrong := append([][]byte(nil), results...)
+	wrong[5] = append([]byte(nil), results[5]...)
+	wrong[5][len(wrong[5])-1] ^= 1
+	for i := 0; i < 10; i++ {
+		if _, _, err := AssembleSKWithChecks(jobs, wrong, 1<<20); err == nil {
+			t.Fatal("assembled a key from a result with a wrong node")
+		}
+		if _, _, err := AssembleSK(jobs, wrong); err == nil {
+			t.Fatal("AssembleSK assembled a key from a result with a wrong node")
+		}
+	}
+	for _, checks := range []int{1, 3, 8} {
+		picked, err := pickNodes(8, checks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen := make(map[int]bool)
+		for _, x := range picked {
+			if x < 0 || x >= 8 || seen[x] {
+				t.Fatalf("invalid picked nodes %v", picked)
+			}
+			seen[x] = true
+		}
+		if len(picked) != checks {
+			t.Errorf("picked %d nodes, want %d", len(picked), checks)
+		}
+	}
+}
@@ -8,6 +8,9 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"crypto/sha512"
+	"encoding"
+	"errors"
+	"hash"
 	"math"
 
 	"golang.org/x/crypto/sha3"
@@ -23,10 +26,10 @@ const (
 // WOTS+ types
 const (
 	_ = iota
-	wotspsha2w256
-	wotspsha2w512
-	wotspshake256
-	wotspshake512
+	WOTSPSHA2W256
+	WOTSPSHA2W512
+	WOTSPSHAKEW256
+	WOTSPSHAKEW512
 )
 
 // XMSS types
@@ -96,10 +99,10 @@ type wotsptype struct {
 
 var wotsptypes = map[uint]*wotsptype{
 	//                     F/PRF     n   w  len
-	uint(wotspsha2w256): {sha2w256, 32, 16, 67},
-	uint(wotspsha2w512): {sha2w512, 64, 16, 131},
-	uint(wotspshake256): {shake128, 32, 16, 67},
-	uint(wotspshake512): {shake256, 64, 16, 131},
+	uint(WOTSPSHA2W256):  {sha2w256, 32, 16, 67},
+	uint(WOTSPSHA2W512):  {sha2w512, 64, 16, 131},
+	uint(WOTSPSHAKEW256): {shake128, 32, 16, 67},
+	uint(WOTSPSHAKEW512): {shake256, 64, 16, 131},
 }
 
 type xmsstype struct {
@@ -171,6 +174,25 @@ var xmssmttypes = map[uint]*xmssmttype{
 	uint(XMSSMTSHAKEH60D12W512): {xmssSHAKEH5W512, 12},
 }
 
+// xmssparams returns the parameters of an XMSS oid, or an error if the oid is
+// not supported. OIDs read from keys and signatures are checked with it before
+// any table lookup.
+func xmssparams(oid uint) (*xmsstype, error) {
+	if t := xmsstypes[oid]; t != nil {
+		return t, nil
+	}
+	return nil, errors.New("xmss: invalid XMSS oid")
+}
+
+// xmssmtparams returns the parameters of an XMSS^MT oid together with those of
+// the XMSS trees it is made of, or an error if the oid is not supported.
+func xmssmtparams(oid uint) (*xmssmttype, *xmsstype, error) {
+	if t := xmssmttypes[oid]; t != nil {
+		return t, xmsstypes[t.xmssty], nil
+	}
+	return nil, nil, errors.New("xmss-mt: invalid XMSS^MT oid")
+}
+
 // Hash types
 const (
 	sha2w256 = iota
@@ -201,17 +223,163 @@ func fn(message []byte, key []byte, hsty int, fnty int) []byte {
 		digest := sha512.Sum512(bytes.Join([][]byte{toByte(uint64(fnty), 64), key, message}, []byte("")))
 		return digest[:]
 	case shake128:
-		digest := make([]byte, 16)
+		digest := make([]byte, 32)
 		sha3.ShakeSum128(digest, bytes.Join([][]byte{toByte(uint64(fnty), 32), key, message}, []byte("")))
 		return digest
 	case shake256:
-		digest := make([]byte, 16)
+		digest := make([]byte, 64)
 		sha3.ShakeSum256(digest, bytes.Join([][]byte{toByte(uint64(fnty), 64), key, message}, []byte("")))
 		return digest
 	}
 	return nil
 }
 
+// shakelen returns the output length n of the SHAKE based functions.
+func shakelen(hsty int) int {
+	if hsty == shake256 {
+		return 64
+	}
+	return 32
+}
+
+// An fnhash computes fn(message, key, hsty, fnty) for a message written to it
+// incrementally.
+type fnhash struct {
+	hsty int
+	d    hash.Hash
+	xof  sha3.ShakeHash
+}
+
+func newFnhash(key []byte, hsty int, fnty int) *fnhash {
+	f := new(fnhash)
+	f.hsty = hsty
+	switch hsty {
+	case sha2w256:
+		f.d = sha256.New()
+		f.d.Write(toByte(uint64(fnty), 32))
+		f.d.Write(key)
+	case sha2w512:
+		f.d = sha512.New()
+		f.d.Write(toByte(uint64(fnty), 64))
+		f.d.Write(key)
+	case shake128:
+		f.xof = sha3.NewShake128()
+		f.xof.Write(toByte(uint64(fnty), 32))
+		f.xof.Write(key)
+	case shake256:
+		f.xof = sha3.NewShake256()
+		f.xof.Write(toByte(uint64(fnty), 64))
+		f.xof.Write(key)
+	}
+	return f
+}
+
+func (f *fnhash) Write(p []byte) (int, error) {
+	if f.d != nil {
+		return f.d.Write(p)
+	}
+	return f.xof.Write(p)
+}
+
+func (f *fnhash) sum() []byte {
+	if f.d != nil {
+		return f.d.Sum(nil)
+	}
+	digest := make([]byte, shakelen(f.hsty))
+	f.xof.Read(digest)
+	return digest
+}
+
+// A prfKey computes PRF(KEY, M) for a fixed KEY. The padded function type and
+// an n-byte key fill exactly one SHA-2 block, so the hash state after absorbing
+// them is computed once and resumed for every call instead of being recompressed.
+type prfKey struct {
+	hsty  int
+	state []byte
+	xof   sha3.ShakeHash
+}
+
+func newPRFKey(key []byte, hsty int) *prfKey {
+	p := new(prfKey)
+	p.hsty = hsty
+	switch hsty {
+	case sha2w256:
+		p.state = sha2State(sha256.New(), toByte(uint64(prf), 32), key)
+	case sha2w512:
+		p.state = sha2State(sha512.New(), toByte(uint64(prf), 64), key)
+	case shake128:
+		p.xof = sha3.NewShake128()
+		p.xof.Write(toByte(uint64(prf), 32))
+		p.xof.Write(key)
+	case shake256:
+		p.xof = sha3.NewShake256()
+		p.xof.Write(toByte(uint64(prf), 64))
+		p.xof.Write(key)
+	}
+	return p
+}
+
+func sha2State(d hash.Hash, pad []byte, key []byte) []byte {
+	d.Write(pad)
+	d.Write(key)
+	state, _ := d.(encoding.BinaryMarshaler).MarshalBinary()
+	return state
+}
+
+// sum returns PRF(KEY, message) resumed from the cached state.
+func (p *prfKey) sum(message []byte) []byte {
+	switch p.hsty {
+	case sha2w256:
+		d := sha256.New()
+		d.(encoding.BinaryUnmarshaler).UnmarshalBinary(p.state)
+		d.Write(message)
+		return d.Sum(nil)
+	case sha2w512:
+		d := sha512.New()
+		d.(encoding.BinaryUnmarshaler).UnmarshalBinary(p.state)
+		d.Write(message)
+		return d.Sum(nil)
+	case shake128, shake256:
+		x := p.xof.Clone()
+		x.Write(message)
+		digest := make([]byte, shakelen(p.hsty))
+		x.Read(digest)
+		return digest
+	}
+	return nil
+}
+
+// clone returns a copy of the PRF that stays usable after p is destroyed.
+func (p *prfKey) clone() *prfKey {
+	c := new(prfKey)
+	c.hsty = p.hsty
+	if p.state != nil {
+		c.state = make([]byte, len(p.state))
+		copy(c.state, p.state)
+	}
+	if p.xof != nil {
+		c.xof = p.xof.Clone()
+	}
+	return c
+}
+
+// destroy clears the hash state derived from the key.
+func (p *prfKey) destroy() {
+	zeroize(p.state)
+	p.state = nil
+	if p.xof != nil {
+		p.xof.Reset()
+	}
+}
+
+// zeroize overwrites b with zeros. It is used to clear secret values once they
+// are no longer needed.
+func zeroize(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
 func toByte(x uint64, y int) []byte {
 	z := make([]byte, y)
 	for i := y - 1; i >= 0; i-- {
@@ -295,10 +463,7 @@ const (
 func set(adrs address, value int64, member int) {
 	switch member {
 	case treeaddr:
-		h := int(value >> 32)
-		l := int(value & 0xffffffff)
-		copy(adrs[member:member+4], toByte(uint64(h), 4))
-		copy(adrs[member+4:member+8], toByte(uint64(l), 4))
+		copy(adrs[member:member+8], toByte(uint64(value), 8))
 	default:
 		copy(adrs[member:member+4], toByte(uint64(value), 4))
 	}
@@ -354,22 +519,22 @@ func strToUint64(str []byte) uint64 {
 func xmsstowotsp(xmssty uint) uint {
 	switch xmssty {
 	case XMSSSHA2H10W256, XMSSSHA2H16W256, XMSSSHA2H20W256, xmssSHA2H5W256:
-		return wotspsha2w256
+		return WOTSPSHA2W256
 	case XMSSSHA2H10W512, XMSSSHA2H16W512, XMSSSHA2H20W512, xmssSHA2H5W512:
-		return wotspsha2w512
+		return WOTSPSHA2W512
 	case XMSSSHAKEH10W256, XMSSSHAKEH16W256, XMSSSHAKEH20W256, xmssSHAKEH5W256:
-		return wotspshake256
+		return WOTSPSHAKEW256
 	case XMSSSHAKEH10W512, XMSSSHAKEH16W512, XMSSSHAKEH20W512, xmssSHAKEH5W512:
-		return wotspshake512
+		return WOTSPSHAKEW512
 	}
 	return 0
 }
 
-func getseed(skseed []byte, adrs address, hsty int) []byte {
+func getseed(skseed *prfKey, adrs address) []byte {
 	set(adrs, 0, chainaddr)
 	set(adrs, 0, hashaddr)
 	set(adrs, 0, keyAndMask)
-	return fn(adrs, skseed, hsty, prf)
+	return skseed.sum(adrs)
 }
 
 func twoDto1D(x [][]byte) []byte {
@@ -0,0 +1,88 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xmss
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+)
+
+func TestPRFKey(t *testing.T) {
+	for _, hsty := range []int{sha2w256, sha2w512, shake128, shake256} {
+		n := 32
+		if hsty == sha2w512 || hsty == shake256 {
+			n = 64
+		}
+		key := make([]byte, n)
+		rand.Read(key)
+		p := newPRFKey(key, hsty)
+		for i := 0; i < 4; i++ {
+			adrs := make([]byte, addrlen)
+			rand.Read(adrs)
+			if !bytes.Equal(p.sum(adrs), fn(adrs, key, hsty, prf)) {
+				t.Errorf("cached PRF != PRF when hash type = %d", hsty)
+			}
+		}
+	}
+}
+
+func TestFnhash(t *testing.T) {
+	for _, hsty := range []int{sha2w256, sha2w512, shake128, shake256} {
+		key := make([]byte, 96)
+		rand.Read(key)
+		message := make([]byte, 1000)
+		rand.Read(message)
+		f := newFnhash(key, hsty, hmsg)
+		f.Write(message[:100])
+		f.Write(message[100:])
+		if !bytes.Equal(f.sum(), fn(message, key, hsty, hmsg)) {
+			t.Errorf("incremental H_msg != H_msg when hash type = %d", hsty)
+		}
+	}
+}
+
+// The expected outputs of F, H_msg and PRF with the SHAKE parameter sets were
+// computed with OpenSSL's SHAKE128 and SHAKE256, independently of
+// golang.org/x/crypto/sha3, as SHAKE(toByte(type, n) || KEY || M, 8n) with
+// KEY = 0x00 0x01 ... and M the n bytes that follow, as in RFC 8391, Section 5.
+func TestShakeOutputLength(t *testing.T) {
+	for _, v := range []struct {
+		hsty int
+		fnty int
+		want string
+	}{
+		{shake128, f, "969cce591508b8163961ea5bf47976d5df1c498707b93ed594ad27695e5cef54"},
+		{shake128, hmsg, "9859c1bea181d05a64ecb582af4678d77636402cf8b67b539a78d57758255460"},
+		{shake128, prf, "462ca4e4e00ea85b5d8c4a271dc02fbd1f629e1f3d1743e3dce2d81ed53329a6"},
+		{shake256, f, "4e9b504168c48c16ae9545f3ed9af6a34a4ecdbdf1da95ab9fdef5dec13092b5578fbb6b444d65be8678b8cf40850d4378136dd65e2dffa8047ca062d9823b3e"},
+		{shake256, hmsg, "4384346c790b17896508c7a99b9e1b1a5e719260d4d4c8b09bdac85571c00e11316883b15a18785fb84101212658ab3b7ff40c6240d57d1e6e1bc1b7b3164c46"},
+		{shake256, prf, "92a567d2a9ba9d498f179181f992964dfc100194ac67ecefa23999071b93a923804818a3849c0546d6a592f6ba345f469a8a789b88753aa13b842393b54d1763"},
+	} {
+		n := shakelen(v.hsty)
+		b := make([]byte, 2*n)
+		for i := range b {
+			b[i] = byte(i)
+		}
+		key, message := b[:n], b[n:]
+		got := fn(message, key, v.hsty, v.fnty)
+		if hex.EncodeToString(got) != v.want {
+			t.Errorf("fn(hash type %d, function type %d) = %x, want %s", v.hsty, v.fnty, got, v.want)
+		}
+		if v.fnty == prf {
+			if got := newPRFKey(key, v.hsty).sum(message); hex.EncodeToString(got) != v.want {
+				t.Errorf("cached PRF with hash type %d = %x, want %s", v.hsty, got, v.want)
+			}
+		}
+		if v.fnty == hmsg {
+			h := newFnhash(key, v.hsty, hmsg)
+			h.Write(message)
+			if got := h.sum(); hex.EncodeToString(got) != v.want {
+				t.Errorf("incremental H_msg with hash type %d = %x, want %s", v.hsty, got, v.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xmss
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// with returns a copy of b with the bytes at off replaced by x.
+func with(b []byte, off int, x []byte) []byte {
+	c := append([]byte(nil), b...)
+	copy(c[off:], x)
+	return c
+}
+
+func TestKeyGenRejectsInvalidOID(t *testing.T) {
+	if _, _, err := KeyGen(0); err == nil {
+		t.Error("KeyGen accepted oid 0")
+	}
+	if _, _, err := KeyGen(XMSSMTSHA2H60D12W512 + 1); err == nil {
+		t.Error("KeyGen accepted an unknown oid")
+	}
+	if _, _, err := KeyGenWithK(0, 0); err == nil {
+		t.Error("KeyGenWithK accepted oid 0")
+	}
+	if _, _, err := MTkeyGen(0); err == nil {
+		t.Error("MTkeyGen accepted oid 0")
+	}
+	if _, _, err := MTkeyGenWithK(XMSSMTSHAKEH60D12W512+1, 0); err == nil {
+		t.Error("MTkeyGenWithK accepted an unknown oid")
+	}
+}
+
+func TestParseKeysRejectInvalidInput(t *testing.T) {
+	xsk, xpk, _ := KeyGen(xmssSHA2H5W256)
+	mtsk, mtpk, _ := MTkeyGen(XMSSMTSHA2H20D4W256)
+	skbytes, _ := hex.DecodeString(privatehex(xsk))
+	pkbytes, _ := hex.DecodeString(xpk.String())
+	mtskbytes, _ := hex.DecodeString(privatehex(mtsk))
+	mtpkbytes, _ := hex.DecodeString(mtpk.String())
+	// Offsets of idx, layer and idxtree in an XMSS private key, and of the
+	// index and the first reduced private key in an XMSS^MT private key.
+	n := 32
+	idx, layer, idxtree := 4+n, 4+n+4, 4+n+8
+	mtidx, mtlayer0 := 4, 4+8+3*n+4
+
+	parseSK := func(s string) error { _, err := ParseSK(s); return err }
+	parsePK := func(s string) error { _, err := ParsePK(s); return err }
+	parseMTSK := func(s string) error { _, err := ParseMTSK(s); return err }
+	parseMTPK := func(s string) error { _, err := ParseMTPK(s); return err }
+
+	tests := []struct {
+		name  string
+		parse func(string) error
+		key   []byte
+	}{
+		{"XMSS private key with oid 0", parseSK, with(skbytes, 0, toByte(0, 4))},
+		{"XMSS private key with an unknown oid", parseSK, with(skbytes, 0, toByte(XMSSMTSHA2H60D12W512+1, 4))},
+		{"truncated XMSS private key", parseSK, skbytes[:len(skbytes)-1]},
+		{"XMSS private key with trailing data", parseSK, append(append([]byte(nil), skbytes...), 0)},
+		{"XMSS private key with idx > 2^h", parseSK, with(skbytes, idx, toByte(33, 4))},
+		{"XMSS private key with layer 1", parseSK, with(skbytes, layer, toByte(1, 4))},
+		{"XMSS private key with idxtree 1", parseSK, with(skbytes, idxtree, toByte(1, 4))},
+		{"XMSS public key with oid 0", parsePK, with(pkbytes, 0, toByte(0, 4))},
+		{"XMSS public key with a 64-byte oid", parsePK, with(pkbytes, 0, toByte(XMSSSHA2H10W512, 4))},
+		{"truncated XMSS public key", parsePK, pkbytes[:len(pkbytes)-1]},
+		{"XMSS^MT private key with oid 0", parseMTSK, with(mtskbytes, 0, toByte(0, 4))},
+		{"XMSS^MT private key with idx > 2^h", parseMTSK, with(mtskbytes, mtidx, toByte(1<<20+1, 8))},
+		{"XMSS^MT private key with idx > 2^(h/d)", parseMTSK, with(mtskbytes, mtlayer0, toByte(33, 4))},
+		{"XMSS^MT private key with idxtree out of range", parseMTSK, with(mtskbytes, mtlayer0+4, toByte(1<<15, 8))},
+		{"XMSS^MT private key with a huge layer length", parseMTSK, with(mtskbytes, mtlayer0-4, toByte(1<<31, 4))},
+		{"XMSS^MT private key with idx ahead of its trees", parseMTSK, with(mtskbytes, mtidx, toByte(100, 8))},
+		{"XMSS^MT private key with layer 0 ahead of idx", parseMTSK, with(mtskbytes, mtlayer0, toByte(1, 4))},
+		{"XMSS^MT private key with idxtree 1 at idx 0", parseMTSK, with(mtskbytes, mtlayer0+4, toByte(1, 8))},
+		{"truncated XMSS^MT private key", parseMTSK, mtskbytes[:len(mtskbytes)-1]},
+		{"XMSS^MT public key with oid 0", parseMTPK, with(mtpkbytes, 0, toByte(0, 4))},
+		{"XMSS^MT public key with a 64-byte oid", parseMTPK, with(mtpkbytes, 0, toByte(XMSSMTSHA2H20D2W512, 4))},
+		{"truncated XMSS^MT public key", parseMTPK, mtpkbytes[:len(mtpkbytes)-1]},
+	}
+	for _, test := range tests {
+		if err := test.parse(hex.EncodeToString(test.key)); err == nil {
+			t.Errorf("accepted %s", test.name)
+		}
+	}
+
+	// The XMSS^MT private key with the last tree of layer 0 is still valid.
+	mtsk.AdvanceTo(1<<20 - 1)
+	if err := parseMTSK(privatehex(mtsk)); err != nil {
+		t.Errorf("rejected XMSS^MT private key with the last idxtree: %v", err)
+	}
+}
+
+func TestParseSignaturesRejectInvalidInput(t *testing.T) {
+	xsk, _, _ := KeyGen(xmssSHA2H5W256)
+	mtsk, _, _ := MTkeyGen(XMSSMTSHA2H20D4W256)
+	sig, _ := xsk.Sign([]byte("message"))
+	mtsig, _ := mtsk.Sign([]byte("message"))
+
+	if _, err := ParseSignature(0, sig); err == nil {
+		t.Error("ParseSignature accepted oid 0")
+	}
+	if _, err := ParseSignature(XMSSMTSHA2H60D12W512+1, sig); err == nil {
+		t.Error("ParseSignature accepted an unknown oid")
+	}
+	if _, err := ParseSignature(xmssSHA2H5W256, sig[:len(sig)-1]); err == nil {
+		t.Error("ParseSignature accepted a truncated signature")
+	}
+	if _, err := ParseSignature(xmssSHA2H5W256, with(sig, 0, toByte(32, 4))); err == nil {
+		t.Error("ParseSignature accepted idx = 2^h")
+	}
+	if _, err := ParseMTSignature(0, mtsig); err == nil {
+		t.Error("ParseMTSignature accepted oid 0")
+	}
+	if _, err := ParseMTSignature(XMSSMTSHAKEH60D12W512+1, mtsig); err == nil {
+		t.Error("ParseMTSignature accepted an unknown oid")
+	}
+	if _, err := ParseMTSignature(XMSSMTSHA2H20D4W256, mtsig[:len(mtsig)-1]); err == nil {
+		t.Error("ParseMTSignature accepted a truncated signature")
+	}
+	if _, err := ParseMTSignature(XMSSMTSHA2H20D4W256, with(mtsig, 0, toByte(1<<20, 3))); err == nil {
+		t.Error("ParseMTSignature accepted idx = 2^h")
+	}
+}
+
+func TestVerifyRejectsInvalidKeys(t *testing.T) {
+	xsk, xpk, _ := KeyGen(xmssSHA2H5W256)
+	mtsk, mtpk, _ := MTkeyGen(XMSSMTSHA2H20D4W256)
+	sig, _ := xsk.Sign([]byte("message"))
+	mtsig, _ := mtsk.Sign([]byte("message"))
+
+	if new(PK).Verify([]byte("message"), sig) {
+		t.Error("zero XMSS public key verified a signature")
+	}
+	if (&PK{oid: xpk.oid, root: xpk.root}).Verify([]byte("message"), sig) {
+		t.Error("XMSS public key without a seed verified a signature")
+	}
+	if new(MTPK).Verify([]byte("message"), mtsig) {
+		t.Error("zero XMSS^MT public key verified a signature")
+	}
+	if (&MTPK{oid: mtpk.oid, seed: mtpk.seed}).Verify([]byte("message"), mtsig) {
+		t.Error("XMSS^MT public key without a root verified a signature")
+	}
+	if (&MTPK{oid: xmssSHA2H5W256, root: mtpk.root, seed: mtpk.seed}).Verify([]byte("message"), mtsig) {
+		t.Error("XMSS^MT public key with a mismatched oid verified a signature")
+	}
+	if !xpk.Verify([]byte("message"), sig) || !mtpk.Verify([]byte("message"), mtsig) {
+		t.Error("valid signatures were rejected")
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xmss
+
+import (
+	"sync"
+
+	"github.com/lingyunzhao/pqcrypto/internal/parallel"
+)
+
+// A rootCache remembers the tree roots computed from the upper layers of
+// XMSS^MT signatures, so that a signature shared by many XMSS^MT signatures is
+// checked once.
+type rootCache struct {
+	mu      sync.Mutex
+	entries map[string]*rootEntry
+}
+
+type rootEntry struct {
+	once sync.Once
+	root []byte
+}
+
+func newRootCache() *rootCache {
+	return &rootCache{entries: make(map[string]*rootEntry)}
+}
+
+// do returns the result of compute for key, calling it only the first time key
+// is seen. A nil cache always calls compute.
+func (c *rootCache) do(key string, compute func() []byte) []byte {
+	if c == nil {
+		return compute()
+	}
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	if !ok {
+		e = new(rootEntry)
+		c.entries[key] = e
+	}
+	c.mu.Unlock()
+	e.once.Do(func() { e.root = compute() })
+	return e.root
+}
+
+// verifyMany calls verify for every message and signature pair in parallel and
+// returns the results in the order of the messages. A message without a
+// signature does not verify.
+func verifyMany(messages, sigs [][]byte, verify func(message, sig []byte) bool) []bool {
+	ok := make([]bool, len(messages))
+	parallel.Do(len(messages), func(j int) {
+		ok[j] = j < len(sigs) && verify(messages[j], sigs[j])
+	})
+	return ok
+}
+
+// VerifyBatch verifies messages with their XMSS signatures in parallel. The i-th
+// result reports whether sigs[i] is a valid signature of messages[i].
+func (xpk *PK) VerifyBatch(messages, sigs [][]byte) []bool {
+	return verifyMany(messages, sigs, xpk.Verify)
+}
+
+// VerifyBatch verifies messages with their XMSS^MT signatures in parallel. The
+// i-th result reports whether sigs[i] is a valid signature of messages[i]. The
+// signatures of tree roots shared by several signatures are verified only once.
+func (mtpk *MTPK) VerifyBatch(messages, sigs [][]byte) []bool {
+	cache := newRootCache()
+	return verifyMany(messages, sigs, func(message, sig []byte) bool {
+		return mtpk.verify(bytesmsg(message), sig, cache)
+	})
+}
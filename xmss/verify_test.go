@@ -0,0 +1,73 @@
+// Copyright 2017 Lingyun Zhao. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xmss
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestVerifyBatch(t *testing.T) {
+	xsk, xpk, _ := KeyGen(XMSSSHA2H10W256)
+	messages := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	sigs := make([][]byte, len(messages))
+	for i := range messages {
+		sigs[i], _ = xsk.Sign(messages[i])
+	}
+	sigs[1], sigs[2] = sigs[2], sigs[1]
+	ok := xpk.VerifyBatch(messages, sigs)
+	if !ok[0] || ok[1] || ok[2] {
+		t.Errorf("VerifyBatch() = %v", ok)
+	}
+	if ok := xpk.VerifyBatch(messages, sigs[:1]); !ok[0] || ok[1] || ok[2] {
+		t.Errorf("VerifyBatch with missing signatures = %v", ok)
+	}
+}
+
+func TestMTVerifyBatch(t *testing.T) {
+	mtsk, mtpk, _ := MTkeyGen(XMSSMTSHA2H20D4W256)
+	messages := make([][]byte, 12)
+	sigs := make([][]byte, len(messages))
+	for i := range messages {
+		messages[i] = []byte(fmt.Sprintf("message %d", i))
+		sigs[i], _ = mtsk.Sign(messages[i])
+	}
+	saved := make([][]byte, len(sigs))
+	for i := range sigs {
+		saved[i] = append([]byte{}, sigs[i]...)
+	}
+	if ok := mtpk.VerifyBatch(messages, sigs); len(ok) != len(messages) {
+		t.Fatalf("VerifyBatch returned %d results", len(ok))
+	}
+	for i := range sigs {
+		if !bytes.Equal(saved[i], sigs[i]) {
+			t.Errorf("verification modified signature %d", i)
+		}
+	}
+
+	// A wrong message and a tampered upper-layer signature.
+	messages[3] = []byte("other")
+	sigs[5] = append([]byte{}, sigs[5]...)
+	sigs[5][len(sigs[5])-1] ^= 1
+
+	ok := mtpk.VerifyBatch(messages, sigs)
+	for i := range ok {
+		if bad := i == 3 || i == 5; bad == ok[i] {
+			t.Errorf("VerifyBatch()[%d] = %v", i, ok[i])
+		}
+	}
+
+	// The valid signatures share the same three upper layers. The wrong message
+	// leads to a different bottom root and so to three more entries, and the
+	// tampered signature adds one for its top layer.
+	cache := newRootCache()
+	for i := range messages {
+		mtpk.verify(bytesmsg(messages[i]), sigs[i], cache)
+	}
+	if len(cache.entries) != 7 {
+		t.Errorf("cache holds %d entries, want 7", len(cache.entries))
+	}
+}
@@ -6,43 +6,226 @@ package xmss
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"io"
 )
 
-// A wotspsk represents a WOTS+ private key.
-type wotspsk struct {
+// A WOTSPSK represents a WOTS+ private key. It is derived from a secret seed
+// and must sign only one message.
+type WOTSPSK struct {
 	wotspty uint
 	seed    []byte
 	sk      [][]byte
 }
 
-// A wotsppk represents a WOTS+ public key.
-type wotsppk struct {
+// A WOTSPPK represents a WOTS+ public key together with the public seed used to
+// compute it.
+type WOTSPPK struct {
 	wotspty uint
 	seed    []byte
 	pk      [][]byte
 }
 
+// WOTSPGenSK generates a WOTS+ private key of type wotspty from a random secret
+// seed.
+func WOTSPGenSK(wotspty uint) (*WOTSPSK, error) {
+	return WOTSPGenSKWithRand(wotspty, rand.Reader)
+}
+
+// WOTSPGenSKWithRand generates a WOTS+ private key of type wotspty from a secret
+// seed read from rand (see WOTSPSKFromSeed).
+func WOTSPGenSKWithRand(wotspty uint, rand io.Reader) (*WOTSPSK, error) {
+	if wotsptypes[wotspty] == nil {
+		return nil, errors.New("wotsp: invalid WOTS+ type")
+	}
+	seed := make([]byte, wotsptypes[wotspty].n)
+	_, err := io.ReadFull(rand, seed)
+	if err != nil {
+		return nil, err
+	}
+	return wotspGenSK(seed, wotspty)
+}
+
+// WOTSPSKFromSeed derives a WOTS+ private key of type wotspty from an n-byte
+// secret seed. The i-th private key element is PRF(seed, toByte(i, 32)).
+func WOTSPSKFromSeed(wotspty uint, seed []byte) (*WOTSPSK, error) {
+	if wotsptypes[wotspty] == nil {
+		return nil, errors.New("wotsp: invalid WOTS+ type")
+	}
+	if len(seed) != wotsptypes[wotspty].n {
+		return nil, errors.New("wotsp: invalid secret seed")
+	}
+	return wotspGenSK(append([]byte(nil), seed...), wotspty)
+}
+
+// Public computes the WOTS+ public key for the 32-byte address adrs and the
+// n-byte public seed.
+func (wsk *WOTSPSK) Public(adrs []byte, seed []byte) (*WOTSPPK, error) {
+	if err := checkwotsp(wsk.wotspty, nil, adrs, seed); err != nil {
+		return nil, err
+	}
+	if wsk.destroyed() {
+		return nil, errors.New("wotsp: invalid WOTS+ private key")
+	}
+	return wsk.wotspGenPK(append(address(nil), adrs...), seed), nil
+}
+
+// Sign signs an n-byte message for the 32-byte address adrs and the n-byte
+// public seed, and returns the len*n-byte signature.
+func (wsk *WOTSPSK) Sign(message []byte, adrs []byte, seed []byte) ([]byte, error) {
+	if err := checkwotsp(wsk.wotspty, message, adrs, seed); err != nil {
+		return nil, err
+	}
+	if wsk.destroyed() {
+		return nil, errors.New("wotsp: invalid WOTS+ private key")
+	}
+	return twoDto1D(wsk.sign(message, append(address(nil), adrs...), seed)), nil
+}
+
+// Verify verifies a WOTS+ signature of an n-byte message for the 32-byte
+// address adrs.
+func (wpk *WOTSPPK) Verify(message []byte, adrs []byte, wsig []byte) bool {
+	if checkwotsp(wpk.wotspty, message, adrs, wpk.seed) != nil {
+		return false
+	}
+	n := wotsptypes[wpk.wotspty].n
+	l := wotsptypes[wpk.wotspty].l
+	if len(wsig) != l*n {
+		return false
+	}
+	return wpk.verify(message, append(address(nil), adrs...), oneDto2D(wsig, l, n))
+}
+
+// Seed returns the public seed of the public key.
+func (wpk *WOTSPPK) Seed() []byte {
+	return wpk.seed
+}
+
+func checkwotsp(wotspty uint, message []byte, adrs []byte, seed []byte) error {
+	if wotsptypes[wotspty] == nil {
+		return errors.New("wotsp: invalid WOTS+ type")
+	}
+	n := wotsptypes[wotspty].n
+	if message != nil && len(message) != n {
+		return errors.New("wotsp: message must be n bytes")
+	}
+	if len(adrs) != addrlen {
+		return errors.New("wotsp: address must be 32 bytes")
+	}
+	if len(seed) != n {
+		return errors.New("wotsp: invalid public seed")
+	}
+	return nil
+}
+
+// MarshalPrivate serializes the private key as oid || secret seed. The result
+// must be protected like the key itself; ParseWOTSPSK reads it back from
+// hexadecimal.
+func (wsk *WOTSPSK) MarshalPrivate() ([]byte, error) {
+	if wsk.destroyed() {
+		return nil, errors.New("wotsp: invalid WOTS+ private key")
+	}
+	return bytes.Join([][]byte{toByte(uint64(wsk.wotspty), 4), wsk.seed}, []byte("")), nil
+}
+
+// Format formats the private key as a placeholder, whatever the verb, so that
+// it is not printed by accident. The key has no String method, so that it is
+// not saved in that form by mistake either; use MarshalPrivate to serialize it.
+func (wsk *WOTSPSK) Format(f fmt.State, verb rune) {
+	io.WriteString(f, "xmss.WOTSPSK(REDACTED)")
+}
+
+// Destroy overwrites the secret seed and the private key elements with zeros.
+// The key can no longer sign or be serialized afterwards.
+func (wsk *WOTSPSK) Destroy() {
+	zeroize(wsk.seed)
+	for _, sk := range wsk.sk {
+		zeroize(sk)
+	}
+	wsk.seed = nil
+	wsk.sk = nil
+}
+
+func (wsk *WOTSPSK) destroyed() bool {
+	return wsk.seed == nil || wsk.sk == nil
+}
+
+// String serializes the public key as oid || public seed || pk and converts it
+// to a hexadecimal string.
+func (wpk *WOTSPPK) String() string {
+	return fmt.Sprintf("%x", bytes.Join([][]byte{toByte(uint64(wpk.wotspty), 4), wpk.seed, twoDto1D(wpk.pk)}, []byte("")))
+}
+
+// ParseWOTSPSK parses a WOTS+ private key in hexadecimal.
+func ParseWOTSPSK(sk string) (*WOTSPSK, error) {
+	skbytes, err := hex.DecodeString(sk)
+	if err != nil {
+		return nil, err
+	}
+	if len(skbytes) < 4 {
+		return nil, errors.New("wotsp: invalid WOTS+ private key")
+	}
+	defer zeroize(skbytes)
+	wsk, err := WOTSPSKFromSeed(strToUint(skbytes[:4]), skbytes[4:])
+	if err != nil {
+		return nil, errors.New("wotsp: invalid WOTS+ private key")
+	}
+	return wsk, nil
+}
+
+// ParseWOTSPPK parses a WOTS+ public key in hexadecimal.
+func ParseWOTSPPK(pk string) (*WOTSPPK, error) {
+	pkbytes, err := hex.DecodeString(pk)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkbytes) < 4 {
+		return nil, errors.New("wotsp: invalid WOTS+ public key")
+	}
+	wotspty := strToUint(pkbytes[:4])
+	if wotsptypes[wotspty] == nil {
+		return nil, errors.New("wotsp: invalid WOTS+ public key")
+	}
+	n := wotsptypes[wotspty].n
+	l := wotsptypes[wotspty].l
+	if len(pkbytes) != 4+n+l*n {
+		return nil, errors.New("wotsp: invalid WOTS+ public key")
+	}
+	wpk := new(WOTSPPK)
+	wpk.wotspty = wotspty
+	wpk.seed = make([]byte, n)
+	copy(wpk.seed, pkbytes[4:4+n])
+	wpk.pk = oneDto2D(append([]byte(nil), pkbytes[4+n:]...), l, n)
+	return wpk, nil
+}
+
 // wotspGenSK generates a WOTS+ private key.
-func wotspGenSK(seed []byte, wotspty uint) (*wotspsk, error) {
+func wotspGenSK(seed []byte, wotspty uint) (*WOTSPSK, error) {
 	if wotsptypes[wotspty] == nil {
 		return nil, errors.New("wotsp: invalid WOTS+ type")
 	}
 	l := wotsptypes[wotspty].l
 	hsty := wotsptypes[wotspty].hsty
-	wsk := new(wotspsk)
+	wsk := new(WOTSPSK)
 	wsk.wotspty = wotspty
 	wsk.seed = seed
 	wsk.sk = make([][]byte, l)
+	p := newPRFKey(seed, hsty)
 	for i := 0; i < l; i++ {
-		wsk.sk[i] = fn(toByte(uint64(i), 32), seed, hsty, prf)
+		wsk.sk[i] = p.sum(toByte(uint64(i), 32))
 	}
 	return wsk, nil
 }
 
-func chain(x []byte, i int, s int, seed []byte, adrs address, wotspty uint) []byte {
+// chain never returns x itself, so a signature does not share memory with the
+// private key elements.
+func chain(x []byte, i int, s int, seed *prfKey, adrs address, wotspty uint) []byte {
 	if s == 0 {
-		return x
+		return append([]byte(nil), x...)
 	}
 	w := wotsptypes[wotspty].w
 	if (i + s) > (w - 1) {
@@ -53,9 +236,9 @@ func chain(x []byte, i int, s int, seed []byte, adrs address, wotspty uint) []by
 	hsty := wotsptypes[wotspty].hsty
 	set(adrs, int64(i+s-1), hashaddr)
 	set(adrs, 0, keyAndMask)
-	key := fn(adrs, seed, hsty, prf)
+	key := seed.sum(adrs)
 	set(adrs, 1, keyAndMask)
-	bm := fn(adrs, seed, hsty, prf)
+	bm := seed.sum(adrs)
 
 	tmp = fn(xor(tmp, bm), key, hsty, f)
 	set(adrs, 0, keyAndMask)
@@ -64,41 +247,39 @@ func chain(x []byte, i int, s int, seed []byte, adrs address, wotspty uint) []by
 }
 
 // wotspGenPK generates the WOTS+ public key.
-func (wsk *wotspsk) wotspGenPK(adrs address, seed []byte) *wotsppk {
+func (wsk *WOTSPSK) wotspGenPK(adrs address, seed []byte) *WOTSPPK {
 	w := wotsptypes[wsk.wotspty].w
 	l := wotsptypes[wsk.wotspty].l
 	n := wotsptypes[wsk.wotspty].n
-	wpk := new(wotsppk)
+	wpk := new(WOTSPPK)
 	wpk.wotspty = wsk.wotspty
 	wpk.seed = make([]byte, n)
 	copy(wpk.seed, seed)
 	wpk.pk = make([][]byte, l)
+	p := newPRFKey(seed, wotsptypes[wsk.wotspty].hsty)
 	for i := 0; i < l; i++ {
 		set(adrs, int64(i), chainaddr)
-		wpk.pk[i] = chain(wsk.sk[i], 0, w-1, seed, adrs, wsk.wotspty)
+		wpk.pk[i] = chain(wsk.sk[i], 0, w-1, p, adrs, wsk.wotspty)
 	}
 	set(adrs, 0, chainaddr)
 	return wpk
 }
 
-func (wsk *wotspsk) sign(message []byte, adrs address, seed []byte) [][]byte {
-	return sigortmppk(message, adrs, seed, wsk.sk, wsk.wotspty, computewotspsig)
+func (wsk *WOTSPSK) sign(message []byte, adrs address, seed []byte) [][]byte {
+	p := newPRFKey(seed, wotsptypes[wsk.wotspty].hsty)
+	return sigortmppk(message, adrs, p, wsk.sk, wsk.wotspty, computewotspsig)
 }
 
-func (wpk *wotsppk) verify(message []byte, adrs address, sig [][]byte) bool {
-	tmpwpk := sigortmppk(message, adrs, wpk.seed, sig, wpk.wotspty, computewotsptmppk)
+func (wpk *WOTSPPK) verify(message []byte, adrs address, sig [][]byte) bool {
+	p := newPRFKey(wpk.seed, wotsptypes[wpk.wotspty].hsty)
+	tmpwpk := sigortmppk(message, adrs, p, sig, wpk.wotspty, computewotsptmppk)
 	if len(tmpwpk) != len(wpk.pk) {
 		return false
 	}
-	for i := 0; i < len(tmpwpk); i++ {
-		if !bytes.Equal(tmpwpk[i], wpk.pk[i]) {
-			return false
-		}
-	}
-	return true
+	return subtle.ConstantTimeCompare(twoDto1D(tmpwpk), twoDto1D(wpk.pk)) == 1
 }
 
-func sigortmppk(message []byte, adrs address, seed []byte, sigorsk [][]byte, wotspty uint, ctype int) [][]byte {
+func sigortmppk(message []byte, adrs address, seed *prfKey, sigorsk [][]byte, wotspty uint, ctype int) [][]byte {
 	csum := 0
 	w := wotsptypes[wotspty].w
 	n := wotsptypes[wotspty].n
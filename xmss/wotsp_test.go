@@ -5,12 +5,13 @@
 package xmss
 
 import (
+	"bytes"
 	"crypto/rand"
 	"testing"
 )
 
 func TestWOTSP(t *testing.T) {
-	wotsptys := []uint{wotspsha2w256, wotspsha2w512, wotspshake256, wotspshake512}
+	wotsptys := []uint{WOTSPSHA2W256, WOTSPSHA2W512, WOTSPSHAKEW256, WOTSPSHAKEW512}
 	for i := 0; i < len(wotsptys); i++ {
 		skseed := make([]byte, wotsptypes[wotsptys[i]].n)
 		rand.Read(skseed)
@@ -28,3 +29,75 @@ func TestWOTSP(t *testing.T) {
 		}
 	}
 }
+
+func TestWOTSPKeys(t *testing.T) {
+	for _, wotspty := range []uint{WOTSPSHA2W256, WOTSPSHA2W512, WOTSPSHAKEW256, WOTSPSHAKEW512} {
+		n := wotsptypes[wotspty].n
+		wsk, err := WOTSPGenSK(wotspty)
+		if err != nil {
+			t.Fatal(err)
+		}
+		adrs := make([]byte, addrlen)
+		rand.Read(adrs)
+		adrscopy := append([]byte(nil), adrs...)
+		seed := make([]byte, n)
+		rand.Read(seed)
+		msg := make([]byte, n)
+		rand.Read(msg)
+
+		wpk, err := wsk.Public(adrs, seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wsig, err := wsk.Sign(msg, adrs, seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(adrs, adrscopy) {
+			t.Errorf("address modified when WOTS+ type = %d", wotspty)
+		}
+		if !wpk.Verify(msg, adrs, wsig) {
+			t.Errorf("invalid signature when WOTS+ type = %d", wotspty)
+		}
+		adrs[3] ^= 1
+		if wpk.Verify(msg, adrs, wsig) {
+			t.Errorf("signature verified for another address when WOTS+ type = %d", wotspty)
+		}
+		adrs[3] ^= 1
+		if _, err := wsk.Sign(msg[1:], adrs, seed); err == nil {
+			t.Errorf("short message accepted when WOTS+ type = %d", wotspty)
+		}
+
+		swsk, err := ParseWOTSPSK(privatehex(wsk))
+		if err != nil || privatehex(swsk) != privatehex(wsk) {
+			t.Errorf("failed to parse private key when WOTS+ type = %d", wotspty)
+		}
+		swpk, err := ParseWOTSPPK(wpk.String())
+		if err != nil || swpk.String() != wpk.String() || !swpk.Verify(msg, adrs, wsig) {
+			t.Errorf("failed to parse public key when WOTS+ type = %d", wotspty)
+		}
+	}
+}
+
+// The nodes passed to ltree may share memory with the signature being
+// verified, which it must leave unchanged.
+func TestLtreeKeepsItsInput(t *testing.T) {
+	for _, wotspty := range []uint{WOTSPSHA2W256, WOTSPSHAKEW512} {
+		n := wotsptypes[wotspty].n
+		l := wotsptypes[wotspty].l
+		buf := make([]byte, l*n)
+		rand.Read(buf)
+		orig := append([]byte(nil), buf...)
+		seed := make([]byte, n)
+		rand.Read(seed)
+		wpk := &WOTSPPK{pk: oneDto2D(buf, l, n), wotspty: wotspty, seed: seed}
+		root := wpk.ltree(make([]byte, addrlen), newPRFKey(seed, wotsptypes[wotspty].hsty))
+		if !bytes.Equal(buf, orig) {
+			t.Errorf("ltree modified its input when WOTS+ type = %x", wotspty)
+		}
+		wpk = &WOTSPPK{pk: oneDto2D(append([]byte(nil), orig...), l, n), wotspty: wotspty, seed: seed}
+		if !bytes.Equal(wpk.ltree(make([]byte, addrlen), newPRFKey(seed, wotsptypes[wotspty].hsty)), root) {
+			t.Errorf("ltree is not deterministic when WOTS+ type = %x", wotspty)
+		}
+	}
+}
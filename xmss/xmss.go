@@ -6,22 +6,53 @@ package xmss
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+
+	"github.com/lingyunzhao/pqcrypto/merkle"
 )
 
 // A SK represents an XMSS private key.
 type SK struct {
 	oid   uint
 	skprf []byte
-	mt    *merkle
+	mt    *xmsstree
 }
 
-// String serializes the private key and converts it to a hexadecimal string.
-func (xsk *SK) String() string {
-	return fmt.Sprintf("%x", xsk.serialize())
+// MarshalPrivate serializes the private key. The result contains the secret
+// seeds and must be protected like the key itself; ParseSK reads it back from
+// hexadecimal.
+func (xsk *SK) MarshalPrivate() ([]byte, error) {
+	if xsk.destroyed() {
+		return nil, errors.New("xmss: invalid XMSS private key")
+	}
+	return xsk.serialize(), nil
+}
+
+// Format formats the private key as a placeholder, whatever the verb, so that
+// it is not printed by accident. The key has no String method, so that it is
+// not saved in that form by mistake either; use MarshalPrivate to serialize it.
+func (xsk *SK) Format(f fmt.State, verb rune) {
+	io.WriteString(f, "xmss.SK(REDACTED)")
+}
+
+// Destroy overwrites the secret seeds of the private key with zeros. The key can
+// no longer sign or be serialized afterwards.
+func (xsk *SK) Destroy() {
+	zeroize(xsk.skprf)
+	xsk.skprf = nil
+	if xsk.mt != nil {
+		xsk.mt.destroy()
+	}
+}
+
+func (xsk *SK) destroyed() bool {
+	return len(xsk.skprf) == 0 || xsk.mt == nil || len(xsk.mt.skseed) == 0
 }
 
 func (xsk *SK) serialize() []byte {
@@ -34,27 +65,28 @@ func ParseSK(sk string) (*SK, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer zeroize(skbytes)
 	if len(skbytes) < 4 {
 		return nil, errors.New("xmss: invalid XMSS private key")
 	}
 	xsk := new(SK)
 	oid := strToUint(skbytes[:4])
 	skbytes = skbytes[4:]
-	if xmsstypes[oid] == nil {
+	xmssty, err := xmssparams(oid)
+	if err != nil {
 		return nil, errors.New("xmss: invalid XMSS private key")
 	}
-	n := xmsstypes[oid].n
+	n := xmssty.n
 	if len(skbytes) < n {
 		return nil, errors.New("xmss: invalid XMSS private key")
 	}
 	xsk.skprf = make([]byte, n)
 	copy(xsk.skprf, skbytes[:n])
 	skbytes = skbytes[n:]
-	wotspty := xmsstowotsp(oid)
-	hsty := xmsstypes[oid].hsty
-	h := xmsstypes[oid].h
-	xsk.mt = parsemerkle(skbytes, n, h, hsty, wotspty)
-	if xsk.mt == nil {
+	xsk.mt = parsemerkle(skbytes, n, xmssty.h, xmssty.hsty, xmsstowotsp(oid))
+	// A single XMSS tree is always tree 0 of layer 0, and its index may be
+	// one past the last leaf once the key is exhausted.
+	if xsk.mt == nil || xsk.mt.layer != 0 || xsk.mt.idxtree != 0 || xsk.mt.idx > pow2(xmssty.h) {
 		return nil, errors.New("xmss: invalid XMSS private key")
 	}
 	xsk.oid = oid
@@ -89,10 +121,11 @@ func ParsePK(pk string) (*PK, error) {
 	}
 
 	oid := strToUint(pkbytes[:4])
-	if xmsstypes[oid] == nil {
+	xmssty, err := xmssparams(oid)
+	if err != nil {
 		return nil, errors.New("xmss: invalid XMSS public key")
 	}
-	n := xmsstypes[oid].n
+	n := xmssty.n
 
 	if len(pkbytes) != 4+n+n {
 		return nil, errors.New("xmss: invalid XMSS public key")
@@ -110,26 +143,110 @@ func ParsePK(pk string) (*PK, error) {
 
 // KeyGen generates an XMSS key pair
 func KeyGen(oid uint) (*SK, *PK, error) {
-	if xmsstypes[oid] == nil {
-		return nil, nil, errors.New("xmss: invalid XMSS oid")
+	xmssty, err := xmssparams(oid)
+	if err != nil {
+		return nil, nil, err
 	}
-	n := xmsstypes[oid].n
+	return KeyGenWithK(oid, defaultK(xmssty.h))
+}
+
+// KeyGenWithK generates an XMSS key pair whose authentication paths are computed
+// by the BDS traversal algorithm with parameter k. The top k levels of the tree
+// are kept in the private key and (h-k)/2 leaves are computed per signature, so
+// a larger k trades private key size for signing time. k should satisfy
+// 0 <= k <= h and h-k even.
+func KeyGenWithK(oid uint, k int) (*SK, *PK, error) {
+	return keyGen(&keygen{ctx: context.Background()}, oid, k, rand.Reader)
+}
+
+// KeyGenWithRand generates an XMSS key pair whose PUB_SEED, SK_SEED and SK_PRF
+// are read, in this order, from rand. The same input always yields the same key
+// pair.
+func KeyGenWithRand(oid uint, rand io.Reader) (*SK, *PK, error) {
+	xmssty, err := xmssparams(oid)
+	if err != nil {
+		return nil, nil, err
+	}
+	return keyGen(&keygen{ctx: context.Background()}, oid, defaultK(xmssty.h), rand)
+}
+
+// KeyGenWithContext generates an XMSS key pair like KeyGen, calling progress,
+// if it is not nil, after each of the 2^h leaves of the tree. Generation stops
+// with the error of ctx once ctx is done.
+func KeyGenWithContext(ctx context.Context, oid uint, progress Progress) (*SK, *PK, error) {
+	xmssty, err := xmssparams(oid)
+	if err != nil {
+		return nil, nil, err
+	}
+	return keyGen(&keygen{ctx: ctx, progress: progress}, oid, defaultK(xmssty.h), rand.Reader)
+}
+
+func keyGen(g *keygen, oid uint, k int, rand io.Reader) (*SK, *PK, error) {
+	xmssty, err := xmssparams(oid)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !validK(xmssty.h, k) {
+		return nil, nil, errors.New("xmss: invalid BDS parameter k")
+	}
+	n := xmssty.n
 	seed := make([]byte, n)
-	_, err := rand.Read(seed)
+	_, err = io.ReadFull(rand, seed)
 	if err != nil {
 		return nil, nil, err
 	}
 	skseed := make([]byte, n)
-	_, err = rand.Read(skseed)
+	_, err = io.ReadFull(rand, skseed)
 	if err != nil {
 		return nil, nil, err
 	}
+	defer zeroize(skseed)
 	skprf := make([]byte, n)
-	_, err = rand.Read(skprf)
+	_, err = io.ReadFull(rand, skprf)
 	if err != nil {
 		return nil, nil, err
 	}
-	return xmsskeyGen(oid, skseed, seed, skprf, 0, 0)
+	defer zeroize(skprf)
+
+	return g.xmss(newxsk(oid, k, skseed, seed, skprf, 0, 0))
+}
+
+// xmss completes the tree of a new XMSS private key.
+func (g *keygen) xmss(xsk *SK) (*SK, *PK, error) {
+	g.total = uint64(pow2(xsk.mt.height))
+	g.state = func() []byte { return xmssCheckpoint(xsk) }
+	if err := g.build(xsk.mt); err != nil {
+		xsk.Destroy()
+		return nil, nil, err
+	}
+	return xsk, xsk.Public(), nil
+}
+
+// Remaining returns the number of signatures the private key can still generate.
+func (xsk *SK) Remaining() uint64 {
+	xmssty, err := xmssparams(xsk.oid)
+	if err != nil || xsk.destroyed() || xsk.mt.idx >= pow2(xmssty.h) {
+		return 0
+	}
+	return uint64(pow2(xmssty.h) - xsk.mt.idx)
+}
+
+// AdvanceTo moves the private key forward so that its next signature uses leaf
+// idx. The leaves before idx are skipped and never used; idx = 2^h exhausts the
+// key. The authentication path of leaf idx is computed in about h*2^(h/2) leaf
+// computations, and the key never moves backward.
+func (xsk *SK) AdvanceTo(idx uint64) error {
+	xmssty, err := xmssparams(xsk.oid)
+	if err != nil || xsk.destroyed() || xsk.mt.bds == nil {
+		return errors.New("xmss: invalid XMSS private key")
+	}
+	if idx < uint64(xsk.mt.idx) {
+		return errors.New("xmss: cannot move the private key backward")
+	}
+	if idx > uint64(pow2(xmssty.h)) {
+		return errors.New("xmss: index out of range")
+	}
+	return xsk.mt.advance(int(idx))
 }
 
 // Public generates the public key of a private key.
@@ -143,15 +260,10 @@ func (xsk *SK) Public() *PK {
 	return xpk
 }
 
-func xmsskeyGen(oid uint, skseed []byte, seed []byte, skprf []byte, layer int, idxtree int) (*SK, *PK, error) {
-	xsk := new(SK)
-	xsk.oid = oid
-	h := xmsstypes[oid].h
+func xmsskeyGen(oid uint, k int, skseed []byte, seed []byte, skprf []byte, layer int, idxtree uint64) (*SK, *PK, error) {
+	xsk := newxsk(oid, k, skseed, seed, skprf, layer, idxtree)
+	xsk.mt.complete()
 	n := xmsstypes[oid].n
-	hsty := xmsstypes[oid].hsty
-	xsk.skprf = make([]byte, n)
-	copy(xsk.skprf, skprf)
-	xsk.mt = genMTree(h, skseed, seed, hsty, xmsstowotsp(oid), layer, idxtree)
 
 	xpk := new(PK)
 	xpk.oid = oid
@@ -163,119 +275,204 @@ func xmsskeyGen(oid uint, skseed []byte, seed []byte, skprf []byte, layer int, i
 	return xsk, xpk, nil
 }
 
+// newxsk returns a private key whose tree has not been computed yet.
+func newxsk(oid uint, k int, skseed []byte, seed []byte, skprf []byte, layer int, idxtree uint64) *SK {
+	xsk := new(SK)
+	xsk.oid = oid
+	xsk.skprf = make([]byte, len(skprf))
+	copy(xsk.skprf, skprf)
+	xsk.mt = newMTree(xmsstypes[oid].h, k, skseed, seed, xmsstypes[oid].hsty, xmsstowotsp(oid), layer, idxtree)
+	return xsk
+}
+
 // Sign generates an XMSS signature and updates the XMSS private key.
 func (xsk *SK) Sign(message []byte) ([]byte, error) {
-	if xmsstypes[xsk.oid] == nil {
+	if err := checkMessage(message); err != nil {
+		return nil, err
+	}
+	return xsk.sign(bytesmsg(message))
+}
+
+// sign signs a message without checking it for the batch tag.
+func (xsk *SK) sign(message msghash) ([]byte, error) {
+	l, err := xsk.reserve()
+	if err != nil {
+		return nil, err
+	}
+	return xsk.signLeaf(l, message)
+}
+
+// reserve consumes the next leaf of the XMSS private key.
+func (xsk *SK) reserve() (*leafsig, error) {
+	xmssty, err := xmssparams(xsk.oid)
+	if err != nil || xsk.destroyed() {
 		return nil, errors.New("xmss: invalid XMSS private key")
 	}
-	h := xmsstypes[xsk.oid].h
-	if xsk.mt.idx >= pow2(h) {
+	if xsk.mt.idx >= pow2(xmssty.h) {
 		return nil, errors.New("xmss: attempted overuse of XMSS private key")
 	}
-	hsty := xsk.mt.hsty
+	return xsk.reserveLeaf(), nil
+}
+
+// signLeaf signs a message with a leaf returned by reserve.
+func (xsk *SK) signLeaf(l *leafsig, message msghash) ([]byte, error) {
+	defer l.destroy()
+	hsty := l.mt.hsty
 	n := xmsstypes[xsk.oid].n
-	r := fn(toByte(uint64(xsk.mt.idx), 32), xsk.skprf, hsty, prf)
-	m := fn(message, bytes.Join([][]byte{r, xsk.mt.root, toByte(uint64(xsk.mt.idx), n)}, []byte("")), hsty, hmsg)
+	r := fn(toByte(uint64(l.idx), 32), l.skprf, hsty, prf)
+	m, err := message(bytes.Join([][]byte{r, l.mt.root, toByte(uint64(l.idx), n)}, []byte("")), hsty)
+	if err != nil {
+		return nil, err
+	}
 	adrs := toByte(0, addrlen)
-	xsig := bytes.Join([][]byte{toByte(uint64(xsk.mt.idx), 4), r}, []byte(""))
-	set(adrs, int64(xsk.mt.layer), layeraddr)
-	set(adrs, int64(xsk.mt.idxtree), treeaddr)
-	xsig = append(xsig, twoDto1D(xsk.treeSig(m, adrs))...)
+	xsig := bytes.Join([][]byte{toByte(uint64(l.idx), 4), r}, []byte(""))
+	set(adrs, int64(l.mt.layer), layeraddr)
+	set(adrs, int64(l.mt.idxtree), treeaddr)
+	xsig = append(xsig, twoDto1D(l.treeSig(m, adrs))...)
 	return xsig, nil
 }
 
 // Verify an XMSS signature using the corresponding XMSS public key and a message.
 func (xpk *PK) Verify(message []byte, xsig []byte) bool {
+	return xpk.verify(bytesmsg(message), xsig)
+}
+
+// validate checks the oid of the public key and the lengths of its fields.
+func (xpk *PK) validate() error {
+	xmssty, err := xmssparams(xpk.oid)
+	if err != nil {
+		return err
+	}
+	if len(xpk.root) != xmssty.n || len(xpk.seed) != xmssty.n {
+		return errors.New("xmss: invalid XMSS public key")
+	}
+	return nil
+}
+
+func (xpk *PK) verify(message msghash, xsig []byte) bool {
+	if xpk.validate() != nil {
+		return false
+	}
+	sig, err := ParseSignature(xpk.oid, xsig)
+	if err != nil {
+		return false
+	}
 	adrs := toByte(0, 32)
 	set(adrs, 0, layeraddr)
 	set(adrs, 0, treeaddr)
 	hsty := xmsstypes[xpk.oid].hsty
 	n := xmsstypes[xpk.oid].n
-	l := xmsstypes[xpk.oid].l
 	h := xmsstypes[xpk.oid].h
-	if len(xsig) != 4+n+l*n+n*h {
+	m, err := message(bytes.Join([][]byte{sig.r, xpk.root, toByte(uint64(sig.idx), n)}, []byte("")), hsty)
+	if err != nil {
 		return false
 	}
-	idx := strToInt(xsig[:4])
-	r := xsig[4 : 4+n]
-	wsig := oneDto2D(xsig[4+n:4+n+n*l], l, n)
-	authpath := oneDto2D(xsig[4+n+n*l:4+n+n*l+n*h], h, n)
-	m := fn(message, bytes.Join([][]byte{r, xpk.root, toByte(uint64(idx), n)}, []byte("")), hsty, hmsg)
-	root := rootFromSig(m, xpk.seed, wsig, authpath, adrs, idx, xmsstowotsp(xpk.oid), h)
-	if !bytes.Equal(root, xpk.root) {
+	root := rootFromSig(m, xpk.seed, sig.wots, sig.authpath, adrs, sig.idx, xmsstowotsp(xpk.oid), h)
+	if subtle.ConstantTimeCompare(root, xpk.root) != 1 {
 		return false
 	}
 	return true
 }
 
 func (xsk *SK) treeSig(m []byte, adrs address) [][]byte {
-	set(adrs, otsAddr, addrtype)
-	set(adrs, int64(xsk.mt.idx), otsaddr)
-	wsk, _ := wotspGenSK(getseed(xsk.mt.skseed, adrs, xsk.mt.hsty), xmsstowotsp(xsk.oid))
-	sig := wsk.sign(m, adrs, xsk.mt.seed)
-	wsklen := len(sig)
-	sig = append(sig, make([][]byte, len(xsk.mt.authpath))...)
-	for i := 0; i < len(xsk.mt.authpath); i++ {
-		sig[wsklen+i] = make([]byte, len(xsk.mt.authpath[i]))
-		copy(sig[wsklen+i], xsk.mt.authpath[i])
-	}
-	if xsk.mt.idx == pow2(xmsstypes[xsk.oid].h)-1 {
-		xsk.mt.idx++
-	} else {
-		xsk.mt.traversal()
+	l := xsk.reserveLeaf()
+	defer l.destroy()
+	return l.treeSig(m, adrs)
+}
+
+// A leafsig is a leaf whose index has been consumed from a tree, together with
+// its authentication path. The tree fields it refers to never change and the
+// secret values are copied, so the WOTS+ signature can be computed without
+// holding the private key, even if the key is destroyed meanwhile. The copies
+// are destroyed once the leaf has signed.
+type leafsig struct {
+	mt        *xmsstree
+	wotspty   uint
+	idx       int
+	authpath  [][]byte
+	skprf     []byte
+	skseedprf *prfKey
+}
+
+// reserveLeaf consumes the next leaf of the tree and advances the traversal.
+func (xsk *SK) reserveLeaf() *leafsig {
+	l := new(leafsig)
+	l.mt = xsk.mt
+	l.wotspty = xmsstowotsp(xsk.oid)
+	l.idx = xsk.mt.idx
+	l.skprf = make([]byte, len(xsk.skprf))
+	copy(l.skprf, xsk.skprf)
+	l.skseedprf = xsk.mt.skseedprf.clone()
+	authpath := xsk.mt.bds.AuthPath()
+	l.authpath = make([][]byte, len(authpath))
+	for i := 0; i < len(authpath); i++ {
+		l.authpath[i] = make([]byte, len(authpath[i]))
+		copy(l.authpath[i], authpath[i])
 	}
-	return sig
+	xsk.mt.traversal()
+	return l
+}
+
+// destroy clears the copies of the secret values.
+func (l *leafsig) destroy() {
+	zeroize(l.skprf)
+	l.skseedprf.destroy()
+}
+
+func (l *leafsig) treeSig(m []byte, adrs address) [][]byte {
+	set(adrs, otsAddr, addrtype)
+	set(adrs, int64(l.idx), otsaddr)
+	wsk, _ := wotspGenSK(getseed(l.skseedprf, adrs), l.wotspty)
+	defer wsk.Destroy()
+	sig := wsk.sign(m, adrs, l.mt.seed)
+	return append(sig, l.authpath...)
 }
 
 func rootFromSig(m []byte, seed []byte, wsig [][]byte, authpath [][]byte, adrs address, idx int, wotspty uint, h int) []byte {
 	set(adrs, otsAddr, addrtype)
 	set(adrs, int64(idx), otsaddr)
-	wpk := new(wotsppk)
-	wpk.pk = sigortmppk(m, adrs, seed, wsig, wotspty, computewotsptmppk)
+	p := newPRFKey(seed, wotsptypes[wotspty].hsty)
+	wpk := new(WOTSPPK)
+	wpk.pk = sigortmppk(m, adrs, p, wsig, wotspty, computewotsptmppk)
 	wpk.wotspty = wotspty
 	wpk.seed = seed
 	set(adrs, ltreeAddr, addrtype)
 	set(adrs, int64(idx), ltreeaddr)
-	nd := wpk.ltree(adrs)
+	nd := wpk.ltree(adrs, p)
 	set(adrs, hashtreeAddr, addrtype)
-	set(adrs, int64(idx), treeindex)
-	hsty := wotsptypes[wotspty].hsty
-	for k := 0; k < h; k++ {
-		set(adrs, int64(k), treeheight)
-		if floor(float64(idx)/float64(pow2(k)))%2 == 0 {
-			set(adrs, get(adrs, treeindex)/2, treeindex)
-			nd = randhash(nd, authpath[k], seed, adrs, hsty)
-		} else {
-			set(adrs, (get(adrs, treeindex)-1)/2, treeindex)
-			nd = randhash(authpath[k], nd, seed, adrs, hsty)
-		}
-	}
-	return nd
+	return merkle.RootFromAuthPath(nd, idx, authpath[:h], func(left []byte, right []byte, height int, idx int) []byte {
+		set(adrs, int64(height-1), treeheight)
+		set(adrs, int64(idx), treeindex)
+		return randhash(left, right, p, adrs)
+	})
 }
 
-func randhash(left []byte, right []byte, seed []byte, adrs address, hsty int) []byte {
+func randhash(left []byte, right []byte, seed *prfKey, adrs address) []byte {
 	set(adrs, 0, keyAndMask)
-	key := fn(adrs, seed, hsty, prf)
+	key := seed.sum(adrs)
 	set(adrs, 1, keyAndMask)
-	bm0 := fn(adrs, seed, hsty, prf)
+	bm0 := seed.sum(adrs)
 	set(adrs, 2, keyAndMask)
-	bm1 := fn(adrs, seed, hsty, prf)
+	bm1 := seed.sum(adrs)
 	set(adrs, 0, keyAndMask)
 
-	return fn(append(xor(left, bm0), xor(right, bm1)...), key, hsty, h)
+	return fn(append(xor(left, bm0), xor(right, bm1)...), key, seed.hsty, h)
 }
 
-func (wpk *wotsppk) ltree(adrs address) []byte {
+// ltree compresses the WOTS+ public key; seed is the PRF keyed with wpk.seed.
+func (wpk *WOTSPPK) ltree(adrs address, seed *prfKey) []byte {
 	l := wotsptypes[wpk.wotspty].l
-	hsty := wotsptypes[wpk.wotspty].hsty
 	set(adrs, 0, treeheight)
 	for l > 1 {
 		for i := 0; i < floor(float64(l)/2); i++ {
 			set(adrs, int64(i), treeindex)
-			wpk.pk[i] = randhash(wpk.pk[2*i], wpk.pk[2*i+1], wpk.seed, adrs, hsty)
+			wpk.pk[i] = randhash(wpk.pk[2*i], wpk.pk[2*i+1], seed, adrs)
 		}
 		if l&0x01 == 1 {
-			copy(wpk.pk[floor(float64(l)/2)], wpk.pk[l-1])
+			// Move the last node up by reference: copying it would overwrite
+			// a node of the level below, which may be part of the signature
+			// being verified.
+			wpk.pk[floor(float64(l)/2)] = wpk.pk[l-1]
 		}
 		l = ceil(float64(l) / 2)
 		set(adrs, get(adrs, treeheight)+1, treeheight)
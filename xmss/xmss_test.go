@@ -25,11 +25,11 @@ func TestXMSS(t *testing.T) {
 				t.Errorf("invalid signature when XMSS types = %x, j = %d", xmsstys[i], j)
 			}
 		}
-		sxsk, serr := ParseSK(xsk.String())
+		sxsk, serr := ParseSK(privatehex(xsk))
 		if serr != nil {
 			t.Errorf("failed to parse private key when XMSS types = %x", xmsstys[i])
 		}
-		if sxsk.String() != xsk.String() {
+		if privatehex(sxsk) != privatehex(xsk) {
 			t.Errorf("parsed xsk != xsk when XMSS types = %x", xmsstys[i])
 		}
 		sxpk, perr := ParsePK(xpk.String())
@@ -49,3 +49,73 @@ func TestXMSS(t *testing.T) {
 		}
 	}
 }
+
+func TestXMSSTraversal(t *testing.T) {
+	for _, k := range []int{2, 4, 10} {
+		xsk, xpk, err := KeyGenWithK(XMSSSHA2H10W256, k)
+		if err != nil {
+			t.Fatalf("KeyGenWithK failed when k = %d: %v", k, err)
+		}
+		msg := []byte("abc")
+		for j := 0; j < 1024; j++ {
+			if xsk.Remaining() != uint64(1024-j) {
+				t.Fatalf("Remaining() = %d, want %d", xsk.Remaining(), 1024-j)
+			}
+			if j == 500 {
+				// The traversal state must survive serialization.
+				if xsk, err = ParseSK(privatehex(xsk)); err != nil {
+					t.Fatalf("failed to parse private key when k = %d", k)
+				}
+			}
+			xsig, err := xsk.Sign(msg)
+			if err != nil {
+				t.Fatalf("failed to sign when k = %d, j = %d: %v", k, j, err)
+			}
+			if !xpk.Verify(msg, xsig) {
+				t.Fatalf("invalid signature when k = %d, j = %d", k, j)
+			}
+		}
+		if _, err := xsk.Sign(msg); err == nil {
+			t.Errorf("exhausted private key signed when k = %d", k)
+		}
+		if xsk.Remaining() != 0 {
+			t.Errorf("exhausted private key has %d signatures remaining", xsk.Remaining())
+		}
+	}
+	for _, k := range []int{-1, 3, 11} {
+		if _, _, err := KeyGenWithK(XMSSSHA2H10W256, k); err == nil {
+			t.Errorf("KeyGenWithK accepted k = %d", k)
+		}
+	}
+}
+
+func TestRemaining(t *testing.T) {
+	xsk, _, _ := KeyGen(xmssSHA2H5W256)
+	for i := 0; i < 3; i++ {
+		if r := xsk.Remaining(); r != uint64(32-i) {
+			t.Fatalf("Remaining() = %d after %d signatures, want %d", r, i, 32-i)
+		}
+		xsk.Sign([]byte("message"))
+	}
+	if loaded, _ := ParseSK(privatehex(xsk)); loaded.Remaining() != 29 {
+		t.Errorf("Remaining() = %d after parsing, want 29", loaded.Remaining())
+	}
+	xsk.AdvanceTo(30)
+	if xsk.Remaining() != 2 {
+		t.Errorf("Remaining() = %d after AdvanceTo(30), want 2", xsk.Remaining())
+	}
+
+	mtsk, _, _ := MTkeyGen(XMSSMTSHA2H20D4W256)
+	if mtsk.Remaining() != 1<<20 {
+		t.Errorf("Remaining() = %d, want 2^20", mtsk.Remaining())
+	}
+	mtsk.Sign([]byte("message"))
+	if loaded, _ := ParseMTSK(privatehex(mtsk)); loaded.Remaining() != 1<<20-1 {
+		t.Errorf("Remaining() = %d after parsing, want 2^20-1", loaded.Remaining())
+	}
+	xsk.Destroy()
+	mtsk.Destroy()
+	if xsk.Remaining() != 0 || mtsk.Remaining() != 0 {
+		t.Errorf("Remaining() = %d and %d after Destroy, want 0", xsk.Remaining(), mtsk.Remaining())
+	}
+}
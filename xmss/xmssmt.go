@@ -6,10 +6,13 @@ package xmss
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 )
 
 // A MTSK represents an XMSS^MT private key.
@@ -22,6 +25,10 @@ type MTSK struct {
 	skprf    []byte
 	xsk      []*SK
 	chainsig [][]byte
+	// next holds the trees that will replace those of the layers below the
+	// top one. Their leaves are computed a few per signature, so that no
+	// signature computes a whole tree.
+	next []*SK
 }
 
 func (mtsk *MTSK) serialize() []byte {
@@ -31,13 +38,68 @@ func (mtsk *MTSK) serialize() []byte {
 		mts = append(mts, toByte(uint64(len(tmpmt)), 4)...)
 		mts = append(mts, tmpmt...)
 	}
+	// The next trees, if any, follow the chain signatures; an empty entry is a
+	// layer without one.
+	var next []byte
+	for _, xsk := range mtsk.next {
+		var tmpmt []byte
+		if xsk != nil {
+			tmpmt = xsk.mt.reducedSK()
+		}
+		next = append(next, toByte(uint64(len(tmpmt)), 4)...)
+		next = append(next, tmpmt...)
+	}
 	return bytes.Join([][]byte{toByte(uint64(mtsk.oid), 4), toByte(mtsk.idx, 8),
-		mtsk.seed, mtsk.skseed, mtsk.skprf, mts, twoDto1D(mtsk.chainsig)}, []byte(""))
+		mtsk.seed, mtsk.skseed, mtsk.skprf, mts, twoDto1D(mtsk.chainsig), next}, []byte(""))
+}
+
+// MarshalPrivate serializes the private key. The result contains the secret
+// seeds and must be protected like the key itself; ParseMTSK reads it back from
+// hexadecimal.
+func (mtsk *MTSK) MarshalPrivate() ([]byte, error) {
+	if mtsk.destroyed() {
+		return nil, errors.New("xmss-mt: invalid XMSS^MT private key")
+	}
+	return mtsk.serialize(), nil
 }
 
-// String serializes the private key and converts it to a hexadecimal string.
-func (mtsk *MTSK) String() string {
-	return fmt.Sprintf("%x", mtsk.serialize())
+// Format formats the private key as a placeholder, whatever the verb, so that
+// it is not printed by accident. The key has no String method, so that it is
+// not saved in that form by mistake either; use MarshalPrivate to serialize it.
+func (mtsk *MTSK) Format(f fmt.State, verb rune) {
+	io.WriteString(f, "xmss.MTSK(REDACTED)")
+}
+
+// Destroy overwrites the secret seeds of the private key and of its trees with
+// zeros. The key can no longer sign or be serialized afterwards.
+func (mtsk *MTSK) Destroy() {
+	zeroize(mtsk.skseed)
+	zeroize(mtsk.skprf)
+	mtsk.skseed = nil
+	mtsk.skprf = nil
+	for _, xsk := range mtsk.xsk {
+		xsk.Destroy()
+	}
+	for _, xsk := range mtsk.next {
+		if xsk != nil {
+			xsk.Destroy()
+		}
+	}
+	mtsk.xsk = nil
+	mtsk.chainsig = nil
+	mtsk.next = nil
+}
+
+func (mtsk *MTSK) destroyed() bool {
+	if len(mtsk.skseed) == 0 || len(mtsk.skprf) == 0 || len(mtsk.xsk) == 0 {
+		return true
+	}
+	for _, xsk := range mtsk.xsk {
+		if xsk.destroyed() {
+			return true
+		}
+	}
+	return false
 }
 
 // ParseMTSK parses an XMSS^MT private key in hexadecimal.
@@ -46,23 +108,27 @@ func ParseMTSK(sk string) (*MTSK, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer zeroize(skbytes)
 	if len(skbytes) < 4+8 {
 		return nil, errors.New("xmss-mt: invalid XMSS^MT private key")
 	}
 	mtsk := new(MTSK)
 	oid := strToUint(skbytes[:4])
 	skbytes = skbytes[4:]
-	if xmssmttypes[oid] == nil {
+	mtty, xmssty, err := xmssmtparams(oid)
+	if err != nil {
 		return nil, errors.New("xmss-mt: invalid XMSS^MT private key")
 	}
+	d := mtty.d
+	n := xmssty.n
+	l := xmssty.l
+	xh := xmssty.h
 	mtsk.oid = oid
 	mtsk.idx = strToUint64(skbytes[:8])
 	skbytes = skbytes[8:]
-	xmssty := xmssmttypes[oid].xmssty
-	d := xmssmttypes[oid].d
-	n := xmsstypes[xmssty].n
-	l := xmsstypes[xmssty].l
-	xh := xmsstypes[xmssty].h
+	if mtsk.idx > 1<<uint(d*xh) {
+		return nil, errors.New("xmss-mt: invalid XMSS^MT private key")
+	}
 	if len(skbytes) < n+n+n {
 		return nil, errors.New("xmss-mt: invalid XMSS^MT private key")
 	}
@@ -76,6 +142,8 @@ func ParseMTSK(sk string) (*MTSK, error) {
 	copy(mtsk.skprf, skbytes[:n])
 	skbytes = skbytes[n:]
 
+	// Every layer must hold the tree and the next leaf that the index selects.
+	idxtree, next := layerIndexes(mtsk.idx, d, xh)
 	mtsk.xsk = make([]*SK, d)
 	for i := 0; i < d; i++ {
 		if len(skbytes) < 4 {
@@ -86,18 +154,46 @@ func ParseMTSK(sk string) (*MTSK, error) {
 		if len(skbytes) < sklen {
 			return nil, errors.New("xmss-mt: invalid XMSS^MT private key")
 		}
-		mtsk.xsk[i] = parseReducedSK(skbytes[:sklen], i, mtsk.skseed, mtsk.seed, mtsk.skprf, xmssty)
+		mtsk.xsk[i] = parseReducedSK(skbytes[:sklen], i, mtsk.skseed, mtsk.seed, mtsk.skprf, mtty.xmssty)
 		skbytes = skbytes[sklen:]
-		if mtsk.xsk[i] == nil {
+		if mtsk.xsk[i] == nil || mtsk.xsk[i].mt.idx != next[i] || !mtsk.xsk[i].mt.bds.Initialized() ||
+			mtsk.xsk[i].mt.idxtree != idxtree[i] {
 			return nil, errors.New("xmss-mt: invalid XMSS^MT private key")
 		}
 	}
 
-	if len(skbytes) != (xh+l)*n*(d-1) {
+	if len(skbytes) < (xh+l)*n*(d-1) {
 		return nil, errors.New("xmss-mt: invalid XMSS^MT private key")
 	}
+	mtsk.chainsig = oneDto2D(append([]byte(nil), skbytes[:(xh+l)*n*(d-1)]...), d-1, (xh+l)*n)
+	skbytes = skbytes[(xh+l)*n*(d-1):]
 
-	mtsk.chainsig = oneDto2D(skbytes, d-1, (xh+l)*n)
+	// Keys written before the next trees were kept end here.
+	if len(skbytes) > 0 {
+		mtsk.next = make([]*SK, d-1)
+	}
+	for i := 0; i < len(mtsk.next); i++ {
+		if len(skbytes) < 4 {
+			return nil, errors.New("xmss-mt: invalid XMSS^MT private key")
+		}
+		sklen := strToInt(skbytes[:4])
+		skbytes = skbytes[4:]
+		if len(skbytes) < sklen {
+			return nil, errors.New("xmss-mt: invalid XMSS^MT private key")
+		}
+		if sklen == 0 {
+			continue
+		}
+		mtsk.next[i] = parseReducedSK(skbytes[:sklen], i, mtsk.skseed, mtsk.seed, mtsk.skprf, mtty.xmssty)
+		skbytes = skbytes[sklen:]
+		if mtsk.next[i] == nil || mtsk.next[i].mt.idx != 0 ||
+			mtsk.next[i].mt.idxtree != mtsk.xsk[i].mt.idxtree+1 {
+			return nil, errors.New("xmss-mt: invalid XMSS^MT private key")
+		}
+	}
+	if len(skbytes) != 0 {
+		return nil, errors.New("xmss-mt: invalid XMSS^MT private key")
+	}
 	mtsk.root = make([]byte, len(mtsk.xsk[d-1].mt.root))
 	copy(mtsk.root, mtsk.xsk[d-1].mt.root)
 	return mtsk, nil
@@ -131,10 +227,11 @@ func ParseMTPK(pk string) (*MTPK, error) {
 	}
 
 	oid := strToUint(pkbytes[:4])
-	if xmssmttypes[oid] == nil {
+	_, xmssty, err := xmssmtparams(oid)
+	if err != nil {
 		return nil, errors.New("xmss-mt: invalid XMSS^MT public key")
 	}
-	n := xmsstypes[oid].n
+	n := xmssty.n
 
 	if len(pkbytes) != 4+n+n {
 		return nil, errors.New("xmss-mt: invalid XMSS^MT public key")
@@ -150,46 +247,113 @@ func ParseMTPK(pk string) (*MTPK, error) {
 	return mtpk, nil
 }
 
+// validate checks the oid of the public key and the lengths of its fields.
+func (mtpk *MTPK) validate() error {
+	_, xmssty, err := xmssmtparams(mtpk.oid)
+	if err != nil {
+		return err
+	}
+	if len(mtpk.root) != xmssty.n || len(mtpk.seed) != xmssty.n {
+		return errors.New("xmss-mt: invalid XMSS^MT public key")
+	}
+	return nil
+}
+
 // MTkeyGen generates an XMSS^MT key pair
 func MTkeyGen(oid uint) (*MTSK, *MTPK, error) {
-	if xmssmttypes[oid] == nil {
-		return nil, nil, errors.New("xmssmt: invalid XMSS^MT type")
+	_, xmssty, err := xmssmtparams(oid)
+	if err != nil {
+		return nil, nil, err
+	}
+	return MTkeyGenWithK(oid, defaultK(xmssty.h))
+}
+
+// MTkeyGenWithK generates an XMSS^MT key pair whose trees use the BDS traversal
+// algorithm with parameter k (see KeyGenWithK).
+func MTkeyGenWithK(oid uint, k int) (*MTSK, *MTPK, error) {
+	return mtkeyGen(&keygen{ctx: context.Background()}, oid, k, rand.Reader)
+}
+
+// MTkeyGenWithRand generates an XMSS^MT key pair whose PUB_SEED, SK_SEED and
+// SK_PRF are read, in this order, from rand. The same input always yields the
+// same key pair.
+func MTkeyGenWithRand(oid uint, rand io.Reader) (*MTSK, *MTPK, error) {
+	_, xmssty, err := xmssmtparams(oid)
+	if err != nil {
+		return nil, nil, err
+	}
+	return mtkeyGen(&keygen{ctx: context.Background()}, oid, defaultK(xmssty.h), rand)
+}
+
+// MTkeyGenWithContext generates an XMSS^MT key pair like MTkeyGen, calling
+// progress, if it is not nil, after each leaf of the trees of all layers,
+// d*2^(h/d) leaves in total. Generation stops with the error of ctx once ctx is
+// done.
+func MTkeyGenWithContext(ctx context.Context, oid uint, progress Progress) (*MTSK, *MTPK, error) {
+	_, xmssty, err := xmssmtparams(oid)
+	if err != nil {
+		return nil, nil, err
+	}
+	return mtkeyGen(&keygen{ctx: ctx, progress: progress}, oid, defaultK(xmssty.h), rand.Reader)
+}
+
+func mtkeyGen(g *keygen, oid uint, k int, rand io.Reader) (*MTSK, *MTPK, error) {
+	mtty, xmssty, err := xmssmtparams(oid)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !validK(xmssty.h, k) {
+		return nil, nil, errors.New("xmssmt: invalid BDS parameter k")
 	}
 	mtsk := new(MTSK)
 
-	n := xmsstypes[xmssmttypes[oid].xmssty].n
+	n := xmssty.n
 
 	mtsk.idx = 0
 	mtsk.oid = oid
-	mtsk.skprf = make([]byte, n)
-	_, err := rand.Read(mtsk.skprf)
+	mtsk.seed = make([]byte, n)
+	_, err = io.ReadFull(rand, mtsk.seed)
 	if err != nil {
 		return nil, nil, err
 	}
-	mtsk.seed = make([]byte, n)
-	_, err = rand.Read(mtsk.seed)
+	mtsk.skseed = make([]byte, n)
+	_, err = io.ReadFull(rand, mtsk.skseed)
 	if err != nil {
 		return nil, nil, err
 	}
-	mtsk.skseed = make([]byte, n)
-	_, err = rand.Read(mtsk.skseed)
+	mtsk.skprf = make([]byte, n)
+	_, err = io.ReadFull(rand, mtsk.skprf)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	mtpk := new(MTPK)
-	mtpk.oid = oid
-	mtpk.seed = make([]byte, n)
-	copy(mtpk.seed, mtsk.seed)
+	mtsk.xsk = make([]*SK, mtty.d)
+	return g.mt(mtsk, k)
+}
 
-	d := xmssmttypes[oid].d
-	mtsk.xsk = make([]*SK, d)
+// mt generates the trees of a new XMSS^MT private key, from the bottom layer
+// up. The trees of the lower layers may be given in mtsk.xsk, complete or under
+// construction, as when resuming from a checkpoint.
+func (g *keygen) mt(mtsk *MTSK, k int) (*MTSK, *MTPK, error) {
+	mtty, xmssty, _ := xmssmtparams(mtsk.oid)
+	d := mtty.d
+	g.total = uint64(d * pow2(xmssty.h))
+	g.state = func() []byte { return mtCheckpoint(mtsk) }
 	for i := 0; i < d; i++ {
-		mtsk.xsk[i], _, err = xmsskeyGen(xmssmttypes[oid].xmssty, mtsk.skseed, mtsk.seed, mtsk.skprf, i, 0)
-		if err != nil {
+		if mtsk.xsk[i] == nil {
+			mtsk.xsk[i] = newxsk(mtty.xmssty, k, mtsk.skseed, mtsk.seed, mtsk.skprf, i, 0)
+		}
+		if err := g.build(mtsk.xsk[i].mt); err != nil {
+			mtsk.xsk = mtsk.xsk[:i+1]
+			mtsk.Destroy()
 			return nil, nil, err
 		}
 	}
+
+	mtpk := new(MTPK)
+	mtpk.oid = mtsk.oid
+	mtpk.seed = make([]byte, len(mtsk.seed))
+	copy(mtpk.seed, mtsk.seed)
 	mtsk.root = make([]byte, len(mtsk.xsk[d-1].mt.root))
 	copy(mtsk.root, mtsk.xsk[d-1].mt.root)
 	mtpk.root = make([]byte, len(mtsk.xsk[d-1].mt.root))
@@ -206,6 +370,113 @@ func MTkeyGen(oid uint) (*MTSK, *MTPK, error) {
 	return mtsk, mtpk, nil
 }
 
+// Remaining returns the number of signatures the private key can still generate.
+func (mtsk *MTSK) Remaining() uint64 {
+	mtty, xmssty, err := xmssmtparams(mtsk.oid)
+	if err != nil || mtsk.destroyed() {
+		return 0
+	}
+	h := uint(mtty.d * xmssty.h)
+	if mtsk.idx >= 1<<h {
+		return 0
+	}
+	return 1<<h - mtsk.idx
+}
+
+// AdvanceTo moves the private key forward so that its next signature has index
+// idx. The trees that stay in use are advanced as by SK.AdvanceTo, those below a
+// layer that moves to another leaf are replaced, and the key never moves
+// backward.
+func (mtsk *MTSK) AdvanceTo(idx uint64) error {
+	mtty, xmssty, err := xmssmtparams(mtsk.oid)
+	if err != nil || mtsk.destroyed() || len(mtsk.xsk) != mtty.d || len(mtsk.chainsig) != mtty.d-1 {
+		return errors.New("xmss-mt: invalid XMSS^MT private key")
+	}
+	if idx < mtsk.idx {
+		return errors.New("xmss-mt: cannot move the private key backward")
+	}
+	if idx > 1<<uint(mtty.d*xmssty.h) {
+		return errors.New("xmss-mt: index out of range")
+	}
+	if idx == mtsk.idx {
+		return nil
+	}
+	return mtsk.moveTo(idx)
+}
+
+// moveTo sets the trees of every layer to those a key that has made idx
+// signatures uses, generating the trees that are missing or differ.
+func (mtsk *MTSK) moveTo(idx uint64) error {
+	mtty, xmssty, _ := xmssmtparams(mtsk.oid)
+	d := mtty.d
+	idxtree, next := layerIndexes(idx, d, xmssty.h)
+
+	top := -1
+	for i := d - 1; i >= 0; i-- {
+		if mtsk.xsk[i] == nil || mtsk.xsk[i].mt.idxtree != idxtree[i] || mtsk.xsk[i].mt.idx != next[i] {
+			top = i
+			break
+		}
+	}
+	adrs := toByte(0, addrlen)
+	for i := 0; i <= top; i++ {
+		if mtsk.xsk[i] == nil || mtsk.xsk[i].mt.idxtree != idxtree[i] {
+			k := defaultK(xmssty.h)
+			if mtsk.xsk[i] != nil {
+				k = mtsk.xsk[i].mt.bds.K()
+				mtsk.xsk[i].Destroy()
+			}
+			xsk, err := mtsk.newTree(i, idxtree[i], k)
+			if err != nil {
+				return err
+			}
+			mtsk.xsk[i] = xsk
+		}
+		if i == 0 {
+			if err := mtsk.xsk[0].mt.advance(next[0]); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := mtsk.xsk[i].mt.advance(next[i] - 1); err != nil {
+			return err
+		}
+		set(adrs, int64(i), layeraddr)
+		set(adrs, int64(idxtree[i]), treeaddr)
+		mtsk.chainsig[i-1] = twoDto1D(mtsk.xsk[i].treeSig(mtsk.xsk[i-1].mt.root, adrs))
+	}
+	mtsk.idx = idx
+	if mtsk.root == nil {
+		mtsk.root = make([]byte, len(mtsk.xsk[d-1].mt.root))
+		copy(mtsk.root, mtsk.xsk[d-1].mt.root)
+	}
+	return nil
+}
+
+// layerIndexes returns the index of the tree of every layer, and of the next
+// leaf of that tree, in a key that has made idx signatures. The bottom tree has
+// used the leaves before idx. A full bottom tree is only replaced by the next
+// signature, so index idx-1 locates it. Every other layer has used the leaf
+// that signed its current child.
+func layerIndexes(idx uint64, d int, h int) ([]uint64, []int) {
+	xh := uint(h)
+	mask := uint64(1)<<xh - 1
+	last := idx
+	if idx > 0 {
+		last = idx - 1
+	}
+	idxtree := make([]uint64, d)
+	next := make([]int, d)
+	for i := 0; i < d; i++ {
+		idxtree[i] = last >> (xh * uint(i+1))
+		next[i] = int(last>>(xh*uint(i))&mask) + 1
+	}
+	if idx == 0 {
+		next[0] = 0
+	}
+	return idxtree, next
+}
+
 // Public generates the public key of a private key.
 func (mtsk *MTSK) Public() *MTPK {
 	xpk := new(MTPK)
@@ -219,12 +490,41 @@ func (mtsk *MTSK) Public() *MTPK {
 
 // Sign generates an XMSS^MT signature and updates the XMSS^MT private key.
 func (mtsk *MTSK) Sign(message []byte) ([]byte, error) {
-	if xmssmttypes[mtsk.oid] == nil {
+	if err := checkMessage(message); err != nil {
+		return nil, err
+	}
+	return mtsk.sign(bytesmsg(message))
+}
+
+// sign signs a message without checking it for the batch tag.
+func (mtsk *MTSK) sign(message msghash) ([]byte, error) {
+	l, err := mtsk.reserve()
+	if err != nil {
+		return nil, err
+	}
+	return mtsk.signLeaf(l, message)
+}
+
+// An mtleafsig is a leaf of the bottom tree whose index has been consumed from
+// an XMSS^MT private key, together with the signatures of the trees above it.
+type mtleafsig struct {
+	idx      uint64
+	leaf     *leafsig
+	chainsig []byte
+}
+
+// reserve consumes the next leaf of the XMSS^MT private key, replacing
+// exhausted trees first.
+func (mtsk *MTSK) reserve() (*mtleafsig, error) {
+	mtty, xmssty, err := xmssmtparams(mtsk.oid)
+	if err != nil || len(mtsk.xsk) != mtty.d || len(mtsk.chainsig) != mtty.d-1 {
 		return nil, errors.New("xmss-mt: invalid XMSS^MT private key")
 	}
-	d := xmssmttypes[mtsk.oid].d
-	xh := xmsstypes[xmssmttypes[mtsk.oid].xmssty].h
-	if mtsk.xsk[d-1].mt.idx >= pow2(xh) {
+	d := mtty.d
+	xh := xmssty.h
+	// The top tree uses its last leaf for the last tree of the layer below, so
+	// only the index tells whether the key is exhausted.
+	if mtsk.idx >= 1<<uint(d*xh) {
 		return nil, errors.New("xmss-mt: attempted overuse of XMSS^MT private key")
 	}
 
@@ -233,10 +533,11 @@ func (mtsk *MTSK) Sign(message []byte) ([]byte, error) {
 		if mtsk.xsk[i].mt.idx < pow2(xh) {
 			break
 		}
-		tmpxsk, _, err := xmsskeyGen(xmssmttypes[mtsk.oid].xmssty, mtsk.skseed, mtsk.seed, mtsk.skprf, i, mtsk.xsk[i].mt.idx+1)
+		tmpxsk, err := mtsk.newTree(i, mtsk.xsk[i].mt.idxtree+1, mtsk.xsk[i].mt.bds.K())
 		if err != nil {
 			return nil, err
 		}
+		mtsk.xsk[i].Destroy()
 		mtsk.xsk[i] = tmpxsk
 	}
 
@@ -248,63 +549,151 @@ func (mtsk *MTSK) Sign(message []byte) ([]byte, error) {
 		mtsk.chainsig[j-1] = twoDto1D(mtsk.xsk[j].treeSig(mtsk.xsk[j-1].mt.root, adrs))
 	}
 
+	mtsk.prepare()
+	mtsk.grow()
+
+	l := new(mtleafsig)
+	l.idx = mtsk.idx
+	l.leaf = mtsk.xsk[0].reserveLeaf()
+	l.chainsig = twoDto1D(mtsk.chainsig)
+	mtsk.idx++
+
+	return l, nil
+}
+
+// prepare starts the next tree of every layer below the top one that has none,
+// unless the current tree is the last of its layer, and drops next trees that
+// no longer follow the current ones.
+func (mtsk *MTSK) prepare() {
+	mtty, xmssty, _ := xmssmtparams(mtsk.oid)
+	d := mtty.d
+	xh := uint(xmssty.h)
+	if len(mtsk.next) != d-1 {
+		mtsk.next = make([]*SK, d-1)
+	}
+	for i := 0; i < d-1; i++ {
+		idxtree := mtsk.xsk[i].mt.idxtree + 1
+		if mtsk.next[i] != nil && mtsk.next[i].mt.idxtree != idxtree {
+			mtsk.next[i].Destroy()
+			mtsk.next[i] = nil
+		}
+		if mtsk.next[i] == nil && idxtree < 1<<(xh*uint(d-1-i)) {
+			mtsk.next[i] = newxsk(mtty.xmssty, mtsk.xsk[i].mt.bds.K(), mtsk.skseed, mtsk.seed, mtsk.skprf, i, idxtree)
+		}
+	}
+}
+
+// grow computes leaves of the next trees, spreading the leaves a tree still
+// lacks evenly over the signatures left before the tree it replaces is
+// exhausted. Unless the key was moved shortly before that, this is one leaf per
+// layer for most signatures.
+func (mtsk *MTSK) grow() {
+	xh := uint(xmsstypes[xmssmttypes[mtsk.oid].xmssty].h)
+	for i, xsk := range mtsk.next {
+		if xsk == nil || xsk.mt.bds.Initialized() {
+			continue
+		}
+		steps := uint64(pow2(int(xh)) - xsk.mt.bds.InitLeaves())
+		// The current tree of layer i covers the signatures up to the first one
+		// of the next tree, counting the one being made.
+		if left := (mtsk.xsk[i].mt.idxtree+1)<<(xh*uint(i+1)) - mtsk.idx; left > 0 {
+			steps = (steps + left - 1) / left
+		}
+		for ; steps > 0 && !xsk.mt.bds.Initialized(); steps-- {
+			xsk.mt.bds.InitStep(xmssHasher{xsk.mt})
+		}
+		if xsk.mt.bds.Initialized() {
+			xsk.mt.complete()
+		}
+	}
+}
+
+// newTree returns tree idxtree of layer i, completing the next tree of the
+// layer if it is that one.
+func (mtsk *MTSK) newTree(i int, idxtree uint64, k int) (*SK, error) {
+	if i < len(mtsk.next) && mtsk.next[i] != nil {
+		xsk := mtsk.next[i]
+		mtsk.next[i] = nil
+		if xsk.mt.idxtree == idxtree {
+			xsk.mt.complete()
+			return xsk, nil
+		}
+		xsk.Destroy()
+	}
+	xsk, _, err := xmsskeyGen(xmssmttypes[mtsk.oid].xmssty, k, mtsk.skseed, mtsk.seed, mtsk.skprf, i, idxtree)
+	return xsk, err
+}
+
+// signLeaf signs a message with a leaf returned by reserve.
+func (mtsk *MTSK) signLeaf(l *mtleafsig, message msghash) ([]byte, error) {
+	defer l.leaf.destroy()
+	d := xmssmttypes[mtsk.oid].d
+	xh := xmsstypes[xmssmttypes[mtsk.oid].xmssty].h
 	hsty := xmsstypes[xmssmttypes[mtsk.oid].xmssty].hsty
 	n := xmsstypes[xmssmttypes[mtsk.oid].xmssty].n
 	h := d * xh
 
-	r := fn(toByte(mtsk.idx, 32), mtsk.skprf, hsty, prf)
-	m := fn(message, bytes.Join([][]byte{r, mtsk.root, toByte(mtsk.idx, n)}, []byte("")), hsty, hmsg)
+	r := fn(toByte(l.idx, 32), l.leaf.skprf, hsty, prf)
+	m, err := message(bytes.Join([][]byte{r, mtsk.root, toByte(l.idx, n)}, []byte("")), hsty)
+	if err != nil {
+		return nil, err
+	}
 
-	mtsig := toByte(mtsk.idx, ceil(float64(h)/8))
+	mtsig := toByte(l.idx, ceil(float64(h)/8))
 	mtsig = append(mtsig, r...)
 
+	adrs := toByte(0, addrlen)
 	set(adrs, 0, layeraddr)
-	set(adrs, int64(mtsk.xsk[0].mt.idxtree), treeaddr)
-	mtsig = append(mtsig, twoDto1D(mtsk.xsk[0].treeSig(m, adrs))...)
-	mtsig = append(mtsig, twoDto1D(mtsk.chainsig)...)
-
-	mtsk.idx++
+	set(adrs, int64(l.leaf.mt.idxtree), treeaddr)
+	mtsig = append(mtsig, twoDto1D(l.leaf.treeSig(m, adrs))...)
+	mtsig = append(mtsig, l.chainsig...)
 
 	return mtsig, nil
 }
 
 // Verify  an XMSS^MT signature using the corresponding XMSS^MT public key and a message.
 func (mtpk *MTPK) Verify(message, mtsig []byte) bool {
-	if xmsstypes[mtpk.oid] == nil {
+	return mtpk.verify(bytesmsg(message), mtsig, nil)
+}
+
+// verify verifies an XMSS^MT signature. The roots computed for the trees above
+// the bottom layer are looked up in and added to the cache if it is not nil.
+func (mtpk *MTPK) verify(message msghash, mtsig []byte, cache *rootCache) bool {
+	if mtpk.validate() != nil {
+		return false
+	}
+	sig, err := ParseMTSignature(mtpk.oid, mtsig)
+	if err != nil {
 		return false
 	}
 	d := xmssmttypes[mtpk.oid].d
 	xh := xmsstypes[xmssmttypes[mtpk.oid].xmssty].h
-	l := xmsstypes[xmssmttypes[mtpk.oid].xmssty].l
 	hsty := xmsstypes[xmssmttypes[mtpk.oid].xmssty].hsty
 	wotspty := xmsstowotsp(xmssmttypes[mtpk.oid].xmssty)
 	n := xmsstypes[xmssmttypes[mtpk.oid].xmssty].n
-	h := d * xh
-	idxsiglen := ceil(float64(h) / 8)
-	if len(mtsig) != idxsiglen+n+(xh+l)*n*d {
-		fmt.Println(len(mtsig), idxsiglen+n+(xh+l)*n*d)
+	m, err := message(bytes.Join([][]byte{sig.r, mtpk.root, toByte(sig.idx, n)}, []byte("")), hsty)
+	if err != nil {
 		return false
 	}
-	idxsig := strToUint64(mtsig[:idxsiglen])
-	r := mtsig[idxsiglen : idxsiglen+n]
-	m := fn(message, bytes.Join([][]byte{r, mtpk.root, toByte(idxsig, n)}, []byte("")), hsty, hmsg)
 
-	idxleaf := int(idxsig % uint64(pow2(xh)))
-	idxtree := idxsig >> uint(xh)
+	idxtree, idxleaf := sig.Tree(0)
 	adrs := toByte(0, 32)
 	set(adrs, 0, layeraddr)
 	set(adrs, int64(idxtree), treeaddr)
-	tmpsig := oneDto2D(mtsig[idxsiglen+n:idxsiglen+n+(xh+l)*n], xh+l, n)
-	node := rootFromSig(m, mtpk.seed, tmpsig[:l], tmpsig[l:], adrs, idxleaf, wotspty, xh)
+	node := rootFromSig(m, mtpk.seed, sig.layers[0].wots, sig.layers[0].authpath, adrs, idxleaf, wotspty, xh)
 	for i := 1; i < d; i++ {
-		idxleaf = int(idxtree % uint64(pow2(xh)))
-		idxtree >>= uint(xh)
-		tmpsig = oneDto2D(mtsig[idxsiglen+n+(xh+l)*n*i:idxsiglen+n+(xh+l)*n*(i+1)], xh+l, n)
-		set(adrs, int64(i), layeraddr)
-		set(adrs, int64(idxtree), treeaddr)
-		node = rootFromSig(node, mtpk.seed, tmpsig[:l], tmpsig[l:], adrs, idxleaf, wotspty, xh)
+		idxtree, idxleaf = sig.Tree(i)
+		layersig := sig.layers[i]
+		key := string(bytes.Join([][]byte{toByte(uint64(i), 4), toByte(idxtree, 8), toByte(uint64(idxleaf), 4), node, layersig.Marshal()}, []byte("")))
+		child, layer, tree, leaf := node, i, idxtree, idxleaf
+		node = cache.do(key, func() []byte {
+			adrs := toByte(0, 32)
+			set(adrs, int64(layer), layeraddr)
+			set(adrs, int64(tree), treeaddr)
+			return rootFromSig(child, mtpk.seed, layersig.wots, layersig.authpath, adrs, leaf, wotspty, xh)
+		})
 	}
-	if !bytes.Equal(mtpk.root, node) {
+	if subtle.ConstantTimeCompare(mtpk.root, node) != 1 {
 		return false
 	}
 	return true
@@ -5,6 +5,7 @@
 package xmss
 
 import (
+	"bytes"
 	"crypto/rand"
 	"testing"
 )
@@ -37,11 +38,11 @@ func TestXMSSMT(t *testing.T) {
 				t.Errorf("invalid signature when XMSS^MT types = %x, j = %d", xmssmttys[i], j)
 			}
 		}
-		smtsk, serr := ParseMTSK(mtsk.String())
+		smtsk, serr := ParseMTSK(privatehex(mtsk))
 		if serr != nil {
 			t.Errorf("failed to parse private key when XMSS^MT types = %x", xmssmttys[i])
 		}
-		if smtsk.String() != mtsk.String() {
+		if privatehex(smtsk) != privatehex(mtsk) {
 			t.Errorf("parsed xsk != xsk when XMSS^MT types = %x", xmssmttys[i])
 		}
 		smtpk, perr := ParseMTPK(mtpk.String())
@@ -61,3 +62,99 @@ func TestXMSSMT(t *testing.T) {
 		}
 	}
 }
+
+func TestXMSSMTLargeIndex(t *testing.T) {
+	mtsk, mtpk, _ := MTkeyGen(XMSSMTSHA2H60D12W256)
+	// The bottom trees have 32 leaves. Sign across the tree boundaries at 2^32,
+	// 2^40 and 2^59, where the tree indices of several layers no longer fit in
+	// 32 bits, and save and load the key in between.
+	for _, boundary := range []uint64{1 << 32, 1 << 40, 1 << 59, 1<<60 - 32} {
+		if err := mtsk.AdvanceTo(boundary - 2); err != nil {
+			t.Fatal(err)
+		}
+		for idx := boundary - 2; idx < boundary+2; idx++ {
+			msg := []byte("message")
+			mtsig, err := mtsk.Sign(msg)
+			if err != nil || !mtpk.Verify(msg, mtsig) {
+				t.Fatalf("invalid signature %d: %v", idx, err)
+			}
+			if sig, _ := ParseMTSignature(XMSSMTSHA2H60D12W256, mtsig); sig.Idx() != idx {
+				t.Fatalf("signature %d has index %d", idx, sig.Idx())
+			}
+			loaded, err := ParseMTSK(privatehex(mtsk))
+			if err != nil || privatehex(loaded) != privatehex(mtsk) {
+				t.Fatalf("failed to load the private key after signature %d: %v", idx, err)
+			}
+			if loaded.xsk[1].mt.idxtree != idx>>10 {
+				t.Fatalf("tree index %d loaded after signature %d, want %d", loaded.xsk[1].mt.idxtree, idx, idx>>10)
+			}
+			mtsk = loaded
+		}
+	}
+	if mtsk.Remaining() != 30 {
+		t.Errorf("Remaining() = %d, want 30", mtsk.Remaining())
+	}
+	backup, _ := mtsk.Backup(29)
+	restored, err := RestoreMTSK(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mtsig, err := restored.Sign([]byte("message"))
+	if err != nil || !mtpk.Verify([]byte("message"), mtsig) {
+		t.Fatalf("invalid last signature: %v", err)
+	}
+	if _, err := restored.Sign([]byte("message")); err == nil {
+		t.Error("signed after the last signature")
+	}
+}
+
+func TestMTDestroysReplacedTrees(t *testing.T) {
+	mtsk, _, _ := MTkeyGen(XMSSMTSHA2H20D4W256)
+	old := mtsk.xsk[0]
+	for i := 0; i <= 32; i++ {
+		mtsk.Sign([]byte("message"))
+	}
+	if mtsk.xsk[0] == old || !old.destroyed() {
+		t.Error("the exhausted bottom tree was not destroyed when it was replaced")
+	}
+}
+
+func TestMTBuildsNextTreesAhead(t *testing.T) {
+	mtsk, mtpk, _ := MTkeyGen(XMSSMTSHA2H20D4W256)
+	for i := 0; i < 70; i++ {
+		next := append([]*SK(nil), mtsk.next...)
+		leaves := make([]int, len(next))
+		for j, xsk := range next {
+			if xsk != nil {
+				leaves[j] = xsk.mt.bds.InitLeaves()
+			}
+		}
+		// The bottom tree is exhausted every 32 signatures; its replacement
+		// must be complete by then.
+		if i > 0 && i%32 == 0 && !next[0].mt.bds.Initialized() {
+			t.Fatalf("the next bottom tree is incomplete before signature %d", i)
+		}
+		// A saved key keeps the trees under construction.
+		if i%16 == 0 {
+			loaded, err := ParseMTSK(privatehex(mtsk))
+			if err != nil || privatehex(loaded) != privatehex(mtsk) {
+				t.Fatalf("failed to load the private key before signature %d: %v", i, err)
+			}
+			sig, _ := loaded.Sign([]byte("message"))
+			want, _ := mtsk.Sign([]byte("message"))
+			if !mtpk.Verify([]byte("message"), sig) || !bytes.Equal(sig, want) {
+				t.Fatalf("loaded key signed differently at signature %d", i)
+			}
+			continue
+		}
+		mtsig, err := mtsk.Sign([]byte("message"))
+		if err != nil || !mtpk.Verify([]byte("message"), mtsig) {
+			t.Fatalf("invalid signature %d: %v", i, err)
+		}
+		for j, xsk := range next {
+			if xsk != nil && xsk == mtsk.next[j] && xsk.mt.bds.InitLeaves()-leaves[j] > 1 {
+				t.Errorf("signature %d computed %d leaves of the next tree of layer %d", i, xsk.mt.bds.InitLeaves()-leaves[j], j)
+			}
+		}
+	}
+}